@@ -7,10 +7,13 @@ import (
 	"log"
 	"net/http"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/fabricekabongo/loggerhead/clustering"
 	"github.com/fabricekabongo/loggerhead/config"
+	"github.com/fabricekabongo/loggerhead/query"
+	"github.com/fabricekabongo/loggerhead/world"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -34,15 +37,30 @@ func init() {
 	TMPL = tmpl
 }
 
+// Snapshotter snapshots the world to disk and returns the snapshot file.
+type Snapshotter func() (string, error)
+
 type OpsServer struct {
-	cluster *clustering.Cluster
-	cfg     config.Config
+	cluster     *clustering.Cluster
+	cfg         config.Config
+	snapshotter Snapshotter
+	world       *world.World
+	// writer runs the namespace changes and the saves of the REST API like the write port does, so they are logged
+	// and broadcast.
+	writer query.EngineInterface
+	// reader answers the reads of the REST API like the read port does.
+	reader query.EngineInterface
 }
 
-func NewOpsServer(cluster *clustering.Cluster, cfg config.Config) *OpsServer {
+// NewOpsServer creates the admin server. snapshotter is nil when durability is disabled.
+func NewOpsServer(cluster *clustering.Cluster, cfg config.Config, snapshotter Snapshotter, world *world.World, writer, reader query.EngineInterface) *OpsServer {
 	return &OpsServer{
-		cluster: cluster,
-		cfg:     cfg,
+		cluster:     cluster,
+		cfg:         cfg,
+		snapshotter: snapshotter,
+		world:       world,
+		writer:      writer,
+		reader:      reader,
 	}
 }
 
@@ -50,6 +68,9 @@ func (o *OpsServer) Start() {
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(StaticFS))))
 	http.Handle("/metrics", promhttp.Handler())
 	http.Handle("/admin-data", o.AdminData())
+	http.Handle("/snapshot", o.Snapshot())
+	http.Handle("/namespaces", o.Namespaces())
+	o.handleREST(http.DefaultServeMux)
 	http.Handle("/", o.AdminUI())
 
 	server := &http.Server{
@@ -140,6 +161,91 @@ func (o *OpsServer) AdminData() http.Handler {
 	})
 }
 
+type SnapshotResult struct {
+	Path string
+}
+
+// Snapshot triggers a snapshot of this node on POST.
+func (o *OpsServer) Snapshot() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if o.snapshotter == nil {
+			http.Error(w, "durability is disabled, start the node with a data directory", http.StatusConflict)
+			return
+		}
+
+		path, err := o.snapshotter()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(SnapshotResult{Path: path})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+type NamespacesData struct {
+	Defaults   world.IndexOptions       `json:"defaults"`
+	Namespaces []world.NamespaceOptions `json:"namespaces"`
+}
+
+// Namespaces lists the namespace catalog on GET, and creates a namespace on POST from a JSON body like
+// {"name": "paris", "options": {"capacity": 100}}, the options left out taking the defaults.
+func (o *OpsServer) Namespaces() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, NamespacesData{
+				Defaults:   o.world.DefaultIndexOptions(),
+				Namespaces: o.world.Catalog(),
+			})
+		case http.MethodPost:
+			namespace := world.NamespaceOptions{Options: o.world.DefaultIndexOptions()}
+			err := json.NewDecoder(r.Body).Decode(&namespace)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if namespace.Name == "" || strings.ContainsAny(namespace.Name, " \t\r\n") {
+				http.Error(w, "namespace names cannot be empty nor hold spaces", http.StatusBadRequest)
+				return
+			}
+
+			response := o.writer.ExecuteQuery(query.FormatCreateNamespace(namespace.Name, namespace.Options))
+			if response != "1.0,created\n" {
+				http.Error(w, strings.Trim(strings.TrimPrefix(strings.TrimSpace(response), "1.0,"), "\""), http.StatusBadRequest)
+				return
+			}
+
+			writeJSON(w, http.StatusCreated, namespace)
+		default:
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Println("Failed to write the admin response: ", err)
+	}
+}
+
 func (*OpsServer) AdminUI() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		err := TMPL.Execute(w, nil)
@@ -0,0 +1,267 @@
+package admin
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fabricekabongo/loggerhead/query"
+	"github.com/fabricekabongo/loggerhead/world"
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+// LocationBody is the body of a PUT of a location. TTL is a duration like 30s, left out for none.
+type LocationBody struct {
+	Lat        *float64          `json:"lat"`
+	Lon        *float64          `json:"lon"`
+	TTL        string            `json:"ttl"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// handleREST adds the REST API to the mux: the locations are saved and deleted through the write path, like the
+// write port does, and read through the read engine, answering in JSON or GeoJSON.
+func (o *OpsServer) handleREST(mux *http.ServeMux) {
+	mux.Handle("GET /openapi.json", o.OpenAPI())
+	mux.Handle("PUT /ns/{ns}/locations/{id}", o.PutLocation())
+	mux.Handle("GET /ns/{ns}/locations/{id}", o.GetLocation())
+	mux.Handle("DELETE /ns/{ns}/locations/{id}", o.DeleteLocation())
+	mux.Handle("GET /ns/{ns}/search", o.Search())
+}
+
+// OpenAPI serves the OpenAPI document of the REST API.
+func (*OpsServer) OpenAPI() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		_, err := w.Write(openAPI)
+		if err != nil {
+			log.Println("Failed to write the OpenAPI document: ", err)
+		}
+	})
+}
+
+// PutLocation saves the location of the JSON body, a LocationBody.
+func (o *OpsServer) PutLocation() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body LocationBody
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			writeRESTError(w, &query.Error{Code: query.CodeSyntax, Message: "the body must be a JSON object: " + err.Error()})
+			return
+		}
+
+		if body.Lat == nil || body.Lon == nil {
+			writeRESTError(w, &query.Error{Code: query.CodeSyntax, Message: "lat and lon are required"})
+			return
+		}
+
+		save := world.BatchSave{Id: r.PathValue("id"), Lat: *body.Lat, Lon: *body.Lon, Attributes: body.Attributes}
+		if body.TTL != "" {
+			save.TTL, err = time.ParseDuration(body.TTL)
+			if err != nil || save.TTL <= 0 {
+				writeRESTError(w, &query.Error{Code: query.CodeBadTTL, Message: "Invalid duration value for ttl"})
+				return
+			}
+		}
+
+		o.answerREST(w, r, o.writer, query.FormatSave(r.PathValue("ns"), save), "", false)
+	})
+}
+
+// GetLocation answers the location as a JSON object, or 404 when the namespace has no location of the id.
+func (o *OpsServer) GetLocation() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o.answerREST(w, r, o.reader, "GET "+query.Quote(r.PathValue("ns"))+" "+query.Quote(r.PathValue("id")), "", true)
+	})
+}
+
+// DeleteLocation deletes the location.
+func (o *OpsServer) DeleteLocation() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o.answerREST(w, r, o.writer, "DELETE "+query.Quote(r.PathValue("ns"))+" "+query.Quote(r.PathValue("id")), "", false)
+	})
+}
+
+// Search answers the locations in a box, bbox=MinLon,MinLat,MaxLon,MaxLat with limit and cursor to page through
+// them, or around a point, near=Lon,Lat with radius in meters, k for the k nearest, or both. where filters them
+// by their attributes. The answer is a GeoJSON FeatureCollection, or NDJSON with format=ndjson.
+func (o *OpsServer) Search() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		format := params.Get("format")
+		if format == "" {
+			format = "GEOJSON"
+		}
+		if _, ok := query.JSON(format); !ok {
+			writeRESTError(w, &query.Error{Code: query.CodeBadValue, Message: "unsupported format, expected NDJSON or GEOJSON"})
+			return
+		}
+
+		ns := query.Quote(r.PathValue("ns"))
+		bbox, near := params.Get("bbox"), params.Get("near")
+
+		var q string
+		switch {
+		case bbox != "" && near == "":
+			box := strings.Split(bbox, ",")
+			if len(box) != 4 {
+				writeRESTError(w, &query.Error{Code: query.CodeSyntax, Message: "bbox must be MinLon,MinLat,MaxLon,MaxLat"})
+				return
+			}
+
+			q = "POLY " + ns + " " + quoteAll(box[1], box[0], box[3], box[2])
+		case near != "" && bbox == "":
+			point := strings.Split(near, ",")
+			if len(point) != 2 {
+				writeRESTError(w, &query.Error{Code: query.CodeSyntax, Message: "near must be Lon,Lat"})
+				return
+			}
+
+			radius, k := params.Get("radius"), params.Get("k")
+			switch {
+			case k != "":
+				q = "NEAREST " + ns + " " + quoteAll(point[1], point[0], k)
+				if radius != "" {
+					q += " " + query.Quote(radius)
+				}
+			case radius != "":
+				q = "RADIUS " + ns + " " + quoteAll(point[1], point[0], radius)
+			default:
+				writeRESTError(w, &query.Error{Code: query.CodeSyntax, Message: "near takes a radius, a k or both"})
+				return
+			}
+		default:
+			writeRESTError(w, &query.Error{Code: query.CodeSyntax, Message: "search takes either a bbox or near"})
+			return
+		}
+
+		if where := params.Get("where"); where != "" {
+			q += " WHERE " + where
+		}
+
+		if bbox != "" {
+			if limit := params.Get("limit"); limit != "" {
+				q += " LIMIT " + query.Quote(limit)
+			}
+			if cursor := params.Get("cursor"); cursor != "" {
+				q += " CURSOR " + query.Quote(cursor)
+			}
+		}
+
+		o.answerREST(w, r, o.reader, q, format, false)
+	})
+}
+
+// answerREST streams the engine's answer to the query in the JSON format of the name, or as one JSON object without
+// a name. The status of the response is the one of the error the answer starts with, if any; an error once the rows
+// are streaming ends the body. single answers the one location of a GET, and 404 when there is none.
+func (o *OpsServer) answerREST(w http.ResponseWriter, r *http.Request, engine query.EngineInterface, q, name string, single bool) {
+	streamer, ok := engine.(query.StreamEngineInterface)
+	if !ok {
+		writeRESTError(w, errors.New("the engine cannot answer the REST API"))
+		return
+	}
+
+	response := &restResponse{ResponseWriter: w, status: http.StatusOK, contentType: "application/json"}
+	switch strings.ToUpper(name) {
+	case "":
+		name = "NDJSON"
+	case "NDJSON":
+		response.contentType = "application/x-ndjson"
+	case "GEOJSON":
+		response.contentType = "application/geo+json"
+	}
+	protocol, _ := query.JSON(name)
+
+	err := streamer.StreamQuery(r.Context(), q, func(statement *query.Statement) query.Format {
+		return &restFormat{Format: protocol(statement), response: response, single: single}
+	}, response)
+	if err != nil {
+		log.Println("Failed to write the REST response: ", err)
+	}
+}
+
+// restResponse holds back the status of the response until its first write, for the format to set it.
+type restResponse struct {
+	http.ResponseWriter
+	status      int
+	contentType string
+	written     bool
+}
+
+func (r *restResponse) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	if !r.written {
+		r.written = true
+		r.Header().Set("Content-Type", r.contentType)
+		r.WriteHeader(r.status)
+	}
+
+	return r.ResponseWriter.Write(data)
+}
+
+// restFormat is a JSON format of the query engine telling the response the status of the errors starting an answer.
+type restFormat struct {
+	query.Format
+	response *restResponse
+	// single answers a GET: the location alone, without the end of the answer.
+	single bool
+	found  bool
+}
+
+func (f *restFormat) Location(location *world.Location) string {
+	f.found = true
+
+	return f.Format.Location(location)
+}
+
+func (f *restFormat) End(result string) string {
+	if !f.single {
+		return f.Format.End(result)
+	}
+
+	if !f.found {
+		return f.Error(&query.Error{Code: query.CodeLocationNotFound, Message: "location not found"})
+	}
+
+	return ""
+}
+
+func (f *restFormat) Error(err error) string {
+	if !f.response.written {
+		f.response.status = query.ErrorStatus(err)
+	}
+
+	return f.Format.Error(err)
+}
+
+// writeRESTError answers an error found before the query is sent to an engine.
+func writeRESTError(w http.ResponseWriter, err error) {
+	protocol, _ := query.JSON("NDJSON")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(query.ErrorStatus(err))
+
+	_, writeErr := w.Write([]byte(protocol(&query.Statement{}).Error(err)))
+	if writeErr != nil {
+		log.Println("Failed to write the REST response: ", writeErr)
+	}
+}
+
+// quoteAll quotes the words taken from the URL, so each stays one argument of the query.
+func quoteAll(words ...string) string {
+	for i, word := range words {
+		words[i] = query.Quote(strings.TrimSpace(word))
+	}
+
+	return strings.Join(words, " ")
+}
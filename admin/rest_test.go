@@ -0,0 +1,147 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fabricekabongo/loggerhead/config"
+	"github.com/fabricekabongo/loggerhead/query"
+	"github.com/fabricekabongo/loggerhead/world"
+)
+
+// recordingEngine answers with the engine it wraps and keeps the queries it ran, like the cluster broadcasts them.
+type recordingEngine struct {
+	engine  *query.Engine
+	queries []string
+}
+
+func (e *recordingEngine) ExecuteQuery(q string) string {
+	e.queries = append(e.queries, q)
+	return e.engine.ExecuteQuery(q)
+}
+
+func (e *recordingEngine) StreamQuery(ctx context.Context, q string, protocol query.Protocol, out io.Writer) error {
+	e.queries = append(e.queries, q)
+	return e.engine.StreamQuery(ctx, q, protocol, out)
+}
+
+func TestREST(t *testing.T) {
+	w := world.NewWorld()
+	w.SetStrict(true)
+	_ = w.Save("fleet", "seed", 50, 50)
+
+	writer := &recordingEngine{engine: query.NewWriteQueryEngine(w)}
+	ops := NewOpsServer(nil, config.Config{}, nil, w, writer, query.NewReadQueryEngine(w))
+
+	mux := http.NewServeMux()
+	ops.handleREST(mux)
+
+	request := func(method, target, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+		return recorder
+	}
+
+	t.Run("should save through the writer", func(t *testing.T) {
+		response := request(http.MethodPut, "/ns/fleet/locations/truck%201", `{"lat":12.5,"lon":13.25,"ttl":"1m","attributes":{"status":"available"}}`)
+		if response.Code != http.StatusOK || response.Body.String() != `{"status":200,"result":"saved"}`+"\n" {
+			t.Fatalf("unexpected answer %d %q", response.Code, response.Body.String())
+		}
+
+		if len(writer.queries) != 1 || writer.queries[0] != `SAVE fleet "truck 1" 12.5 13.25 TTL 1m0s status=available` {
+			t.Errorf("expected the save to go through the writer, got %q", writer.queries)
+		}
+
+		location, ok := w.GetLocation("fleet", "truck 1")
+		if !ok || location.Lon() != 13.25 || location.Attributes()["status"] != "available" {
+			t.Errorf("expected the location to be saved")
+		}
+	})
+
+	t.Run("should answer the location as a JSON object", func(t *testing.T) {
+		response := request(http.MethodGet, "/ns/fleet/locations/truck%201", "")
+		if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("unexpected answer %d %q", response.Code, response.Body.String())
+		}
+
+		var location map[string]any
+		err := json.Unmarshal(response.Body.Bytes(), &location)
+		if err != nil || location["id"] != "truck 1" || location["lat"] != 12.5 || location["updated_at"] == "" {
+			t.Errorf("unexpected location %q: %v", response.Body.String(), err)
+		}
+	})
+
+	t.Run("should search a box and around a point", func(t *testing.T) {
+		response := request(http.MethodGet, "/ns/fleet/search?bbox=13,12,14,13&where=status%3Davailable", "")
+		if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "application/geo+json" {
+			t.Fatalf("unexpected answer %d %q", response.Code, response.Body.String())
+		}
+
+		var collection struct {
+			Type     string `json:"type"`
+			Features []struct {
+				Id string `json:"id"`
+			} `json:"features"`
+		}
+		err := json.Unmarshal(response.Body.Bytes(), &collection)
+		if err != nil || collection.Type != "FeatureCollection" || len(collection.Features) != 1 || collection.Features[0].Id != "truck 1" {
+			t.Errorf("unexpected collection %q: %v", response.Body.String(), err)
+		}
+
+		response = request(http.MethodGet, "/ns/fleet/search?near=13.25,12.5&k=5&format=ndjson", "")
+		lines := strings.Split(strings.TrimSuffix(response.Body.String(), "\n"), "\n")
+		if response.Code != http.StatusOK || len(lines) != 3 || !strings.Contains(lines[0], `"id":"truck 1","lat":12.5,"lon":13.25,"distance":0,`) ||
+			!strings.Contains(lines[1], `"id":"seed"`) || lines[2] != `{"status":200,"result":"done"}` {
+			t.Errorf("unexpected neighbors %d %q", response.Code, response.Body.String())
+		}
+	})
+
+	t.Run("should answer the errors with their status", func(t *testing.T) {
+		for _, exchange := range []struct {
+			method, target, body string
+			status               int
+			expected             string
+		}{
+			{http.MethodGet, "/ns/fleet/locations/nobody", "", 404, `{"status":404,"code":"E_LOCATION_NOT_FOUND","message":"location not found"}`},
+			{http.MethodGet, "/ns/typo/locations/a", "", 404, `{"status":404,"code":"E_NAMESPACE_NOT_FOUND","message":"namespace not found"}`},
+			{http.MethodPut, "/ns/fleet/locations/a", `{"lat":91,"lon":0}`, 400, `{"status":400,"code":"E_BAD_LAT","message":"invalid latitude"}`},
+			{http.MethodPut, "/ns/fleet/locations/a", `{"lat":1}`, 400, `{"status":400,"code":"E_SYNTAX","message":"lat and lon are required"}`},
+			{http.MethodGet, "/ns/fleet/search?bbox=1,2,3", "", 400, `{"status":400,"code":"E_SYNTAX","message":"bbox must be MinLon,MinLat,MaxLon,MaxLat"}`},
+			{http.MethodGet, "/ns/fleet/search?near=1,2", "", 400, `{"status":400,"code":"E_SYNTAX","message":"near takes a radius, a k or both"}`},
+			{http.MethodGet, "/ns/typo/search?bbox=0,0,1,1", "", 404, `{"type":"FeatureCollection","features":[],"status":404,"code":"E_NAMESPACE_NOT_FOUND","message":"namespace not found"}`},
+		} {
+			response := request(exchange.method, exchange.target, exchange.body)
+			if response.Code != exchange.status || response.Body.String() != exchange.expected+"\n" {
+				t.Errorf("%s %s: expected %d %q got %d %q", exchange.method, exchange.target, exchange.status, exchange.expected,
+					response.Code, response.Body.String())
+			}
+		}
+	})
+
+	t.Run("should delete through the writer and serve the OpenAPI document", func(t *testing.T) {
+		response := request(http.MethodDelete, "/ns/fleet/locations/truck%201", "")
+		if response.Code != http.StatusOK || writer.queries[len(writer.queries)-1] != `DELETE fleet "truck 1"` {
+			t.Errorf("unexpected answer %d %q", response.Code, response.Body.String())
+		}
+
+		if _, ok := w.GetLocation("fleet", "truck 1"); ok {
+			t.Error("expected the location to be deleted")
+		}
+
+		response = request(http.MethodGet, "/openapi.json", "")
+		var document struct {
+			OpenAPI string         `json:"openapi"`
+			Paths   map[string]any `json:"paths"`
+		}
+		err := json.Unmarshal(response.Body.Bytes(), &document)
+		if err != nil || document.OpenAPI == "" || document.Paths["/ns/{ns}/search"] == nil {
+			t.Errorf("unexpected OpenAPI document: %v", err)
+		}
+	})
+}
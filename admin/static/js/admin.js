@@ -21,6 +21,16 @@ function renderTableRow(data) {
 $(document).ready(function() {
     $('.data-placeholder').addClass('d-none');
 
+    $('#snapshot-button').on('click', function() {
+        const $result = $('#snapshot-result');
+
+        $.post('/snapshot', function(data) {
+            $result.text('Snapshot written to ' + data.Path);
+        }).fail(function(xhr) {
+            $result.text('Snapshot failed: ' + xhr.responseText);
+        });
+    });
+
     $table = $('#data-table');
     $tbody = $table.find('tbody');
 
@@ -30,7 +30,7 @@ func TestBroadcastDelegateNotifyMsgExecutesCommand(t *testing.T) {
 	}
 
 	if location.Lat() != 1 || location.Lon() != 2 {
-		t.Fatalf("unexpected location coordinates: %v", location)
+		t.Fatalf("unexpected location coordinates: %s", location.String())
 	}
 }
 
@@ -65,7 +65,7 @@ func TestBroadcastDelegateLocalState(t *testing.T) {
 
 	loc, ok := restored.GetLocation("ns", "loc")
 	if !ok || loc.Lat() != 10 || loc.Lon() != 20 {
-		t.Fatalf("expected saved location after restoration, got %#v, present=%v", loc, ok)
+		t.Fatalf("expected saved location after restoration, got %s, present=%v", loc.String(), ok)
 	}
 }
 
@@ -81,6 +81,6 @@ func TestBroadcastDelegateMergeRemoteState(t *testing.T) {
 
 	loc, ok := local.GetLocation("ns", "loc")
 	if !ok || loc.Lat() != 3 || loc.Lon() != 4 {
-		t.Fatalf("expected merged location, got %#v, present=%v", loc, ok)
+		t.Fatalf("expected merged location, got %s, present=%v", loc.String(), ok)
 	}
 }
@@ -50,6 +50,11 @@ func (c *Cluster) Broadcasts() *memberlist.TransmitLimitedQueue {
 	return c.broadcasts
 }
 
+// BroadcastCommand sends a write command to the other nodes, as the EngineDecorator does for the commands it runs.
+func (c *Cluster) BroadcastCommand(command string) {
+	c.broadcasts.QueueBroadcast(NewLocationBroadcast(command))
+}
+
 func (c *Cluster) MemberList() *memberlist.Memberlist {
 	return c.memberList
 }
@@ -2,10 +2,16 @@ package clustering
 
 import (
 	"context"
+	"io"
 
 	"github.com/fabricekabongo/loggerhead/query"
+	"github.com/fabricekabongo/loggerhead/world"
 )
 
+// maxBroadcastSize is the longest command broadcast as is. The gossip never sends a broadcast longer than what
+// fits in a packet, so the longer batches are cut into several.
+const maxBroadcastSize = 1024
+
 type EngineDecorator struct {
 	cluster     *Cluster
 	engine      *query.Engine
@@ -20,6 +26,22 @@ func (e EngineDecorator) ExecuteQuery(query string) string {
 	return e.engine.ExecuteQuery(query)
 }
 
+// StreamQuery answers the query in the protocol's version, then broadcasts it like ExecuteQuery.
+func (e EngineDecorator) StreamQuery(ctx context.Context, query string, protocol query.Protocol, out io.Writer) error {
+	defer func() {
+		e.commandChan <- query
+	}()
+	return e.engine.StreamQuery(ctx, query, protocol, out)
+}
+
+// Save saves the location, then broadcasts it as the SAVE query saving it.
+func (e EngineDecorator) Save(ns string, save world.BatchSave, protocol query.Protocol, out io.Writer) error {
+	defer func() {
+		e.commandChan <- query.FormatSave(ns, save)
+	}()
+	return e.engine.Save(ns, save, protocol, out)
+}
+
 func NewEngineDecorator(ctx context.Context, cluster *Cluster, engine *query.Engine) query.EngineInterface {
 	eng := &EngineDecorator{
 		cluster:     cluster,
@@ -39,7 +61,9 @@ func (e EngineDecorator) commandLoop() {
 		case <-e.ctx.Done():
 			return
 		case command := <-e.commandChan:
-			e.cluster.Broadcasts().QueueBroadcast(NewLocationBroadcast(command))
+			for _, batch := range query.SplitBatch(command, maxBroadcastSize) {
+				e.cluster.Broadcasts().QueueBroadcast(NewLocationBroadcast(batch))
+			}
 		}
 	}
 }
@@ -2,6 +2,8 @@ package clustering
 
 import (
 	"context"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -44,6 +46,86 @@ func TestEngineDecoratorQueuesBroadcasts(t *testing.T) {
 	}
 }
 
+func TestEngineDecoratorSplitsLongBatches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cluster := &Cluster{broadcasts: &memberlist.TransmitLimitedQueue{NumNodes: func() int { return 1 }, RetransmitMult: 1}}
+	engine := query.NewWriteQueryEngine(world.NewWorld())
+	decorator := NewEngineDecorator(ctx, cluster, engine)
+
+	items := make([]string, 200)
+	for i := range items {
+		items[i] = "loc" + strconv.Itoa(i) + " 1 1"
+	}
+
+	result := decorator.ExecuteQuery("MSAVE ns " + strings.Join(items, " ; "))
+	if result != "1.0,saved,200\n" {
+		t.Fatalf("expected the batch to be saved, got %q", result)
+	}
+
+	replica := query.NewWriteQueryEngine(world.NewWorld())
+	saved := 0
+	deadline := time.Now().Add(time.Second)
+	for saved < 200 && time.Now().Before(deadline) {
+		for _, broadcast := range cluster.broadcasts.GetBroadcasts(0, 1400) {
+			if len(broadcast) > maxBroadcastSize {
+				t.Fatalf("expected broadcasts of at most %d bytes, got %d", maxBroadcastSize, len(broadcast))
+			}
+
+			_ = replica.ExecuteQuery(string(broadcast))
+			saved = len(replica.World().QueryRange("ns", -90, 90, -180, 180))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if saved != 200 {
+		t.Fatalf("expected the batches to save the 200 locations, got %d", saved)
+	}
+}
+
+func TestEngineDecoratorBroadcastsSavesAsQueries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cluster := &Cluster{broadcasts: &memberlist.TransmitLimitedQueue{NumNodes: func() int { return 1 }, RetransmitMult: 1}}
+	engine := query.NewWriteQueryEngine(world.NewWorld())
+	decorator := NewEngineDecorator(ctx, cluster, engine).(*EngineDecorator)
+
+	save := world.BatchSave{Id: "truck 1", Lat: 0.000000123, Lon: -45.987654321, Attributes: world.Attributes{"color": "red"}}
+
+	var answer strings.Builder
+	err := decorator.Save("ns", save, query.V1, &answer)
+	if err != nil || answer.String() != "1.0,saved\n" {
+		t.Fatalf("expected save confirmation, got %q: %v", answer.String(), err)
+	}
+
+	var broadcasts [][]byte
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		broadcasts = cluster.broadcasts.GetBroadcasts(0, 1024)
+		if len(broadcasts) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(broadcasts) != 1 || string(broadcasts[0]) != query.FormatSave("ns", save) {
+		t.Fatalf("expected the save broadcast as its query, got %q", broadcasts)
+	}
+
+	replica := query.NewWriteQueryEngine(world.NewWorld())
+	_ = replica.ExecuteQuery(string(broadcasts[0]))
+
+	location, ok := replica.World().GetLocation("ns", "truck 1")
+	if !ok {
+		t.Fatal("expected the replica to save the location")
+	}
+	if location.Lat() != save.Lat || location.Lon() != save.Lon || location.Attributes()["color"] != "red" {
+		t.Fatalf("expected the location as saved, got %v,%v %v", location.Lat(), location.Lon(), location.Attributes())
+	}
+}
+
 func TestEngineDecoratorStopsWithCanceledContext(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cluster := &Cluster{broadcasts: &memberlist.TransmitLimitedQueue{NumNodes: func() int { return 1 }, RetransmitMult: 1}}
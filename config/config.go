@@ -34,6 +34,45 @@ var (
 
 	envMaxEOFWait, envMaxEOFWaitErr = strconv.Atoi(os.Getenv("MAX_EOF_WAIT"))
 	flagMaxEOFWait                  int
+
+	envDataDir  = os.Getenv("DATA_DIR")
+	flagDataDir string
+
+	envWALSync  = os.Getenv("WAL_SYNC")
+	flagWALSync string
+
+	envWALSyncInterval, envWALSyncIntervalErr = strconv.Atoi(os.Getenv("WAL_SYNC_INTERVAL"))
+	flagWALSyncInterval                       int
+
+	envWALBatchSize, envWALBatchSizeErr = strconv.Atoi(os.Getenv("WAL_BATCH_SIZE"))
+	flagWALBatchSize                    int
+
+	envSnapshotInterval, envSnapshotIntervalErr = strconv.Atoi(os.Getenv("SNAPSHOT_INTERVAL"))
+	flagSnapshotInterval                        int
+
+	envSnapshotRetain, envSnapshotRetainErr = strconv.Atoi(os.Getenv("SNAPSHOT_RETAIN"))
+	flagSnapshotRetain                      int
+
+	envRestoreSnapshot  = os.Getenv("RESTORE_SNAPSHOT")
+	flagRestoreSnapshot string
+
+	envExpiryInterval, envExpiryIntervalErr = strconv.Atoi(os.Getenv("EXPIRY_INTERVAL"))
+	flagExpiryInterval                      int
+
+	envSubBuffer, envSubBufferErr = strconv.Atoi(os.Getenv("SUB_BUFFER"))
+	flagSubBuffer                 int
+
+	envSubSlowConsumer  = os.Getenv("SUB_SLOW_CONSUMER")
+	flagSubSlowConsumer string
+
+	envFenceWebhook  = os.Getenv("FENCE_WEBHOOK")
+	flagFenceWebhook string
+
+	envConfigFile  = os.Getenv("CONFIG_FILE")
+	flagConfigFile string
+
+	envStrictNamespaces, _ = strconv.ParseBool(os.Getenv("STRICT_NAMESPACES"))
+	flagStrictNamespaces   bool
 )
 
 type Config struct {
@@ -46,6 +85,28 @@ type Config struct {
 	HttpPort       int
 	ClusterPort    int
 	MaxEOFWait     time.Duration
+	// DataDir is where the write-ahead log lives. Durability is disabled when it is empty.
+	DataDir         string
+	WALSync         string
+	WALSyncInterval time.Duration
+	WALBatchSize    int
+	// SnapshotInterval is how often the world is snapshotted and the log truncated. Zero disables it.
+	SnapshotInterval time.Duration
+	SnapshotRetain   int
+	// RestoreSnapshot is a snapshot file to start from instead of the data directory's own state.
+	RestoreSnapshot string
+	// ExpiryInterval is how often locations whose TTL ran out are removed. Zero disables expiry.
+	ExpiryInterval time.Duration
+	// SubBuffer is how many events a subscription holds for its subscriber before SubSlowConsumer applies.
+	SubBuffer int
+	// SubSlowConsumer is what happens when a subscriber falls behind: "drop" its events or "disconnect" it.
+	SubSlowConsumer string
+	// FenceWebhook is a URL the fence events are posted to. Empty disables it.
+	FenceWebhook string
+	// ConfigFile is the YAML file with the namespaces' index options. See File.
+	ConfigFile string
+	// StrictNamespaces makes reads against a namespace that does not exist fail instead of finding nothing.
+	StrictNamespaces bool
 }
 
 func parseFlags() {
@@ -54,10 +115,25 @@ func parseFlags() {
 	flag.IntVar(&flagMaxConnections, "max-connections", 20, "Max connections concurrently per port (eg: 20 read, 20 write). Default: 20. Remember this database is supposed to be called by your backend services not by your consumers. So you shouldn't need too many connections.")
 	flag.IntVar(&flagReadPort, "read-port", 19998, "Read port. Default: 19998")
 	flag.IntVar(&flagWritePort, "write-port", 19999, "Write port. Default: 19999")
-	flag.IntVar(&flagSubPort, "sub-port", 20001, "Subscription port. Default: 20001")
+	flag.IntVar(&flagSubPort, "sub-port", 20002, "Subscription port. Default: 20002")
 	flag.IntVar(&flagHttpPort, "http-port", 20000, "HTTP port. Default: 20000")
 	flag.IntVar(&flagClusterPort, "cluster-port", 20001, "Cluster port. Default: 20001")
 	flag.IntVar(&flagMaxEOFWait, "max-eof-wait", 30, "Max EOF wait time in seconds. Default: 30")
+	flag.StringVar(&flagDataDir, "data-dir", "", "Directory of the write-ahead log. Leave empty to keep everything in memory only.")
+	flag.StringVar(&flagWALSync, "wal-sync", "interval", "When the write-ahead log is fsynced: always (every write), batch (every wal-batch-size writes) or interval (every wal-sync-interval). Default: interval")
+	flag.IntVar(&flagWALSyncInterval, "wal-sync-interval", 1000, "Write-ahead log sync interval in milliseconds, also the longest a batch waits. Default: 1000")
+	flag.IntVar(&flagWALBatchSize, "wal-batch-size", 128, "Number of writes per fsync with the batch sync policy. Default: 128")
+	flag.IntVar(&flagSnapshotInterval, "snapshot-interval", 300, "Seconds between snapshots of the world, which truncate the write-ahead log. 0 disables them. Default: 300")
+	flag.IntVar(&flagSnapshotRetain, "snapshot-retain", 2, "Number of snapshots kept in the data directory. Default: 2")
+	flag.StringVar(&flagRestoreSnapshot, "restore-snapshot", "", "Snapshot file to restore on startup. The data directory is reset to it and its write-ahead log is not replayed.")
+
+	flag.IntVar(&flagExpiryInterval, "expiry-interval", 1000, "Milliseconds between removals of the locations whose TTL ran out. 0 disables expiry. Default: 1000")
+
+	flag.IntVar(&flagSubBuffer, "sub-buffer", 1024, "Number of events buffered per subscription for a subscriber that falls behind. Default: 1024")
+	flag.StringVar(&flagSubSlowConsumer, "sub-slow-consumer", "drop", "What happens when a subscription's buffer is full: drop (the new events) or disconnect (the subscriber). Default: drop")
+	flag.StringVar(&flagFenceWebhook, "fence-webhook", "", "URL the fence ENTER, EXIT and DWELL events are posted to as JSON. Leave empty to disable it.")
+	flag.StringVar(&flagConfigFile, "config", "", "YAML file with the default index options and the namespaces to create on startup.")
+	flag.BoolVar(&flagStrictNamespaces, "strict-namespaces", false, "Answer reads and subscriptions against a namespace that does not exist with an error. Default: false")
 
 	flag.Parse()
 }
@@ -66,15 +142,28 @@ func GetConfig() Config {
 	parseFlags()
 
 	return Config{
-		ClusterDNS:     processClusterDNS(),
-		MaxConnections: processMaxConnections(),
-		SeedNode:       processSeedNode(),
-		ReadPort:       processReadPort(),
-		WritePort:      processWritePort(),
-		SubPort:        processSubPort(),
-		HttpPort:       processHttpPort(),
-		ClusterPort:    processClusterPort(),
-		MaxEOFWait:     processMaxEOFWait(),
+		ClusterDNS:       processClusterDNS(),
+		MaxConnections:   processMaxConnections(),
+		SeedNode:         processSeedNode(),
+		ReadPort:         processReadPort(),
+		WritePort:        processWritePort(),
+		SubPort:          processSubPort(),
+		HttpPort:         processHttpPort(),
+		ClusterPort:      processClusterPort(),
+		MaxEOFWait:       processMaxEOFWait(),
+		DataDir:          processDataDir(),
+		WALSync:          processWALSync(),
+		WALSyncInterval:  processWALSyncInterval(),
+		WALBatchSize:     processWALBatchSize(),
+		SnapshotInterval: processSnapshotInterval(),
+		SnapshotRetain:   processSnapshotRetain(),
+		RestoreSnapshot:  processRestoreSnapshot(),
+		ExpiryInterval:   processExpiryInterval(),
+		SubBuffer:        processSubBuffer(),
+		SubSlowConsumer:  processSubSlowConsumer(),
+		FenceWebhook:     processFenceWebhook(),
+		ConfigFile:       processConfigFile(),
+		StrictNamespaces: processStrictNamespaces(),
 	}
 }
 
@@ -146,3 +235,103 @@ func processClusterPort() int {
 	}
 	return flagClusterPort
 }
+
+func processDataDir() string {
+	if flagDataDir != "" {
+		return flagDataDir
+	}
+	if envDataDir != "" {
+		return envDataDir
+	}
+	return ""
+}
+
+func processWALSync() string {
+	if envWALSync != "" {
+		return envWALSync
+	}
+	return flagWALSync
+}
+
+func processWALSyncInterval() time.Duration {
+	if envWALSyncIntervalErr == nil && envWALSyncInterval > 0 {
+		return time.Duration(envWALSyncInterval) * time.Millisecond
+	}
+	return time.Duration(flagWALSyncInterval) * time.Millisecond
+}
+
+func processWALBatchSize() int {
+	if envWALBatchSizeErr == nil && envWALBatchSize > 0 {
+		return envWALBatchSize
+	}
+	return flagWALBatchSize
+}
+
+func processSnapshotInterval() time.Duration {
+	if envSnapshotIntervalErr == nil && envSnapshotInterval >= 0 {
+		return time.Duration(envSnapshotInterval) * time.Second
+	}
+	return time.Duration(flagSnapshotInterval) * time.Second
+}
+
+func processSnapshotRetain() int {
+	if envSnapshotRetainErr == nil && envSnapshotRetain > 0 {
+		return envSnapshotRetain
+	}
+	return flagSnapshotRetain
+}
+
+func processRestoreSnapshot() string {
+	if flagRestoreSnapshot != "" {
+		return flagRestoreSnapshot
+	}
+	if envRestoreSnapshot != "" {
+		return envRestoreSnapshot
+	}
+	return ""
+}
+
+func processExpiryInterval() time.Duration {
+	if envExpiryIntervalErr == nil && envExpiryInterval >= 0 {
+		return time.Duration(envExpiryInterval) * time.Millisecond
+	}
+	return time.Duration(flagExpiryInterval) * time.Millisecond
+}
+
+func processSubBuffer() int {
+	if envSubBufferErr == nil && envSubBuffer > 0 {
+		return envSubBuffer
+	}
+	return flagSubBuffer
+}
+
+func processSubSlowConsumer() string {
+	if envSubSlowConsumer != "" {
+		return envSubSlowConsumer
+	}
+	return flagSubSlowConsumer
+}
+
+func processFenceWebhook() string {
+	if flagFenceWebhook != "" {
+		return flagFenceWebhook
+	}
+	if envFenceWebhook != "" {
+		return envFenceWebhook
+	}
+	return ""
+}
+
+func processConfigFile() string {
+	if flagConfigFile != "" {
+		return flagConfigFile
+	}
+	if envConfigFile != "" {
+		return envConfigFile
+	}
+	return ""
+}
+
+func processStrictNamespaces() bool {
+	return flagStrictNamespaces || envStrictNamespaces
+}
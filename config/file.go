@@ -0,0 +1,107 @@
+package config
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/fabricekabongo/loggerhead/world"
+	"gopkg.in/yaml.v3"
+)
+
+// File is the YAML configuration file:
+//
+//	index:              # options of the namespaces created by their first use
+//	  capacity: 500
+//	namespaces:         # namespaces created on startup, the options left out taking index's
+//	  paris:
+//	    capacity: 100
+//	    predivide: 2
+//	    extent: {lat1: 48.8, lon1: 2.2, lat2: 48.9, lon2: 2.5}
+//	  ships:
+//	    backend: rtree
+type File struct {
+	Index      world.IndexOptions
+	Namespaces map[string]world.IndexOptions
+}
+
+// fileLayout is the file's layout, only decoded to reject unknown keys. The options are decoded from the raw
+// nodes over the defaults, so the ones left out keep them.
+type fileLayout struct {
+	Index      world.IndexOptions            `yaml:"index"`
+	Namespaces map[string]world.IndexOptions `yaml:"namespaces"`
+}
+
+type rawFile struct {
+	Index      yaml.Node            `yaml:"index"`
+	Namespaces map[string]yaml.Node `yaml:"namespaces"`
+}
+
+// LoadFile reads and validates a configuration file.
+func LoadFile(path string) (File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return File{}, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&fileLayout{})
+	if err != nil {
+		return File{}, err
+	}
+
+	var raw rawFile
+	err = yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return File{}, err
+	}
+
+	file := File{
+		Index:      world.DefaultIndexOptions(),
+		Namespaces: make(map[string]world.IndexOptions, len(raw.Namespaces)),
+	}
+
+	if !raw.Index.IsZero() {
+		err = raw.Index.Decode(&file.Index)
+		if err != nil {
+			return File{}, err
+		}
+	}
+
+	err = file.Index.Validate()
+	if err != nil {
+		return File{}, &FileError{Section: "index", Err: err}
+	}
+
+	for name, node := range raw.Namespaces {
+		options := file.Index
+
+		err = node.Decode(&options)
+		if err != nil {
+			return File{}, err
+		}
+
+		err = options.Validate()
+		if err != nil {
+			return File{}, &FileError{Section: "namespaces." + name, Err: err}
+		}
+
+		file.Namespaces[name] = options
+	}
+
+	return file, nil
+}
+
+// FileError is an invalid section of the configuration file.
+type FileError struct {
+	Section string
+	Err     error
+}
+
+func (e *FileError) Error() string {
+	return e.Section + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
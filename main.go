@@ -15,6 +15,7 @@ import (
 	"github.com/fabricekabongo/loggerhead/config"
 	"github.com/fabricekabongo/loggerhead/query"
 	"github.com/fabricekabongo/loggerhead/server"
+	"github.com/fabricekabongo/loggerhead/storage"
 	"github.com/fabricekabongo/loggerhead/world"
 )
 
@@ -25,9 +26,35 @@ func main() {
 	cfg := config.GetConfig()
 
 	worldMap := world.NewWorld()
+	worldMap.SetStrict(cfg.StrictNamespaces)
+
+	file := loadConfigFile(cfg)
+	err := worldMap.SetDefaultIndexOptions(file.Index)
+	if err != nil {
+		log.Fatal("Invalid index options: ", err)
+	}
+
+	wal := openWriteAheadLog(cfg, worldMap)
+	if wal != nil {
+		defer closeWriteAheadLog(wal)
+	}
+
+	// The namespaces of the file are created after the replay, so the file wins over the options in the log.
+	for name, options := range file.Namespaces {
+		err := worldMap.CreateNamespace(name, options)
+		if err != nil {
+			log.Fatal("Failed to create the namespace ", name, ": ", err)
+		}
+	}
+
 	readEngine := query.NewReadQueryEngine(worldMap)
 	writeEngine := query.NewWriteQueryEngine(worldMap)
-	// subscriberEngine := query.NewSubscriberQueryEngine(worldMap)
+
+	slowConsumerPolicy, err := world.ParseSlowConsumerPolicy(cfg.SubSlowConsumer)
+	if err != nil {
+		log.Fatal("Invalid slow consumer policy: ", err)
+	}
+	subscriberEngine := query.NewSubscriberQueryEngine(worldMap, cfg.SubBuffer, slowConsumerPolicy)
 
 	cluster, err := clustering.NewCluster(writeEngine, cfg)
 
@@ -49,14 +76,43 @@ func main() {
 	ClusterCtx, concel := context.WithCancel(ctx)
 	clusterEngine := clustering.NewEngineDecorator(ClusterCtx, cluster, writeEngine)
 
-	opsServer := admin.NewOpsServer(cluster, cfg)
+	var snapshotter admin.Snapshotter
+	if wal != nil {
+		snapshotter = func() (string, error) {
+			return wal.Snapshot(worldMap)
+		}
+
+		if cfg.SnapshotInterval > 0 {
+			go wal.RunSnapshots(ClusterCtx, worldMap, cfg.SnapshotInterval)
+		}
+	}
+
+	// Every node expires its own locations; broadcasting the deletes also covers nodes that missed the save.
+	if cfg.ExpiryInterval > 0 {
+		go worldMap.RunExpiry(ClusterCtx, cfg.ExpiryInterval, func(ns, id string) {
+			cluster.BroadcastCommand("DELETE " + query.Quote(ns) + " " + query.Quote(id))
+		})
+	}
+
+	// The webhook drops what it cannot keep up with rather than going silent.
+	if cfg.FenceWebhook != "" {
+		fenceEvents, err := worldMap.WatchFences("", cfg.SubBuffer, world.DropEvents)
+		if err != nil {
+			log.Fatal("Failed to watch the fences: ", err)
+		}
+		go server.NewWebhook(cfg.FenceWebhook, 5*time.Second, fenceEvents).Run(ClusterCtx)
+	}
+
+	opsServer := admin.NewOpsServer(cluster, cfg, snapshotter, worldMap, clusterEngine, readEngine)
 	go opsServer.Start()
 
 	writer := server.NewListener(cfg.WritePort, cfg.MaxConnections, cfg.MaxEOFWait, clusterEngine) // This is the writer listener (for writes and broadcasts)
 	reader := server.NewListener(cfg.ReadPort, cfg.MaxConnections, cfg.MaxEOFWait, readEngine)     // This is the reader listener (for reads).
-	// subscriber := server.NewListener(cfg, subscriberEngine)
 
-	svr := server.NewServer([]*server.Listener{writer, reader})
+	// This is the subscriber listener (for SUBSCRIBE streams).
+	subscriber := server.NewSubscriptionListener(cfg.SubPort, cfg.MaxConnections, cfg.MaxEOFWait, subscriberEngine)
+
+	svr := server.NewServer([]*server.Listener{writer, reader, subscriber})
 
 	defer svr.Stop()
 
@@ -71,6 +127,10 @@ func main() {
 		close(sigc)
 		cancel()
 
+		if wal != nil {
+			closeWriteAheadLog(wal)
+		}
+
 		err := cluster.Close(0)
 		if err != nil {
 			return
@@ -83,18 +143,84 @@ func main() {
 	svr.Start()
 }
 
+// loadConfigFile reads the configuration file, or returns the defaults when there is none.
+func loadConfigFile(cfg config.Config) config.File {
+	if cfg.ConfigFile == "" {
+		return config.File{Index: world.DefaultIndexOptions()}
+	}
+
+	file, err := config.LoadFile(cfg.ConfigFile)
+	if err != nil {
+		log.Fatal("Failed to load the configuration file: ", err)
+	}
+
+	return file
+}
+
+// openWriteAheadLog restores the world from the data directory (or from the snapshot to restore) and then
+// records every new write in the log.
+// It returns nil when no data directory is configured.
+func openWriteAheadLog(cfg config.Config, worldMap *world.World) *storage.WAL {
+	if cfg.DataDir == "" {
+		return nil
+	}
+
+	policy, err := storage.ParseSyncPolicy(cfg.WALSync)
+	if err != nil {
+		log.Fatal("Invalid write-ahead log sync policy: ", err)
+	}
+
+	wal, err := storage.OpenWAL(cfg.DataDir, storage.Options{
+		Sync:           policy,
+		BatchSize:      cfg.WALBatchSize,
+		Interval:       cfg.WALSyncInterval,
+		SnapshotRetain: cfg.SnapshotRetain,
+	})
+	if err != nil {
+		log.Fatal("Failed to open the write-ahead log: ", err)
+	}
+
+	if cfg.RestoreSnapshot != "" {
+		err = wal.RestoreSnapshot(worldMap, cfg.RestoreSnapshot)
+		if err != nil {
+			log.Fatal("Failed to restore the snapshot: ", err)
+		}
+		log.Println("Restored snapshot ", cfg.RestoreSnapshot)
+	} else {
+		replayed, err := wal.Replay(worldMap)
+		if err != nil {
+			log.Fatal("Failed to replay the write-ahead log: ", err)
+		}
+		log.Println("Replayed ", replayed, " entries from the write-ahead log")
+	}
+
+	worldMap.SetJournal(wal)
+
+	return wal
+}
+
+func closeWriteAheadLog(wal *storage.WAL) {
+	err := wal.Close()
+	if err != nil {
+		log.Println("Failed to close the write-ahead log: ", err)
+	}
+}
+
 func printWelcomeMessage(cfg config.Config, cluster *clustering.Cluster) {
 	fmt.Println("===========================================================")
 	fmt.Println("Starting the Database Server")
 	fmt.Println("===========================================================")
 	fmt.Println("Read Port: ", cfg.ReadPort)
 	fmt.Println("Write Port: ", cfg.WritePort)
+	fmt.Println("Subscription Port: ", cfg.SubPort)
 	fmt.Println("Cluster Port: ", cfg.ClusterPort)
 	fmt.Println("Admin & Prometheus Port:", cfg.HttpPort)
 	fmt.Println("Max Connections: ", cfg.MaxConnections)
 	fmt.Println("Max EOF Wait: ", cfg.MaxEOFWait)
 	fmt.Println("Cluster DNS: ", cfg.ClusterDNS)
 	fmt.Println("Seed Node: ", cfg.SeedNode)
+	fmt.Println("Data Dir: ", cfg.DataDir)
+	fmt.Println("WAL Sync: ", cfg.WALSync)
 	fmt.Println("My IP: ", cluster.MemberList().LocalNode().Addr.String())
 	fmt.Println("Node Name: ", cluster.MemberList().LocalNode().Name)
 	fmt.Println("Node State: ", clustering.StateToString(cluster.MemberList().LocalNode().State))
@@ -0,0 +1,166 @@
+package query
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+
+	w "github.com/fabricekabongo/loggerhead/world"
+)
+
+// The kinds of the frames answering the requests of the binary protocol.
+const (
+	// frameLocation is a location: its namespace, id, latitude, longitude, when it was saved and its attributes.
+	frameLocation byte = 1
+	// frameNeighbor is a location followed by its distance in meters.
+	frameNeighbor byte = 2
+	// framePosition is a position of a trail: its namespace, id, latitude, longitude and time.
+	framePosition byte = 3
+	// frameRow is a row with no frame of its own, as the second version of the protocol writes it.
+	frameRow byte = 4
+	// frameCursor is the cursor of the next page.
+	frameCursor byte = 5
+	// frameOK ends a successful answer with its result and a count, 0 for the results without one.
+	frameOK byte = 6
+	// frameError answers an error, or ends an answer with it: its status, code and message.
+	frameError byte = 7
+)
+
+// Binary is the protocol of the binary connections: it answers the request of the id in frames of the form
+// Length uint32 | RequestID uint32 | Kind byte | Body, Length counting the bytes after it. In the bodies, numbers
+// are big-endian, coordinates and distances are float64, times are int64 nanoseconds since 1970 and texts are
+// their length as a uvarint followed by their bytes.
+func Binary(id uint32) Protocol {
+	return func(statement *Statement) Format {
+		return binaryFormat{id: id, rows: v2Format{prefix: "2.0,,"}}
+	}
+}
+
+type binaryFormat struct {
+	id uint32
+	// rows writes the rows with no frame of their own.
+	rows v2Format
+}
+
+func (f binaryFormat) Location(location *w.Location) string {
+	return f.frame(frameLocation).location(location).String()
+}
+
+func (f binaryFormat) Neighbor(neighbor w.Neighbor) string {
+	return f.frame(frameNeighbor).location(neighbor.Location).float(neighbor.Distance).String()
+}
+
+func (f binaryFormat) Position(ns, id string, position w.Position) string {
+	return f.frame(framePosition).text(ns).text(id).float(position.Lat).float(position.Lon).time(position.At).String()
+}
+
+func (f binaryFormat) Fence(ns string, fence w.Fence) string {
+	return f.row(f.rows.Fence(ns, fence))
+}
+
+func (f binaryFormat) Namespace(namespace w.NamespaceCount) string {
+	return f.row(f.rows.Namespace(namespace))
+}
+
+func (f binaryFormat) Stats(ns string, stats w.Stats) string {
+	return f.row(f.rows.Stats(ns, stats))
+}
+
+func (f binaryFormat) Cell(cell w.CellCount) string {
+	return f.row(f.rows.Cell(cell))
+}
+
+func (f binaryFormat) Cursor(cursor string) string {
+	return f.frame(frameCursor).text(cursor).String()
+}
+
+func (f binaryFormat) Item(position int, id string, err error) string {
+	return f.row(f.rows.Item(position, id, err))
+}
+
+func (f binaryFormat) Event(event w.Event) string {
+	return f.row(f.rows.Event(event))
+}
+
+func (f binaryFormat) Count(count int) string {
+	return f.frame(frameOK).text("count").count(count).String()
+}
+
+func (f binaryFormat) Saved(count int) string {
+	return f.frame(frameOK).text("saved").count(count).String()
+}
+
+func (f binaryFormat) End(result string) string {
+	return f.frame(frameOK).text(result).count(0).String()
+}
+
+func (f binaryFormat) Error(err error) string {
+	code := errorCode(err)
+
+	return f.frame(frameError).uint16(uint16(errorStatus(code))).text(code).text(err.Error()).String()
+}
+
+func (f binaryFormat) frame(kind byte) *frame {
+	return newFrame(f.id, kind)
+}
+
+// row writes a line of the second version of the protocol in a row frame, without its version, tag and new line.
+func (f binaryFormat) row(line string) string {
+	return f.frame(frameRow).text(strings.TrimSuffix(strings.TrimPrefix(line, f.rows.prefix), "\n")).String()
+}
+
+// frame is a frame being written: its length is set once its body is complete.
+type frame struct {
+	data []byte
+}
+
+func newFrame(id uint32, kind byte) *frame {
+	data := make([]byte, 4, 64)
+	data = binary.BigEndian.AppendUint32(data, id)
+
+	return &frame{data: append(data, kind)}
+}
+
+func (f *frame) location(location *w.Location) *frame {
+	f.text(location.Ns()).text(location.Id()).float(location.Lat()).float(location.Lon()).time(location.UpdatedAt())
+
+	attributes := location.Attributes()
+	f.data = binary.AppendUvarint(f.data, uint64(len(attributes)))
+	for _, key := range attributes.Keys() {
+		f.text(key).text(attributes[key])
+	}
+
+	return f
+}
+
+func (f *frame) text(value string) *frame {
+	f.data = append(binary.AppendUvarint(f.data, uint64(len(value))), value...)
+	return f
+}
+
+func (f *frame) float(value float64) *frame {
+	f.data = binary.BigEndian.AppendUint64(f.data, math.Float64bits(value))
+	return f
+}
+
+func (f *frame) time(value time.Time) *frame {
+	f.data = binary.BigEndian.AppendUint64(f.data, uint64(value.UnixNano()))
+	return f
+}
+
+func (f *frame) count(value int) *frame {
+	f.data = binary.AppendUvarint(f.data, uint64(value))
+	return f
+}
+
+func (f *frame) uint16(value uint16) *frame {
+	f.data = binary.BigEndian.AppendUint16(f.data, value)
+	return f
+}
+
+func (f *frame) String() string {
+	binary.BigEndian.PutUint32(f.data, uint32(len(f.data)-4))
+
+	return string(f.data)
+}
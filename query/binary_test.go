@@ -0,0 +1,43 @@
+package query
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	w "github.com/fabricekabongo/loggerhead/world"
+)
+
+func TestBinary(t *testing.T) {
+	t.Run("should write the rows without a frame of their own as 2.0 does", func(t *testing.T) {
+		data := Binary(42)(&Statement{Tag: "ignored"}).Namespace(w.NamespaceCount{Name: "a,b", Locations: 3})
+
+		expected := binary.BigEndian.AppendUint32(nil, uint32(5+1+len(`namespace,"a,b",3`)))
+		expected = binary.BigEndian.AppendUint32(expected, 42)
+		expected = append(expected, frameRow, byte(len(`namespace,"a,b",3`)))
+		expected = append(expected, `namespace,"a,b",3`...)
+
+		if data != string(expected) {
+			t.Errorf("expected %q got %q", expected, data)
+		}
+	})
+
+	t.Run("should format the saves as queries keeping all of the digits", func(t *testing.T) {
+		save := w.BatchSave{Id: "truck 1", Lat: 0.000000123, Lon: -45.987654321, TTL: 90 * time.Second, Attributes: w.Attributes{"b": "x y", "a": "1"}}
+
+		query := FormatSave("my fleet", save)
+		if query != `SAVE "my fleet" "truck 1" 0.000000123 -45.987654321 TTL 1m30s a=1 "b=x y"` {
+			t.Errorf("unexpected query %q", query)
+		}
+
+		statement, err := Parse(query)
+		if err != nil {
+			t.Fatalf("expected the query to parse, got %v", err)
+		}
+
+		parsed, err := parseSave(statement, statement.Args[1:])
+		if err != nil || parsed.Lat != save.Lat || parsed.Lon != save.Lon || parsed.TTL != save.TTL || parsed.Attributes["b"] != "x y" {
+			t.Errorf("expected the save back, got %+v: %v", parsed, err)
+		}
+	})
+}
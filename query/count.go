@@ -0,0 +1,211 @@
+package query
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	w "github.com/fabricekabongo/loggerhead/world"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	CountCounter  prometheus.Counter
+	CountDuration prometheus.Histogram
+
+	GridCounter  prometheus.Counter
+	GridDuration prometheus.Histogram
+)
+
+func init() {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	CountCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_query_count_total",
+		Help: "Total number of count queries",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	CountDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_query_count_duration_nanoseconds",
+		Help: "Duration of count queries in nanoseconds",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	GridCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_query_grid_total",
+		Help: "Total number of heatmap queries",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	GridDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_query_grid_duration_nanoseconds",
+		Help: "Duration of heatmap queries in nanoseconds",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+}
+
+// CountQueryProcessor answers how many locations are in a rectangle or a polygon, without listing them.
+type CountQueryProcessor struct {
+	World *w.World
+}
+
+func (p *CountQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer CountCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
+
+	//COUNT NamespaceID Latitude1 Longitude1 Latitude2 Longitude2 [WHERE Conditions]
+	//COUNT NamespaceID POLYGON((Longitude Latitude, ...))|{"type":"Polygon",...} [WHERE Conditions]
+	if statement.Command != "COUNT" { //No trust
+		panic("Invalid COUNT query")
+	}
+
+	ns := statement.Args[0]
+
+	filter, err := parseWhere(statement)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	var count func() int
+	if statement.Shape == "" {
+		lat1, lon1, lat2, lon2, err := parseRectangle(statement.Args[1:5])
+		if err != nil {
+			return format.Error(err)
+		}
+
+		count = func() int {
+			return p.World.Count(ns, lat1, lat2, lon1, lon2, filter)
+		}
+	} else {
+		polygon, err := w.ParsePolygon(statement.Shape)
+		if err != nil {
+			return format.Error(err)
+		}
+
+		count = func() int {
+			return p.World.CountPolygon(ns, polygon, filter)
+		}
+	}
+
+	err = p.World.CheckNamespace(ns)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	result := format.Count(count())
+
+	elapsed := time.Since(start)
+	CountDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return result
+}
+
+func (*CountQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "COUNT"
+}
+
+// GridQueryProcessor answers a heatmap of a rectangle: the number of locations in each of its cells holding any.
+type GridQueryProcessor struct {
+	World *w.World
+}
+
+func (p *GridQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer GridCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
+
+	//GRID NamespaceID Latitude1 Longitude1 Latitude2 Longitude2 CellSize [WHERE Conditions]
+	if statement.Command != "GRID" { //No trust
+		panic("Invalid GRID query")
+	}
+
+	ns := statement.Args[0]
+	lat1, lon1, lat2, lon2, err := parseRectangle(statement.Args[1:5])
+	if err != nil {
+		return format.Error(err)
+	}
+
+	cellSize, err := strconv.ParseFloat(statement.Args[5], 64)
+	if err != nil {
+		return format.Error(invalid(CodeBadValue, "float64", "cell size"))
+	}
+
+	filter, err := parseWhere(statement)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	err = p.World.CheckNamespace(ns)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	cells, err := p.World.Heatmap(ns, lat1, lat2, lon1, lon2, cellSize, filter)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	var result strings.Builder
+	for _, cell := range cells {
+		result.WriteString(format.Cell(cell))
+	}
+	result.WriteString(format.End("done"))
+
+	elapsed := time.Since(start)
+	GridDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return result.String()
+}
+
+func (*GridQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "GRID"
+}
+
+// parseRectangle reads the Latitude1 Longitude1 Latitude2 Longitude2 of an area query.
+func parseRectangle(corners []string) (float64, float64, float64, float64, error) {
+	names := []string{"latitude1", "longitude1", "latitude2", "longitude2"}
+	codes := []string{CodeBadLatitude, CodeBadLongitude, CodeBadLatitude, CodeBadLongitude}
+	values := make([]float64, len(names))
+
+	for i, name := range names {
+		value, err := strconv.ParseFloat(corners[i], 64)
+		if err != nil {
+			return 0, 0, 0, 0, &Error{Code: codes[i], Message: "Invalid float64 value for " + name}
+		}
+		values[i] = value
+	}
+
+	return values[0], values[1], values[2], values[3], nil
+}
+
+// formatCoordinate writes a latitude or longitude with the precision of the locations.
+func formatCoordinate(value float64) string {
+	return strconv.FormatFloat(value, 'f', 6, 64)
+}
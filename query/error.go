@@ -0,0 +1,132 @@
+package query
+
+import (
+	"errors"
+
+	w "github.com/fabricekabongo/loggerhead/world"
+)
+
+// The codes of the query errors. They are part of the protocol: clients act on them, so a code is never renamed
+// or reused, whatever becomes of the wording of its messages.
+const (
+	CodeSyntax             = "E_SYNTAX"
+	CodeUnterminatedString = "E_UNTERMINATED_STRING"
+	CodeUnknownCommand     = "E_UNKNOWN_COMMAND"
+	CodeBadLatitude        = "E_BAD_LAT"
+	CodeBadLongitude       = "E_BAD_LON"
+	CodeBadTTL             = "E_BAD_TTL"
+	CodeBadAttribute       = "E_BAD_ATTRIBUTE"
+	CodeBadPolygon         = "E_BAD_POLYGON"
+	CodeBadFilter          = "E_BAD_FILTER"
+	CodeBadLimit           = "E_BAD_LIMIT"
+	CodeBadCursor          = "E_BAD_CURSOR"
+	CodeBadTime            = "E_BAD_TIME"
+	CodeBadId              = "E_BAD_ID"
+	CodeOutOfExtent        = "E_OUT_OF_EXTENT"
+	CodeNamespaceNotFound  = "E_NAMESPACE_NOT_FOUND"
+	CodeHistoryDisabled    = "E_HISTORY_DISABLED"
+	CodeCanceled           = "E_CANCELED"
+	CodeInternal           = "E_INTERNAL"
+	CodeBadValue           = "E_BAD_VALUE"
+	CodeBadNamespace       = "E_BAD_NAMESPACE"
+	CodeNamespaceExists    = "E_NAMESPACE_EXISTS"
+	CodeBadVersion         = "E_BAD_VERSION"
+	CodeSlowConsumer       = "E_SLOW_CONSUMER"
+	CodeLocationNotFound   = "E_LOCATION_NOT_FOUND"
+)
+
+// Error is an error of a query with its code.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// errorCodes are the codes of the errors of the world, and of the query errors without an Error of their own.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{w.ErrLocationInvalidLatitude, CodeBadLatitude},
+	{w.ErrLocationInvalidLongitude, CodeBadLongitude},
+	{w.ErrLocationRequiredId, CodeBadId},
+	{w.ErrInvalidFenceId, CodeBadId},
+	{w.ErrLocationRequiredNamespace, CodeBadNamespace},
+	{w.ErrInvalidTTL, CodeBadTTL},
+	{w.ErrTooManyAttributes, CodeBadAttribute},
+	{w.ErrInvalidAttributeKey, CodeBadAttribute},
+	{w.ErrInvalidAttribute, CodeBadAttribute},
+	{w.ErrPolygonInvalid, CodeBadPolygon},
+	{w.ErrPolygonTooFewPoints, CodeBadPolygon},
+	{w.ErrPolygonOutOfTheWorld, CodeBadPolygon},
+	{w.ErrInvalidFilter, CodeBadFilter},
+	{w.ErrInvalidFilterNumber, CodeBadFilter},
+	{w.ErrLocationOutOfExtent, CodeOutOfExtent},
+	{w.ErrNamespaceNotFound, CodeNamespaceNotFound},
+	{w.ErrNamespaceExists, CodeNamespaceExists},
+	{w.ErrHistoryDisabled, CodeHistoryDisabled},
+	{w.ErrInvalidFenceDwell, CodeBadValue},
+	{w.ErrInvalidRadius, CodeBadValue},
+	{w.ErrInvalidNeighborCount, CodeBadValue},
+	{w.ErrInvalidHistory, CodeBadValue},
+	{w.ErrInvalidCellSize, CodeBadValue},
+	{w.ErrTooManyCells, CodeBadValue},
+	{w.ErrInvalidRange, CodeBadValue},
+	{w.ErrInvalidIndexBackend, CodeBadValue},
+	{w.ErrInvalidIndexCapacity, CodeBadValue},
+	{w.ErrInvalidIndexPreDivide, CodeBadValue},
+	{w.ErrInvalidIndexMaxDepth, CodeBadValue},
+	{w.ErrInvalidIndexExtent, CodeBadValue},
+	{ErrInvalidCursor, CodeBadCursor},
+	{ErrQueryCanceled, CodeCanceled},
+	{ErrSlowConsumer, CodeSlowConsumer},
+	{ErrorInvalidQuery, CodeSyntax},
+}
+
+// errorCode returns the code of the error, CodeInternal for the errors without one.
+func errorCode(err error) string {
+	var queryErr *Error
+	if errors.As(err, &queryErr) {
+		return queryErr.Code
+	}
+
+	for _, known := range errorCodes {
+		if errors.Is(err, known.err) {
+			return known.code
+		}
+	}
+
+	return CodeInternal
+}
+
+// errorStatus returns the status answering an error code in the versions of the protocol with statuses, like HTTP's:
+// 400 for the queries to fix, 404 and 409 for the ones at odds with the namespace, 499 for the canceled ones.
+func errorStatus(code string) int {
+	switch code {
+	case CodeNamespaceNotFound, CodeLocationNotFound:
+		return 404
+	case CodeNamespaceExists, CodeHistoryDisabled:
+		return 409
+	case CodeSlowConsumer:
+		return 429
+	case CodeCanceled:
+		return 499
+	case CodeInternal:
+		return 500
+	}
+
+	return 400
+}
+
+// ErrorStatus returns the status answering the error in the versions of the protocol with statuses, and over HTTP.
+func ErrorStatus(err error) int {
+	return errorStatus(errorCode(err))
+}
+
+// invalid returns an error of an argument that does not read as the value it stands for.
+func invalid(code, value, name string) *Error {
+	return &Error{Code: code, Message: "Invalid " + value + " value for " + name}
+}
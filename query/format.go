@@ -0,0 +1,315 @@
+package query
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	w "github.com/fabricekabongo/loggerhead/world"
+)
+
+// Format writes the lines answering a statement in a version of the protocol. Every method returns whole lines,
+// ending with a new line.
+type Format interface {
+	Location(location *w.Location) string
+	Neighbor(neighbor w.Neighbor) string
+	Position(ns, id string, position w.Position) string
+	Fence(ns string, fence w.Fence) string
+	Namespace(namespace w.NamespaceCount) string
+	Stats(ns string, stats w.Stats) string
+	Cell(cell w.CellCount) string
+	Cursor(cursor string) string
+	// Item reports an item of a batch that failed, by its position in the batch and its id.
+	Item(position int, id string, err error) string
+	Event(event w.Event) string
+	// Count answers a count, all of the answer.
+	Count(count int) string
+	// Saved ends the answer to a batch with the number of items saved.
+	Saved(count int) string
+	// End ends a successful answer with its result: done, saved, deleted...
+	End(result string) string
+	// Error answers an error, or ends an answer with it.
+	Error(err error) string
+}
+
+// Protocol is a version of the protocol: it returns the format answering each statement.
+type Protocol func(statement *Statement) Format
+
+// V1 is the first version of the protocol, the one a connection starts with. Its lines are 1.0 followed by the
+// values separated by commas, as they are. The commands answer their errors with their codes, but for GET and
+// DELETE, which keep answering 1.0,"message" as they did before the codes.
+func V1(statement *Statement) Format {
+	g, ok := grammars[statement.Command]
+
+	return v1Format{coded: ok && g.codes}
+}
+
+// V2 is the second version of the protocol. Its lines are 2.0, the tag of the query, then a keyword telling what
+// the line holds and its values, or a status for the lines ending the answer. Texts are quoted, so they can hold
+// commas, numbers and keywords are not, and a missing value is left empty.
+func V2(statement *Statement) Format {
+	prefix := "2.0,"
+	if statement.Tag != "" {
+		prefix += quoteField(statement.Tag)
+	}
+
+	return v2Format{prefix: prefix + ","}
+}
+
+// Protocols are the versions of the protocol a HELLO can ask for.
+var Protocols = map[string]Protocol{
+	version: V1,
+	"2.0":   V2,
+}
+
+// Hello answers a HELLO query. It returns the protocol to answer the next queries of the connection with, the
+// one asked for or the current one if the HELLO fails, and false for the queries that are not HELLOs.
+func Hello(query string, protocol Protocol) (Protocol, string, bool) {
+	if !startsWith(query, "HELLO") {
+		return protocol, "", false
+	}
+
+	statement, err := Parse(query)
+	if err != nil {
+		return protocol, protocol(statement).Error(err), true
+	}
+
+	next, ok := Protocols[statement.Args[0]]
+	if !ok {
+		return protocol, protocol(statement).Error(&Error{Code: CodeBadVersion, Message: "unsupported version, expected 1.0 or 2.0"}), true
+	}
+
+	return next, next(statement).End("hello"), true
+}
+
+// startsWith tells if the query starts with the command, after its tag if any, without reading the rest of it.
+func startsWith(query, command string) bool {
+	query = strings.TrimLeft(query, " \t")
+	if strings.HasPrefix(query, "#") {
+		at := strings.IndexAny(query, " \t")
+		if at < 0 {
+			return false
+		}
+		query = strings.TrimLeft(query[at:], " \t")
+	}
+
+	return len(query) >= len(command) && strings.EqualFold(query[:len(command)], command) &&
+		(len(query) == len(command) || isSpace(query[len(command)]))
+}
+
+type v1Format struct {
+	coded bool
+}
+
+func (f v1Format) Location(location *w.Location) string {
+	return version + "," + formatLocation(location) + "\n"
+}
+
+func (f v1Format) Neighbor(neighbor w.Neighbor) string {
+	line := version + "," + neighbor.Location.String() + "," + strconv.FormatFloat(neighbor.Distance, 'f', 6, 64)
+	if attributes := neighbor.Location.Attributes(); len(attributes) > 0 {
+		line += "," + attributes.String()
+	}
+
+	return line + "\n"
+}
+
+func (f v1Format) Position(ns, id string, position w.Position) string {
+	return version + "," + formatPosition(ns, id, position) + "\n"
+}
+
+func (f v1Format) Fence(ns string, fence w.Fence) string {
+	return version + "," + ns + "," + fence.Id + "," + fence.Dwell.String() + "," + fence.Polygon.String() + "\n"
+}
+
+func (f v1Format) Namespace(namespace w.NamespaceCount) string {
+	return version + "," + namespace.Name + "," + strconv.Itoa(namespace.Locations) + "\n"
+}
+
+func (f v1Format) Stats(ns string, stats w.Stats) string {
+	return version + "," + ns + "," + FormatStats(stats) + "\n"
+}
+
+func (f v1Format) Cell(cell w.CellCount) string {
+	return version + "," + formatCoordinate(cell.Lat1) + "," + formatCoordinate(cell.Lon1) + "," +
+		formatCoordinate(cell.Lat2) + "," + formatCoordinate(cell.Lon2) + "," + strconv.Itoa(cell.Count) + "\n"
+}
+
+func (f v1Format) Cursor(cursor string) string {
+	return version + ",cursor," + cursor + "\n"
+}
+
+func (f v1Format) Item(position int, id string, err error) string {
+	return version + "," + strconv.Itoa(position) + "," + id + ",\"" + err.Error() + "\"\n"
+}
+
+// Event writes the event's kind, then the location and its attributes as GET returns them. Fence events start
+// with FENCE and have the fence id before the location's.
+func (f v1Format) Event(event w.Event) string {
+	line := version + ","
+	if event.Fence != "" {
+		line += "FENCE,"
+	}
+	line += event.Kind.String() + "," + event.Ns + ","
+	if event.Fence != "" {
+		line += event.Fence + ","
+	}
+	line += event.Id + "," + formatCoordinate(event.Lat) + "," + formatCoordinate(event.Lon)
+
+	if len(event.Attributes) > 0 {
+		line += "," + event.Attributes.String()
+	}
+
+	return line + "\n"
+}
+
+func (f v1Format) Count(count int) string {
+	return version + "," + strconv.Itoa(count) + "\n"
+}
+
+func (f v1Format) Saved(count int) string {
+	return version + ",saved," + strconv.Itoa(count) + "\n"
+}
+
+func (f v1Format) End(result string) string {
+	return version + "," + result + "\n"
+}
+
+// Error answers 1.0,ERR,Code,"Message" for the commands with codes, and 1.0,"Message" for GET and DELETE, which answer
+// invalid query to any query they cannot read.
+func (f v1Format) Error(err error) string {
+	code := errorCode(err)
+	if f.coded && code != CodeUnknownCommand {
+		return version + ",ERR," + code + ",\"" + err.Error() + "\"\n"
+	}
+
+	message := err.Error()
+	if code == CodeSyntax || code == CodeUnterminatedString || code == CodeUnknownCommand {
+		message = ErrorInvalidQuery.Error()
+	}
+
+	return version + ",\"" + message + "\"\n"
+}
+
+type v2Format struct {
+	// prefix is the start of every line: the version and the tag.
+	prefix string
+}
+
+func (f v2Format) Location(location *w.Location) string {
+	return f.prefix + "location," + quoteField(location.Ns()) + "," + quoteField(location.Id()) + "," +
+		formatNumber(location.Lat()) + "," + formatNumber(location.Lon()) + "," + attributesField(location.Attributes()) + "\n"
+}
+
+func (f v2Format) Neighbor(neighbor w.Neighbor) string {
+	location := neighbor.Location
+
+	return f.prefix + "neighbor," + quoteField(location.Ns()) + "," + quoteField(location.Id()) + "," +
+		formatNumber(location.Lat()) + "," + formatNumber(location.Lon()) + "," + formatNumber(neighbor.Distance) + "," +
+		attributesField(location.Attributes()) + "\n"
+}
+
+func (f v2Format) Position(ns, id string, position w.Position) string {
+	return f.prefix + "position," + quoteField(ns) + "," + quoteField(id) + "," + formatNumber(position.Lat) + "," +
+		formatNumber(position.Lon) + "," + quoteField(position.At.UTC().Format(time.RFC3339Nano)) + "\n"
+}
+
+func (f v2Format) Fence(ns string, fence w.Fence) string {
+	return f.prefix + "fence," + quoteField(ns) + "," + quoteField(fence.Id) + "," + quoteField(fence.Dwell.String()) + "," +
+		quoteField(fence.Polygon.String()) + "\n"
+}
+
+func (f v2Format) Namespace(namespace w.NamespaceCount) string {
+	return f.prefix + "namespace," + quoteField(namespace.Name) + "," + strconv.Itoa(namespace.Locations) + "\n"
+}
+
+// Stats writes the statistics in the order of FormatStats, without their names.
+func (f v2Format) Stats(ns string, stats w.Stats) string {
+	options := stats.Options
+
+	return f.prefix + "stats," + strings.Join([]string{
+		quoteField(ns),
+		strconv.Itoa(stats.Locations),
+		strconv.Itoa(stats.Expiring),
+		strconv.Itoa(stats.Fences),
+		strconv.Itoa(stats.Subscriptions),
+		strconv.Itoa(stats.Grids),
+		strconv.Itoa(stats.Leaves),
+		strconv.Itoa(stats.Depth),
+		strconv.FormatBool(stats.Created),
+		quoteField(options.Backend),
+		strconv.Itoa(options.Capacity),
+		strconv.Itoa(options.PreDivide),
+		strconv.Itoa(options.MaxDepth),
+		formatNumber(options.Extent.Lat1),
+		formatNumber(options.Extent.Lon1),
+		formatNumber(options.Extent.Lat2),
+		formatNumber(options.Extent.Lon2),
+		quoteField(stats.DefaultTTL.String()),
+		strconv.Itoa(stats.HistorySize),
+		quoteField(stats.HistoryAge.String()),
+	}, ",") + "\n"
+}
+
+func (f v2Format) Cell(cell w.CellCount) string {
+	return f.prefix + "cell," + formatNumber(cell.Lat1) + "," + formatNumber(cell.Lon1) + "," + formatNumber(cell.Lat2) + "," +
+		formatNumber(cell.Lon2) + "," + strconv.Itoa(cell.Count) + "\n"
+}
+
+func (f v2Format) Cursor(cursor string) string {
+	return f.prefix + "cursor," + quoteField(cursor) + "\n"
+}
+
+func (f v2Format) Item(position int, id string, err error) string {
+	code := errorCode(err)
+
+	return f.prefix + "item," + strconv.Itoa(position) + "," + quoteField(id) + "," + strconv.Itoa(errorStatus(code)) + "," +
+		code + "," + quoteField(err.Error()) + "\n"
+}
+
+func (f v2Format) Event(event w.Event) string {
+	fence := ""
+	if event.Fence != "" {
+		fence = quoteField(event.Fence)
+	}
+
+	return f.prefix + "event," + event.Kind.String() + "," + quoteField(event.Ns) + "," + fence + "," + quoteField(event.Id) + "," +
+		formatNumber(event.Lat) + "," + formatNumber(event.Lon) + "," + attributesField(event.Attributes) + "\n"
+}
+
+func (f v2Format) Count(count int) string {
+	return f.prefix + "200,count," + strconv.Itoa(count) + "\n"
+}
+
+func (f v2Format) Saved(count int) string {
+	return f.prefix + "200,saved," + strconv.Itoa(count) + "\n"
+}
+
+func (f v2Format) End(result string) string {
+	return f.prefix + "200," + result + "\n"
+}
+
+func (f v2Format) Error(err error) string {
+	code := errorCode(err)
+
+	return f.prefix + strconv.Itoa(errorStatus(code)) + "," + code + "," + quoteField(err.Error()) + "\n"
+}
+
+// quoteField quotes a text of a v2 line, doubling its quotes.
+func quoteField(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+}
+
+// attributesField writes the attributes as one text of key=value pairs, left empty without attributes.
+func attributesField(attributes w.Attributes) string {
+	if len(attributes) == 0 {
+		return ""
+	}
+
+	return quoteField(attributes.String())
+}
+
+// formatNumber writes a number with as many digits as it takes, no more.
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
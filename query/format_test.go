@@ -0,0 +1,148 @@
+package query
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	w "github.com/fabricekabongo/loggerhead/world"
+)
+
+func TestV2(t *testing.T) {
+	answer := func(engine *Engine, query string) string {
+		var out strings.Builder
+		_ = engine.StreamQuery(context.Background(), query, V2, &out)
+
+		return out.String()
+	}
+
+	t.Run("should quote the texts and echo the tag", func(t *testing.T) {
+		world := w.NewWorld()
+		engine := NewQueryEngine(world).(*Engine)
+
+		for _, exchange := range []struct{ query, expected string }{
+			{`#1 SAVE "a,b" "say \"hi\"" 1.5 -2 color=red`, "2.0,\"1\",200,saved\n"},
+			{`GET "a,b" "say \"hi\""`, "2.0,,location,\"a,b\",\"say \"\"hi\"\"\",1.5,-2,\"color=red\"\n2.0,,200,done\n"},
+			{`#x RADIUS "a,b" 1.5 -2 10`, "2.0,\"x\",neighbor,\"a,b\",\"say \"\"hi\"\"\",1.5,-2,0,\"color=red\"\n2.0,\"x\",200,done\n"},
+			{`COUNT "a,b" 0 -3 2 0`, "2.0,,200,count,1\n"},
+			{`GRID "a,b" 0 -3 2 0 5`, "2.0,,cell,0,-3,2,0,1\n2.0,,200,done\n"},
+			{"NAMESPACES", "2.0,,namespace,\"a,b\",1\n2.0,,200,done\n"},
+			{"MSAVE ns a 1 1 ; b 91 1", "2.0,,item,1,\"b\",400,E_BAD_LAT,\"invalid latitude\"\n2.0,,200,saved,1\n"},
+			{"FENCE ADD ns depot POLYGON ((0 0, 1 0, 1 1, 0 0))", "2.0,,200,saved\n"},
+			{"FENCE LIST ns", "2.0,,fence,\"ns\",\"depot\",\"0s\",\"POLYGON ((0 0, 1 0, 1 1, 0 0))\"\n2.0,,200,done\n"},
+		} {
+			if data := answer(engine, exchange.query); data != exchange.expected {
+				t.Errorf("%s: expected %q got %q", exchange.query, exchange.expected, data)
+			}
+		}
+
+		data := answer(engine, "STATS ns")
+		if !strings.HasPrefix(data, "2.0,,stats,\"ns\",1,0,1,0,") || !strings.HasSuffix(data, "\n2.0,,200,done\n") {
+			t.Errorf("unexpected stats %q", data)
+		}
+	})
+
+	t.Run("should answer the errors with their status and code", func(t *testing.T) {
+		world := w.NewWorld()
+		world.SetStrict(true)
+		engine := NewQueryEngine(world).(*Engine)
+		_ = world.Save("ns", "a", 1, 1)
+		_ = world.Save("other", "a", 1, 1)
+
+		for query, expected := range map[string]string{
+			"#q FETCH ns a":             "2.0,\"q\",400,E_UNKNOWN_COMMAND,\"invalid query\"\n",
+			"GET ns":                    "2.0,,400,E_SYNTAX,\"expected GET NamespaceID LocationID [FORMAT NDJSON|GEOJSON]\"\n",
+			`GET ns "a`:                 "2.0,,400,E_UNTERMINATED_STRING,\"unterminated quoted string\"\n",
+			"GET typo a":                "2.0,,404,E_NAMESPACE_NOT_FOUND,\"namespace not found\"\n",
+			"HISTORY ns a":              "2.0,,409,E_HISTORY_DISABLED,\"history is not kept for this namespace\"\n",
+			"RADIUS ns 0 0 -1":          "2.0,,400,E_BAD_VALUE,\"radius must be a positive number of meters\"\n",
+			"TRACK ns x":                "2.0,,400,E_BAD_VALUE,\"Invalid integer value for size\"\n",
+			"RENAME NAMESPACE ns other": "2.0,,409,E_NAMESPACE_EXISTS,\"namespace already exists\"\n",
+			"POLY ns 0 0 1 1 LIMIT -1":  "2.0,,400,E_BAD_LIMIT,\"Invalid integer value for limit\"\n",
+		} {
+			if data := answer(engine, query); data != expected {
+				t.Errorf("%s: expected %q got %q", query, expected, data)
+			}
+		}
+	})
+
+	t.Run("should write the events of subscriptions with their tag", func(t *testing.T) {
+		world := w.NewWorld()
+		engine := NewSubscriberQueryEngine(world, 4, w.DropEvents)
+
+		subscription, format, response := engine.Subscribe("#s SUBSCRIBE ns 0 0 10 10", V2)
+		if subscription == nil || response != "2.0,\"s\",200,subscribed\n" {
+			t.Fatalf("expected the subscription, got %q", response)
+		}
+		defer subscription.Close()
+
+		_ = world.Save("ns", "a", 5, 5)
+
+		select {
+		case event := <-subscription.Events():
+			if data := format.Event(event); data != "2.0,\"s\",event,ENTER,\"ns\",,\"a\",5,5,\n" {
+				t.Errorf("unexpected event %q", data)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected an event")
+		}
+	})
+}
+
+func TestHello(t *testing.T) {
+	t.Run("should switch to the version asked for", func(t *testing.T) {
+		protocol, answer, ok := Hello("#h hello 2.0", V1)
+		if !ok || answer != "2.0,\"h\",200,hello\n" {
+			t.Fatalf("expected the hello of 2.0, got %q", answer)
+		}
+
+		protocol, answer, ok = Hello("HELLO 1.0", protocol)
+		if !ok || answer != "1.0,hello\n" {
+			t.Fatalf("expected the hello of 1.0, got %q", answer)
+		}
+
+		if data := protocol(&Statement{Command: "SAVE"}).End("saved"); data != "1.0,saved\n" {
+			t.Errorf("expected to answer in 1.0, got %q", data)
+		}
+	})
+
+	t.Run("should keep the current version when the HELLO fails", func(t *testing.T) {
+		for query, expected := range map[string]string{
+			"HELLO 9.9":     "2.0,,400,E_BAD_VERSION,\"unsupported version, expected 1.0 or 2.0\"\n",
+			"HELLO":         "2.0,,400,E_SYNTAX,\"expected HELLO Version\"\n",
+			"HELLO 2.0 zip": "2.0,,400,E_SYNTAX,\"expected HELLO Version\"\n",
+		} {
+			protocol, answer, ok := Hello(query, V2)
+			if !ok || answer != expected {
+				t.Errorf("%s: expected %q got %q", query, expected, answer)
+			}
+
+			if data := protocol(&Statement{}).End("done"); data != "2.0,,200,done\n" {
+				t.Errorf("%s: expected to answer in 2.0, got %q", query, data)
+			}
+		}
+	})
+
+	t.Run("should leave the other queries to the engines", func(t *testing.T) {
+		for _, query := range []string{"GET ns a", "HELLOS 2.0", `GET "HELLO" a`, "#1"} {
+			if _, _, ok := Hello(query, V1); ok {
+				t.Errorf("%s: did not expect a hello", query)
+			}
+		}
+	})
+}
+
+func TestIsCancel(t *testing.T) {
+	for _, query := range []string{"CANCEL", "cancel", " Cancel ", "#q CANCEL"} {
+		if !IsCancel(query) {
+			t.Errorf("%s: expected a cancel", query)
+		}
+	}
+
+	for _, query := range []string{"CANCELS", "CANCEL now", `GET "CANCEL" a`, "#1", ""} {
+		if IsCancel(query) {
+			t.Errorf("%s: did not expect a cancel", query)
+		}
+	}
+}
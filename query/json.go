@@ -0,0 +1,196 @@
+package query
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	w "github.com/fabricekabongo/loggerhead/world"
+)
+
+// formats are the formats a read query can ask for with its FORMAT clause, in place of the lines of the protocol's
+// version. They write the locations, the neighbors, the cursor and the end of the answer, and leave the other rows
+// to the format of the version they are given.
+var formats = map[string]func(statement *Statement, format Format) Format{
+	"NDJSON": func(statement *Statement, format Format) Format {
+		return ndjsonFormat{Format: format, tag: statement.Tag}
+	},
+	"GEOJSON": func(statement *Statement, format Format) Format {
+		return &geoJSONFormat{Format: format, tag: statement.Tag}
+	},
+}
+
+// JSON returns the protocol answering every statement in the format of the name, NDJSON or GEOJSON, as their FORMAT
+// clause would over the second version of the protocol, and false for the other names.
+func JSON(name string) (Protocol, bool) {
+	newFormat, ok := formats[strings.ToUpper(name)]
+	if !ok {
+		return nil, false
+	}
+
+	return func(statement *Statement) Format {
+		return newFormat(statement, V2(statement))
+	}, true
+}
+
+// statementFormat returns the format answering the statement in the protocol: the one its FORMAT clause asks for,
+// or the protocol's. Along with an error, it returns the protocol's format to answer it.
+func statementFormat(statement *Statement, protocol Protocol) (Format, error) {
+	format := protocol(statement)
+
+	names, ok := statement.Clause("FORMAT")
+	if !ok {
+		return format, nil
+	}
+
+	newFormat, ok := formats[strings.ToUpper(names[0])]
+	if !ok {
+		return format, &Error{Code: CodeBadValue, Message: "unsupported format, expected NDJSON or GEOJSON"}
+	}
+
+	return newFormat(statement, format), nil
+}
+
+// ndjsonFormat writes a JSON object per line: one per location, then the cursor of the next page if any, then the
+// status ending the answer, as in the second version of the protocol.
+type ndjsonFormat struct {
+	Format
+	tag string
+}
+
+func (f ndjsonFormat) Location(location *w.Location) string {
+	return "{" + f.tagMember() + `"namespace":` + jsonString(location.Ns()) + `,"id":` + jsonString(location.Id()) +
+		`,"lat":` + formatNumber(location.Lat()) + `,"lon":` + formatNumber(location.Lon()) + "," +
+		locationMembers(location) + "}\n"
+}
+
+// Neighbor writes the location with its distance in meters.
+func (f ndjsonFormat) Neighbor(neighbor w.Neighbor) string {
+	location := neighbor.Location
+
+	return "{" + f.tagMember() + `"namespace":` + jsonString(location.Ns()) + `,"id":` + jsonString(location.Id()) +
+		`,"lat":` + formatNumber(location.Lat()) + `,"lon":` + formatNumber(location.Lon()) +
+		`,"distance":` + formatNumber(neighbor.Distance) + "," + locationMembers(location) + "}\n"
+}
+
+func (f ndjsonFormat) Cursor(cursor string) string {
+	return "{" + f.tagMember() + `"cursor":` + jsonString(cursor) + "}\n"
+}
+
+func (f ndjsonFormat) End(result string) string {
+	return "{" + f.tagMember() + statusMembers(nil, result) + "}\n"
+}
+
+func (f ndjsonFormat) Error(err error) string {
+	return "{" + f.tagMember() + statusMembers(err, "") + "}\n"
+}
+
+func (f ndjsonFormat) tagMember() string {
+	if f.tag == "" {
+		return ""
+	}
+
+	return `"tag":` + jsonString(f.tag) + ","
+}
+
+// geoJSONFormat writes the answer as one GeoJSON FeatureCollection on one line, a Point feature per location. The
+// tag, the cursor of the next page and the status are members of the collection. It opens the collection with
+// the first feature, so it answers a single statement.
+type geoJSONFormat struct {
+	Format
+	tag    string
+	open   bool
+	cursor string
+}
+
+func (f *geoJSONFormat) Location(location *w.Location) string {
+	return f.feature(location, "")
+}
+
+// Neighbor writes the location's feature with its distance in meters in the properties.
+func (f *geoJSONFormat) Neighbor(neighbor w.Neighbor) string {
+	return f.feature(neighbor.Location, `"distance":`+formatNumber(neighbor.Distance)+",")
+}
+
+// feature writes the location's feature, with the members given at the start of its properties.
+func (f *geoJSONFormat) feature(location *w.Location, members string) string {
+	feature := `{"type":"Feature","id":` + jsonString(location.Id()) + `,"geometry":{"type":"Point","coordinates":[` +
+		formatNumber(location.Lon()) + "," + formatNumber(location.Lat()) + `]},"properties":{"namespace":` +
+		jsonString(location.Ns()) + "," + members + locationMembers(location) + "}}"
+
+	if f.open {
+		return "," + feature
+	}
+
+	return f.start() + feature
+}
+
+// Cursor keeps the cursor for the end of the collection.
+func (f *geoJSONFormat) Cursor(cursor string) string {
+	f.cursor = cursor
+
+	return ""
+}
+
+func (f *geoJSONFormat) End(result string) string {
+	return f.close(nil, result)
+}
+
+func (f *geoJSONFormat) Error(err error) string {
+	return f.close(err, "")
+}
+
+func (f *geoJSONFormat) start() string {
+	f.open = true
+
+	header := `{"type":"FeatureCollection",`
+	if f.tag != "" {
+		header += `"tag":` + jsonString(f.tag) + ","
+	}
+
+	return header + `"features":[`
+}
+
+// close ends the collection, opening it first if it has no features.
+func (f *geoJSONFormat) close(err error, result string) string {
+	collection := ""
+	if !f.open {
+		collection = f.start()
+	}
+	collection += "],"
+
+	if f.cursor != "" {
+		collection += `"cursor":` + jsonString(f.cursor) + ","
+	}
+
+	return collection + statusMembers(err, result) + "}\n"
+}
+
+// locationMembers writes when the location was saved and its attributes, as an object, empty without attributes.
+func locationMembers(location *w.Location) string {
+	attributes := location.Attributes()
+	if attributes == nil {
+		attributes = w.Attributes{}
+	}
+	data, _ := json.Marshal(attributes)
+
+	return `"updated_at":` + jsonString(location.UpdatedAt().UTC().Format(time.RFC3339Nano)) + `,"attributes":` + string(data)
+}
+
+// statusMembers writes the status of the answer with its result, or with the code and message of its error.
+func statusMembers(err error, result string) string {
+	if err == nil {
+		return `"status":200,"result":` + jsonString(result)
+	}
+
+	code := errorCode(err)
+
+	return `"status":` + strconv.Itoa(errorStatus(code)) + `,"code":` + jsonString(code) + `,"message":` + jsonString(err.Error())
+}
+
+func jsonString(value string) string {
+	data, _ := json.Marshal(value)
+
+	return string(data)
+}
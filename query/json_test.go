@@ -0,0 +1,127 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	w "github.com/fabricekabongo/loggerhead/world"
+)
+
+func TestJSONFormats(t *testing.T) {
+	world := w.NewWorld()
+	world.SetStrict(true)
+	engine := NewQueryEngine(world).(*Engine)
+	_ = world.SaveWithAttributes("ns", "a", 1, 2, 0, w.Attributes{"color": "red"})
+	_ = world.Save("ns", "b", 3, 4)
+	_ = world.Save("ns", "c", 5, 6)
+
+	answer := func(protocol Protocol, query string) string {
+		var out strings.Builder
+		_ = engine.StreamQuery(context.Background(), query, protocol, &out)
+
+		return out.String()
+	}
+
+	t.Run("should write a JSON object per location with NDJSON", func(t *testing.T) {
+		lines := strings.Split(strings.TrimSuffix(answer(V1, "GET ns a FORMAT ndjson"), "\n"), "\n")
+		if len(lines) != 2 || lines[1] != `{"status":200,"result":"done"}` {
+			t.Fatalf("unexpected answer %q", lines)
+		}
+
+		var location struct {
+			Namespace  string            `json:"namespace"`
+			Id         string            `json:"id"`
+			Lat        float64           `json:"lat"`
+			Lon        float64           `json:"lon"`
+			UpdatedAt  time.Time         `json:"updated_at"`
+			Attributes map[string]string `json:"attributes"`
+		}
+		err := json.Unmarshal([]byte(lines[0]), &location)
+		if err != nil {
+			t.Fatalf("expected a JSON object, got %q: %v", lines[0], err)
+		}
+
+		if location.Namespace != "ns" || location.Id != "a" || location.Lat != 1 || location.Lon != 2 ||
+			location.Attributes["color"] != "red" || time.Since(location.UpdatedAt) > time.Minute {
+			t.Errorf("unexpected location %q", lines[0])
+		}
+	})
+
+	t.Run("should page and tag the NDJSON lines", func(t *testing.T) {
+		data := answer(V2, "#p POLY ns 0 0 10 10 LIMIT 1 FORMAT NDJSON")
+
+		lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+		if len(lines) != 3 || !strings.HasPrefix(lines[0], `{"tag":"p","namespace":"ns","id":"a",`) ||
+			lines[1] != `{"tag":"p","cursor":"YQ"}` || lines[2] != `{"tag":"p","status":200,"result":"done"}` {
+			t.Errorf("unexpected answer %q", data)
+		}
+	})
+
+	t.Run("should write a FeatureCollection with GeoJSON", func(t *testing.T) {
+		data := answer(V1, "POLY ns 0 0 4 5 LIMIT 5 FORMAT GEOJSON")
+		if strings.Count(data, "\n") != 1 {
+			t.Fatalf("expected the collection on one line, got %q", data)
+		}
+
+		var collection struct {
+			Type     string `json:"type"`
+			Status   int    `json:"status"`
+			Features []struct {
+				Type     string `json:"type"`
+				Id       string `json:"id"`
+				Geometry struct {
+					Type        string    `json:"type"`
+					Coordinates []float64 `json:"coordinates"`
+				} `json:"geometry"`
+				Properties struct {
+					Namespace  string            `json:"namespace"`
+					UpdatedAt  time.Time         `json:"updated_at"`
+					Attributes map[string]string `json:"attributes"`
+				} `json:"properties"`
+			} `json:"features"`
+		}
+		err := json.Unmarshal([]byte(data), &collection)
+		if err != nil {
+			t.Fatalf("expected a JSON object, got %q: %v", data, err)
+		}
+
+		if collection.Type != "FeatureCollection" || collection.Status != 200 || len(collection.Features) != 2 {
+			t.Fatalf("unexpected collection %q", data)
+		}
+
+		first, second := collection.Features[0], collection.Features[1]
+		if first.Type != "Feature" || first.Id != "a" || first.Geometry.Type != "Point" ||
+			first.Geometry.Coordinates[0] != 2 || first.Geometry.Coordinates[1] != 1 ||
+			first.Properties.Namespace != "ns" || first.Properties.Attributes["color"] != "red" || first.Properties.UpdatedAt.IsZero() {
+			t.Errorf("unexpected feature %q", data)
+		}
+
+		if second.Id != "b" || len(second.Properties.Attributes) != 0 {
+			t.Errorf("unexpected feature %q", data)
+		}
+	})
+
+	t.Run("should end the collection with the cursor, the tag or the error", func(t *testing.T) {
+		for query, expected := range map[string]string{
+			"#g GET ns z FORMAT GEOJSON":           `{"type":"FeatureCollection","tag":"g","features":[],"status":200,"result":"done"}` + "\n",
+			"GET typo a FORMAT GEOJSON":            `{"type":"FeatureCollection","features":[],"status":404,"code":"E_NAMESPACE_NOT_FOUND","message":"namespace not found"}` + "\n",
+			"POLY ns north 0 1 1 FORMAT GEOJSON":   `{"type":"FeatureCollection","features":[],"status":400,"code":"E_BAD_LAT","message":"Invalid float64 value for latitude1"}` + "\n",
+			"GET typo a FORMAT NDJSON":             `{"status":404,"code":"E_NAMESPACE_NOT_FOUND","message":"namespace not found"}` + "\n",
+			"POLY ns 0 0 10 10 FORMAT CSV":         "1.0,ERR,E_BAD_VALUE,\"unsupported format, expected NDJSON or GEOJSON\"\n",
+			"GET ns a FORMAT":                      "1.0,\"invalid query\"\n",
+			"GET ns a FORMAT NDJSON FORMAT NDJSON": "1.0,\"invalid query\"\n",
+		} {
+			if data := answer(V1, query); data != expected {
+				t.Errorf("%s: expected %q got %q", query, expected, data)
+			}
+		}
+
+		data := answer(V1, "POLY ns 0 0 10 10 LIMIT 2 FORMAT GEOJSON")
+		if !strings.HasSuffix(data, `],"cursor":"Yg","status":200,"result":"done"}`+"\n") {
+			t.Errorf("expected the cursor at the end of the collection, got %q", data)
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package query
+
+import (
+	"strings"
+)
+
+// token is a word of a query. Words are separated by spaces or tabs, however many. A part of a word between
+// double quotes keeps its spaces, and \" and \\ stand for a quote and a backslash in it, so ids and values can
+// hold anything: "my car" is one word, and so is name="John Smith". A word with a quoted part is never taken for
+// a keyword.
+type token struct {
+	text   string
+	quoted bool
+	// start and end are the offsets of the word in the query, for the arguments taken as written, like polygons.
+	start, end int
+}
+
+// lex cuts the query into its words. On an unterminated quote, it returns the words before the one holding it
+// along with the error.
+func lex(query string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(query); {
+		if isSpace(query[i]) {
+			i++
+			continue
+		}
+
+		current := token{start: i}
+		var text strings.Builder
+
+		for i < len(query) && !isSpace(query[i]) {
+			if query[i] != '"' {
+				text.WriteByte(query[i])
+				i++
+				continue
+			}
+
+			current.quoted = true
+			i++
+
+			closed := false
+			for i < len(query) {
+				c := query[i]
+				if c == '"' {
+					closed = true
+					i++
+					break
+				}
+
+				if c == '\\' && i+1 < len(query) && (query[i+1] == '"' || query[i+1] == '\\') {
+					c = query[i+1]
+					i++
+				}
+
+				text.WriteByte(c)
+				i++
+			}
+
+			if !closed {
+				return tokens, &Error{Code: CodeUnterminatedString, Message: "unterminated quoted string"}
+			}
+		}
+
+		current.text = text.String()
+		current.end = i
+		tokens = append(tokens, current)
+	}
+
+	return tokens, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// is tells if the token is the keyword, in any case. Quoted words are never keywords.
+func (t token) is(keyword string) bool {
+	return !t.quoted && strings.EqualFold(t.text, keyword)
+}
+
+// Quote returns the value as a word of a query: as is when it reads as one, between double quotes otherwise.
+func Quote(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\r\n\";") {
+		return value
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
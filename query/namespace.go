@@ -0,0 +1,335 @@
+package query
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	w "github.com/fabricekabongo/loggerhead/world"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	CreateNamespaceCounter  prometheus.Counter
+	CreateNamespaceDuration prometheus.Histogram
+
+	NamespaceCounter  prometheus.Counter
+	NamespaceDuration prometheus.Histogram
+)
+
+func init() {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	CreateNamespaceCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_query_create_namespace_total",
+		Help: "Total number of create namespace queries",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	CreateNamespaceDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_query_create_namespace_duration_nanoseconds",
+		Help: "Duration of create namespace queries in nanoseconds",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	NamespaceCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_query_namespace_total",
+		Help: "Total number of namespace listing, statistics, drop and rename queries",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	NamespaceDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_query_namespace_duration_nanoseconds",
+		Help: "Duration of namespace listing, statistics, drop and rename queries in nanoseconds",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+}
+
+// CreateNamespaceQueryProcessor creates namespaces with their index options. The options left out take the
+// world's defaults.
+type CreateNamespaceQueryProcessor struct {
+	World *w.World
+}
+
+func (p *CreateNamespaceQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer CreateNamespaceCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
+
+	//CREATE NAMESPACE NamespaceID [BACKEND QUADTREE|GRID|RTREE] [CAPACITY Locations] [PREDIVIDE Levels] [MAXDEPTH Levels] [EXTENT Latitude1 Longitude1 Latitude2 Longitude2]
+	if statement.Command != "CREATE NAMESPACE" { //No trust
+		panic("Invalid CREATE NAMESPACE query")
+	}
+
+	ns := statement.Args[0]
+	options := p.World.DefaultIndexOptions()
+
+	if backend, ok := statement.Clause("BACKEND"); ok {
+		options.Backend = strings.ToLower(backend[0])
+	}
+
+	for _, option := range []struct {
+		keyword string
+		value   *int
+	}{
+		{"CAPACITY", &options.Capacity},
+		{"PREDIVIDE", &options.PreDivide},
+		{"MAXDEPTH", &options.MaxDepth},
+	} {
+		args, ok := statement.Clause(option.keyword)
+		if !ok {
+			continue
+		}
+
+		value, err := strconv.Atoi(args[0])
+		if err != nil {
+			return format.Error(invalid(CodeBadValue, "integer", strings.ToLower(option.keyword)))
+		}
+		*option.value = value
+	}
+
+	if extent, ok := statement.Clause("EXTENT"); ok {
+		bounds := make([]float64, 4)
+		for j, name := range []string{"latitude1", "longitude1", "latitude2", "longitude2"} {
+			var err error
+			bounds[j], err = strconv.ParseFloat(extent[j], 64)
+			if err != nil {
+				return format.Error(invalid(CodeBadValue, "float64", name))
+			}
+		}
+
+		options.Extent = w.Extent{Lat1: bounds[0], Lon1: bounds[1], Lat2: bounds[2], Lon2: bounds[3]}
+	}
+
+	err := p.World.CreateNamespace(ns, options)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	elapsed := time.Since(start)
+	CreateNamespaceDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return format.End("created")
+}
+
+func (*CreateNamespaceQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "CREATE NAMESPACE"
+}
+
+// FormatCreateNamespace returns the CREATE NAMESPACE query creating the namespace with all of the options, as
+// the admin API sends it through the write path.
+func FormatCreateNamespace(ns string, options w.IndexOptions) string {
+	backend := options.Backend
+	if backend == "" {
+		backend = w.IndexQuadtree
+	}
+
+	return "CREATE NAMESPACE " + Quote(ns) +
+		" BACKEND " + strings.ToUpper(backend) +
+		" CAPACITY " + strconv.Itoa(options.Capacity) +
+		" PREDIVIDE " + strconv.Itoa(options.PreDivide) +
+		" MAXDEPTH " + strconv.Itoa(options.MaxDepth) +
+		" EXTENT " + strconv.FormatFloat(options.Extent.Lat1, 'f', -1, 64) +
+		" " + strconv.FormatFloat(options.Extent.Lon1, 'f', -1, 64) +
+		" " + strconv.FormatFloat(options.Extent.Lat2, 'f', -1, 64) +
+		" " + strconv.FormatFloat(options.Extent.Lon2, 'f', -1, 64)
+}
+
+// NamespacesQueryProcessor lists the namespaces with their number of locations.
+type NamespacesQueryProcessor struct {
+	World *w.World
+}
+
+func (p *NamespacesQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer NamespaceCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
+
+	//NAMESPACES
+	if statement.Command != "NAMESPACES" { //No trust
+		panic("Invalid NAMESPACES query")
+	}
+
+	var result strings.Builder
+
+	for _, namespace := range p.World.Namespaces() {
+		result.WriteString(format.Namespace(namespace))
+	}
+
+	result.WriteString(format.End("done"))
+
+	elapsed := time.Since(start)
+	NamespaceDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return result.String()
+}
+
+func (*NamespacesQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "NAMESPACES"
+}
+
+// StatsQueryProcessor describes a namespace: its locations, its tree and its settings.
+type StatsQueryProcessor struct {
+	World *w.World
+}
+
+func (p *StatsQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer NamespaceCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
+
+	//STATS NamespaceID
+	if statement.Command != "STATS" { //No trust
+		panic("Invalid STATS query")
+	}
+
+	ns := statement.Args[0]
+
+	stats, err := p.World.Stats(ns)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	var result strings.Builder
+	result.WriteString(format.Stats(ns, stats))
+	result.WriteString(format.End("done"))
+
+	elapsed := time.Since(start)
+	NamespaceDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return result.String()
+}
+
+func (*StatsQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "STATS"
+}
+
+// FormatStats writes a namespace's statistics as key=value pairs, like attributes.
+func FormatStats(stats w.Stats) string {
+	options := stats.Options
+
+	return strings.Join([]string{
+		"locations=" + strconv.Itoa(stats.Locations),
+		"expiring=" + strconv.Itoa(stats.Expiring),
+		"fences=" + strconv.Itoa(stats.Fences),
+		"subscriptions=" + strconv.Itoa(stats.Subscriptions),
+		"grids=" + strconv.Itoa(stats.Grids),
+		"leaves=" + strconv.Itoa(stats.Leaves),
+		"depth=" + strconv.Itoa(stats.Depth),
+		"created=" + strconv.FormatBool(stats.Created),
+		"backend=" + options.Backend,
+		"capacity=" + strconv.Itoa(options.Capacity),
+		"predivide=" + strconv.Itoa(options.PreDivide),
+		"maxdepth=" + strconv.Itoa(options.MaxDepth),
+		"lat1=" + strconv.FormatFloat(options.Extent.Lat1, 'f', -1, 64),
+		"lon1=" + strconv.FormatFloat(options.Extent.Lon1, 'f', -1, 64),
+		"lat2=" + strconv.FormatFloat(options.Extent.Lat2, 'f', -1, 64),
+		"lon2=" + strconv.FormatFloat(options.Extent.Lon2, 'f', -1, 64),
+		"ttl=" + stats.DefaultTTL.String(),
+		"history=" + strconv.Itoa(stats.HistorySize),
+		"history_age=" + stats.HistoryAge.String(),
+	}, ",")
+}
+
+// DropNamespaceQueryProcessor drops a namespace and everything in it.
+type DropNamespaceQueryProcessor struct {
+	World *w.World
+}
+
+func (p *DropNamespaceQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer NamespaceCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
+
+	//DROP NAMESPACE NamespaceID
+	if statement.Command != "DROP NAMESPACE" { //No trust
+		panic("Invalid DROP NAMESPACE query")
+	}
+
+	err := p.World.DropNamespace(statement.Args[0])
+	if err != nil {
+		return format.Error(err)
+	}
+
+	elapsed := time.Since(start)
+	NamespaceDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return format.End("dropped")
+}
+
+func (*DropNamespaceQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "DROP NAMESPACE"
+}
+
+// RenameNamespaceQueryProcessor moves a namespace to a new name.
+type RenameNamespaceQueryProcessor struct {
+	World *w.World
+}
+
+func (p *RenameNamespaceQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer NamespaceCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
+
+	//RENAME NAMESPACE NamespaceID NewNamespaceID
+	if statement.Command != "RENAME NAMESPACE" { //No trust
+		panic("Invalid RENAME NAMESPACE query")
+	}
+
+	err := p.World.RenameNamespace(statement.Args[0], statement.Args[1])
+	if err != nil {
+		return format.Error(err)
+	}
+
+	elapsed := time.Since(start)
+	NamespaceDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return format.End("renamed")
+}
+
+func (*RenameNamespaceQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "RENAME NAMESPACE"
+}
@@ -0,0 +1,317 @@
+package query
+
+import (
+	"strings"
+
+	w "github.com/fabricekabongo/loggerhead/world"
+)
+
+// Statement is a parsed query: its command, the arguments in their positions and the optional clauses.
+type Statement struct {
+	// Tag is the request id the query starts with, as #Tag, echoed back by the versions of the protocol able to.
+	Tag string
+	// Command is the keywords of the statement, upper-cased and joined by a space, like SAVE or CREATE NAMESPACE.
+	Command string
+	// Args are the positional arguments, without their quotes.
+	Args []string
+	// Shape is the polygon given instead of the rest of the positional arguments, as written in the query.
+	Shape string
+	// Clauses are the arguments of the optional clauses, by their upper-cased keyword.
+	Clauses map[string][]string
+	// Attributes are the Key=Value attributes following the arguments of a save.
+	Attributes w.Attributes
+	// Items are the items of a batch statement like MSAVE, cut at the ";" between them.
+	Items []Item
+}
+
+// Item is an item of a batch statement: a statement with the arguments of one save, or the error it could not be
+// parsed with.
+type Item struct {
+	Statement *Statement
+	Err       error
+}
+
+// Clause returns the arguments of the clause, and whether the statement has it.
+func (s *Statement) Clause(keyword string) ([]string, bool) {
+	args, ok := s.Clauses[keyword]
+	return args, ok
+}
+
+// grammar is what a command takes after its keywords.
+type grammar struct {
+	// usage is the syntax of the command, as the errors show it.
+	usage string
+	// minArgs and maxArgs bound the positional arguments. maxArgs is -1 for no bound.
+	minArgs, maxArgs int
+	// shape is how many positional arguments come before the polygon the command can take in place of the rest,
+	// 0 when it takes none. A polygon is a WKT POLYGON or a GeoJSON object and runs until the next clause.
+	shape int
+	// clauses are the keywords opening the optional clauses, with how many arguments each takes; -1 takes all
+	// of the words until the next clause. The clauses are only looked for after the first minArgs arguments, so
+	// an id can be named like a keyword.
+	clauses map[string]int
+	// attributes tells if the command takes Key=Value attributes after its maxArgs arguments.
+	attributes bool
+	// item is the grammar of each item of a batch command, after its first minArgs arguments.
+	item *grammar
+	// codes tells if the command answers its errors with their codes. All of them do but GET and DELETE, which
+	// answer them with the messages of the first version of the protocol.
+	codes bool
+}
+
+var saveItem = &grammar{
+	usage:   "LocationID Latitude Longitude [TTL Duration] [Key=Value...]",
+	minArgs: 3, maxArgs: 3,
+	clauses:    map[string]int{"TTL": 1},
+	attributes: true,
+}
+
+var areaClauses = map[string]int{"WHERE": -1, "LIMIT": 1, "CURSOR": 1, "BETWEEN": 2, "FORMAT": 1}
+
+var whereClause = map[string]int{"WHERE": -1}
+
+var grammars = map[string]*grammar{
+	"GET":    {usage: "GET NamespaceID LocationID [FORMAT NDJSON|GEOJSON]", minArgs: 2, maxArgs: 2, clauses: map[string]int{"FORMAT": 1}},
+	"DELETE": {usage: "DELETE NamespaceID LocationID", minArgs: 2, maxArgs: 2},
+	"SAVE": {
+		usage:   "SAVE NamespaceID " + saveItem.usage,
+		minArgs: 4, maxArgs: 4,
+		clauses:    saveItem.clauses,
+		attributes: true,
+		codes:      true,
+	},
+	"MSAVE": {
+		usage:   "MSAVE NamespaceID " + saveItem.usage + " [; " + saveItem.usage + "...]",
+		minArgs: 1, maxArgs: 1,
+		item:  saveItem,
+		codes: true,
+	},
+	"TTL":        {usage: "TTL NamespaceID Duration", minArgs: 2, maxArgs: 2, codes: true},
+	"TRACK":      {usage: "TRACK NamespaceID Size [MaxAge]", minArgs: 2, maxArgs: 3, codes: true},
+	"FENCE ADD":  {usage: "FENCE ADD NamespaceID FenceID [DWELL Duration] Polygon", minArgs: 3, maxArgs: 3, shape: 2, clauses: map[string]int{"DWELL": 1}, codes: true},
+	"FENCE DEL":  {usage: "FENCE DEL NamespaceID FenceID", minArgs: 2, maxArgs: 2, codes: true},
+	"FENCE LIST": {usage: "FENCE LIST NamespaceID", minArgs: 1, maxArgs: 1, codes: true},
+	"POLY": {
+		usage:   "POLY NamespaceID Latitude1 Longitude1 Latitude2 Longitude2|Polygon [WHERE Conditions] [LIMIT Rows [CURSOR Token]] [BETWEEN Since Until] [FORMAT NDJSON|GEOJSON]",
+		minArgs: 5, maxArgs: 5, shape: 1,
+		clauses: areaClauses,
+		codes:   true,
+	},
+	"COUNT":            {usage: "COUNT NamespaceID Latitude1 Longitude1 Latitude2 Longitude2|Polygon [WHERE Conditions]", minArgs: 5, maxArgs: 5, shape: 1, clauses: whereClause, codes: true},
+	"GRID":             {usage: "GRID NamespaceID Latitude1 Longitude1 Latitude2 Longitude2 CellSize [WHERE Conditions]", minArgs: 6, maxArgs: 6, clauses: whereClause, codes: true},
+	"RADIUS":           {usage: "RADIUS NamespaceID Latitude Longitude Meters [WHERE Conditions]", minArgs: 4, maxArgs: 4, clauses: whereClause, codes: true},
+	"NEAREST":          {usage: "NEAREST NamespaceID Latitude Longitude K [MaxMeters] [WHERE Conditions]", minArgs: 4, maxArgs: 5, clauses: whereClause, codes: true},
+	"HISTORY":          {usage: "HISTORY NamespaceID LocationID [Since] [Until]", minArgs: 2, maxArgs: 4, codes: true},
+	"NAMESPACES":       {usage: "NAMESPACES", minArgs: 0, maxArgs: 0, codes: true},
+	"STATS":            {usage: "STATS NamespaceID", minArgs: 1, maxArgs: 1, codes: true},
+	"DROP NAMESPACE":   {usage: "DROP NAMESPACE NamespaceID", minArgs: 1, maxArgs: 1, codes: true},
+	"RENAME NAMESPACE": {usage: "RENAME NAMESPACE NamespaceID NewNamespaceID", minArgs: 2, maxArgs: 2, codes: true},
+	"CREATE NAMESPACE": {
+		usage:   "CREATE NAMESPACE NamespaceID [BACKEND QUADTREE|GRID|RTREE] [CAPACITY Locations] [PREDIVIDE Levels] [MAXDEPTH Levels] [EXTENT Latitude1 Longitude1 Latitude2 Longitude2]",
+		minArgs: 1, maxArgs: 1,
+		clauses: map[string]int{"BACKEND": 1, "CAPACITY": 1, "PREDIVIDE": 1, "MAXDEPTH": 1, "EXTENT": 4},
+		codes:   true,
+	},
+	"SUBSCRIBE": {usage: "SUBSCRIBE NamespaceID Latitude1 Longitude1 Latitude2 Longitude2", minArgs: 5, maxArgs: 5, codes: true},
+	"FENCES":    {usage: "FENCES NamespaceID", minArgs: 1, maxArgs: 1, codes: true},
+	"HELLO":     {usage: "HELLO Version", minArgs: 1, maxArgs: 1, codes: true},
+	"CANCEL":    {usage: "CANCEL", minArgs: 0, maxArgs: 0, codes: true},
+}
+
+// Parse reads a query into its statement. Keywords are read in any case. The errors are Errors with their code:
+// CodeUnterminatedString for an unclosed quote, CodeUnknownCommand for a query no command starts, and CodeSyntax
+// for the arguments and clauses out of the command's grammar. Along with an error, the statement only holds the
+// tag and the command, as far as they could be read, to answer it.
+func Parse(query string) (*Statement, error) {
+	tokens, lexErr := lex(query)
+
+	statement := &Statement{}
+	if len(tokens) > 0 && !tokens[0].quoted && strings.HasPrefix(tokens[0].text, "#") {
+		statement.Tag = tokens[0].text[1:]
+		tokens = tokens[1:]
+	}
+
+	command, g, rest := matchCommand(tokens)
+	statement.Command = command
+	if lexErr != nil {
+		return statement, lexErr
+	}
+
+	if g == nil {
+		return statement, &Error{Code: CodeUnknownCommand, Message: ErrorInvalidQuery.Error()}
+	}
+
+	if g.item != nil {
+		statement.Args = texts(rest[:min(g.minArgs, len(rest))])
+		if len(rest) <= g.minArgs {
+			return &Statement{Tag: statement.Tag, Command: command}, statement.syntaxError()
+		}
+
+		for _, item := range cutItems(rest[g.minArgs:]) {
+			itemStatement := &Statement{Command: command}
+			err := parseArgs(query, item, g.item, itemStatement)
+			if err != nil {
+				err = &Error{Code: CodeSyntax, Message: ErrorInvalidQuery.Error()}
+			}
+			statement.Items = append(statement.Items, Item{Statement: itemStatement, Err: err})
+		}
+
+		return statement, nil
+	}
+
+	err := parseArgs(query, rest, g, statement)
+	if err != nil {
+		return &Statement{Tag: statement.Tag, Command: command}, statement.syntaxError()
+	}
+
+	return statement, nil
+}
+
+// matchCommand finds the command the tokens start with, one keyword or two, and returns it with its grammar and
+// the tokens following it. The grammar is nil for an unknown command.
+func matchCommand(tokens []token) (string, *grammar, []token) {
+	if len(tokens) == 0 || tokens[0].quoted {
+		return "", nil, nil
+	}
+
+	first := strings.ToUpper(tokens[0].text)
+	if len(tokens) > 1 && !tokens[1].quoted {
+		command := first + " " + strings.ToUpper(tokens[1].text)
+		if g, ok := grammars[command]; ok {
+			return command, g, tokens[2:]
+		}
+	}
+
+	if g, ok := grammars[first]; ok {
+		return first, g, tokens[1:]
+	}
+
+	return first, nil, nil
+}
+
+// parseArgs reads the tokens following the command into the statement's arguments, polygon and clauses. It only
+// tells whether they follow the grammar: the callers answer with its usage.
+func parseArgs(query string, tokens []token, g *grammar, statement *Statement) error {
+	for i := 0; i < len(tokens); {
+		t := tokens[i]
+
+		if keyword := strings.ToUpper(t.text); len(statement.Args) >= g.minArgs || (g.shape > 0 && len(statement.Args) >= g.shape) {
+			if arity, ok := g.clauses[keyword]; ok && !t.quoted {
+				if _, seen := statement.Clauses[keyword]; seen {
+					return ErrorInvalidQuery
+				}
+
+				end := i + 1 + arity
+				if arity < 0 {
+					end = nextClause(tokens, i+1, g)
+				}
+				if end > len(tokens) {
+					return ErrorInvalidQuery
+				}
+
+				if statement.Clauses == nil {
+					statement.Clauses = map[string][]string{}
+				}
+				statement.Clauses[keyword] = texts(tokens[i+1 : end])
+				i = end
+
+				continue
+			}
+		}
+
+		if g.shape > 0 && len(statement.Args) == g.shape && statement.Shape == "" && isShape(query[t.start:t.end]) {
+			end := nextClause(tokens, i+1, g)
+			statement.Shape = query[t.start:tokens[end-1].end]
+			i = end
+
+			continue
+		}
+
+		if statement.Shape != "" {
+			return ErrorInvalidQuery
+		}
+
+		if g.attributes && len(statement.Args) == g.maxArgs {
+			key, value, ok := strings.Cut(t.text, "=")
+			if !ok {
+				return ErrorInvalidQuery
+			}
+
+			if statement.Attributes == nil {
+				statement.Attributes = w.Attributes{}
+			}
+			statement.Attributes[key] = value
+			i++
+
+			continue
+		}
+
+		statement.Args = append(statement.Args, t.text)
+		i++
+	}
+
+	if statement.Shape != "" {
+		if len(statement.Args) != g.shape {
+			return ErrorInvalidQuery
+		}
+
+		return nil
+	}
+
+	if len(statement.Args) < g.minArgs || (g.maxArgs >= 0 && len(statement.Args) > g.maxArgs) {
+		return ErrorInvalidQuery
+	}
+
+	return nil
+}
+
+// nextClause returns the position of the first token from start opening one of the grammar's clauses, or the
+// number of tokens if none does.
+func nextClause(tokens []token, start int, g *grammar) int {
+	for i := start; i < len(tokens); i++ {
+		if _, ok := g.clauses[strings.ToUpper(tokens[i].text)]; ok && !tokens[i].quoted {
+			return i
+		}
+	}
+
+	return len(tokens)
+}
+
+// isShape tells if the word, as written, starts a polygon: a WKT POLYGON or a GeoJSON object.
+func isShape(word string) bool {
+	return strings.HasPrefix(strings.ToUpper(word), "POLYGON") || strings.HasPrefix(word, "{")
+}
+
+// cutItems cuts the tokens of a batch at the ";" between its items.
+func cutItems(tokens []token) [][]token {
+	var items [][]token
+
+	start := 0
+	for i, t := range tokens {
+		if t.text == ";" && !t.quoted {
+			items = append(items, tokens[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(items, tokens[start:])
+}
+
+func texts(tokens []token) []string {
+	values := make([]string, len(tokens))
+	for i, t := range tokens {
+		values[i] = t.text
+	}
+
+	return values
+}
+
+// syntaxError returns the error of a statement that parsed but does not make sense, like a CURSOR without LIMIT.
+func (s *Statement) syntaxError() error {
+	usage := ErrorInvalidQuery.Error()
+	if g, ok := grammars[s.Command]; ok {
+		usage = "expected " + g.usage
+	}
+
+	return &Error{Code: CodeSyntax, Message: usage}
+}
@@ -0,0 +1,110 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	w "github.com/fabricekabongo/loggerhead/world"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("should read the arguments, the polygon, the clauses and the attributes", func(t *testing.T) {
+		for query, expected := range map[string]*Statement{
+			"GET ns a":            {Command: "GET", Args: []string{"ns", "a"}},
+			`get "my ns"  "a\\b"`: {Command: "GET", Args: []string{"my ns", `a\b`}},
+			"SAVE ns a 1 2 TTL 30s color=red note=\"a b\"": {
+				Command:    "SAVE",
+				Args:       []string{"ns", "a", "1", "2"},
+				Clauses:    map[string][]string{"TTL": {"30s"}},
+				Attributes: w.Attributes{"color": "red", "note": "a b"},
+			},
+			"SAVE ns TTL 1 2": {Command: "SAVE", Args: []string{"ns", "TTL", "1", "2"}},
+			"POLY ns 0 0 1 1 where color=red AND size IN s,m Limit 10": {
+				Command: "POLY",
+				Args:    []string{"ns", "0", "0", "1", "1"},
+				Clauses: map[string][]string{"WHERE": {"color=red", "AND", "size", "IN", "s,m"}, "LIMIT": {"10"}},
+			},
+			"POLY ns POLYGON ((0 0, 1 0, 1 1, 0 0)) WHERE color=red": {
+				Command: "POLY",
+				Args:    []string{"ns"},
+				Shape:   "POLYGON ((0 0, 1 0, 1 1, 0 0))",
+				Clauses: map[string][]string{"WHERE": {"color=red"}},
+			},
+			"fence add ns a DWELL 1m {\"type\": \"Polygon\"}": {
+				Command: "FENCE ADD",
+				Args:    []string{"ns", "a"},
+				Shape:   "{\"type\": \"Polygon\"}",
+				Clauses: map[string][]string{"DWELL": {"1m"}},
+			},
+			"NAMESPACES": {Command: "NAMESPACES"},
+		} {
+			statement, err := Parse(query)
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", query, err)
+				continue
+			}
+
+			if !reflect.DeepEqual(statement, expected) {
+				t.Errorf("%s: expected %+v got %+v", query, expected, statement)
+			}
+		}
+	})
+
+	t.Run("should cut a batch into its items", func(t *testing.T) {
+		statement, err := Parse(`MSAVE ns a 1 1 ; "b ;" 2 2 TTL 1m ; c 3`)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		if len(statement.Items) != 3 || statement.Args[0] != "ns" {
+			t.Fatalf("expected 3 items of ns, got %+v", statement)
+		}
+
+		if item := statement.Items[1]; item.Err != nil || item.Statement.Args[0] != "b ;" || item.Statement.Clauses["TTL"][0] != "1m" {
+			t.Errorf("expected b with its ttl, got %+v", item.Statement)
+		}
+
+		if statement.Items[2].Err == nil {
+			t.Errorf("expected the incomplete item to fail")
+		}
+	})
+
+	t.Run("should return errors with their codes", func(t *testing.T) {
+		for query, code := range map[string]string{
+			"":                                    CodeUnknownCommand,
+			"UPSERT ns a":                         CodeUnknownCommand,
+			`"GET" ns a`:                          CodeUnknownCommand,
+			`GET ns "a`:                           CodeUnterminatedString,
+			"GET ns":                              CodeSyntax,
+			"GET ns a b":                          CodeSyntax,
+			"SAVE ns a 1 2 TTL":                   CodeSyntax,
+			"POLY ns 0 0 1 1 WHERE a=b WHERE c=d": CodeSyntax,
+			"POLY ns POLYGON ((0 0)) LIMIT":       CodeSyntax,
+			"MSAVE ns":                            CodeSyntax,
+		} {
+			_, err := Parse(query)
+			if err == nil || errorCode(err) != code {
+				t.Errorf("%q: expected %s got %v", query, code, err)
+			}
+		}
+	})
+}
+
+func TestQuote(t *testing.T) {
+	for value, expected := range map[string]string{
+		"car-1":  "car-1",
+		"my car": `"my car"`,
+		`a"b\c`:  `"a\"b\\c"`,
+		";":      `";"`,
+		"":       `""`,
+	} {
+		if quoted := Quote(value); quoted != expected {
+			t.Errorf("%q: expected %s got %s", value, expected, quoted)
+		}
+
+		tokens, err := lex(Quote(value))
+		if err != nil || len(tokens) != 1 || tokens[0].text != value {
+			t.Errorf("%q: expected to read the value back, got %+v %v", value, tokens, err)
+		}
+	}
+}
@@ -1,8 +1,9 @@
 package query
 
 import (
+	"context"
 	"errors"
-	"log"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -20,14 +21,41 @@ var (
 	SaveCounter  prometheus.Counter
 	SaveDuration prometheus.Histogram
 
+	MSaveCounter  prometheus.Counter
+	MSaveDuration prometheus.Histogram
+
 	DeleteCounter  prometheus.Counter
 	DeleteDuration prometheus.Histogram
 
+	TTLCounter  prometheus.Counter
+	TTLDuration prometheus.Histogram
+
 	PolyCounter  prometheus.Counter
 	PolyDuration prometheus.Histogram
 
+	PolygonCounter  prometheus.Counter
+	PolygonDuration prometheus.Histogram
+
 	GetCounter  prometheus.Counter
 	GetDuration prometheus.Histogram
+
+	RadiusCounter  prometheus.Counter
+	RadiusDuration prometheus.Histogram
+
+	NearestCounter  prometheus.Counter
+	NearestDuration prometheus.Histogram
+
+	TrackCounter  prometheus.Counter
+	TrackDuration prometheus.Histogram
+
+	HistoryCounter  prometheus.Counter
+	HistoryDuration prometheus.Histogram
+
+	PolyBetweenCounter  prometheus.Counter
+	PolyBetweenDuration prometheus.Histogram
+
+	FenceCounter  prometheus.Counter
+	FenceDuration prometheus.Histogram
 )
 
 func init() {
@@ -52,6 +80,22 @@ func init() {
 		},
 	})
 
+	MSaveCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_query_msave_total",
+		Help: "Total number of batch save queries",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	MSaveDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_query_msave_duration_nanoseconds",
+		Help: "Duration of batch save queries in nanoseconds",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
 	DeleteCounter = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "loggerhead_query_delete_total",
 		Help: "Total number of delete queries",
@@ -68,6 +112,22 @@ func init() {
 		},
 	})
 
+	TTLCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_query_ttl_total",
+		Help: "Total number of default ttl queries",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	TTLDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_query_ttl_duration_nanoseconds",
+		Help: "Duration of default ttl queries in nanoseconds",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
 	PolyCounter = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "loggerhead_query_query_total",
 		Help: "Total number of query queries",
@@ -84,6 +144,22 @@ func init() {
 		},
 	})
 
+	PolygonCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_query_polygon_total",
+		Help: "Total number of polygon queries",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	PolygonDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_query_polygon_duration_nanoseconds",
+		Help: "Duration of polygon queries in nanoseconds",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
 	GetCounter = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "loggerhead_query_get_total",
 		Help: "Total number of get queries",
@@ -99,6 +175,102 @@ func init() {
 			"hostname": hostname,
 		},
 	})
+
+	RadiusCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_query_radius_total",
+		Help: "Total number of radius queries",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	RadiusDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_query_radius_duration_nanoseconds",
+		Help: "Duration of radius queries in nanoseconds",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	NearestCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_query_nearest_total",
+		Help: "Total number of nearest neighbor queries",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	NearestDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_query_nearest_duration_nanoseconds",
+		Help: "Duration of nearest neighbor queries in nanoseconds",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	TrackCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_query_track_total",
+		Help: "Total number of history setting queries",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	TrackDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_query_track_duration_nanoseconds",
+		Help: "Duration of history setting queries in nanoseconds",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	HistoryCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_query_history_total",
+		Help: "Total number of location history queries",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	HistoryDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_query_history_duration_nanoseconds",
+		Help: "Duration of location history queries in nanoseconds",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	PolyBetweenCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_query_poly_between_total",
+		Help: "Total number of time-sliced query queries",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	PolyBetweenDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_query_poly_between_duration_nanoseconds",
+		Help: "Duration of time-sliced query queries in nanoseconds",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	FenceCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_query_fence_total",
+		Help: "Total number of fence queries",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	FenceDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_query_fence_duration_nanoseconds",
+		Help: "Duration of fence queries in nanoseconds",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
 }
 
 type EngineInterface interface {
@@ -110,9 +282,11 @@ type Engine struct {
 	chain []Processor
 }
 
+// Processor answers the statements of a command. The engine parses each query once and hands its statement to
+// the first processor of its chain able to answer it.
 type Processor interface {
-	Execute(query string) string
-	CanProcess(query string) bool
+	Execute(statement *Statement, format Format) string
+	CanProcess(statement *Statement) bool
 }
 
 func NewQueryEngine(world *w.World) EngineInterface {
@@ -122,7 +296,24 @@ func NewQueryEngine(world *w.World) EngineInterface {
 			&GetQueryProcessor{World: world},
 			&DeleteQueryProcessor{World: world},
 			&SaveQueryProcessor{World: world},
+			&MSaveQueryProcessor{World: world},
+			&TTLQueryProcessor{World: world},
+			&TrackQueryProcessor{World: world},
+			&FenceQueryProcessor{World: world},
+			&FenceListQueryProcessor{World: world},
+			&PolygonQueryProcessor{World: world},
+			&PolyBetweenQueryProcessor{World: world},
 			&PolyQueryProcessor{World: world},
+			&CountQueryProcessor{World: world},
+			&GridQueryProcessor{World: world},
+			&RadiusQueryProcessor{World: world},
+			&NearestQueryProcessor{World: world},
+			&HistoryQueryProcessor{World: world},
+			&CreateNamespaceQueryProcessor{World: world},
+			&DropNamespaceQueryProcessor{World: world},
+			&RenameNamespaceQueryProcessor{World: world},
+			&NamespacesQueryProcessor{World: world},
+			&StatsQueryProcessor{World: world},
 		},
 	}
 }
@@ -132,7 +323,17 @@ func NewReadQueryEngine(world *w.World) EngineInterface {
 		world: world,
 		chain: []Processor{
 			&GetQueryProcessor{World: world},
+			&PolygonQueryProcessor{World: world},
+			&PolyBetweenQueryProcessor{World: world},
 			&PolyQueryProcessor{World: world},
+			&CountQueryProcessor{World: world},
+			&GridQueryProcessor{World: world},
+			&RadiusQueryProcessor{World: world},
+			&NearestQueryProcessor{World: world},
+			&HistoryQueryProcessor{World: world},
+			&FenceListQueryProcessor{World: world},
+			&NamespacesQueryProcessor{World: world},
+			&StatsQueryProcessor{World: world},
 		},
 	}
 }
@@ -142,7 +343,14 @@ func NewWriteQueryEngine(world *w.World) *Engine {
 		world: world,
 		chain: []Processor{
 			&SaveQueryProcessor{World: world},
+			&MSaveQueryProcessor{World: world},
 			&DeleteQueryProcessor{World: world},
+			&TTLQueryProcessor{World: world},
+			&TrackQueryProcessor{World: world},
+			&FenceQueryProcessor{World: world},
+			&CreateNamespaceQueryProcessor{World: world},
+			&DropNamespaceQueryProcessor{World: world},
+			&RenameNamespaceQueryProcessor{World: world},
 		},
 	}
 }
@@ -151,16 +359,23 @@ func (qp *Engine) World() *w.World {
 	return qp.world
 }
 
+// ExecuteQuery answers the query in the first version of the protocol.
 func (qp *Engine) ExecuteQuery(query string) string {
+	var answer strings.Builder
+	_ = qp.StreamQuery(context.Background(), query, V1, &answer)
+
+	return answer.String()
+}
+
+// processor returns the processor of the chain answering the statement, nil if none does.
+func (qp *Engine) processor(statement *Statement) Processor {
 	for _, processor := range qp.chain {
-		if processor.CanProcess(query) {
-			return processor.Execute(query)
+		if processor.CanProcess(statement) {
+			return processor
 		}
 	}
 
-	log.Println(ErrorInvalidQuery.Error(), query)
-
-	return version + ",\"" + ErrorInvalidQuery.Error() + "\"\n"
+	return nil
 }
 
 type GetQueryProcessor struct {
@@ -168,7 +383,7 @@ type GetQueryProcessor struct {
 	Processor
 }
 
-func (p *GetQueryProcessor) Execute(query string) string {
+func (p *GetQueryProcessor) Execute(statement *Statement, format Format) string {
 	defer GetCounter.Inc()
 	start := time.Now()
 
@@ -176,29 +391,32 @@ func (p *GetQueryProcessor) Execute(query string) string {
 		panic("world is nil")
 	}
 
-	if !p.CanProcess(query) {
+	if !p.CanProcess(statement) {
 		panic("call CanProcess before calling me")
 	}
 
 	//GET NamespaceID LocationID
-	chunks := strings.Split(query, " ")
-
-	if chunks[0] != "GET" { //No trust
+	if statement.Command != "GET" { //No trust
 		panic("Invalid GET query")
 	}
 
-	namespaceID := chunks[1]
-	locationID := chunks[2]
+	namespaceID := statement.Args[0]
+	locationID := statement.Args[1]
+
+	err := p.World.CheckNamespace(namespaceID)
+	if err != nil {
+		return format.Error(err)
+	}
 
 	location, ok := p.World.GetLocation(namespaceID, locationID)
 
 	if !ok {
-		return version + ",done\n"
+		return format.End("done")
 	}
 
 	stringBuilder := strings.Builder{}
-	stringBuilder.WriteString(version + "," + location.String() + "\n")
-	stringBuilder.WriteString(version + ",done\n")
+	stringBuilder.WriteString(format.Location(&location))
+	stringBuilder.WriteString(format.End("done"))
 
 	elapsed := time.Since(start)
 	GetDuration.Observe(float64(elapsed.Nanoseconds()))
@@ -206,13 +424,8 @@ func (p *GetQueryProcessor) Execute(query string) string {
 	return stringBuilder.String()
 }
 
-func (*GetQueryProcessor) CanProcess(query string) bool {
-	chunks := strings.Split(query, " ")
-	if len(chunks) != 3 {
-		return false
-	}
-
-	return chunks[0] == "GET"
+func (*GetQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "GET"
 }
 
 type DeleteQueryProcessor struct {
@@ -220,162 +433,922 @@ type DeleteQueryProcessor struct {
 	Processor
 }
 
-func (p *DeleteQueryProcessor) Execute(query string) string {
+func (p *DeleteQueryProcessor) Execute(statement *Statement, format Format) string {
 	defer DeleteCounter.Inc()
 	start := time.Now()
 	if p.World == nil {
 		panic("world is nil")
 	}
 
-	if !p.CanProcess(query) {
+	if !p.CanProcess(statement) {
 		panic("call CanProcess before calling me")
 	}
 
 	//DELETE NamespaceID LocationID
-	chunks := strings.Split(query, " ")
-
-	if chunks[0] != "DELETE" { //No trust
+	if statement.Command != "DELETE" { //No trust
 		panic("Invalid DELETE query")
 	}
 
-	namespaceID := chunks[1]
-	locationID := chunks[2]
+	namespaceID := statement.Args[0]
+	locationID := statement.Args[1]
 
-	p.World.Delete(namespaceID, locationID)
+	err := p.World.Delete(namespaceID, locationID)
+	if err != nil {
+		return format.Error(err)
+	}
 
 	elapsed := time.Since(start)
 	DeleteDuration.Observe(float64(elapsed.Nanoseconds()))
 
-	return version + ",deleted\n"
+	return format.End("deleted")
 }
 
-func (*DeleteQueryProcessor) CanProcess(query string) bool {
-	chunks := strings.Split(query, " ")
-	if len(chunks) != 3 {
-		return false
-	}
-
-	return chunks[0] == "DELETE"
+func (*DeleteQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "DELETE"
 }
 
+// SaveQueryProcessor saves a location. It answers its errors with their codes.
 type SaveQueryProcessor struct {
 	World *w.World
 }
 
-func (p *SaveQueryProcessor) Execute(query string) string {
+func (p *SaveQueryProcessor) Execute(statement *Statement, format Format) string {
 	defer SaveCounter.Inc()
 	start := time.Now()
 	if p.World == nil {
 		panic("world is nil")
 	}
 
-	if !p.CanProcess(query) {
+	if !p.CanProcess(statement) {
 		panic("call CanProcess before calling me")
 	}
 
-	//SAVE NamespaceID LocationID Latitude Longitude
-	chunks := strings.Split(query, " ")
-
-	if chunks[0] != "SAVE" { //No trust
+	//SAVE NamespaceID LocationID Latitude Longitude [TTL Duration] [Key=Value...]
+	if statement.Command != "SAVE" { //No trust
 		panic("Invalid SAVE query")
 	}
 
-	namespaceID := chunks[1]
-	locationID := chunks[2]
-	latitude := chunks[3]
-	longitude := chunks[4]
+	save, err := parseSave(statement, statement.Args[1:])
+	if err != nil {
+		return format.Error(err)
+	}
+
+	return p.save(statement.Args[0], save, format, start)
+}
 
-	latFloat, err := strconv.ParseFloat(latitude, 64)
+// save saves the location in the namespace and answers it, the time since start being the duration of the save.
+func (p *SaveQueryProcessor) save(ns string, save w.BatchSave, format Format, start time.Time) string {
+	err := p.World.SaveWithAttributes(ns, save.Id, save.Lat, save.Lon, save.TTL, save.Attributes)
 	if err != nil {
-		return version + "," + "\"Invalid float64 value for latitude\"\n"
+		return format.Error(err)
 	}
 
-	lonFloat, err := strconv.ParseFloat(longitude, 64)
+	elapsed := time.Since(start)
+	SaveDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return format.End("saved")
+}
+
+// parseSave reads a save from its arguments, LocationID Latitude Longitude, and from the TTL and attributes of
+// the statement holding them.
+func parseSave(statement *Statement, args []string) (w.BatchSave, error) {
+	save := w.BatchSave{Id: args[0], Attributes: statement.Attributes}
+
+	var err error
+	save.Lat, err = strconv.ParseFloat(args[1], 64)
 	if err != nil {
-		return version + "," + "\"Invalid float64 value for longitude\"\n"
+		return w.BatchSave{}, &Error{Code: CodeBadLatitude, Message: "Invalid float64 value for latitude"}
 	}
 
-	err = p.World.Save(namespaceID, locationID, latFloat, lonFloat)
+	save.Lon, err = strconv.ParseFloat(args[2], 64)
 	if err != nil {
-		return version + ",\"" + err.Error() + "\"\n"
+		return w.BatchSave{}, &Error{Code: CodeBadLongitude, Message: "Invalid float64 value for longitude"}
 	}
 
-	elapsed := time.Since(start)
-	SaveDuration.Observe(float64(elapsed.Nanoseconds()))
+	if ttl, ok := statement.Clause("TTL"); ok {
+		save.TTL, err = time.ParseDuration(ttl[0])
+		if err != nil || save.TTL <= 0 {
+			return w.BatchSave{}, &Error{Code: CodeBadTTL, Message: "Invalid duration value for ttl"}
+		}
+	}
+
+	return save, nil
+}
 
-	return version + ",saved\n"
+func (*SaveQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "SAVE"
 }
 
-func (*SaveQueryProcessor) CanProcess(query string) bool {
-	chunks := strings.Split(query, " ")
-	if len(chunks) != 5 {
-		return false
+// FormatSave returns the SAVE query saving the location, as the REST API sends it through the write path and the
+// cluster broadcasts the saves of the binary protocol. The coordinates keep all of their digits.
+func FormatSave(ns string, save w.BatchSave) string {
+	query := "SAVE " + Quote(ns) + " " + Quote(save.Id) +
+		" " + strconv.FormatFloat(save.Lat, 'f', -1, 64) +
+		" " + strconv.FormatFloat(save.Lon, 'f', -1, 64)
+
+	if save.TTL > 0 {
+		query += " TTL " + save.TTL.String()
 	}
 
-	return chunks[0] == "SAVE"
+	for _, key := range save.Attributes.Keys() {
+		query += " " + Quote(key+"="+save.Attributes[key])
+	}
+
+	return query
 }
 
-type PolyQueryProcessor struct {
+// SaveEngineInterface is an engine saving the locations of the binary protocol as they are read, without a query
+// to write and parse again.
+type SaveEngineInterface interface {
+	Save(ns string, save w.BatchSave, protocol Protocol, out io.Writer) error
+}
+
+// Save saves the location like a SAVE query would and writes the answer to out in the protocol's version. It only
+// returns the errors of out.
+func (qp *Engine) Save(ns string, save w.BatchSave, protocol Protocol, out io.Writer) error {
+	start := time.Now()
+	statement := &Statement{Command: "SAVE"}
+	format := protocol(statement)
+
+	processor, ok := qp.processor(statement).(*SaveQueryProcessor)
+	if !ok {
+		return writeString(out, format.Error(&Error{Code: CodeUnknownCommand, Message: ErrorInvalidQuery.Error()}))
+	}
+
+	SaveCounter.Inc()
+
+	return writeString(out, processor.save(ns, save, format, start))
+}
+
+// MSaveQueryProcessor saves many locations of a namespace in one query, all under one acquisition of the
+// namespace's lock. The saves that fail are reported one by one without stopping the others.
+type MSaveQueryProcessor struct {
 	World *w.World
 }
 
-func (p *PolyQueryProcessor) Execute(query string) string {
-	defer PolyCounter.Inc()
+func (p *MSaveQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer MSaveCounter.Inc()
 	start := time.Now()
 	if p.World == nil {
 		panic("world is nil")
 	}
 
-	if !p.CanProcess(query) {
+	if !p.CanProcess(statement) {
 		panic("call CanProcess before calling me")
 	}
 
-	//POLY Latitude1 Longitude1 Latitude2 Longitude2
-	chunks := strings.Split(query, " ")
+	//MSAVE NamespaceID LocationID Latitude Longitude [TTL Duration] [Key=Value...] [; LocationID Latitude Longitude ...]
+	if statement.Command != "MSAVE" { //No trust
+		panic("Invalid MSAVE query")
+	}
 
-	if chunks[0] != "POLY" { //No trust
-		panic("Invalid POLY query")
+	items := statement.Items
+	saves := make([]w.BatchSave, 0, len(items))
+	positions := make([]int, 0, len(items))
+	errs := make([]error, len(items))
+
+	for i, item := range items {
+		if item.Err != nil {
+			errs[i] = item.Err
+			continue
+		}
+
+		save, err := parseSave(item.Statement, item.Statement.Args)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		saves = append(saves, save)
+		positions = append(positions, i)
+	}
+
+	for i, err := range p.World.SaveBatch(statement.Args[0], saves) {
+		errs[positions[i]] = err
+	}
+
+	var result strings.Builder
+	saved := 0
+
+	for i, err := range errs {
+		if err == nil {
+			saved++
+			continue
+		}
+
+		id := ""
+		if args := items[i].Statement.Args; len(args) > 0 {
+			id = args[0]
+		}
+		result.WriteString(format.Item(i, id, err))
+	}
+
+	result.WriteString(format.Saved(saved))
+
+	elapsed := time.Since(start)
+	MSaveDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return result.String()
+}
+
+func (*MSaveQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "MSAVE"
+}
+
+// SplitBatch cuts an MSAVE into MSAVEs of the same namespace no longer than size bytes, as long as each item fits,
+// so it can be sent in messages of that size. The items are kept as written. Any other query is returned as is.
+func SplitBatch(query string, size int) []string {
+	if len(query) <= size {
+		return []string{query}
+	}
+
+	tokens, err := lex(query)
+	if err != nil || len(tokens) < 3 || !tokens[0].is("MSAVE") {
+		return []string{query}
+	}
+
+	prefix := "MSAVE " + query[tokens[1].start:tokens[1].end] + " "
+
+	var queries []string
+	var batch strings.Builder
+
+	for _, item := range cutItems(tokens[2:]) {
+		if len(item) == 0 {
+			continue
+		}
+		part := query[item[0].start:item[len(item)-1].end]
+
+		if batch.Len() > 0 && batch.Len()+len(" ; ")+len(part) > size {
+			queries = append(queries, batch.String())
+			batch.Reset()
+		}
+
+		if batch.Len() == 0 {
+			batch.WriteString(prefix + part)
+		} else {
+			batch.WriteString(" ; " + part)
+		}
+	}
+
+	return append(queries, batch.String())
+}
+
+// TTLQueryProcessor sets the default time-to-live of a namespace's locations.
+type TTLQueryProcessor struct {
+	World *w.World
+}
+
+func (p *TTLQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer TTLCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
 	}
 
-	ns := chunks[1]
-	lat1, err := strconv.ParseFloat(chunks[2], 64)
+	//TTL NamespaceID Duration (0 to never expire)
+	if statement.Command != "TTL" { //No trust
+		panic("Invalid TTL query")
+	}
+
+	ttl, err := time.ParseDuration(statement.Args[1])
 	if err != nil {
-		return version + "," + "\"Invalid float64 value for latitude1\"\n"
+		return format.Error(invalid(CodeBadTTL, "duration", "ttl"))
 	}
-	lon1, err := strconv.ParseFloat(chunks[3], 64)
+
+	err = p.World.SetDefaultTTL(statement.Args[0], ttl)
 	if err != nil {
-		return version + "," + "\"Invalid float64 value for longitude1\"\n"
+		return format.Error(err)
+	}
+
+	elapsed := time.Since(start)
+	TTLDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return format.End("updated")
+}
+
+func (*TTLQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "TTL"
+}
+
+// TrackQueryProcessor sets how many positions a namespace keeps in each location's history.
+type TrackQueryProcessor struct {
+	World *w.World
+}
+
+func (p *TrackQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer TrackCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
 	}
-	lat2, err := strconv.ParseFloat(chunks[4], 64)
+
+	//TRACK NamespaceID Size (0 for no count limit, with MaxAge 0 too to turn history off) [MaxAge]
+	if statement.Command != "TRACK" { //No trust
+		panic("Invalid TRACK query")
+	}
+
+	size, err := strconv.Atoi(statement.Args[1])
 	if err != nil {
-		return version + "," + "\"Invalid float64 value for latitude2\"\n"
+		return format.Error(invalid(CodeBadValue, "integer", "size"))
+	}
+
+	var maxAge time.Duration
+	if len(statement.Args) == 3 {
+		maxAge, err = time.ParseDuration(statement.Args[2])
+		if err != nil {
+			return format.Error(invalid(CodeBadValue, "duration", "max age"))
+		}
 	}
-	lon2, err := strconv.ParseFloat(chunks[5], 64)
+
+	err = p.World.SetHistory(statement.Args[0], size, maxAge)
 	if err != nil {
-		return version + "," + "\"Invalid float64 value for longitude2\"\n"
+		return format.Error(err)
+	}
+
+	elapsed := time.Since(start)
+	TrackDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return format.End("updated")
+}
+
+func (*TrackQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "TRACK"
+}
+
+// FenceQueryProcessor adds and deletes the fences of a namespace.
+type FenceQueryProcessor struct {
+	World *w.World
+}
+
+func (p *FenceQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer FenceCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
 	}
 
-	locations := p.World.QueryRange(ns, lat1, lat2, lon1, lon2)
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
 
-	var result strings.Builder
+	//FENCE ADD NamespaceID FenceID [DWELL Duration] POLYGON((Longitude Latitude, ...))|{"type":"Polygon",...}
+	//FENCE DEL NamespaceID FenceID
+	if statement.Command != "FENCE ADD" && statement.Command != "FENCE DEL" { //No trust
+		panic("Invalid FENCE query")
+	}
+
+	ns := statement.Args[0]
+	id := statement.Args[1]
+
+	if statement.Command == "FENCE DEL" {
+		err := p.World.DeleteFence(ns, id)
+		if err != nil {
+			return format.Error(err)
+		}
+
+		elapsed := time.Since(start)
+		FenceDuration.Observe(float64(elapsed.Nanoseconds()))
+
+		return format.End("deleted")
+	}
+
+	var dwell time.Duration
+	if options, ok := statement.Clause("DWELL"); ok {
+		var err error
+		dwell, err = time.ParseDuration(options[0])
+		if err != nil {
+			return format.Error(invalid(CodeBadValue, "duration", "dwell"))
+		}
+	}
+
+	shape := statement.Shape
+	if shape == "" {
+		shape = statement.Args[2]
+	}
 
-	for _, location := range locations {
-		result.WriteString(version + "," + location.String() + "\n")
+	polygon, err := w.ParsePolygon(shape)
+	if err != nil {
+		return format.Error(err)
 	}
 
-	result.WriteString(version + ",done\n")
+	err = p.World.AddFence(ns, id, polygon, dwell)
+	if err != nil {
+		return format.Error(err)
+	}
 
 	elapsed := time.Since(start)
-	PolyDuration.Observe(float64(elapsed.Nanoseconds()))
+	FenceDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return format.End("saved")
+}
+
+func (*FenceQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "FENCE ADD" || statement.Command == "FENCE DEL"
+}
+
+// FenceListQueryProcessor lists the fences of a namespace.
+type FenceListQueryProcessor struct {
+	World *w.World
+}
+
+func (p *FenceListQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer FenceCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
+
+	//FENCE LIST NamespaceID
+	if statement.Command != "FENCE LIST" { //No trust
+		panic("Invalid FENCE LIST query")
+	}
+
+	ns := statement.Args[0]
+
+	var result strings.Builder
+
+	err := p.World.CheckNamespace(ns)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	for _, fence := range p.World.Fences(ns) {
+		result.WriteString(format.Fence(ns, fence))
+	}
+
+	result.WriteString(format.End("done"))
+
+	elapsed := time.Since(start)
+	FenceDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return result.String()
+}
+
+func (*FenceListQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "FENCE LIST"
+}
+
+// PolyQueryProcessor answers POLY queries over a rectangle. It answers its errors with their codes.
+type PolyQueryProcessor struct {
+	World *w.World
+}
+
+func (p *PolyQueryProcessor) Execute(statement *Statement, format Format) string {
+	var result strings.Builder
+	_ = p.Stream(context.Background(), statement, format, &result)
+
+	return result.String()
+}
+
+// Stream writes the locations to out as the index finds them, or a page of them with LIMIT.
+func (p *PolyQueryProcessor) Stream(ctx context.Context, statement *Statement, format Format, out io.Writer) error {
+	defer PolyCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
+
+	//POLY NamespaceID Latitude1 Longitude1 Latitude2 Longitude2 [WHERE Conditions] [LIMIT Rows [CURSOR Token]]
+	if statement.Command != "POLY" { //No trust
+		panic("Invalid POLY query")
+	}
+
+	ns := statement.Args[0]
+	lat1, lon1, lat2, lon2, err := parseRectangle(statement.Args[1:5])
+	if err != nil {
+		return writeString(out, format.Error(err))
+	}
+
+	filter, err := parseWhere(statement)
+	if err != nil {
+		return writeString(out, format.Error(err))
+	}
+
+	page, err := parsePage(statement)
+	if err != nil {
+		return writeString(out, format.Error(err))
+	}
+
+	err = p.World.CheckNamespace(ns)
+	if err != nil {
+		return writeString(out, format.Error(err))
+	}
+
+	err = writeArea(ctx, out, format, page, func(visit func(location *w.Location) bool) {
+		p.World.WalkRange(ns, lat1, lat2, lon1, lon2, filter, visit)
+	})
+
+	elapsed := time.Since(start)
+	PolyDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return err
+}
+
+func (*PolyQueryProcessor) CanProcess(statement *Statement) bool {
+	_, between := statement.Clause("BETWEEN")
+
+	return statement.Command == "POLY" && statement.Shape == "" && !between
+}
+
+// PolyBetweenQueryProcessor answers POLY queries over the namespace's history: who was in the rectangle between two times.
+type PolyBetweenQueryProcessor struct {
+	World *w.World
+}
+
+func (p *PolyBetweenQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer PolyBetweenCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
+
+	//POLY NamespaceID Latitude1 Longitude1 Latitude2 Longitude2 BETWEEN Since Until
+	between, ok := statement.Clause("BETWEEN")
+	if statement.Command != "POLY" || !ok { //No trust
+		panic("Invalid POLY BETWEEN query")
+	}
+
+	if statement.Shape != "" || len(statement.Clauses) != 1 {
+		return format.Error(statement.syntaxError())
+	}
+
+	ns := statement.Args[0]
+	lat1, lon1, lat2, lon2, err := parseRectangle(statement.Args[1:5])
+	if err != nil {
+		return format.Error(err)
+	}
+	since, err := parseTime(between[0])
+	if err != nil {
+		return format.Error(&Error{Code: CodeBadTime, Message: "Invalid time value for since"})
+	}
+	until, err := parseTime(between[1])
+	if err != nil {
+		return format.Error(&Error{Code: CodeBadTime, Message: "Invalid time value for until"})
+	}
+
+	err = p.World.CheckNamespace(ns)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	sightings, err := p.World.QueryRangeBetween(ns, lat1, lat2, lon1, lon2, since, until)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	var result strings.Builder
+
+	for _, sighting := range sightings {
+		result.WriteString(format.Position(ns, sighting.Id, sighting.Position))
+	}
+
+	result.WriteString(format.End("done"))
+
+	elapsed := time.Since(start)
+	PolyBetweenDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return result.String()
+}
+
+func (*PolyBetweenQueryProcessor) CanProcess(statement *Statement) bool {
+	_, between := statement.Clause("BETWEEN")
+
+	return statement.Command == "POLY" && between
+}
+
+// PolygonQueryProcessor answers POLY queries given a WKT or GeoJSON polygon instead of a rectangle. It answers its
+// errors with their codes.
+type PolygonQueryProcessor struct {
+	World *w.World
+}
+
+func (p *PolygonQueryProcessor) Execute(statement *Statement, format Format) string {
+	var result strings.Builder
+	_ = p.Stream(context.Background(), statement, format, &result)
+
+	return result.String()
+}
+
+// Stream writes the locations to out as the index finds them, or a page of them with LIMIT.
+func (p *PolygonQueryProcessor) Stream(ctx context.Context, statement *Statement, format Format, out io.Writer) error {
+	defer PolygonCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
+
+	//POLY NamespaceID POLYGON((Longitude Latitude, ...), (hole...)) [WHERE Conditions] [LIMIT Rows [CURSOR Token]]
+	//POLY NamespaceID {"type":"Polygon",...} [WHERE Conditions] [LIMIT Rows [CURSOR Token]]
+	if statement.Command != "POLY" { //No trust
+		panic("Invalid POLY query")
+	}
+
+	ns := statement.Args[0]
+
+	polygon, err := w.ParsePolygon(statement.Shape)
+	if err != nil {
+		return writeString(out, format.Error(err))
+	}
+
+	filter, err := parseWhere(statement)
+	if err != nil {
+		return writeString(out, format.Error(err))
+	}
+
+	page, err := parsePage(statement)
+	if err != nil {
+		return writeString(out, format.Error(err))
+	}
+
+	err = p.World.CheckNamespace(ns)
+	if err != nil {
+		return writeString(out, format.Error(err))
+	}
+
+	err = writeArea(ctx, out, format, page, func(visit func(location *w.Location) bool) {
+		p.World.WalkPolygon(ns, polygon, filter, visit)
+	})
+
+	elapsed := time.Since(start)
+	PolygonDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return err
+}
+
+func (*PolygonQueryProcessor) CanProcess(statement *Statement) bool {
+	_, between := statement.Clause("BETWEEN")
+
+	return statement.Command == "POLY" && statement.Shape != "" && !between
+}
+
+type RadiusQueryProcessor struct {
+	World *w.World
+}
+
+func (p *RadiusQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer RadiusCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
+
+	//RADIUS NamespaceID Latitude Longitude Meters [WHERE Conditions]
+	if statement.Command != "RADIUS" { //No trust
+		panic("Invalid RADIUS query")
+	}
+
+	ns := statement.Args[0]
+	lat, err := strconv.ParseFloat(statement.Args[1], 64)
+	if err != nil {
+		return format.Error(invalid(CodeBadLatitude, "float64", "latitude"))
+	}
+	lon, err := strconv.ParseFloat(statement.Args[2], 64)
+	if err != nil {
+		return format.Error(invalid(CodeBadLongitude, "float64", "longitude"))
+	}
+	meters, err := strconv.ParseFloat(statement.Args[3], 64)
+	if err != nil {
+		return format.Error(invalid(CodeBadValue, "float64", "meters"))
+	}
+
+	filter, err := parseWhere(statement)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	err = p.World.CheckNamespace(ns)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	neighbors, err := p.World.QueryRadiusWhere(ns, lat, lon, meters, filter)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	var result strings.Builder
+
+	for _, neighbor := range neighbors {
+		result.WriteString(format.Neighbor(neighbor))
+	}
+
+	result.WriteString(format.End("done"))
+
+	elapsed := time.Since(start)
+	RadiusDuration.Observe(float64(elapsed.Nanoseconds()))
 
 	return result.String()
 }
 
-func (*PolyQueryProcessor) CanProcess(query string) bool {
-	chunks := strings.Split(query, " ")
-	if len(chunks) != 6 {
-		return false
+func (*RadiusQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "RADIUS"
+}
+
+type NearestQueryProcessor struct {
+	World *w.World
+}
+
+func (p *NearestQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer NearestCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
+
+	//NEAREST NamespaceID Latitude Longitude K [MaxMeters] [WHERE Conditions]
+	if statement.Command != "NEAREST" { //No trust
+		panic("Invalid NEAREST query")
+	}
+
+	ns := statement.Args[0]
+	lat, err := strconv.ParseFloat(statement.Args[1], 64)
+	if err != nil {
+		return format.Error(invalid(CodeBadLatitude, "float64", "latitude"))
+	}
+	lon, err := strconv.ParseFloat(statement.Args[2], 64)
+	if err != nil {
+		return format.Error(invalid(CodeBadLongitude, "float64", "longitude"))
+	}
+	k, err := strconv.Atoi(statement.Args[3])
+	if err != nil {
+		return format.Error(invalid(CodeBadValue, "integer", "k"))
+	}
+
+	maxMeters := 0.0
+	if len(statement.Args) == 5 {
+		maxMeters, err = strconv.ParseFloat(statement.Args[4], 64)
+		if err != nil {
+			return format.Error(invalid(CodeBadValue, "float64", "max meters"))
+		}
+	}
+
+	filter, err := parseWhere(statement)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	err = p.World.CheckNamespace(ns)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	neighbors, err := p.World.NearestWhere(ns, lat, lon, k, maxMeters, filter)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	var result strings.Builder
+
+	for _, neighbor := range neighbors {
+		result.WriteString(format.Neighbor(neighbor))
+	}
+
+	result.WriteString(format.End("done"))
+
+	elapsed := time.Since(start)
+	NearestDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return result.String()
+}
+
+func (*NearestQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "NEAREST"
+}
+
+// HistoryQueryProcessor returns the positions a location was saved at, oldest first.
+type HistoryQueryProcessor struct {
+	World *w.World
+}
+
+func (p *HistoryQueryProcessor) Execute(statement *Statement, format Format) string {
+	defer HistoryCounter.Inc()
+	start := time.Now()
+	if p.World == nil {
+		panic("world is nil")
+	}
+
+	if !p.CanProcess(statement) {
+		panic("call CanProcess before calling me")
+	}
+
+	//HISTORY NamespaceID LocationID [Since] [Until]
+	if statement.Command != "HISTORY" { //No trust
+		panic("Invalid HISTORY query")
+	}
+
+	ns := statement.Args[0]
+	id := statement.Args[1]
+
+	var since, until time.Time
+	var err error
+	if len(statement.Args) >= 3 {
+		since, err = parseTime(statement.Args[2])
+		if err != nil {
+			return format.Error(invalid(CodeBadTime, "time", "since"))
+		}
+	}
+	if len(statement.Args) == 4 {
+		until, err = parseTime(statement.Args[3])
+		if err != nil {
+			return format.Error(invalid(CodeBadTime, "time", "until"))
+		}
+	}
+
+	err = p.World.CheckNamespace(ns)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	positions, err := p.World.History(ns, id, since, until)
+	if err != nil {
+		return format.Error(err)
+	}
+
+	var result strings.Builder
+
+	for _, position := range positions {
+		result.WriteString(format.Position(ns, id, position))
+	}
+
+	result.WriteString(format.End("done"))
+
+	elapsed := time.Since(start)
+	HistoryDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return result.String()
+}
+
+func (*HistoryQueryProcessor) CanProcess(statement *Statement) bool {
+	return statement.Command == "HISTORY"
+}
+
+// formatLocation returns the location followed by its attributes, if it has some.
+func formatLocation(location *w.Location) string {
+	attributes := location.Attributes()
+	if len(attributes) == 0 {
+		return location.String()
+	}
+
+	return location.String() + "," + attributes.String()
+}
+
+// parseWhere turns the conditions of the statement's WHERE clause into a filter, nil when it has none.
+func parseWhere(statement *Statement) (w.Filter, error) {
+	where, ok := statement.Clause("WHERE")
+	if !ok {
+		return nil, nil
+	}
+
+	return w.ParseFilter(where)
+}
+
+// formatPosition returns a historic position like a location, followed by when it was saved in RFC 3339 UTC.
+func formatPosition(ns, id string, position w.Position) string {
+	return ns + "," + id + "," + strconv.FormatFloat(position.Lat, 'f', 6, 64) + "," +
+		strconv.FormatFloat(position.Lon, 'f', 6, 64) + "," + position.At.UTC().Format(time.RFC3339Nano)
+}
+
+// parseTime reads a time given as Unix seconds or in RFC 3339. A "-" leaves it unset, for an open interval.
+func parseTime(value string) (time.Time, error) {
+	if value == "-" {
+		return time.Time{}, nil
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
 	}
 
-	return chunks[0] == "POLY"
+	return time.Parse(time.RFC3339Nano, value)
 }
@@ -1,12 +1,15 @@
 package query
 
 import (
+	"context"
 	"fmt"
 	w "github.com/fabricekabongo/loggerhead/world"
 	"math/rand/v2"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestQuery(t *testing.T) {
@@ -24,6 +27,83 @@ func TestQuery(t *testing.T) {
 		})
 
 	})
+	t.Run("Query language", func(t *testing.T) {
+		t.Run("should save and find ids and values holding spaces between quotes", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			data := queryProcessor.ExecuteQuery(`SAVE "my fleet" "car \"one\"" 1 1 name="John"`)
+			if data != "1.0,saved\n" {
+				t.Fatalf("expected the location to be saved, got %q", data)
+			}
+
+			location, ok := world.GetLocation("my fleet", `car "one"`)
+			if !ok || location.Attributes()["name"] != "John" {
+				t.Fatalf("expected the quoted id and value, got %v", location.Attributes())
+			}
+
+			data = queryProcessor.ExecuteQuery(`GET "my fleet" "car \"one\""`)
+			if !strings.HasSuffix(data, "1.0,done\n") || strings.Count(data, "\n") != 2 {
+				t.Errorf("expected the location, got %q", data)
+			}
+
+			data = queryProcessor.ExecuteQuery(`POLY "my fleet" 0 0 2 2 WHERE name="John"`)
+			if strings.Count(data, "\n") != 2 {
+				t.Errorf("expected the location, got %q", data)
+			}
+		})
+
+		t.Run("should read keywords in any case and words separated by any spaces", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			for _, query := range []string{"SAVE ns a 1 1", "save  ns b 1 1 ttl 30s color=red", "Save\tns c  1 1"} {
+				if data := queryProcessor.ExecuteQuery(query); data != "1.0,saved\n" {
+					t.Errorf("%q: expected saved, got %q", query, data)
+				}
+			}
+
+			data := queryProcessor.ExecuteQuery("poly ns 0 0 2 2 where color in red,blue limit 5")
+			if strings.Count(data, "\n") != 2 {
+				t.Errorf("expected b only, got %q", data)
+			}
+		})
+
+		t.Run("should answer the errors of the commands but GET and DELETE with their codes", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			for query, expected := range map[string]string{
+				`SAVE ns "a 1 1`:                  "1.0,ERR,E_UNTERMINATED_STRING,\"unterminated quoted string\"\n",
+				"SAVE ns a 1":                     "1.0,ERR,E_SYNTAX,\"expected " + grammars["SAVE"].usage + "\"\n",
+				"SAVE ns a 1 1 TTL":               "1.0,ERR,E_SYNTAX,\"expected " + grammars["SAVE"].usage + "\"\n",
+				"SAVE ns a 1 1 color":             "1.0,ERR,E_SYNTAX,\"expected " + grammars["SAVE"].usage + "\"\n",
+				"SAVE ns a x 1":                   "1.0,ERR,E_BAD_LAT,\"Invalid float64 value for latitude\"\n",
+				"POLY ns 0 0 2":                   "1.0,ERR,E_SYNTAX,\"expected " + grammars["POLY"].usage + "\"\n",
+				"POLY ns 0 0 2 2 CURSOR x":        "1.0,ERR,E_SYNTAX,\"expected " + grammars["POLY"].usage + "\"\n",
+				"POLY ns 0 0 2 2 LIMIT 1 LIMIT 2": "1.0,ERR,E_SYNTAX,\"expected " + grammars["POLY"].usage + "\"\n",
+				"POLY ns 0 x 2 2":                 "1.0,ERR,E_BAD_LON,\"Invalid float64 value for longitude1\"\n",
+				"RADIUS ns 0 0":                   "1.0,ERR,E_SYNTAX,\"expected " + grammars["RADIUS"].usage + "\"\n",
+				"UPSERT ns a 1 1":                 "1.0,\"invalid query\"\n",
+				"GET ns":                          "1.0,\"invalid query\"\n",
+				"DELETE ns":                       "1.0,\"invalid query\"\n",
+			} {
+				if data := queryProcessor.ExecuteQuery(query); data != expected {
+					t.Errorf("%s: expected %q got %q", query, expected, data)
+				}
+			}
+		})
+
+		t.Run("should keep the quotes of the items when splitting a batch", func(t *testing.T) {
+			query := `msave "my fleet" "car one" 1 1 ; b 2 2 name="a ; b"`
+
+			batches := SplitBatch(query, 30)
+			expected := []string{`MSAVE "my fleet" "car one" 1 1`, `MSAVE "my fleet" b 2 2 name="a ; b"`}
+			if strings.Join(batches, "|") != strings.Join(expected, "|") {
+				t.Errorf("expected %q got %q", expected, batches)
+			}
+		})
+	})
 	t.Run("GetQuery", func(t *testing.T) {
 		t.Run("should return a location", func(t *testing.T) {
 			world := w.NewWorld()
@@ -140,8 +220,8 @@ func TestQuery(t *testing.T) {
 
 			data := queryProcessor.ExecuteQuery(query)
 
-			if data != "1.0,\"invalid longitude\"\n" {
-				t.Errorf("Expected \"1.0,\"invalid longitude\"\n\" but got %v", data)
+			if data != "1.0,ERR,E_BAD_LON,\"invalid longitude\"\n" {
+				t.Errorf("Expected \"1.0,ERR,E_BAD_LON,\"invalid longitude\"\n\" but got %v", data)
 			}
 
 			query = "GET ns-id-8 loc-id-9"
@@ -160,8 +240,8 @@ func TestQuery(t *testing.T) {
 
 			data := queryProcessor.ExecuteQuery(query)
 
-			if data != "1.0,\"invalid latitude\"\n" {
-				t.Errorf("Expected \"1.0,invalid latitude\n\" but got %v", data)
+			if data != "1.0,ERR,E_BAD_LAT,\"invalid latitude\"\n" {
+				t.Errorf("Expected \"1.0,ERR,E_BAD_LAT,\"invalid latitude\"\n\" but got %v", data)
 			}
 
 			query = "GET ns-id-8 loc-id-9"
@@ -180,16 +260,206 @@ func TestQuery(t *testing.T) {
 
 			data := queryProcessor.ExecuteQuery(query)
 
-			if data != "1.0,\"Invalid float64 value for latitude\"\n" {
-				t.Errorf("Expected \"1.0,Invalid float64 value for latitude\" but got %v", data)
+			if data != "1.0,ERR,E_BAD_LAT,\"Invalid float64 value for latitude\"\n" {
+				t.Errorf("Expected \"1.0,ERR,E_BAD_LAT,\"Invalid float64 value for latitude\"\" but got %v", data)
 			}
 
 			query = "SAVE ns-id-8 loc-id-9 70 monga"
 
 			data = queryProcessor.ExecuteQuery(query)
 
-			if data != "1.0,\"Invalid float64 value for longitude\"\n" {
-				t.Errorf("Expected \"1.0,Invalid float64 value for longitude\" but got %v", data)
+			if data != "1.0,ERR,E_BAD_LON,\"Invalid float64 value for longitude\"\n" {
+				t.Errorf("Expected \"1.0,ERR,E_BAD_LON,\"Invalid float64 value for longitude\"\" but got %v", data)
+			}
+		})
+	})
+	t.Run("MSAVE", func(t *testing.T) {
+		t.Run("should save the locations and report the ones that failed", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewWriteQueryEngine(world)
+
+			data := queryProcessor.ExecuteQuery("MSAVE ns a 1 1 ; b ina 2 ; c 3 3 TTL 30s color=red ; d 100 4 ; e 5 ; f 6 6")
+			expected := "1.0,1,b,\"Invalid float64 value for latitude\"\n" +
+				"1.0,3,d,\"" + w.ErrLocationInvalidLatitude.Error() + "\"\n" +
+				"1.0,4,e,\"invalid query\"\n" +
+				"1.0,saved,3\n"
+			if data != expected {
+				t.Errorf("expected %q got %q", expected, data)
+			}
+
+			location, ok := world.GetLocation("ns", "c")
+			if !ok || location.Attributes()["color"] != "red" || location.ExpiresAt().IsZero() {
+				t.Errorf("expected c to be saved with its ttl and attributes, got %v", location.String())
+			}
+
+			if len(world.QueryRange("ns", -90, 90, -180, 180)) != 3 {
+				t.Errorf("expected a, c and f to be saved")
+			}
+		})
+
+		t.Run("should split a batch into batches no longer than a size", func(t *testing.T) {
+			query := "MSAVE ns a 1 1 ; b 2 2 TTL 30s ; c 3 3 ; d 4 4"
+
+			batches := SplitBatch(query, 30)
+			expected := []string{"MSAVE ns a 1 1 ; b 2 2 TTL 30s", "MSAVE ns c 3 3 ; d 4 4"}
+			if strings.Join(batches, "|") != strings.Join(expected, "|") {
+				t.Errorf("expected %q got %q", expected, batches)
+			}
+
+			if batches := SplitBatch(query, len(query)); len(batches) != 1 || batches[0] != query {
+				t.Errorf("expected the batch as is, got %q", batches)
+			}
+
+			if batches := SplitBatch("SAVE ns a 1 1", 5); len(batches) != 1 {
+				t.Errorf("expected a save as is, got %q", batches)
+			}
+		})
+	})
+	t.Run("TTL", func(t *testing.T) {
+		t.Run("should save a location with a ttl", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			data := queryProcessor.ExecuteQuery("SAVE ns a 1 2 TTL 30s")
+			if data != "1.0,saved\n" {
+				t.Fatalf("expected \"1.0,saved\" got %v", data)
+			}
+
+			loc, ok := world.GetLocation("ns", "a")
+			if !ok {
+				t.Fatalf("expected the location to be saved")
+			}
+
+			remaining := time.Until(loc.ExpiresAt())
+			if remaining <= 25*time.Second || remaining > 30*time.Second {
+				t.Errorf("expected the location to expire in 30s, got %v", remaining)
+			}
+		})
+
+		t.Run("should set the namespace default ttl", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			data := queryProcessor.ExecuteQuery("TTL ns 1m")
+			if data != "1.0,updated\n" {
+				t.Fatalf("expected \"1.0,updated\" got %v", data)
+			}
+
+			queryProcessor.ExecuteQuery("SAVE ns a 1 2")
+
+			loc, _ := world.GetLocation("ns", "a")
+			if loc.ExpiresAt().IsZero() {
+				t.Errorf("expected the location to get the default ttl")
+			}
+
+			data = queryProcessor.ExecuteQuery("TTL ns 0")
+			if data != "1.0,updated\n" {
+				t.Fatalf("expected \"1.0,updated\" got %v", data)
+			}
+
+			queryProcessor.ExecuteQuery("SAVE ns a 1 2")
+
+			loc, _ = world.GetLocation("ns", "a")
+			if !loc.ExpiresAt().IsZero() {
+				t.Errorf("expected the location to never expire")
+			}
+		})
+
+		t.Run("should return an error for invalid ttls", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			expectations := map[string]string{
+				"SAVE ns a 1 2 TTL soon": "1.0,ERR,E_BAD_TTL,\"Invalid duration value for ttl\"\n",
+				"SAVE ns a 1 2 TTL -1s":  "1.0,ERR,E_BAD_TTL,\"Invalid duration value for ttl\"\n",
+				"SAVE ns a 1 2 TTL 0s":   "1.0,ERR,E_BAD_TTL,\"Invalid duration value for ttl\"\n",
+				"TTL ns soon":            "1.0,ERR,E_BAD_TTL,\"Invalid duration value for ttl\"\n",
+				"TTL ns -1s":             "1.0,ERR,E_BAD_TTL,\"ttl must be a positive duration\"\n",
+			}
+
+			for query, expected := range expectations {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != expected {
+					t.Errorf("%s: expected %q got %q", query, expected, data)
+				}
+			}
+		})
+	})
+	t.Run("Attributes", func(t *testing.T) {
+		t.Run("should save attributes and return them sorted after the location", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			for _, query := range []string{"SAVE ns a 1 2 type=van status=available", "SAVE ns a 1 3", "SAVE ns b 1 2 TTL 1m status=busy"} {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != "1.0,saved\n" {
+					t.Fatalf("%s: expected \"1.0,saved\" got %v", query, data)
+				}
+			}
+
+			data := queryProcessor.ExecuteQuery("GET ns a")
+			if data != "1.0,ns,a,1.000000,3.000000,status=available,type=van\n1.0,done\n" {
+				t.Errorf("unexpected GET result %q", data)
+			}
+
+			data = queryProcessor.ExecuteQuery("SAVE ns a 1 3 type=")
+			if data != "1.0,saved\n" {
+				t.Fatalf("expected \"1.0,saved\" got %v", data)
+			}
+
+			data = queryProcessor.ExecuteQuery("GET ns a")
+			if data != "1.0,ns,a,1.000000,3.000000,status=available\n1.0,done\n" {
+				t.Errorf("unexpected GET result %q", data)
+			}
+		})
+
+		t.Run("should filter read queries with WHERE", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			for _, query := range []string{
+				"SAVE ns a 1 1 status=available type=car battery=80",
+				"SAVE ns b 1 1.001 status=available type=bike battery=15",
+				"SAVE ns c 1 1.002 status=busy type=car battery=90",
+			} {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != "1.0,saved\n" {
+					t.Fatalf("%s: expected \"1.0,saved\" got %v", query, data)
+				}
+			}
+
+			queries := []string{
+				"POLY ns 0 0 2 2 WHERE status=available AND battery>=20",
+				"POLY ns POLYGON ((0 0, 2 0, 2 2, 0 2, 0 0)) WHERE status=available AND type IN car,van",
+				"RADIUS ns 1 1 10000 WHERE battery>20 AND battery<=85",
+				"NEAREST ns 1 1.002 1 WHERE status!=busy AND type=car",
+				"NEAREST ns 1 1.002 1 5000 WHERE status=available AND type=car",
+			}
+
+			for _, query := range queries {
+				data := queryProcessor.ExecuteQuery(query)
+				if !strings.HasPrefix(data, "1.0,ns,a,1.000000,1.000000,") || !strings.HasSuffix(data, "battery=80,status=available,type=car\n1.0,done\n") || strings.Count(data, "\n") != 2 {
+					t.Errorf("%s: expected only a, got %q", query, data)
+				}
+			}
+		})
+
+		t.Run("should return an error for invalid attributes and conditions", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			expectations := map[string]string{
+				"SAVE ns a 1 2 =van":                    "1.0,ERR,E_BAD_ATTRIBUTE,\"attribute keys are made of 1 to 64 letters, digits, '_', '-' or '.'\"\n",
+				`SAVE ns a 1 2 note="a\"b"`:             "1.0,ERR,E_BAD_ATTRIBUTE,\"attribute values are at most 256 characters, without spaces, commas, quotes or '='\"\n",
+				"POLY ns 0 0 2 2 WHERE":                 "1.0,ERR,E_BAD_FILTER,\"invalid WHERE clause, expected conditions like key=value, key IN a,b or key>=10 joined by AND\"\n",
+				"RADIUS ns 1 1 100 WHERE battery>=high": "1.0,ERR,E_BAD_FILTER,\"numeric comparisons in WHERE clauses need a number\"\n",
+			}
+
+			for query, expected := range expectations {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != expected {
+					t.Errorf("%s: expected %q got %q", query, expected, data)
+				}
 			}
 		})
 	})
@@ -243,5 +513,742 @@ func TestQuery(t *testing.T) {
 				t.Errorf("Expected '1.0,' but got %v", data)
 			}
 		})
+		t.Run("should cross the antimeridian when longitude1 is greater than longitude2", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			for _, query := range []string{"SAVE ns fiji -17.7 178.4", "SAVE ns samoa -13.8 -172.1", "SAVE ns tahiti -17.6 -149.4"} {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != "1.0,saved\n" {
+					t.Fatalf("expected \"1.0,saved\" got %v", data)
+				}
+			}
+
+			data := queryProcessor.ExecuteQuery("POLY ns -20 170 -10 -170")
+
+			lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+			sort.Strings(lines)
+
+			expected := []string{"1.0,done", "1.0,ns,fiji,-17.700000,178.400000", "1.0,ns,samoa,-13.800000,-172.100000"}
+			if strings.Join(lines, "|") != strings.Join(expected, "|") {
+				t.Errorf("expected %q got %q", expected, lines)
+			}
+		})
+
+		t.Run("should page through the locations in the order of their ids with LIMIT and CURSOR", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+			for _, id := range []string{"e", "b", "d", "a", "c"} {
+				_ = world.SaveWithAttributes("ns", id, 1, 1, 0, w.Attributes{"type": "van"})
+			}
+			_ = world.Save("ns", "z", 50, 50)
+
+			data := queryProcessor.ExecuteQuery("POLY ns 0 0 2 2 WHERE type=van LIMIT 2")
+			expected := "1.0,ns,a,1.000000,1.000000,type=van\n1.0,ns,b,1.000000,1.000000,type=van\n1.0,cursor,Yg\n1.0,done\n"
+			if data != expected {
+				t.Fatalf("expected %q got %q", expected, data)
+			}
+
+			data = queryProcessor.ExecuteQuery("POLY ns 0 0 2 2 LIMIT 2 CURSOR Yg")
+			expected = "1.0,ns,c,1.000000,1.000000,type=van\n1.0,ns,d,1.000000,1.000000,type=van\n1.0,cursor,ZA\n1.0,done\n"
+			if data != expected {
+				t.Fatalf("expected %q got %q", expected, data)
+			}
+
+			data = queryProcessor.ExecuteQuery("POLY ns POLYGON((0 0, 2 0, 2 2, 0 2, 0 0)) LIMIT 2 CURSOR ZA")
+			expected = "1.0,ns,e,1.000000,1.000000,type=van\n1.0,done\n"
+			if data != expected {
+				t.Fatalf("expected %q got %q", expected, data)
+			}
+
+			expectations := map[string]string{
+				"POLY ns 0 0 2 2 LIMIT 0":           "1.0,ERR,E_BAD_LIMIT,\"Invalid integer value for limit\"\n",
+				"POLY ns 0 0 2 2 LIMIT x":           "1.0,ERR,E_BAD_LIMIT,\"Invalid integer value for limit\"\n",
+				"POLY ns 0 0 2 2 LIMIT 2 CURSOR !!": "1.0,ERR,E_BAD_CURSOR,\"" + ErrInvalidCursor.Error() + "\"\n",
+			}
+			for query, expected := range expectations {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != expected {
+					t.Errorf("%s: expected %q got %q", query, expected, data)
+				}
+			}
+		})
+
+		t.Run("should stream the rows and stop when canceled", func(t *testing.T) {
+			world := w.NewWorld()
+			engine := NewQueryEngine(world).(*Engine)
+			for i := 0; i < 100; i++ {
+				_ = world.Save("ns", strconv.Itoa(i), 1, 1)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			out := &cancelingWriter{cancel: cancel, after: 10}
+
+			err := engine.StreamQuery(ctx, "POLY ns 0 0 2 2", V1, out)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if len(lines) != 11 || lines[10] != "1.0,ERR,E_CANCELED,\""+ErrQueryCanceled.Error()+"\"" {
+				t.Errorf("expected 10 rows and the cancellation, got %q", lines)
+			}
+		})
 	})
+	t.Run("POLY Query with a polygon", func(t *testing.T) {
+		t.Run("should return the locations inside the polygon and not in its holes", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			for _, query := range []string{"SAVE ns in 1 1", "SAVE ns hole 5 5", "SAVE ns out 9 1"} {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != "1.0,saved\n" {
+					t.Fatalf("expected \"1.0,saved\" got %v", data)
+				}
+			}
+
+			queries := []string{
+				"POLY ns POLYGON ((0 0, 10 0, 10 8, 0 8, 0 0), (4 4, 6 4, 6 6, 4 6, 4 4))",
+				"POLY ns polygon((0 0,10 0,10 8,0 8,0 0),(4 4,6 4,6 6,4 6,4 4))",
+				`POLY ns {"type":"Polygon","coordinates":[[[0,0],[10,0],[10,8],[0,8],[0,0]],[[4,4],[6,4],[6,6],[4,6],[4,4]]]}`,
+			}
+
+			for _, query := range queries {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != "1.0,ns,in,1.000000,1.000000\n1.0,done\n" {
+					t.Errorf("%s: expected only \"in\" got %q", query, data)
+				}
+			}
+		})
+
+		t.Run("should return an error for an invalid polygon", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			expectations := map[string]string{
+				"POLY ns POLYGON ((0 0, 1 a, 0 1, 0 0))":       "1.0,ERR,E_BAD_POLYGON,\"invalid polygon, expected a WKT POLYGON or a GeoJSON Polygon\"\n",
+				"POLY ns POLYGON ((0 0, 1 1, 0 0))":            "1.0,ERR,E_BAD_POLYGON,\"polygon rings need at least 3 distinct points\"\n",
+				"POLY ns POLYGON ((0 0, 200 0, 0 1, 0 0))":     "1.0,ERR,E_BAD_POLYGON,\"polygon coordinates must be valid latitudes and longitudes\"\n",
+				`POLY ns {"type":"Point","coordinates":[0,0]}`: "1.0,ERR,E_BAD_POLYGON,\"invalid polygon, expected a WKT POLYGON or a GeoJSON Polygon\"\n",
+			}
+
+			for query, expected := range expectations {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != expected {
+					t.Errorf("%s: expected %q got %q", query, expected, data)
+				}
+			}
+		})
+	})
+	t.Run("COUNT Query", func(t *testing.T) {
+		t.Run("should count the locations in a rectangle or a polygon", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+			_ = world.SaveWithAttributes("ns", "car", 1, 1, 0, w.Attributes{"type": "car"})
+			_ = world.SaveWithAttributes("ns", "van", 1.5, 1.5, 0, w.Attributes{"type": "van"})
+			_ = world.Save("ns", "out", 9, 9)
+
+			expectations := map[string]string{
+				"COUNT ns 0 0 2 2":                                            "1.0,2\n",
+				"COUNT ns 0 0 2 2 WHERE type=car":                             "1.0,1\n",
+				"COUNT ns -20 170 -10 -170":                                   "1.0,0\n",
+				"COUNT ns POLYGON ((0 5, 10 5, 10 10, 0 10, 0 5))":            "1.0,1\n",
+				"COUNT ns POLYGON ((0 0, 10 0, 10 10, 0 10, 0 0))":            "1.0,3\n",
+				"COUNT ns POLYGON ((0 0, 2 0, 2 2, 0 2, 0 0)) WHERE type=van": "1.0,1\n",
+				"COUNT unknown 0 0 2 2":                                       "1.0,0\n",
+			}
+
+			for query, expected := range expectations {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != expected {
+					t.Errorf("%s: expected %q got %q", query, expected, data)
+				}
+			}
+		})
+
+		t.Run("should return an error for invalid arguments", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			expectations := map[string]string{
+				"COUNT ns 0 a 2 2":                   "1.0,ERR,E_BAD_LON,\"Invalid float64 value for longitude1\"\n",
+				"COUNT ns 0 0 2 2 WHERE type":        "1.0,ERR,E_BAD_FILTER,\"" + w.ErrInvalidFilter.Error() + "\"\n",
+				"COUNT ns POLYGON ((0 0, 1 1, 0 0))": "1.0,ERR,E_BAD_POLYGON,\"polygon rings need at least 3 distinct points\"\n",
+				"COUNT ns":                           "1.0,ERR,E_SYNTAX,\"expected " + grammars["COUNT"].usage + "\"\n",
+			}
+
+			for query, expected := range expectations {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != expected {
+					t.Errorf("%s: expected %q got %q", query, expected, data)
+				}
+			}
+		})
+	})
+
+	t.Run("GRID Query", func(t *testing.T) {
+		t.Run("should return the count of each cell holding locations", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+			_ = world.SaveWithAttributes("ns", "a", 1, 1, 0, w.Attributes{"type": "car"})
+			_ = world.SaveWithAttributes("ns", "b", 1.5, 1.5, 0, w.Attributes{"type": "van"})
+			_ = world.SaveWithAttributes("ns", "c", 15, 5, 0, w.Attributes{"type": "car"})
+
+			data := queryProcessor.ExecuteQuery("GRID ns 0 0 20 20 10")
+			expected := "1.0,0.000000,0.000000,10.000000,10.000000,2\n1.0,10.000000,0.000000,20.000000,10.000000,1\n1.0,done\n"
+			if data != expected {
+				t.Errorf("expected %q got %q", expected, data)
+			}
+
+			data = queryProcessor.ExecuteQuery("GRID ns 0 0 20 20 10 WHERE type=van")
+			expected = "1.0,0.000000,0.000000,10.000000,10.000000,1\n1.0,done\n"
+			if data != expected {
+				t.Errorf("expected %q got %q", expected, data)
+			}
+		})
+
+		t.Run("should return an error for invalid arguments", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			expectations := map[string]string{
+				"GRID ns 0 0 20 20 x":          "1.0,ERR,E_BAD_VALUE,\"Invalid float64 value for cell size\"\n",
+				"GRID ns 0 0 20 20 -1":         "1.0,ERR,E_BAD_VALUE,\"" + w.ErrInvalidCellSize.Error() + "\"\n",
+				"GRID ns -90 -180 90 180 0.01": "1.0,ERR,E_BAD_VALUE,\"" + w.ErrTooManyCells.Error() + "\"\n",
+				"GRID ns 0 0 100 20 1":         "1.0,ERR,E_BAD_LAT,\"" + w.ErrLocationInvalidLatitude.Error() + "\"\n",
+			}
+
+			for query, expected := range expectations {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != expected {
+					t.Errorf("%s: expected %q got %q", query, expected, data)
+				}
+			}
+		})
+	})
+	t.Run("RADIUS Query", func(t *testing.T) {
+		t.Run("should return the locations in the circle closest first with their distance", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			for _, query := range []string{"SAVE ns far 0 0.009", "SAVE ns near 0 0.001", "SAVE ns out 0 1"} {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != "1.0,saved\n" {
+					t.Fatalf("expected \"1.0,saved\" got %v", data)
+				}
+			}
+
+			data := queryProcessor.ExecuteQuery("RADIUS ns 0 0 1500")
+
+			lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+			if len(lines) != 3 {
+				t.Fatalf("expected 2 locations and done, got %q", data)
+			}
+
+			if !strings.HasPrefix(lines[0], "1.0,ns,near,0.000000,0.001000,111.") {
+				t.Errorf("expected near first with its distance, got %q", lines[0])
+			}
+
+			if !strings.HasPrefix(lines[1], "1.0,ns,far,0.000000,0.009000,1000.") {
+				t.Errorf("expected far second with its distance, got %q", lines[1])
+			}
+
+			if lines[2] != "1.0,done" {
+				t.Errorf("expected done, got %q", lines[2])
+			}
+		})
+
+		t.Run("should return an error for invalid arguments", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			expectations := map[string]string{
+				"RADIUS ns a 0 10":  "1.0,ERR,E_BAD_LAT,\"Invalid float64 value for latitude\"\n",
+				"RADIUS ns 0 a 10":  "1.0,ERR,E_BAD_LON,\"Invalid float64 value for longitude\"\n",
+				"RADIUS ns 0 0 a":   "1.0,ERR,E_BAD_VALUE,\"Invalid float64 value for meters\"\n",
+				"RADIUS ns 0 0 -1":  "1.0,ERR,E_BAD_VALUE,\"radius must be a positive number of meters\"\n",
+				"RADIUS ns 91 0 10": "1.0,ERR,E_BAD_LAT,\"invalid latitude\"\n",
+			}
+
+			for query, expected := range expectations {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != expected {
+					t.Errorf("%s: expected %q got %q", query, expected, data)
+				}
+			}
+		})
+	})
+	t.Run("NEAREST Query", func(t *testing.T) {
+		t.Run("should return the k closest locations closest first", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			for _, query := range []string{"SAVE ns c 0 0.003", "SAVE ns a 0 0.001", "SAVE ns b 0 0.002"} {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != "1.0,saved\n" {
+					t.Fatalf("expected \"1.0,saved\" got %v", data)
+				}
+			}
+
+			data := queryProcessor.ExecuteQuery("NEAREST ns 0 0 2")
+
+			lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+			if len(lines) != 3 {
+				t.Fatalf("expected 2 locations and done, got %q", data)
+			}
+
+			if !strings.HasPrefix(lines[0], "1.0,ns,a,") || !strings.HasPrefix(lines[1], "1.0,ns,b,") || lines[2] != "1.0,done" {
+				t.Errorf("expected a then b then done, got %q", data)
+			}
+		})
+
+		t.Run("should honour the max distance", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			_ = queryProcessor.ExecuteQuery("SAVE ns a 0 0.001")
+			_ = queryProcessor.ExecuteQuery("SAVE ns b 0 1")
+
+			data := queryProcessor.ExecuteQuery("NEAREST ns 0 0 10 500")
+
+			if !strings.HasPrefix(data, "1.0,ns,a,0.000000,0.001000,111.") || !strings.HasSuffix(data, "\n1.0,done\n") || strings.Count(data, "\n") != 2 {
+				t.Errorf("expected only a, got %q", data)
+			}
+		})
+
+		t.Run("should return an error for invalid arguments", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			expectations := map[string]string{
+				"NEAREST ns 0 0 x":    "1.0,ERR,E_BAD_VALUE,\"Invalid integer value for k\"\n",
+				"NEAREST ns 0 0 0":    "1.0,ERR,E_BAD_VALUE,\"the number of neighbors must be a positive integer\"\n",
+				"NEAREST ns 0 0 1 x":  "1.0,ERR,E_BAD_VALUE,\"Invalid float64 value for max meters\"\n",
+				"NEAREST ns x 0 1":    "1.0,ERR,E_BAD_LAT,\"Invalid float64 value for latitude\"\n",
+				"NEAREST ns 0 x 1":    "1.0,ERR,E_BAD_LON,\"Invalid float64 value for longitude\"\n",
+				"NEAREST ns 0 0 1 -1": "1.0,ERR,E_BAD_VALUE,\"radius must be a positive number of meters\"\n",
+			}
+
+			for query, expected := range expectations {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != expected {
+					t.Errorf("%s: expected %q got %q", query, expected, data)
+				}
+			}
+		})
+	})
+
+	t.Run("History", func(t *testing.T) {
+		t.Run("should return the positions a location was saved at", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			data := queryProcessor.ExecuteQuery("TRACK ns 2 1h")
+			if data != "1.0,updated\n" {
+				t.Fatalf("expected \"1.0,updated\" got %q", data)
+			}
+
+			for _, query := range []string{"SAVE ns a 1 1", "SAVE ns a 2 2", "SAVE ns a 3 3"} {
+				_ = queryProcessor.ExecuteQuery(query)
+			}
+
+			data = queryProcessor.ExecuteQuery("HISTORY ns a")
+			lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+			if len(lines) != 3 || !strings.HasPrefix(lines[0], "1.0,ns,a,2.000000,2.000000,") || !strings.HasPrefix(lines[1], "1.0,ns,a,3.000000,3.000000,") || lines[2] != "1.0,done" {
+				t.Fatalf("expected the last 2 positions, got %q", data)
+			}
+
+			at, err := time.Parse(time.RFC3339Nano, strings.Split(lines[1], ",")[5])
+			if err != nil {
+				t.Fatalf("expected an RFC 3339 time, got %q", lines[1])
+			}
+
+			data = queryProcessor.ExecuteQuery("HISTORY ns a " + at.Format(time.RFC3339Nano))
+			if !strings.HasPrefix(data, "1.0,ns,a,3.000000,3.000000,") || strings.Count(data, "\n") != 2 {
+				t.Errorf("expected the last position, got %q", data)
+			}
+
+			data = queryProcessor.ExecuteQuery("HISTORY ns a - 0")
+			if data != "1.0,done\n" {
+				t.Errorf("expected nothing before 1970, got %q", data)
+			}
+		})
+
+		t.Run("should find who was in the rectangle between two times", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			_ = queryProcessor.ExecuteQuery("TRACK ns 10")
+			_ = queryProcessor.ExecuteQuery("SAVE ns a 1 1")
+			_ = queryProcessor.ExecuteQuery("SAVE ns b 50 50")
+
+			data := NewReadQueryEngine(world).ExecuteQuery("POLY ns 0 0 10 10 BETWEEN 0 " + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+			if !strings.HasPrefix(data, "1.0,ns,a,1.000000,1.000000,") || !strings.HasSuffix(data, "\n1.0,done\n") || strings.Count(data, "\n") != 2 {
+				t.Errorf("expected only a, got %q", data)
+			}
+		})
+
+		t.Run("should return an error for invalid arguments", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+			_ = queryProcessor.ExecuteQuery("TRACK on 10")
+
+			expectations := map[string]string{
+				"TRACK ns x":                    "1.0,ERR,E_BAD_VALUE,\"Invalid integer value for size\"\n",
+				"TRACK ns 1 x":                  "1.0,ERR,E_BAD_VALUE,\"Invalid duration value for max age\"\n",
+				"TRACK ns -1":                   "1.0,ERR,E_BAD_VALUE,\"history size and age must be positive, both 0 turn history off\"\n",
+				"HISTORY off a":                 "1.0,ERR,E_HISTORY_DISABLED,\"history is not kept for this namespace\"\n",
+				"HISTORY on a yesterday":        "1.0,ERR,E_BAD_TIME,\"Invalid time value for since\"\n",
+				"HISTORY on a - tomorrow":       "1.0,ERR,E_BAD_TIME,\"Invalid time value for until\"\n",
+				"POLY on 0 0 1 1 BETWEEN x 0":   "1.0,ERR,E_BAD_TIME,\"Invalid time value for since\"\n",
+				"POLY on 0 0 1 1 BETWEEN 0 x":   "1.0,ERR,E_BAD_TIME,\"Invalid time value for until\"\n",
+				"POLY on x 0 1 1 BETWEEN 0 0":   "1.0,ERR,E_BAD_LAT,\"Invalid float64 value for latitude1\"\n",
+				"POLY off 0 0 1 1 BETWEEN 0 0":  "1.0,ERR,E_HISTORY_DISABLED,\"history is not kept for this namespace\"\n",
+				"POLY on 0 0 1 1 BETWEEN 0 0 1": "1.0,ERR,E_SYNTAX,\"expected " + grammars["POLY"].usage + "\"\n",
+			}
+
+			for query, expected := range expectations {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != expected {
+					t.Errorf("%s: expected %q got %q", query, expected, data)
+				}
+			}
+		})
+	})
+
+	t.Run("FENCE", func(t *testing.T) {
+		t.Run("should add, list and delete fences", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			data := queryProcessor.ExecuteQuery("FENCE ADD ns depot DWELL 5m POLYGON ((0 0, 10 0, 10 10, 0 10, 0 0))")
+			if data != "1.0,saved\n" {
+				t.Fatalf("expected \"1.0,saved\" got %q", data)
+			}
+
+			data = queryProcessor.ExecuteQuery(`FENCE ADD ns yard {"type":"Polygon","coordinates":[[[20,20],[30,20],[30,30],[20,20]]]}`)
+			if data != "1.0,saved\n" {
+				t.Fatalf("expected \"1.0,saved\" got %q", data)
+			}
+
+			data = NewReadQueryEngine(world).ExecuteQuery("FENCE LIST ns")
+			expected := "1.0,ns,depot,5m0s,POLYGON ((0 0, 10 0, 10 10, 0 10, 0 0))\n" +
+				"1.0,ns,yard,0s,POLYGON ((20 20, 30 20, 30 30, 20 20))\n" +
+				"1.0,done\n"
+			if data != expected {
+				t.Errorf("expected %q got %q", expected, data)
+			}
+
+			data = queryProcessor.ExecuteQuery("FENCE DEL ns yard")
+			if data != "1.0,deleted\n" {
+				t.Fatalf("expected \"1.0,deleted\" got %q", data)
+			}
+
+			if len(world.Fences("ns")) != 1 {
+				t.Errorf("expected only the depot fence, got %v", world.Fences("ns"))
+			}
+		})
+
+		t.Run("should stream the events of the fences", func(t *testing.T) {
+			world := w.NewWorld()
+			engine := NewSubscriberQueryEngine(world, 4, w.DropEvents)
+
+			subscription, format, response := engine.Subscribe("FENCES ns", V1)
+			if subscription == nil || response != "1.0,subscribed\n" {
+				t.Fatalf("expected \"1.0,subscribed\" got %q", response)
+			}
+			defer subscription.Close()
+
+			queryProcessor := NewQueryEngine(world)
+			_ = queryProcessor.ExecuteQuery("FENCE ADD ns depot POLYGON ((0 0, 10 0, 10 10, 0 10, 0 0))")
+			_ = queryProcessor.ExecuteQuery("SAVE ns a 5 5 status=busy")
+			_ = queryProcessor.ExecuteQuery("DELETE ns a")
+
+			for _, expected := range []string{
+				"1.0,FENCE,ENTER,ns,depot,a,5.000000,5.000000,status=busy\n",
+				"1.0,FENCE,EXIT,ns,depot,a,5.000000,5.000000,status=busy\n",
+			} {
+				data := format.Event(<-subscription.Events())
+				if data != expected {
+					t.Errorf("expected %q got %q", expected, data)
+				}
+			}
+		})
+
+		t.Run("should return an error for invalid arguments", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			expectations := map[string]string{
+				"FENCE ADD ns a DWELL x POLYGON ((0 0, 1 0, 1 1, 0 0))":   "1.0,ERR,E_BAD_VALUE,\"Invalid duration value for dwell\"\n",
+				"FENCE ADD ns a DWELL 1m":                                 "1.0,ERR,E_SYNTAX,\"expected " + grammars["FENCE ADD"].usage + "\"\n",
+				"FENCE ADD ns a DWELL -1m POLYGON ((0 0, 1 0, 1 1, 0 0))": "1.0,ERR,E_BAD_VALUE,\"" + w.ErrInvalidFenceDwell.Error() + "\"\n",
+				"FENCE ADD ns a+b POLYGON ((0 0, 1 0, 1 1, 0 0))":         "1.0,ERR,E_BAD_ID,\"" + w.ErrInvalidFenceId.Error() + "\"\n",
+				"FENCE ADD ns a":  "1.0,ERR,E_SYNTAX,\"expected " + grammars["FENCE ADD"].usage + "\"\n",
+				"FENCE DEL ns":    "1.0,ERR,E_SYNTAX,\"expected " + grammars["FENCE DEL"].usage + "\"\n",
+				"FENCE LIST ns x": "1.0,ERR,E_SYNTAX,\"expected " + grammars["FENCE LIST"].usage + "\"\n",
+			}
+
+			for query, expected := range expectations {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != expected {
+					t.Errorf("%s: expected %q got %q", query, expected, data)
+				}
+			}
+		})
+	})
+
+	t.Run("CREATE NAMESPACE", func(t *testing.T) {
+		t.Run("should create a namespace with its options, the others taking the defaults", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewWriteQueryEngine(world)
+
+			data := queryProcessor.ExecuteQuery("CREATE NAMESPACE paris CAPACITY 100 EXTENT 48.8 2.2 48.9 2.5")
+			if data != "1.0,created\n" {
+				t.Fatalf("expected \"1.0,created\" got %q", data)
+			}
+
+			expected := w.DefaultIndexOptions()
+			expected.Capacity = 100
+			expected.Extent = w.Extent{Lat1: 48.8, Lat2: 48.9, Lon1: 2.2, Lon2: 2.5}
+
+			catalog := world.Catalog()
+			if len(catalog) != 1 || catalog[0].Name != "paris" || catalog[0].Options != expected {
+				t.Fatalf("expected paris with %+v, got %+v", expected, catalog)
+			}
+
+			data = queryProcessor.ExecuteQuery("SAVE paris a 40 2.3")
+			if data != "1.0,ERR,E_OUT_OF_EXTENT,\"location is outside of the namespace's extent\"\n" {
+				t.Errorf("expected the extent to be enforced, got %q", data)
+			}
+
+			expected.Backend = w.IndexGrid
+			expected.PreDivide = 2
+			expected.MaxDepth = 10
+			data = queryProcessor.ExecuteQuery(FormatCreateNamespace("paris", expected))
+			if data != "1.0,created\n" || world.Catalog()[0].Options != expected {
+				t.Errorf("expected %+v, got %q and %+v", expected, data, world.Catalog())
+			}
+		})
+
+		t.Run("should return an error for invalid arguments", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+
+			expectations := map[string]string{
+				"CREATE NAMESPACE":                       "1.0,ERR,E_SYNTAX,\"expected " + grammars["CREATE NAMESPACE"].usage + "\"\n",
+				"CREATE NAMESPACE ns CAPACITY":           "1.0,ERR,E_SYNTAX,\"expected " + grammars["CREATE NAMESPACE"].usage + "\"\n",
+				"CREATE NAMESPACE ns BACKEND":            "1.0,ERR,E_SYNTAX,\"expected " + grammars["CREATE NAMESPACE"].usage + "\"\n",
+				"CREATE NAMESPACE ns BACKEND BTREE":      "1.0,ERR,E_BAD_VALUE,\"" + w.ErrInvalidIndexBackend.Error() + "\"\n",
+				"CREATE NAMESPACE ns SIZE 10":            "1.0,ERR,E_SYNTAX,\"expected " + grammars["CREATE NAMESPACE"].usage + "\"\n",
+				"CREATE NAMESPACE ns EXTENT 1 2 3":       "1.0,ERR,E_SYNTAX,\"expected " + grammars["CREATE NAMESPACE"].usage + "\"\n",
+				"CREATE NAMESPACE ns CAPACITY x":         "1.0,ERR,E_BAD_VALUE,\"Invalid integer value for capacity\"\n",
+				"CREATE NAMESPACE ns PREDIVIDE x":        "1.0,ERR,E_BAD_VALUE,\"Invalid integer value for predivide\"\n",
+				"CREATE NAMESPACE ns MAXDEPTH x":         "1.0,ERR,E_BAD_VALUE,\"Invalid integer value for maxdepth\"\n",
+				"CREATE NAMESPACE ns EXTENT 1 2 3 x":     "1.0,ERR,E_BAD_VALUE,\"Invalid float64 value for longitude2\"\n",
+				"CREATE NAMESPACE ns CAPACITY 0":         "1.0,ERR,E_BAD_VALUE,\"" + w.ErrInvalidIndexCapacity.Error() + "\"\n",
+				"CREATE NAMESPACE ns PREDIVIDE 9":        "1.0,ERR,E_BAD_VALUE,\"" + w.ErrInvalidIndexPreDivide.Error() + "\"\n",
+				"CREATE NAMESPACE ns MAXDEPTH 2":         "1.0,ERR,E_BAD_VALUE,\"" + w.ErrInvalidIndexMaxDepth.Error() + "\"\n",
+				"CREATE NAMESPACE ns EXTENT 10 0 -10 20": "1.0,ERR,E_BAD_VALUE,\"" + w.ErrInvalidIndexExtent.Error() + "\"\n",
+			}
+
+			for query, expected := range expectations {
+				data := queryProcessor.ExecuteQuery(query)
+				if data != expected {
+					t.Errorf("%s: expected %q got %q", query, expected, data)
+				}
+			}
+
+			if len(world.Catalog()) != 0 {
+				t.Errorf("expected no namespace to be created, got %+v", world.Catalog())
+			}
+		})
+	})
+
+	t.Run("NAMESPACES", func(t *testing.T) {
+		t.Run("should list the namespaces with their number of locations", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewReadQueryEngine(world)
+
+			data := queryProcessor.ExecuteQuery("NAMESPACES")
+			if data != "1.0,done\n" {
+				t.Errorf("expected no namespace, got %q", data)
+			}
+
+			_ = world.Save("b", "1", 1, 1)
+			_ = world.Save("b", "2", 1, 1)
+			_ = world.Save("a", "1", 1, 1)
+
+			data = queryProcessor.ExecuteQuery("NAMESPACES")
+			if data != "1.0,a,1\n1.0,b,2\n1.0,done\n" {
+				t.Errorf("expected a and b, got %q", data)
+			}
+		})
+	})
+
+	t.Run("STATS", func(t *testing.T) {
+		t.Run("should describe the namespace", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewReadQueryEngine(world)
+			_ = world.CreateNamespace("paris", w.IndexOptions{Capacity: 10, PreDivide: 1, MaxDepth: 4, Extent: w.Extent{Lat1: 48.8, Lat2: 48.9, Lon1: 2.2, Lon2: 2.5}})
+			_ = world.Save("paris", "a", 48.85, 2.35)
+
+			data := queryProcessor.ExecuteQuery("STATS paris")
+			expected := "1.0,paris,locations=1,expiring=0,fences=0,subscriptions=0,grids=5,leaves=4,depth=1,created=true," +
+				"backend=quadtree,capacity=10,predivide=1,maxdepth=4,lat1=48.8,lon1=2.2,lat2=48.9,lon2=2.5,ttl=0s,history=0,history_age=0s\n1.0,done\n"
+			if data != expected {
+				t.Errorf("expected %q got %q", expected, data)
+			}
+
+			data = queryProcessor.ExecuteQuery("STATS lyon")
+			if data != "1.0,ERR,E_NAMESPACE_NOT_FOUND,\"namespace not found\"\n" {
+				t.Errorf("expected namespace not found, got %q", data)
+			}
+		})
+	})
+
+	t.Run("DROP NAMESPACE", func(t *testing.T) {
+		t.Run("should drop the namespace", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewWriteQueryEngine(world)
+			_ = world.Save("ns", "a", 1, 1)
+
+			data := queryProcessor.ExecuteQuery("DROP NAMESPACE ns")
+			if data != "1.0,dropped\n" {
+				t.Errorf("expected \"1.0,dropped\" got %q", data)
+			}
+
+			if len(world.Namespaces()) != 0 {
+				t.Errorf("expected the namespace to be dropped, got %+v", world.Namespaces())
+			}
+
+			data = queryProcessor.ExecuteQuery("DROP NAMESPACE ns")
+			if data != "1.0,ERR,E_NAMESPACE_NOT_FOUND,\"namespace not found\"\n" {
+				t.Errorf("expected namespace not found, got %q", data)
+			}
+		})
+	})
+
+	t.Run("RENAME NAMESPACE", func(t *testing.T) {
+		t.Run("should rename the namespace", func(t *testing.T) {
+			world := w.NewWorld()
+			queryProcessor := NewQueryEngine(world)
+			_ = world.Save("old", "a", 1, 1)
+			_ = world.Save("taken", "a", 1, 1)
+
+			data := queryProcessor.ExecuteQuery("RENAME NAMESPACE old taken")
+			if data != "1.0,ERR,E_NAMESPACE_EXISTS,\"namespace already exists\"\n" {
+				t.Errorf("expected namespace already exists, got %q", data)
+			}
+
+			data = queryProcessor.ExecuteQuery("RENAME NAMESPACE old new")
+			if data != "1.0,renamed\n" {
+				t.Errorf("expected \"1.0,renamed\" got %q", data)
+			}
+
+			data = queryProcessor.ExecuteQuery("GET new a")
+			if data != "1.0,new,a,1.000000,1.000000\n1.0,done\n" {
+				t.Errorf("expected the location under the new name, got %q", data)
+			}
+
+			data = queryProcessor.ExecuteQuery("RENAME NAMESPACE old other")
+			if data != "1.0,ERR,E_NAMESPACE_NOT_FOUND,\"namespace not found\"\n" {
+				t.Errorf("expected namespace not found, got %q", data)
+			}
+		})
+	})
+
+	t.Run("Strict namespaces", func(t *testing.T) {
+		t.Run("should answer reads against unknown namespaces with an error", func(t *testing.T) {
+			world := w.NewWorld()
+			world.SetStrict(true)
+			queryProcessor := NewReadQueryEngine(world)
+			_ = world.Save("ns", "a", 1, 1)
+
+			for _, query := range []string{
+				"GET typo a",
+				"POLY typo 0 0 2 2",
+				"POLY typo POLYGON((0 0, 2 0, 2 2, 0 0))",
+				"RADIUS typo 1 1 100",
+				"NEAREST typo 1 1 1",
+				"HISTORY typo a",
+				"FENCE LIST typo",
+			} {
+				expected := "1.0,ERR,E_NAMESPACE_NOT_FOUND,\"namespace not found\"\n"
+				if strings.HasPrefix(query, "GET") {
+					expected = "1.0,\"namespace not found\"\n"
+				}
+
+				data := queryProcessor.ExecuteQuery(query)
+				if data != expected {
+					t.Errorf("%s: expected namespace not found, got %q", query, data)
+				}
+			}
+
+			data := queryProcessor.ExecuteQuery("GET ns a")
+			if data != "1.0,ns,a,1.000000,1.000000\n1.0,done\n" {
+				t.Errorf("expected the location, got %q", data)
+			}
+
+			if len(world.Namespaces()) != 1 {
+				t.Errorf("expected the reads not to create namespaces, got %+v", world.Namespaces())
+			}
+		})
+	})
+
+	t.Run("SUBSCRIBE", func(t *testing.T) {
+		t.Run("should open a subscription and format its events", func(t *testing.T) {
+			world := w.NewWorld()
+			engine := NewSubscriberQueryEngine(world, 4, w.DropEvents)
+
+			subscription, format, response := engine.Subscribe("SUBSCRIBE ns 0 170 10 -170", V1)
+			if subscription == nil || response != "1.0,subscribed\n" {
+				t.Fatalf("expected \"1.0,subscribed\" got %q", response)
+			}
+			defer subscription.Close()
+
+			_ = NewQueryEngine(world).ExecuteQuery("SAVE ns a 5 175 status=busy")
+
+			data := format.Event(<-subscription.Events())
+			if data != "1.0,ENTER,ns,a,5.000000,175.000000,status=busy\n" {
+				t.Errorf("unexpected event %q", data)
+			}
+		})
+
+		t.Run("should return an error for invalid arguments", func(t *testing.T) {
+			world := w.NewWorld()
+			engine := NewSubscriberQueryEngine(world, 4, w.DropEvents)
+
+			expectations := map[string]string{
+				"SUBSCRIBE ns 0 0 10":     "1.0,ERR,E_SYNTAX,\"expected " + grammars["SUBSCRIBE"].usage + "\"\n",
+				"GET ns a":                "1.0,\"invalid query\"\n",
+				"SUBSCRIBE ns 0 x 10 10":  "1.0,ERR,E_BAD_LON,\"Invalid float64 value for longitude1\"\n",
+				"SUBSCRIBE ns 0 0 10 x":   "1.0,ERR,E_BAD_LON,\"Invalid float64 value for longitude2\"\n",
+				"SUBSCRIBE ns 0 0 100 10": "1.0,ERR,E_BAD_LAT,\"invalid latitude\"\n",
+			}
+
+			for query, expected := range expectations {
+				subscription, _, data := engine.Subscribe(query, V1)
+				if subscription != nil || data != expected {
+					t.Errorf("%s: expected %q got %q", query, expected, data)
+				}
+			}
+		})
+	})
+}
+
+// cancelingWriter cancels its query once it has been written after rows.
+type cancelingWriter struct {
+	strings.Builder
+	cancel context.CancelFunc
+	after  int
+}
+
+func (c *cancelingWriter) WriteString(s string) (int, error) {
+	c.after--
+	if c.after == 0 {
+		c.cancel()
+	}
+
+	return c.Builder.WriteString(s)
 }
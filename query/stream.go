@@ -0,0 +1,210 @@
+package query
+
+import (
+	"container/heap"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+
+	w "github.com/fabricekabongo/loggerhead/world"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrQueryCanceled = errors.New("query canceled")
+)
+
+// StreamProcessor is a Processor able to write its rows as it finds them rather than return them all at once,
+// and to stop when its context is done.
+type StreamProcessor interface {
+	Processor
+	Stream(ctx context.Context, statement *Statement, format Format, out io.Writer) error
+}
+
+// StreamEngineInterface is an engine writing its answers in any version of the protocol, and the answers of its
+// streaming processors as they are found.
+type StreamEngineInterface interface {
+	EngineInterface
+	StreamQuery(ctx context.Context, query string, protocol Protocol, out io.Writer) error
+}
+
+// IsCancel tells if the query is a CANCEL, which the connections answer themselves by stopping the answer
+// streaming, if any.
+func IsCancel(query string) bool {
+	if !startsWith(query, "CANCEL") {
+		return false
+	}
+
+	statement, err := Parse(query)
+
+	return err == nil && statement.Command == "CANCEL"
+}
+
+// StreamQuery writes the answer to the query to out in the protocol's version, or the format its FORMAT clause asks
+// for, row by row for the processors that stream. It only returns the errors of out.
+func (qp *Engine) StreamQuery(ctx context.Context, query string, protocol Protocol, out io.Writer) error {
+	statement, err := Parse(query)
+
+	processor := qp.processor(statement)
+	if processor == nil {
+		err = &Error{Code: CodeUnknownCommand, Message: ErrorInvalidQuery.Error()}
+	}
+
+	format, formatErr := statementFormat(statement, protocol)
+	if err == nil {
+		err = formatErr
+	}
+
+	if err != nil {
+		log.Println(ErrorInvalidQuery.Error(), query)
+		return writeString(out, format.Error(err))
+	}
+
+	if streamer, ok := processor.(StreamProcessor); ok {
+		return streamer.Stream(ctx, statement, format, out)
+	}
+
+	return writeString(out, processor.Execute(statement, format))
+}
+
+// page is the LIMIT and CURSOR of an area query: the limit first ids after the cursor's, in the order of the ids.
+// A zero limit streams all of the rows in the order the index finds them.
+type page struct {
+	limit int
+	after string
+}
+
+// parsePage reads the LIMIT and CURSOR clauses of an area query. A CURSOR only makes sense with a LIMIT.
+func parsePage(statement *Statement) (page, error) {
+	limits, ok := statement.Clause("LIMIT")
+	cursors, paged := statement.Clause("CURSOR")
+	if !ok {
+		if paged {
+			return page{}, statement.syntaxError()
+		}
+
+		return page{}, nil
+	}
+
+	limit, err := strconv.Atoi(limits[0])
+	if err != nil || limit <= 0 {
+		return page{}, &Error{Code: CodeBadLimit, Message: "Invalid integer value for limit"}
+	}
+
+	if !paged {
+		return page{limit: limit}, nil
+	}
+
+	after, err := base64.RawURLEncoding.DecodeString(cursors[0])
+	if err != nil || len(after) == 0 {
+		return page{}, ErrInvalidCursor
+	}
+
+	return page{limit: limit, after: string(after)}, nil
+}
+
+// writeArea writes the rows of the locations walk visits, then done. The walks visit copies of the locations once
+// the index let go of them, so a slow client holds up no change to the index. Without a limit the rows are written
+// as they are found; with one, the page's locations are kept aside, then written in the order of their ids,
+// followed by the cursor of the next page if the page is full. It stops the walk when ctx is done or out fails, and
+// ends the answer with the error instead of done.
+func writeArea(ctx context.Context, out io.Writer, format Format, page page, walk func(visit func(location *w.Location) bool)) error {
+	var err error
+
+	if page.limit == 0 {
+		walk(func(location *w.Location) bool {
+			if ctx.Err() != nil {
+				return false
+			}
+
+			_, err = io.WriteString(out, format.Location(location))
+			return err == nil
+		})
+	} else {
+		rows := &pageRows{}
+		walk(func(location *w.Location) bool {
+			if ctx.Err() != nil {
+				return false
+			}
+
+			id := location.Id()
+			if id <= page.after || (rows.Len() == page.limit && id >= (*rows)[0].id) {
+				return true
+			}
+
+			if rows.Len() == page.limit {
+				heap.Pop(rows)
+			}
+			heap.Push(rows, pageRow{id: id, location: location})
+
+			return true
+		})
+
+		sort.Slice(*rows, func(i, j int) bool {
+			return (*rows)[i].id < (*rows)[j].id
+		})
+
+		for _, row := range *rows {
+			if ctx.Err() != nil {
+				break
+			}
+
+			_, err = io.WriteString(out, format.Location(row.location))
+			if err != nil {
+				break
+			}
+		}
+
+		if err == nil && ctx.Err() == nil && rows.Len() == page.limit {
+			cursor := base64.RawURLEncoding.EncodeToString([]byte((*rows)[rows.Len()-1].id))
+			_, err = io.WriteString(out, format.Cursor(cursor))
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if ctx.Err() != nil {
+		_, err = io.WriteString(out, format.Error(ErrQueryCanceled))
+		return err
+	}
+
+	_, err = io.WriteString(out, format.End("done"))
+	return err
+}
+
+// pageRow is a location of a page, kept until the page is complete.
+type pageRow struct {
+	id       string
+	location *w.Location
+}
+
+// pageRows is a max-heap of the rows of a page by id: the first row is the one to drop for a smaller id.
+type pageRows []pageRow
+
+func (r pageRows) Len() int           { return len(r) }
+func (r pageRows) Less(i, j int) bool { return r[i].id > r[j].id }
+func (r pageRows) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+func (r *pageRows) Push(row any) {
+	*r = append(*r, row.(pageRow))
+}
+
+func (r *pageRows) Pop() any {
+	old := *r
+	row := old[len(old)-1]
+	*r = old[:len(old)-1]
+
+	return row
+}
+
+// writeString writes a whole answer, like an error, to out.
+func writeString(out io.Writer, answer string) error {
+	_, err := io.WriteString(out, answer)
+	return err
+}
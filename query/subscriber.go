@@ -0,0 +1,99 @@
+package query
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	w "github.com/fabricekabongo/loggerhead/world"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// ErrSlowConsumer ends the subscriptions of the subscribers disconnected for not reading their events.
+	ErrSlowConsumer = errors.New("disconnected, events were not read fast enough")
+
+	SubscribeCounter  prometheus.Counter
+	SubscribeDuration prometheus.Histogram
+)
+
+func init() {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	SubscribeCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_query_subscribe_total",
+		Help: "Total number of subscribe queries",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+
+	SubscribeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_query_subscribe_duration_nanoseconds",
+		Help: "Duration of subscribe queries in nanoseconds",
+		ConstLabels: map[string]string{
+			"hostname": hostname,
+		},
+	})
+}
+
+// SubscriberEngine opens the subscriptions asked by SUBSCRIBE and FENCES queries. Unlike the other engines a query
+// does not produce a single response: the listener streams the subscription's events until it is closed.
+type SubscriberEngine struct {
+	world  *w.World
+	buffer int
+	policy w.SlowConsumerPolicy
+}
+
+// NewSubscriberQueryEngine returns an engine whose subscriptions buffer that many events, then apply the policy.
+func NewSubscriberQueryEngine(world *w.World, buffer int, policy w.SlowConsumerPolicy) *SubscriberEngine {
+	return &SubscriberEngine{
+		world:  world,
+		buffer: buffer,
+		policy: policy,
+	}
+}
+
+// Subscribe runs a SUBSCRIBE or FENCES query. It returns the subscription, the format of its events in the
+// protocol's version, and its acknowledgement; or nil and the error.
+func (e *SubscriberEngine) Subscribe(query string, protocol Protocol) (*w.Subscription, Format, string) {
+	defer SubscribeCounter.Inc()
+	start := time.Now()
+	if e.world == nil {
+		panic("world is nil")
+	}
+
+	//SUBSCRIBE NamespaceID Latitude1 Longitude1 Latitude2 Longitude2
+	//FENCES NamespaceID
+	statement, err := Parse(query)
+	format := protocol(statement)
+	if err == nil && statement.Command != "SUBSCRIBE" && statement.Command != "FENCES" {
+		err = &Error{Code: CodeUnknownCommand, Message: ErrorInvalidQuery.Error()}
+	}
+	if err != nil {
+		return nil, format, format.Error(err)
+	}
+
+	var subscription *w.Subscription
+	if statement.Command == "FENCES" {
+		subscription, err = e.world.WatchFences(statement.Args[0], e.buffer, e.policy)
+	} else {
+		var lat1, lon1, lat2, lon2 float64
+		lat1, lon1, lat2, lon2, err = parseRectangle(statement.Args[1:5])
+		if err == nil {
+			subscription, err = e.world.Subscribe(statement.Args[0], lat1, lat2, lon1, lon2, e.buffer, e.policy)
+		}
+	}
+	if err != nil {
+		return nil, format, format.Error(err)
+	}
+
+	elapsed := time.Since(start)
+	SubscribeDuration.Observe(float64(elapsed.Nanoseconds()))
+
+	return subscription, format, format.End("subscribed")
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/fabricekabongo/loggerhead/query"
+	w "github.com/fabricekabongo/loggerhead/world"
+)
+
+// binaryMagic is the first byte of the connections speaking the binary protocol. No query starts with it: it is
+// not a character, nor the start of one in UTF-8.
+const binaryMagic byte = 0xB1
+
+// The operations of the requests of the binary protocol.
+const (
+	// opQuery is a query of the text protocol, the rest of the frame.
+	opQuery byte = 1
+	// opSave saves a location: its namespace, id, latitude, longitude, TTL and attributes.
+	opSave byte = 2
+	// opCancel stops the answer to the request of its id if it is streaming.
+	opCancel byte = 3
+)
+
+// requestQueue is how many requests are read ahead of the answers: the answers are only flushed once no request
+// is waiting, so pipelined requests share their writes.
+const requestQueue = 64
+
+var (
+	errFrameTooLong  = errors.New("frame too long")
+	errFrameTooShort = errors.New("frame too short")
+)
+
+// request is a request of a binary connection: the query it stands for, or the location it saves in the namespace
+// ns, or the error it could not be read with, answered with its id.
+type request struct {
+	id    uint32
+	query string
+	ns    string
+	save  *w.BatchSave
+	err   error
+}
+
+// handleBinary answers the requests of a binary connection in the order they come, each in frames carrying its
+// id, so a client can send requests without waiting for the answers. A request is framed as
+// Length uint32 | RequestID uint32 | Operation byte | Body, Length counting the bytes after it.
+func (h *Handler) handleBinary(reader *bufio.Reader, writer *bufio.Writer) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	var mu sync.Mutex
+	var streaming uint32
+	cancelQuery := context.CancelFunc(func() {})
+	cancel := func(id uint32) {
+		mu.Lock()
+		if id == streaming {
+			cancelQuery()
+		}
+		mu.Unlock()
+	}
+
+	requests := make(chan request, requestQueue)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(requests)
+		readErr <- readRequests(reader, requests, done, cancel)
+	}()
+
+	streamer, streams := h.QueryEngine.(query.StreamEngineInterface)
+	saver, saves := h.QueryEngine.(query.SaveEngineInterface)
+
+	for r := range requests {
+		protocol := query.Binary(r.id)
+
+		var err error
+		switch {
+		case r.err != nil:
+			_, err = writer.WriteString(protocol(&query.Statement{}).Error(r.err))
+		case !streams || (r.save != nil && !saves):
+			_, err = writer.WriteString(protocol(&query.Statement{}).Error(errors.New("the binary protocol is not served here")))
+		case r.save != nil:
+			err = saver.Save(r.ns, *r.save, protocol, writer)
+		default:
+			ctx, cancelRequest := context.WithCancel(context.Background())
+			mu.Lock()
+			streaming, cancelQuery = r.id, cancelRequest
+			mu.Unlock()
+
+			err = streamer.StreamQuery(ctx, r.query, protocol, writer)
+			cancelRequest()
+		}
+
+		if err == nil && len(requests) == 0 {
+			err = writer.Flush()
+		}
+
+		if err != nil {
+			log.Println("Error writing to connection: ", err)
+			return err
+		}
+	}
+
+	return <-readErr
+}
+
+// readRequests sends the requests of the connection to requests until it ends, and calls cancel for the CANCELs.
+func readRequests(reader io.Reader, requests chan<- request, done <-chan struct{}, cancel func(id uint32)) error {
+	header := make([]byte, 4)
+
+	for {
+		_, err := io.ReadFull(reader, header)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			log.Println("Error reading from connection ", err)
+			return err
+		}
+
+		length := binary.BigEndian.Uint32(header)
+		if length > maxQueryLength {
+			return errFrameTooLong
+		}
+		if length < 5 {
+			return errFrameTooShort
+		}
+
+		data := make([]byte, length)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			log.Println("Error reading from connection ", err)
+			return err
+		}
+
+		r := request{id: binary.BigEndian.Uint32(data)}
+		rest := body(data[5:])
+
+		switch data[4] {
+		case opQuery:
+			r.query = string(rest)
+		case opSave:
+			r.ns, r.save, r.err = rest.save()
+		case opCancel:
+			cancel(r.id)
+			continue
+		default:
+			r.err = &query.Error{Code: query.CodeUnknownCommand, Message: "unknown operation"}
+		}
+
+		select {
+		case requests <- r:
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// body is what is left to read of the body of a request.
+type body []byte
+
+// save reads the body of a SAVE, Namespace Text | ID Text | Latitude float64 | Longitude float64 | TTL int64
+// nanoseconds, 0 for none | Attributes uvarint count of Key Text | Value Text, into its namespace and location.
+func (b body) save() (string, *w.BatchSave, error) {
+	ns, ok := b.text()
+	save := w.BatchSave{}
+	if ok {
+		save.Id, ok = b.text()
+	}
+	if ok {
+		save.Lat, ok = b.float()
+	}
+	if ok {
+		save.Lon, ok = b.float()
+	}
+
+	var ttl uint64
+	if ok {
+		ttl, ok = b.uint64()
+		save.TTL = time.Duration(ttl)
+	}
+
+	var count uint64
+	if ok {
+		count, ok = b.uvarint()
+	}
+
+	for i := uint64(0); ok && i < count; i++ {
+		var key, value string
+		key, ok = b.text()
+		if ok {
+			value, ok = b.text()
+		}
+
+		if save.Attributes == nil {
+			save.Attributes = w.Attributes{}
+		}
+		save.Attributes[key] = value
+	}
+
+	if !ok || len(b) > 0 {
+		return "", nil, &query.Error{Code: query.CodeSyntax, Message: "malformed SAVE"}
+	}
+
+	return ns, &save, nil
+}
+
+func (b *body) text() (string, bool) {
+	length, ok := b.uvarint()
+	if !ok || uint64(len(*b)) < length {
+		return "", false
+	}
+
+	value := string((*b)[:length])
+	*b = (*b)[length:]
+
+	return value, true
+}
+
+func (b *body) float() (float64, bool) {
+	bits, ok := b.uint64()
+
+	return math.Float64frombits(bits), ok
+}
+
+func (b *body) uint64() (uint64, bool) {
+	if len(*b) < 8 {
+		return 0, false
+	}
+
+	value := binary.BigEndian.Uint64(*b)
+	*b = (*b)[8:]
+
+	return value, true
+}
+
+func (b *body) uvarint() (uint64, bool) {
+	value, n := binary.Uvarint(*b)
+	if n <= 0 {
+		return 0, false
+	}
+
+	*b = (*b)[n:]
+
+	return value, true
+}
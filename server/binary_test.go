@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ataul443/memnet"
+	"github.com/fabricekabongo/loggerhead/query"
+	"github.com/fabricekabongo/loggerhead/world"
+)
+
+// binaryRequest frames a request of the binary protocol.
+func binaryRequest(id uint32, op byte, body []byte) []byte {
+	data := binary.BigEndian.AppendUint32(nil, uint32(5+len(body)))
+	data = binary.BigEndian.AppendUint32(data, id)
+
+	return append(append(data, op), body...)
+}
+
+func appendText(data []byte, value string) []byte {
+	return append(binary.AppendUvarint(data, uint64(len(value))), value...)
+}
+
+func appendFloat(data []byte, value float64) []byte {
+	return binary.BigEndian.AppendUint64(data, math.Float64bits(value))
+}
+
+// binaryFrame is a frame answering a request of the binary protocol.
+type binaryFrame struct {
+	id   uint32
+	kind byte
+	body []byte
+}
+
+func readFrame(t *testing.T, reader io.Reader) binaryFrame {
+	t.Helper()
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatalf("Failed to read a frame: %v", err)
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(reader, data); err != nil {
+		t.Fatalf("Failed to read a frame: %v", err)
+	}
+
+	return binaryFrame{id: binary.BigEndian.Uint32(data), kind: data[4], body: data[5:]}
+}
+
+func (f *binaryFrame) text() string {
+	length, n := binary.Uvarint(f.body)
+	value := string(f.body[n : n+int(length)])
+	f.body = f.body[n+int(length):]
+
+	return value
+}
+
+func (f *binaryFrame) float() float64 {
+	value := math.Float64frombits(binary.BigEndian.Uint64(f.body))
+	f.body = f.body[8:]
+
+	return value
+}
+
+func dialBinary(t *testing.T, name string, engine query.EngineInterface) (net.Conn, func()) {
+	netListener, err := memnet.Listen(1, 4096, name)
+	if err != nil {
+		t.Fatalf("Failed to create memnet listener: %v", err)
+	}
+	l := NewListener(19999, 10, time.Second, engine)
+
+	go l.Handler.listen(netListener)
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := netListener.Dial()
+	if err != nil {
+		t.Fatalf("Failed to dial connection: %v", err)
+	}
+
+	_, err = conn.Write([]byte{binaryMagic})
+	if err != nil {
+		t.Fatalf("Failed to write the magic byte: %v", err)
+	}
+
+	return conn, func() {
+		conn.Close()
+		close(l.Handler.(*Handler).closeChan)
+		netListener.Close()
+	}
+}
+
+func TestBinaryProtocol(t *testing.T) {
+	t.Run("should answer pipelined requests in order with their ids", func(t *testing.T) {
+		w := world.NewWorld()
+		w.SetStrict(true)
+		_ = w.Save("ns", "seed", 0, 0)
+
+		conn, stop := dialBinary(t, "test-binary", query.NewQueryEngine(w))
+		defer stop()
+
+		save := appendText(appendText(nil, "ns"), "truck 1")
+		save = appendFloat(appendFloat(save, 12.123456789), -45.987654321)
+		save = binary.BigEndian.AppendUint64(save, uint64(time.Minute))
+		save = appendText(appendText(binary.AppendUvarint(save, 1), "color"), "red")
+
+		var requests []byte
+		requests = append(requests, binaryRequest(1, opSave, save)...)
+		requests = append(requests, binaryRequest(2, opQuery, []byte(`GET ns "truck 1"`))...)
+		requests = append(requests, binaryRequest(3, opQuery, []byte("GET typo a"))...)
+		requests = append(requests, binaryRequest(4, 9, nil)...)
+		requests = append(requests, binaryRequest(5, opSave, save[:3])...)
+
+		_, err := conn.Write(requests)
+		if err != nil {
+			t.Fatalf("Failed to write the requests: %v", err)
+		}
+
+		reader := bufio.NewReader(conn)
+
+		saved := readFrame(t, reader)
+		if saved.id != 1 || saved.kind != 6 || saved.text() != "saved" {
+			t.Errorf("unexpected answer to the save %+v", saved)
+		}
+
+		location := readFrame(t, reader)
+		if location.id != 2 || location.kind != 1 || location.text() != "ns" || location.text() != "truck 1" ||
+			location.float() != 12.123456789 || location.float() != -45.987654321 {
+			t.Fatalf("unexpected location %+v", location)
+		}
+
+		updatedAt := time.Unix(0, int64(binary.BigEndian.Uint64(location.body)))
+		location.body = location.body[8:]
+		if time.Since(updatedAt) > time.Minute {
+			t.Errorf("unexpected time %v", updatedAt)
+		}
+
+		count, n := binary.Uvarint(location.body)
+		location.body = location.body[n:]
+		if count != 1 || location.text() != "color" || location.text() != "red" {
+			t.Errorf("unexpected attributes %+v", location)
+		}
+
+		if done := readFrame(t, reader); done.id != 2 || done.kind != 6 || done.text() != "done" {
+			t.Errorf("unexpected end %+v", done)
+		}
+
+		for _, expected := range []struct {
+			id      uint32
+			status  uint16
+			code    string
+			message string
+		}{
+			{3, 404, query.CodeNamespaceNotFound, "namespace not found"},
+			{4, 400, query.CodeUnknownCommand, "unknown operation"},
+			{5, 400, query.CodeSyntax, "malformed SAVE"},
+		} {
+			frame := readFrame(t, reader)
+			if frame.id != expected.id || frame.kind != 7 {
+				t.Fatalf("expected the error of %d, got %+v", expected.id, frame)
+			}
+
+			status := binary.BigEndian.Uint16(frame.body)
+			frame.body = frame.body[2:]
+			if status != expected.status || frame.text() != expected.code || frame.text() != expected.message {
+				t.Errorf("unexpected error %+v", frame)
+			}
+		}
+
+		stored, ok := w.GetLocation("ns", "truck 1")
+		if !ok || stored.Lat() != 12.123456789 || stored.ExpiresAt().IsZero() {
+			t.Errorf("expected the location to be saved with all of its digits and its TTL")
+		}
+	})
+
+	t.Run("should stop the answer of the request canceled", func(t *testing.T) {
+		conn, stop := dialBinary(t, "test-binary-cancel", streamEngine{})
+		defer stop()
+
+		_, err := conn.Write(binaryRequest(7, opQuery, []byte("POLY ns 0 0 1 1")))
+		if err != nil {
+			t.Fatalf("Failed to write the query: %v", err)
+		}
+
+		reader := bufio.NewReader(conn)
+		if line, err := reader.ReadString('\n'); err != nil || line != "row\n" {
+			t.Fatalf("expected the answer to stream, got %q: %v", line, err)
+		}
+
+		_, err = conn.Write(append(binaryRequest(8, opCancel, nil), binaryRequest(7, opCancel, nil)...))
+		if err != nil {
+			t.Fatalf("Failed to write the cancel: %v", err)
+		}
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("expected the answer to end: %v", err)
+			}
+			if strings.HasPrefix(line, "canceled") {
+				break
+			}
+		}
+	})
+}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"bufio"
+	"context"
+	"io"
 	"log"
 	"net"
 	"time"
@@ -11,6 +13,9 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// maxQueryLength is the longest line read as a query, so batches of saves fit in one.
+const maxQueryLength = 1 << 20
+
 var (
 	connectionGauge = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "loggerhead_server_connections",
@@ -45,6 +50,12 @@ func (h *Handler) close() error {
 }
 
 func (h *Handler) listen(listener net.Listener) {
+	serve(listener, h.closeChan, h.MaxConnections, h.handleConnection)
+}
+
+// serve accepts connections until the listener fails or closeChan is signalled, handling at most maxConnections
+// of them at a time.
+func serve(listener net.Listener, closeChan chan int, maxConnections int, handle func(conn net.Conn) error) {
 	defer func(listener net.Listener) {
 		err := listener.Close()
 		if err != nil {
@@ -52,11 +63,11 @@ func (h *Handler) listen(listener net.Listener) {
 		}
 	}(listener)
 
-	workLimit := make(chan int, h.MaxConnections)
+	workLimit := make(chan int, maxConnections)
 
 	for {
 		select {
-		case <-h.closeChan:
+		case <-closeChan:
 			return
 		default:
 			conn, err := listener.Accept()
@@ -71,7 +82,7 @@ func (h *Handler) listen(listener net.Listener) {
 					<-workLimit
 				}()
 
-				err := h.handleConnection(conn)
+				err := handle(conn)
 				if err != nil {
 					log.Println("Error handling write connection: ", err)
 					return
@@ -82,6 +93,11 @@ func (h *Handler) listen(listener net.Listener) {
 	}
 }
 
+// handleConnection answers the queries of the connection one after the other, in the first version of the protocol
+// until a HELLO asks for another; engines that do not stream only answer in the first. The queries are read ahead
+// of the answers, so a CANCEL sent while an answer streams stops it: the answer then ends with an error instead of
+// done. A CANCEL with no answer streaming is ignored. A connection starting with binaryMagic speaks the binary
+// protocol instead, see handleBinary.
 func (h *Handler) handleConnection(conn net.Conn) error {
 	connectionGauge.Inc()
 	defer func(conn net.Conn) {
@@ -94,7 +110,80 @@ func (h *Handler) handleConnection(conn net.Conn) error {
 		}
 	}(conn)
 
-	scanner := bufio.NewScanner(conn)
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	if magic, err := reader.Peek(1); err == nil && magic[0] == binaryMagic {
+		_, _ = reader.Discard(1)
+		return h.handleBinary(reader, writer)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	lines := make(chan queryLine)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		readErr <- h.readQueries(reader, lines, done)
+	}()
+
+	streamer, streams := h.QueryEngine.(query.StreamEngineInterface)
+	protocol := query.Protocol(query.V1)
+
+	for line := range lines {
+		next, answer, hello := query.Hello(line.query, protocol)
+		if hello {
+			line.cancel()
+			protocol = next
+			_, err := writer.WriteString(answer)
+			if err == nil {
+				err = writer.Flush()
+			}
+			if err != nil {
+				log.Println("Error writing to connection: ", err)
+				return err
+			}
+
+			continue
+		}
+
+		var err error
+		if streams {
+			err = streamer.StreamQuery(line.ctx, line.query, protocol, writer)
+		} else {
+			_, err = writer.WriteString(h.QueryEngine.ExecuteQuery(line.query))
+		}
+		line.cancel()
+
+		if err == nil {
+			err = writer.Flush()
+		}
+
+		if err != nil {
+			log.Println("Error writing to connection: ", err)
+			return err
+		}
+	}
+
+	return <-readErr
+}
+
+// queryLine is a query of a text connection with the context its answer stops with, canceled by the CANCEL read after
+// it. Cancel it once the query is answered.
+type queryLine struct {
+	query  string
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// readQueries sends the lines of the connection to lines until an empty one, and cancels the last line sent on a
+// CANCEL. The context of a line is set before it is sent, so a CANCEL read as soon as it is taken stops its answer.
+func (h *Handler) readQueries(reader io.Reader, lines chan<- queryLine, done <-chan struct{}) error {
+	cancel := context.CancelFunc(func() {})
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, maxQueryLength)
 
 	var startOfEOF time.Time = time.Time{} // start the counter when the connection is opened so that we can track EOF wait time correctly
 
@@ -115,20 +204,25 @@ func (h *Handler) handleConnection(conn net.Conn) error {
 		}
 
 		startOfEOF = time.Time{}
-		line := scanner.Text()
-		if line == "" {
+		text := scanner.Text()
+		if text == "" {
 			log.Println("Empty line received. Closing connection")
-			break
+			return nil
 		}
 
-		var response string = h.QueryEngine.ExecuteQuery(line)
-		_, err := conn.Write([]byte(response))
+		if query.IsCancel(text) {
+			cancel()
+			continue
+		}
 
-		if err != nil {
-			log.Println("Error writing to connection: ", err)
-			return err
+		ctx, cancelLine := context.WithCancel(context.Background())
+		cancel = cancelLine
+
+		select {
+		case lines <- queryLine{query: text, ctx: ctx, cancel: cancelLine}:
+		case <-done:
+			cancelLine()
+			return nil
 		}
 	}
-
-	return nil
 }
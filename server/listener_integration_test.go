@@ -83,3 +83,61 @@ func TestListenerCRUDFlow(t *testing.T) {
 		t.Fatalf("Unexpected final get response: %q", resp)
 	}
 }
+
+func TestListenerNegotiatesTheProtocolVersion(t *testing.T) {
+	netListener, err := memnet.Listen(1, 4096, "test-hello")
+	if err != nil {
+		t.Fatalf("Failed to create memnet listener: %v", err)
+	}
+	l := NewListener(19999, 10, time.Second, query.NewQueryEngine(world.NewWorld()))
+
+	go l.Handler.listen(netListener)
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := netListener.Dial()
+	if err != nil {
+		t.Fatalf("Failed to dial connection: %v", err)
+	}
+	defer func() {
+		conn.Close()
+		if h, ok := l.Handler.(*Handler); ok {
+			close(h.closeChan)
+		}
+		netListener.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+
+	for _, exchange := range []struct {
+		query    string
+		expected []string
+	}{
+		{"SAVE ns a 1 2", []string{"1.0,saved\n"}},
+		{"HELLO 3.0", []string{"1.0,ERR,E_BAD_VERSION,\"unsupported version, expected 1.0 or 2.0\"\n"}},
+		{"hello 2.0", []string{"2.0,,200,hello\n"}},
+		{`#7 SAVE ns "b,c" 1 2`, []string{"2.0,\"7\",200,saved\n"}},
+		{"#8 POLY ns 0 0 2 2 LIMIT 5", []string{
+			"2.0,\"8\",location,\"ns\",\"a\",1,2,\n",
+			"2.0,\"8\",location,\"ns\",\"b,c\",1,2,\n",
+			"2.0,\"8\",200,done\n",
+		}},
+		{"GET ns", []string{"2.0,,400,E_SYNTAX,\"expected GET NamespaceID LocationID [FORMAT NDJSON|GEOJSON]\"\n"}},
+		{"HELLO 1.0", []string{"1.0,hello\n"}},
+		{"GET ns a", []string{"1.0,ns,a,1.000000,2.000000\n", "1.0,done\n"}},
+	} {
+		_, err := conn.Write([]byte(exchange.query + "\n"))
+		if err != nil {
+			t.Fatalf("Failed to write %s: %v", exchange.query, err)
+		}
+
+		for _, expected := range exchange.expected {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read the answer to %s: %v", exchange.query, err)
+			}
+			if line != expected {
+				t.Errorf("%s: expected %q got %q", exchange.query, expected, line)
+			}
+		}
+	}
+}
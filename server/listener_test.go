@@ -1,10 +1,14 @@
 package server
 
 import (
+	"bufio"
+	"context"
 	"errors"
+	"io"
 	"math/rand/v2"
 	"net"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -19,6 +23,24 @@ func (e testEngine) ExecuteQuery(string) string {
 	return string(e)
 }
 
+// streamEngine streams rows until its query is canceled.
+type streamEngine struct{}
+
+func (streamEngine) ExecuteQuery(string) string {
+	return "done\n"
+}
+
+func (streamEngine) StreamQuery(ctx context.Context, _ string, _ query.Protocol, out io.Writer) error {
+	for ctx.Err() == nil {
+		if _, err := io.WriteString(out, "row\n"); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(out, "canceled\n")
+	return err
+}
+
 type errListener struct {
 	closed bool
 }
@@ -117,6 +139,88 @@ func TestHandleConnectionProcessesQueries(t *testing.T) {
 	}
 }
 
+func TestHandleConnectionCancelsStreamingQueries(t *testing.T) {
+	handler := &Handler{
+		QueryEngine:    streamEngine{},
+		closeChan:      make(chan int),
+		MaxConnections: 1,
+		maxEOFWait:     time.Second,
+	}
+
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		serverConn.Close()
+		clientConn.Close()
+	})
+
+	go func() {
+		_ = handler.handleConnection(serverConn)
+	}()
+
+	if err := clientConn.SetDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf("failed to set deadline: %v", err)
+	}
+
+	if _, err := clientConn.Write([]byte("POLY\n")); err != nil {
+		t.Fatalf("failed to write query: %v", err)
+	}
+
+	reader := bufio.NewReader(clientConn)
+	if line, err := reader.ReadString('\n'); err != nil || line != "row\n" {
+		t.Fatalf("expected the rows to stream, got %q and %v", line, err)
+	}
+
+	go func() {
+		_, _ = clientConn.Write([]byte("CANCEL\nPOLY\n"))
+	}()
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("expected the query to be canceled, got %v", err)
+		}
+		if line == "canceled\n" {
+			break
+		}
+	}
+
+	if line, err := reader.ReadString('\n'); err != nil || line != "row\n" {
+		t.Fatalf("expected the next query to be answered, got %q and %v", line, err)
+	}
+}
+
+func TestReadQueriesCancelsTheLineTakenLast(t *testing.T) {
+	handler := &Handler{maxEOFWait: time.Second}
+
+	lines := make(chan queryLine)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(lines)
+		_ = handler.readQueries(strings.NewReader("POLY\n#q cancel\nPOLY\n CANCEL\n\n"), lines, done)
+	}()
+
+	first := <-lines
+	second := <-lines
+	if first.ctx.Err() == nil {
+		t.Fatal("expected the tagged lowercase CANCEL to cancel the first line as soon as it was taken")
+	}
+	if second.ctx.Err() != nil {
+		t.Fatal("expected the second line to run until its own CANCEL")
+	}
+
+	select {
+	case <-second.ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the second CANCEL to cancel the second line")
+	}
+
+	if _, ok := <-lines; ok {
+		t.Fatal("expected the CANCELs not to be sent as queries")
+	}
+}
+
 func TestHandleConnectionReturnsAfterEOFTimeout(t *testing.T) {
 	handler := &Handler{
 		QueryEngine:    testEngine("ignored"),
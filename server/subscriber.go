@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fabricekabongo/loggerhead/query"
+	w "github.com/fabricekabongo/loggerhead/world"
+)
+
+// SubscriptionHandler serves the subscription port: every SUBSCRIBE line opens a subscription whose events are
+// streamed on the connection until the client leaves.
+type SubscriptionHandler struct {
+	Engine         *query.SubscriberEngine
+	closeChan      chan int
+	MaxConnections int
+	maxEOFWait     time.Duration
+}
+
+func NewSubscriptionListener(port, maxConn int, maxEOF time.Duration, engine *query.SubscriberEngine) *Listener {
+	return &Listener{
+		Port: port,
+		Handler: &SubscriptionHandler{
+			Engine:         engine,
+			closeChan:      make(chan int),
+			MaxConnections: maxConn,
+			maxEOFWait:     maxEOF,
+		},
+		Type: TCP,
+	}
+}
+
+func (h *SubscriptionHandler) close() error {
+	h.closeChan <- 0
+	close(h.closeChan)
+	return nil
+}
+
+func (h *SubscriptionHandler) listen(listener net.Listener) {
+	serve(listener, h.closeChan, h.MaxConnections, h.handleConnection)
+}
+
+// subscriberConnection writes the events of all the subscriptions of a connection, one line at a time.
+type subscriberConnection struct {
+	conn          net.Conn
+	writeTimeout  time.Duration
+	mu            sync.Mutex
+	closed        bool
+	subscriptions []*w.Subscription
+	forwarders    sync.WaitGroup
+}
+
+func (c *subscriberConnection) write(line string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	// A client that stops reading must not pin the connection forever.
+	err := c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	if err != nil {
+		return err
+	}
+
+	_, err = c.conn.Write([]byte(line))
+
+	return err
+}
+
+// forward streams the subscription's events in its format. The channel closes when the connection ends, or when
+// the slow consumer policy disconnects the subscriber, in which case the whole connection is closed.
+func (c *subscriberConnection) forward(subscription *w.Subscription, format query.Format) {
+	defer c.forwarders.Done()
+
+	for event := range subscription.Events() {
+		err := c.write(format.Event(event))
+		if err != nil {
+			log.Println("Error writing to subscriber: ", err)
+			c.close()
+		}
+	}
+
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+
+	if !closed {
+		log.Println("Disconnecting slow subscriber: ", c.conn.RemoteAddr())
+		_ = c.write(format.Error(query.ErrSlowConsumer))
+		c.close()
+	}
+}
+
+func (c *subscriberConnection) subscribe(subscription *w.Subscription, format query.Format) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	c.subscriptions = append(c.subscriptions, subscription)
+	c.forwarders.Add(1)
+	go c.forward(subscription, format)
+
+	return true
+}
+
+// close closes the subscriptions and the connection, which also unblocks the reader.
+func (c *subscriberConnection) close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	subscriptions := c.subscriptions
+	c.mu.Unlock()
+
+	for _, subscription := range subscriptions {
+		subscription.Close()
+	}
+
+	err := c.conn.Close()
+	if err != nil {
+		log.Println("Error closing connection: ", err)
+	}
+}
+
+func (h *SubscriptionHandler) handleConnection(conn net.Conn) error {
+	connectionGauge.Inc()
+	defer connectionGauge.Dec()
+
+	subscriber := &subscriberConnection{conn: conn, writeTimeout: h.maxEOFWait}
+	defer func() {
+		log.Println("Closing connection from: ", conn.RemoteAddr())
+		subscriber.close()
+		subscriber.forwarders.Wait()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	protocol := query.Protocol(query.V1)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			log.Println("Empty line received. Closing connection")
+			return nil
+		}
+
+		next, answer, hello := query.Hello(line, protocol)
+		if hello {
+			protocol = next
+			err := subscriber.write(answer)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		subscription, format, response := h.Engine.Subscribe(line, protocol)
+
+		// Acknowledge before the first event can be written.
+		err := subscriber.write(response)
+		if err != nil {
+			if subscription != nil {
+				subscription.Close()
+			}
+			return err
+		}
+
+		if subscription != nil && !subscriber.subscribe(subscription, format) {
+			subscription.Close()
+			return nil
+		}
+	}
+
+	// The client left, or the connection was closed under the reader.
+	return nil
+}
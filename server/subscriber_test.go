@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fabricekabongo/loggerhead/query"
+	"github.com/fabricekabongo/loggerhead/world"
+)
+
+func startSubscriber(t *testing.T, engine *query.SubscriberEngine) (net.Conn, *bufio.Reader, chan error) {
+	handler := &SubscriptionHandler{
+		Engine:         engine,
+		closeChan:      make(chan int),
+		MaxConnections: 1,
+		maxEOFWait:     time.Second,
+	}
+
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		serverConn.Close()
+		clientConn.Close()
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- handler.handleConnection(serverConn)
+	}()
+
+	if err := clientConn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set read deadline: %v", err)
+	}
+
+	return clientConn, bufio.NewReader(clientConn), errCh
+}
+
+func readLine(t *testing.T, reader *bufio.Reader) string {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read line: %v", err)
+	}
+
+	return line
+}
+
+func TestSubscriptionHandlerStreamsEvents(t *testing.T) {
+	w := world.NewWorld()
+	clientConn, reader, errCh := startSubscriber(t, query.NewSubscriberQueryEngine(w, 16, world.DropEvents))
+
+	if _, err := clientConn.Write([]byte("SUBSCRIBE ns 0 0 10 10\n")); err != nil {
+		t.Fatalf("failed to write query: %v", err)
+	}
+	if line := readLine(t, reader); line != "1.0,subscribed\n" {
+		t.Fatalf("unexpected acknowledgement: %q", line)
+	}
+
+	if _, err := clientConn.Write([]byte("SUBSCRIBE ns x 0 10 10\n")); err != nil {
+		t.Fatalf("failed to write query: %v", err)
+	}
+	if line := readLine(t, reader); line != "1.0,ERR,E_BAD_LAT,\"Invalid float64 value for latitude1\"\n" {
+		t.Fatalf("unexpected error: %q", line)
+	}
+
+	if err := w.SaveWithAttributes("ns", "a", 1, 2, 0, world.Attributes{"status": "busy"}); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if err := w.Delete("ns", "a"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	if line := readLine(t, reader); line != "1.0,ENTER,ns,a,1.000000,2.000000,status=busy\n" {
+		t.Fatalf("unexpected event: %q", line)
+	}
+	if line := readLine(t, reader); line != "1.0,DELETE,ns,a,1.000000,2.000000,status=busy\n" {
+		t.Fatalf("unexpected event: %q", line)
+	}
+
+	if _, err := clientConn.Write([]byte("\n")); err != nil {
+		t.Fatalf("failed to write terminator: %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("handleConnection returned error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("handleConnection did not return")
+	}
+
+	if err := w.Save("ns", "b", 1, 2); err != nil {
+		t.Fatalf("failed to save after the subscriber left: %v", err)
+	}
+}
+
+func TestSubscriptionHandlerDisconnectsSlowConsumers(t *testing.T) {
+	w := world.NewWorld()
+	clientConn, reader, errCh := startSubscriber(t, query.NewSubscriberQueryEngine(w, 1, world.Disconnect))
+
+	if _, err := clientConn.Write([]byte("SUBSCRIBE ns 0 0 10 10\n")); err != nil {
+		t.Fatalf("failed to write query: %v", err)
+	}
+	if line := readLine(t, reader); line != "1.0,subscribed\n" {
+		t.Fatalf("unexpected acknowledgement: %q", line)
+	}
+
+	// Nothing is read while saving: the first event waits to be written, the second fills the buffer and the
+	// third does not fit.
+	for i := 0; i < 3; i++ {
+		if err := w.Save("ns", "a", 1, float64(i)); err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 2; i++ {
+		expected := "1.0,"
+		if i == 0 {
+			expected += "ENTER"
+		} else {
+			expected += "MOVE"
+		}
+		expected += ",ns,a,1.000000," + strconv.Itoa(i) + ".000000\n"
+
+		if line := readLine(t, reader); line != expected {
+			t.Fatalf("expected %q got %q", expected, line)
+		}
+	}
+
+	if line := readLine(t, reader); line != "1.0,ERR,E_SLOW_CONSUMER,\"disconnected, events were not read fast enough\"\n" {
+		t.Fatalf("expected the disconnection notice, got %q", line)
+	}
+
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Fatalf("expected the connection to be closed")
+	}
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("handleConnection returned error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("handleConnection did not return")
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	w "github.com/fabricekabongo/loggerhead/world"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	webhookFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_server_webhook_failures",
+		Help: "Number of events the webhook failed to deliver",
+	})
+)
+
+// webhookEvent is the JSON body posted for an event.
+type webhookEvent struct {
+	Event      string       `json:"event"`
+	Namespace  string       `json:"namespace"`
+	Fence      string       `json:"fence,omitempty"`
+	Id         string       `json:"id"`
+	Lat        float64      `json:"lat"`
+	Lon        float64      `json:"lon"`
+	Attributes w.Attributes `json:"attributes,omitempty"`
+}
+
+// Webhook posts the events of a subscription to a URL, one JSON object per request, in order. An event that
+// cannot be delivered is logged and skipped: the subscription's buffer is what absorbs a slow endpoint.
+type Webhook struct {
+	url          string
+	client       *http.Client
+	subscription *w.Subscription
+}
+
+func NewWebhook(url string, timeout time.Duration, subscription *w.Subscription) *Webhook {
+	return &Webhook{
+		url:          url,
+		client:       &http.Client{Timeout: timeout},
+		subscription: subscription,
+	}
+}
+
+// Run delivers the events until the context is done, which closes the subscription, or the subscription closes.
+func (h *Webhook) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		h.subscription.Close()
+	}()
+
+	for event := range h.subscription.Events() {
+		err := h.post(ctx, event)
+		if err != nil {
+			webhookFailures.Inc()
+			log.Println("Error posting to the webhook: ", err)
+		}
+	}
+}
+
+func (h *Webhook) post(ctx context.Context, event w.Event) error {
+	body, err := json.Marshal(webhookEvent{
+		Event:      event.Kind.String(),
+		Namespace:  event.Ns,
+		Fence:      event.Fence,
+		Id:         event.Id,
+		Lat:        event.Lat,
+		Lon:        event.Lon,
+		Attributes: event.Attributes,
+	})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := h.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return errors.New("webhook answered with status " + strconv.Itoa(response.StatusCode))
+	}
+
+	return nil
+}
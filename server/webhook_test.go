@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabricekabongo/loggerhead/world"
+)
+
+func TestWebhookPostsFenceEvents(t *testing.T) {
+	received := make(chan webhookEvent, 4)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var event webhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("failed to decode the body: %v", err)
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected content type %q", r.Header.Get("Content-Type"))
+		}
+		received <- event
+
+		if event.Event == "ENTER" {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer endpoint.Close()
+
+	w := world.NewWorld()
+	subscription, err := w.WatchFences("", 16, world.DropEvents)
+	if err != nil {
+		t.Fatalf("failed to watch fences: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewWebhook(endpoint.URL, time.Second, subscription).Run(ctx)
+		close(done)
+	}()
+
+	polygon, err := world.ParsePolygon("POLYGON ((0 0, 10 0, 10 10, 0 10, 0 0))")
+	if err != nil {
+		t.Fatalf("failed to parse the polygon: %v", err)
+	}
+	if err := w.AddFence("ns", "depot", polygon, 0); err != nil {
+		t.Fatalf("failed to add the fence: %v", err)
+	}
+	if err := w.SaveWithAttributes("ns", "a", 1, 2, 0, world.Attributes{"status": "busy"}); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if err := w.Save("ns", "a", 20, 20); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	// The endpoint failing the first event does not stop the second.
+	expected := []webhookEvent{
+		{Event: "ENTER", Namespace: "ns", Fence: "depot", Id: "a", Lat: 1, Lon: 2, Attributes: world.Attributes{"status": "busy"}},
+		{Event: "EXIT", Namespace: "ns", Fence: "depot", Id: "a", Lat: 20, Lon: 20, Attributes: world.Attributes{"status": "busy"}},
+	}
+	for _, want := range expected {
+		select {
+		case event := <-received:
+			if event.Event != want.Event || event.Namespace != want.Namespace || event.Fence != want.Fence ||
+				event.Id != want.Id || event.Lat != want.Lat || event.Lon != want.Lon || event.Attributes["status"] != "busy" {
+				t.Fatalf("expected %+v got %+v", want, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the webhook did not post %s", want.Event)
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Run did not return once the context was done")
+	}
+}
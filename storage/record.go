@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+
+	"github.com/fabricekabongo/loggerhead/world"
+)
+
+const (
+	recordHeaderSize = 8
+	maxRecordSize    = 1 << 20
+)
+
+var (
+	ErrCorruptedRecord = errors.New("corrupted write-ahead log record")
+	crcTable           = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// A record is laid out as: payload length (uint32), CRC32-C of the payload (uint32), payload.
+// The payload is the operation byte followed by the namespace and the id as uvarint-prefixed strings,
+// and for saves the latitude and longitude as float64 bits, then the expiry in Unix nanoseconds (uint64, 0 for
+// never) if the location expires or has attributes, then the attributes if it has some: a uvarint count
+// followed by key and value strings. Default TTL changes carry the TTL in nanoseconds (uint64), and history
+// changes the history size (uint64) and age in nanoseconds (uint64). Added fences carry their dwell time in
+// nanoseconds (uint64) and their rings: a uvarint count of rings, each a uvarint count of lat/lon float64 pairs.
+// Created namespaces carry their leaf capacity, pre-division depth and maximum depth (uint64 each), then their
+// extent's lat1, lat2, lon1 and lon2 as float64 bits, then their backend as a string unless it is the quadtree.
+// Renamed namespaces carry their new name as the id.
+func encodeRecord(mutation world.Mutation) []byte {
+	payload := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(mutation.Ns)+len(mutation.Id)+24)
+
+	payload = append(payload, byte(mutation.Op))
+	payload = appendString(payload, mutation.Ns)
+	payload = appendString(payload, mutation.Id)
+
+	switch mutation.Op {
+	case world.OpSave:
+		payload = binary.BigEndian.AppendUint64(payload, math.Float64bits(mutation.Lat))
+		payload = binary.BigEndian.AppendUint64(payload, math.Float64bits(mutation.Lon))
+		if !mutation.ExpiresAt.IsZero() || len(mutation.Attributes) > 0 {
+			var expiresAt uint64
+			if !mutation.ExpiresAt.IsZero() {
+				expiresAt = uint64(mutation.ExpiresAt.UnixNano())
+			}
+			payload = binary.BigEndian.AppendUint64(payload, expiresAt)
+		}
+		if len(mutation.Attributes) > 0 {
+			payload = binary.AppendUvarint(payload, uint64(len(mutation.Attributes)))
+			for key, value := range mutation.Attributes {
+				payload = appendString(payload, key)
+				payload = appendString(payload, value)
+			}
+		}
+	case world.OpDefaultTTL:
+		payload = binary.BigEndian.AppendUint64(payload, uint64(mutation.TTL))
+	case world.OpHistory:
+		payload = binary.BigEndian.AppendUint64(payload, uint64(mutation.HistorySize))
+		payload = binary.BigEndian.AppendUint64(payload, uint64(mutation.HistoryAge))
+	case world.OpFenceAdd:
+		payload = binary.BigEndian.AppendUint64(payload, uint64(mutation.Dwell))
+		payload = binary.AppendUvarint(payload, uint64(len(mutation.Polygon.Rings)))
+		for _, ring := range mutation.Polygon.Rings {
+			payload = binary.AppendUvarint(payload, uint64(len(ring)))
+			for _, point := range ring {
+				payload = binary.BigEndian.AppendUint64(payload, math.Float64bits(point.Lat))
+				payload = binary.BigEndian.AppendUint64(payload, math.Float64bits(point.Lon))
+			}
+		}
+	case world.OpCreateNamespace:
+		options := mutation.Options
+		payload = binary.BigEndian.AppendUint64(payload, uint64(options.Capacity))
+		payload = binary.BigEndian.AppendUint64(payload, uint64(options.PreDivide))
+		payload = binary.BigEndian.AppendUint64(payload, uint64(options.MaxDepth))
+		for _, bound := range []float64{options.Extent.Lat1, options.Extent.Lat2, options.Extent.Lon1, options.Extent.Lon2} {
+			payload = binary.BigEndian.AppendUint64(payload, math.Float64bits(bound))
+		}
+		if options.Backend != "" && options.Backend != world.IndexQuadtree {
+			payload = appendString(payload, options.Backend)
+		}
+	}
+
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+
+	return append(record, payload...)
+}
+
+// readRecord returns io.EOF on a clean end of log, and io.ErrUnexpectedEOF or ErrCorruptedRecord on a torn or damaged record.
+func readRecord(reader *bufio.Reader) (world.Mutation, int, error) {
+	var header [recordHeaderSize]byte
+
+	_, err := io.ReadFull(reader, header[:])
+	if err != nil {
+		return world.Mutation{}, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size == 0 || size > maxRecordSize {
+		return world.Mutation{}, 0, ErrCorruptedRecord
+	}
+
+	payload := make([]byte, size)
+	_, err = io.ReadFull(reader, payload)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return world.Mutation{}, 0, io.ErrUnexpectedEOF
+		}
+		return world.Mutation{}, 0, err
+	}
+
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return world.Mutation{}, 0, ErrCorruptedRecord
+	}
+
+	mutation, err := decodePayload(payload)
+	if err != nil {
+		return world.Mutation{}, 0, err
+	}
+
+	return mutation, recordHeaderSize + int(size), nil
+}
+
+func decodePayload(payload []byte) (world.Mutation, error) {
+	mutation := world.Mutation{Op: world.Operation(payload[0])}
+	rest := payload[1:]
+
+	var err error
+
+	mutation.Ns, rest, err = readString(rest)
+	if err != nil {
+		return world.Mutation{}, err
+	}
+
+	mutation.Id, rest, err = readString(rest)
+	if err != nil {
+		return world.Mutation{}, err
+	}
+
+	switch mutation.Op {
+	case world.OpSave:
+		if len(rest) != 16 && len(rest) < 24 {
+			return world.Mutation{}, ErrCorruptedRecord
+		}
+		mutation.Lat = math.Float64frombits(binary.BigEndian.Uint64(rest[0:8]))
+		mutation.Lon = math.Float64frombits(binary.BigEndian.Uint64(rest[8:16]))
+		if len(rest) >= 24 {
+			if expiresAt := binary.BigEndian.Uint64(rest[16:24]); expiresAt != 0 {
+				mutation.ExpiresAt = time.Unix(0, int64(expiresAt))
+			}
+		}
+		if len(rest) > 24 {
+			mutation.Attributes, err = readAttributes(rest[24:])
+			if err != nil {
+				return world.Mutation{}, err
+			}
+		}
+	case world.OpDefaultTTL:
+		if len(rest) != 8 {
+			return world.Mutation{}, ErrCorruptedRecord
+		}
+		mutation.TTL = time.Duration(binary.BigEndian.Uint64(rest))
+	case world.OpHistory:
+		if len(rest) != 16 {
+			return world.Mutation{}, ErrCorruptedRecord
+		}
+		mutation.HistorySize = int(binary.BigEndian.Uint64(rest[0:8]))
+		mutation.HistoryAge = time.Duration(binary.BigEndian.Uint64(rest[8:16]))
+	case world.OpFenceAdd:
+		if len(rest) < 8 {
+			return world.Mutation{}, ErrCorruptedRecord
+		}
+		mutation.Dwell = time.Duration(binary.BigEndian.Uint64(rest[0:8]))
+		mutation.Polygon, err = readPolygon(rest[8:])
+		if err != nil {
+			return world.Mutation{}, err
+		}
+	case world.OpCreateNamespace:
+		if len(rest) < 56 {
+			return world.Mutation{}, ErrCorruptedRecord
+		}
+		mutation.Options = world.IndexOptions{
+			Backend:   world.IndexQuadtree,
+			Capacity:  int(binary.BigEndian.Uint64(rest[0:8])),
+			PreDivide: int(binary.BigEndian.Uint64(rest[8:16])),
+			MaxDepth:  int(binary.BigEndian.Uint64(rest[16:24])),
+			Extent: world.Extent{
+				Lat1: math.Float64frombits(binary.BigEndian.Uint64(rest[24:32])),
+				Lat2: math.Float64frombits(binary.BigEndian.Uint64(rest[32:40])),
+				Lon1: math.Float64frombits(binary.BigEndian.Uint64(rest[40:48])),
+				Lon2: math.Float64frombits(binary.BigEndian.Uint64(rest[48:56])),
+			},
+		}
+		if len(rest) > 56 {
+			var extra []byte
+			mutation.Options.Backend, extra, err = readString(rest[56:])
+			if err != nil || len(extra) != 0 {
+				return world.Mutation{}, ErrCorruptedRecord
+			}
+		}
+	case world.OpDelete, world.OpFenceDelete, world.OpDropNamespace, world.OpRenameNamespace:
+		if len(rest) != 0 {
+			return world.Mutation{}, ErrCorruptedRecord
+		}
+	default:
+		return world.Mutation{}, ErrCorruptedRecord
+	}
+
+	return mutation, nil
+}
+
+func readAttributes(buf []byte) (world.Attributes, error) {
+	count, n := binary.Uvarint(buf)
+	if n <= 0 || count == 0 || count > uint64(len(buf)) {
+		return nil, ErrCorruptedRecord
+	}
+	buf = buf[n:]
+
+	attributes := make(world.Attributes, count)
+	for i := uint64(0); i < count; i++ {
+		key, rest, err := readString(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		value, rest, err := readString(rest)
+		if err != nil {
+			return nil, err
+		}
+
+		attributes[key] = value
+		buf = rest
+	}
+
+	if len(buf) != 0 {
+		return nil, ErrCorruptedRecord
+	}
+
+	return attributes, nil
+}
+
+func readPolygon(buf []byte) (*world.Polygon, error) {
+	count, n := binary.Uvarint(buf)
+	if n <= 0 || count == 0 || count > uint64(len(buf)) {
+		return nil, ErrCorruptedRecord
+	}
+	buf = buf[n:]
+
+	rings := make([][]world.Point, 0, count)
+	for i := uint64(0); i < count; i++ {
+		points, n := binary.Uvarint(buf)
+		if n <= 0 || points > uint64(len(buf)-n)/16 {
+			return nil, ErrCorruptedRecord
+		}
+		buf = buf[n:]
+
+		ring := make([]world.Point, 0, points)
+		for j := uint64(0); j < points; j++ {
+			ring = append(ring, world.Point{
+				Lat: math.Float64frombits(binary.BigEndian.Uint64(buf[0:8])),
+				Lon: math.Float64frombits(binary.BigEndian.Uint64(buf[8:16])),
+			})
+			buf = buf[16:]
+		}
+		rings = append(rings, ring)
+	}
+
+	if len(buf) != 0 {
+		return nil, ErrCorruptedRecord
+	}
+
+	polygon, err := world.NewPolygon(rings)
+	if err != nil {
+		return nil, ErrCorruptedRecord
+	}
+
+	return polygon, nil
+}
+
+func appendString(buf []byte, value string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+
+	return append(buf, value...)
+}
+
+func readString(buf []byte) (string, []byte, error) {
+	length, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < length {
+		return "", nil, ErrCorruptedRecord
+	}
+
+	end := n + int(length)
+
+	return string(buf[n:end]), buf[end:], nil
+}
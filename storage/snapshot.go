@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fabricekabongo/loggerhead/world"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const snapshotExtension = ".snap"
+
+var (
+	snapshotsTaken = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_storage_snapshots",
+		Help: "The number of snapshots written",
+	})
+	snapshotDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "loggerhead_storage_snapshot_duration_seconds",
+		Help: "Time spent writing a snapshot",
+	})
+)
+
+// Snapshot writes the world to disk and truncates the log behind it.
+//
+// The log is first rotated to a new segment N and the snapshot is saved as N.snap, so N.snap stands for every
+// segment below N. Writes keep going during the snapshot and land in segment N; the ones the snapshot already
+// saw are replayed again on recovery, which is harmless since replaying the changes to a namespace is idempotent.
+// Creating, dropping or renaming a namespace is not, so the catalog is frozen from the rotation to the end of
+// the snapshot, and those changes all come after it in segment N.
+func (l *WAL) Snapshot(w *world.World) (string, error) {
+	l.snapshotMu.Lock()
+	defer l.snapshotMu.Unlock()
+
+	start := time.Now()
+
+	var segment uint64
+	var path string
+	err := w.FreezeCatalog(func() error {
+		var err error
+		segment, err = l.rotate()
+		if err != nil {
+			return err
+		}
+
+		path = snapshotPath(l.dir, segment)
+
+		return writeSnapshotFile(w, path)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = l.removeBefore(segment)
+	if err != nil {
+		log.Println("Failed to remove files covered by snapshot: ", err)
+	}
+
+	snapshotsTaken.Inc()
+	snapshotDuration.Observe(time.Since(start).Seconds())
+
+	return path, nil
+}
+
+// RunSnapshots takes a snapshot every interval until the context is done.
+func (l *WAL) RunSnapshots(ctx context.Context, w *world.World, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			path, err := l.Snapshot(w)
+			if err != nil {
+				log.Println("Failed to take a snapshot: ", err)
+				continue
+			}
+			log.Println("Snapshot written to ", path)
+		}
+	}
+}
+
+// RestoreSnapshot loads the world from a specific snapshot file, ignoring the log, and then snapshots the
+// restored world so the data directory starts over from it.
+func (l *WAL) RestoreSnapshot(w *world.World, path string) error {
+	err := loadSnapshotFile(w, path)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.Snapshot(w)
+
+	return err
+}
+
+func (l *WAL) rotate() (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, ErrLogClosed
+	}
+
+	err := l.sync()
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := openSegment(l.dir, l.segment+1)
+	if err != nil {
+		return 0, err
+	}
+
+	err = l.file.Close()
+	if err != nil {
+		log.Println("Failed to close write-ahead log segment: ", err)
+	}
+
+	l.segment++
+	l.file = file
+	l.writer.Reset(file)
+
+	return l.segment, nil
+}
+
+// removeBefore drops the segments covered by the snapshot of segment, and the snapshots beyond the retained ones.
+func (l *WAL) removeBefore(segment uint64) error {
+	segments, err := listFiles(l.dir, segmentExtension)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range segments {
+		if s >= segment {
+			break
+		}
+
+		err = os.Remove(segmentPath(l.dir, s))
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshots, err := listFiles(l.dir, snapshotExtension)
+	if err != nil {
+		return err
+	}
+
+	retain := max(l.opts.SnapshotRetain, 1)
+
+	for i := 0; i < len(snapshots)-retain; i++ {
+		err = os.Remove(snapshotPath(l.dir, snapshots[i]))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// latestSnapshot returns the newest snapshot, or 0 if there is none.
+func latestSnapshot(dir string) (uint64, error) {
+	snapshots, err := listFiles(dir, snapshotExtension)
+	if err != nil || len(snapshots) == 0 {
+		return 0, err
+	}
+
+	return snapshots[len(snapshots)-1], nil
+}
+
+func writeSnapshotFile(w *world.World, path string) error {
+	tmp := path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+
+	err = w.WriteSnapshot(file)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+func loadSnapshotFile(w *world.World, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	err = w.LoadSnapshot(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+
+	return d.Sync()
+}
+
+func snapshotPath(dir string, segment uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", segment, snapshotExtension))
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fabricekabongo/loggerhead/world"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWALSnapshot(t *testing.T) {
+	t.Run("should truncate the log behind the snapshot and recover from both", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways, SnapshotRetain: 1})
+
+		original := world.NewWorld()
+		original.SetJournal(wal)
+
+		assert.NoError(t, original.Save("ns", "before", 1, 1))
+		assert.NoError(t, original.Save("ns", "deleted", 2, 2))
+
+		path, err := wal.Snapshot(original)
+		assert.NoError(t, err)
+		assert.Equal(t, snapshotPath(dir, 2), path)
+
+		_, err = os.Stat(segmentPath(dir, 1))
+		assert.True(t, os.IsNotExist(err), "the segment covered by the snapshot should be removed")
+
+		assert.NoError(t, original.Save("ns", "after", 3, 3))
+		assert.NoError(t, original.Delete("ns", "deleted"))
+		assert.NoError(t, wal.Close())
+
+		restored := world.NewWorld()
+		replayed, err := openTestWAL(t, dir, Options{Sync: SyncAlways}).Replay(restored)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, replayed)
+
+		_, ok := restored.GetLocation("ns", "before")
+		assert.True(t, ok)
+		_, ok = restored.GetLocation("ns", "after")
+		assert.True(t, ok)
+		_, ok = restored.GetLocation("ns", "deleted")
+		assert.False(t, ok)
+	})
+
+	t.Run("should only retain the configured number of snapshots", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways, SnapshotRetain: 2})
+		defer wal.Close()
+
+		w := world.NewWorld()
+		for i := 0; i < 3; i++ {
+			_, err := wal.Snapshot(w)
+			assert.NoError(t, err)
+		}
+
+		snapshots, err := listFiles(dir, snapshotExtension)
+		assert.NoError(t, err)
+		assert.Equal(t, []uint64{3, 4}, snapshots)
+
+		segments, err := listFiles(dir, segmentExtension)
+		assert.NoError(t, err)
+		assert.Equal(t, []uint64{4}, segments)
+	})
+
+	t.Run("should restore a specific snapshot and ignore the log", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways, SnapshotRetain: 5})
+
+		original := world.NewWorld()
+		original.SetJournal(wal)
+
+		assert.NoError(t, original.Save("ns", "old", 1, 1))
+		path, err := wal.Snapshot(original)
+		assert.NoError(t, err)
+		assert.NoError(t, original.Save("ns", "new", 2, 2))
+		assert.NoError(t, wal.Close())
+
+		wal = openTestWAL(t, dir, Options{Sync: SyncAlways, SnapshotRetain: 5})
+		restored := world.NewWorld()
+		assert.NoError(t, wal.RestoreSnapshot(restored, path))
+		assert.NoError(t, wal.Close())
+
+		_, ok := restored.GetLocation("ns", "old")
+		assert.True(t, ok)
+		_, ok = restored.GetLocation("ns", "new")
+		assert.False(t, ok)
+
+		again := world.NewWorld()
+		_, err = openTestWAL(t, dir, Options{Sync: SyncAlways}).Replay(again)
+		assert.NoError(t, err)
+		_, ok = again.GetLocation("ns", "new")
+		assert.False(t, ok, "the restored snapshot should be where the next start resumes from")
+	})
+
+	t.Run("should snapshot periodically", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways})
+		defer wal.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan struct{})
+
+		go func() {
+			wal.RunSnapshots(ctx, world.NewWorld(), 5*time.Millisecond)
+			close(stopped)
+		}()
+
+		assert.Eventually(t, func() bool {
+			snapshot, err := latestSnapshot(dir)
+			return err == nil && snapshot > 0
+		}, time.Second, 5*time.Millisecond)
+
+		// Stop before the temporary directory is removed, or a snapshot in progress races the cleanup.
+		cancel()
+		<-stopped
+	})
+}
@@ -0,0 +1,357 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabricekabongo/loggerhead/world"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const segmentExtension = ".wal"
+
+var (
+	ErrUnknownSyncPolicy = errors.New("unknown sync policy, expected always, batch or interval")
+	ErrLogClosed         = errors.New("write-ahead log is closed")
+
+	walAppends = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_storage_wal_appends",
+		Help: "The number of records appended to the write-ahead log",
+	})
+	walSyncs = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_storage_wal_syncs",
+		Help: "The number of times the write-ahead log was flushed to disk",
+	})
+)
+
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every record. Nothing acknowledged is ever lost.
+	SyncAlways SyncPolicy = iota
+	// SyncBatch fsyncs once BatchSize records are pending, or after Interval if fewer arrive.
+	SyncBatch
+	// SyncInterval fsyncs every Interval. Up to Interval worth of writes can be lost on a crash.
+	SyncInterval
+)
+
+func ParseSyncPolicy(policy string) (SyncPolicy, error) {
+	switch strings.ToLower(policy) {
+	case "always":
+		return SyncAlways, nil
+	case "batch":
+		return SyncBatch, nil
+	case "interval":
+		return SyncInterval, nil
+	}
+
+	return SyncAlways, ErrUnknownSyncPolicy
+}
+
+type Options struct {
+	Sync      SyncPolicy
+	BatchSize int
+	Interval  time.Duration
+	// SnapshotRetain is how many snapshots are kept on disk. The newest one is always kept.
+	SnapshotRetain int
+}
+
+// WAL is an append-only log of every mutation applied to the world, split in numbered segment files.
+// Snapshots share the numbering: snapshot N holds everything logged in the segments before N.
+type WAL struct {
+	dir        string
+	opts       Options
+	file       *os.File
+	writer     *bufio.Writer
+	segment    uint64
+	pending    int
+	closed     bool
+	done       chan struct{}
+	mu         sync.Mutex
+	snapshotMu sync.Mutex
+}
+
+func OpenWAL(dir string, opts Options) (*WAL, error) {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, err
+	}
+
+	segments, err := listFiles(dir, segmentExtension)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := latestSnapshot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segment := max(snapshot, 1)
+	if len(segments) > 0 {
+		segment = max(segment, segments[len(segments)-1])
+	}
+
+	file, err := openSegment(dir, segment)
+	if err != nil {
+		return nil, err
+	}
+
+	wal := &WAL{
+		dir:     dir,
+		opts:    opts,
+		file:    file,
+		writer:  bufio.NewWriter(file),
+		segment: segment,
+		done:    make(chan struct{}),
+	}
+
+	if opts.Sync != SyncAlways && opts.Interval > 0 {
+		go wal.syncLoop()
+	}
+
+	return wal, nil
+}
+
+// Append writes the mutation to the active segment and syncs it according to the sync policy.
+func (l *WAL) Append(mutation world.Mutation) error {
+	record := encodeRecord(mutation)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrLogClosed
+	}
+
+	_, err := l.writer.Write(record)
+	if err != nil {
+		return err
+	}
+	walAppends.Inc()
+	l.pending++
+
+	switch l.opts.Sync {
+	case SyncAlways:
+		return l.sync()
+	case SyncBatch:
+		if l.pending >= l.opts.BatchSize {
+			return l.sync()
+		}
+	case SyncInterval:
+	}
+
+	return nil
+}
+
+// Replay loads the latest snapshot into the world, then applies every record logged after it, in order, and
+// returns how many records were applied. A torn record at the end of the last segment is what a crash
+// mid-write leaves behind: it is cut off and the replay succeeds. Damage anywhere else is reported as an error.
+func (l *WAL) Replay(w *world.World) (int, error) {
+	snapshot, err := latestSnapshot(l.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	if snapshot > 0 {
+		err = loadSnapshotFile(w, snapshotPath(l.dir, snapshot))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	segments, err := listFiles(l.dir, segmentExtension)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+
+	for i, segment := range segments {
+		if segment < snapshot {
+			continue // Left behind by a crash before the snapshot could remove it.
+		}
+
+		count, err := l.replaySegment(w, segment, i == len(segments)-1)
+		replayed += count
+		if err != nil {
+			return replayed, err
+		}
+	}
+
+	return replayed, nil
+}
+
+func (l *WAL) replaySegment(w *world.World, segment uint64, last bool) (int, error) {
+	path := segmentPath(l.dir, segment)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reader := bufio.NewReader(file)
+	replayed := 0
+	offset := int64(0)
+
+	for {
+		mutation, size, err := readRecord(reader)
+		if errors.Is(err, io.EOF) {
+			return replayed, nil
+		}
+
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrCorruptedRecord) {
+			if !last {
+				return replayed, fmt.Errorf("%s at offset %d: %w", path, offset, err)
+			}
+
+			log.Println("Truncating torn record at the end of the write-ahead log: ", path, " offset ", offset)
+
+			return replayed, l.truncate(offset)
+		}
+
+		if err != nil {
+			return replayed, err
+		}
+
+		err = w.Apply(mutation)
+		if err != nil {
+			return replayed, fmt.Errorf("%s at offset %d: %w", path, offset, err)
+		}
+
+		replayed++
+		offset += int64(size)
+	}
+}
+
+func (l *WAL) truncate(offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	return l.file.Truncate(offset)
+}
+
+// Sync flushes buffered records and fsyncs the active segment.
+func (l *WAL) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrLogClosed
+	}
+
+	return l.sync()
+}
+
+func (l *WAL) sync() error {
+	err := l.writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = l.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	walSyncs.Inc()
+	l.pending = 0
+
+	return nil
+}
+
+func (l *WAL) syncLoop() {
+	ticker := time.NewTicker(l.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			if !l.closed && l.pending > 0 {
+				err := l.sync()
+				if err != nil {
+					log.Println("Failed to sync the write-ahead log: ", err)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+func (l *WAL) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+
+	l.closed = true
+	close(l.done)
+
+	err := l.sync()
+	if err != nil {
+		_ = l.file.Close()
+		return err
+	}
+
+	return l.file.Close()
+}
+
+func openSegment(dir string, segment uint64) (*os.File, error) {
+	return os.OpenFile(segmentPath(dir, segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+}
+
+func segmentPath(dir string, segment uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", segment, segmentExtension))
+}
+
+// listFiles returns the sequence numbers of the files named <number><extension> in dir, in ascending order.
+func listFiles(dir, extension string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var numbers []uint64
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, extension) {
+			continue
+		}
+
+		number, err := strconv.ParseUint(strings.TrimSuffix(name, extension), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		numbers = append(numbers, number)
+	}
+
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	return numbers, nil
+}
@@ -0,0 +1,360 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fabricekabongo/loggerhead/query"
+	"github.com/fabricekabongo/loggerhead/world"
+	"github.com/stretchr/testify/assert"
+)
+
+func openTestWAL(t *testing.T, dir string, opts Options) *WAL {
+	t.Helper()
+
+	wal, err := OpenWAL(dir, opts)
+	if err != nil {
+		t.Fatalf("Failed to open the write-ahead log: %v", err)
+	}
+
+	return wal
+}
+
+func TestWALReplay(t *testing.T) {
+	t.Run("should replay saves and deletes in order", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways})
+
+		original := world.NewWorld()
+		original.SetJournal(wal)
+
+		assert.NoError(t, original.Save("ns", "kept", 1, 2))
+		assert.NoError(t, original.Save("ns", "moved", 3, 4))
+		assert.NoError(t, original.Save("ns", "moved", 5, 6))
+		assert.NoError(t, original.Save("ns", "deleted", 7, 8))
+		assert.NoError(t, original.Delete("ns", "deleted"))
+		assert.NoError(t, wal.Close())
+
+		restored := world.NewWorld()
+		replayed, err := openTestWAL(t, dir, Options{Sync: SyncAlways}).Replay(restored)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, replayed)
+
+		kept, ok := restored.GetLocation("ns", "kept")
+		assert.True(t, ok)
+		assert.Equal(t, 1.0, kept.Lat())
+		assert.Equal(t, 2.0, kept.Lon())
+
+		moved, ok := restored.GetLocation("ns", "moved")
+		assert.True(t, ok)
+		assert.Equal(t, 5.0, moved.Lat())
+		assert.Equal(t, 6.0, moved.Lon())
+
+		_, ok = restored.GetLocation("ns", "deleted")
+		assert.False(t, ok)
+	})
+
+	t.Run("should replay expiries, default TTLs and expirations", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways})
+
+		original := world.NewWorld()
+		original.SetJournal(wal)
+
+		assert.NoError(t, original.SetDefaultTTL("ns", time.Minute))
+		assert.NoError(t, original.SaveWithTTL("ns", "expiring", 1, 2, time.Hour))
+		assert.NoError(t, original.SaveWithTTL("ns", "expired", 3, 4, time.Millisecond))
+		assert.Equal(t, 1, original.Expire(time.Now().Add(time.Second), nil))
+		assert.NoError(t, wal.Close())
+
+		restored := world.NewWorld()
+		replayed, err := openTestWAL(t, dir, Options{Sync: SyncAlways}).Replay(restored)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, replayed)
+
+		expected, _ := original.GetLocation("ns", "expiring")
+		expiring, ok := restored.GetLocation("ns", "expiring")
+		assert.True(t, ok)
+		assert.True(t, expected.ExpiresAt().Equal(expiring.ExpiresAt()))
+
+		_, ok = restored.GetLocation("ns", "expired")
+		assert.False(t, ok)
+
+		assert.NoError(t, restored.Save("ns", "defaulted", 5, 6))
+		defaulted, _ := restored.GetLocation("ns", "defaulted")
+		assert.WithinDuration(t, time.Now().Add(time.Minute), defaulted.ExpiresAt(), 5*time.Second)
+	})
+
+	t.Run("should replay history settings", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways})
+
+		original := world.NewWorld()
+		original.SetJournal(wal)
+
+		assert.NoError(t, original.SetHistory("ns", 2, time.Hour))
+		assert.NoError(t, wal.Close())
+
+		restored := world.NewWorld()
+		replayed, err := openTestWAL(t, dir, Options{Sync: SyncAlways}).Replay(restored)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, replayed)
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, restored.Save("ns", "a", float64(i), 0))
+		}
+
+		positions, err := restored.History("ns", "a", time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		assert.Len(t, positions, 2)
+	})
+
+	t.Run("should replay fences", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways})
+
+		original := world.NewWorld()
+		original.SetJournal(wal)
+
+		donut, err := world.ParsePolygon("POLYGON ((0 0, 10 0, 10 10, 0 10, 0 0), (4 4, 6 4, 6 6, 4 6, 4 4))")
+		assert.NoError(t, err)
+		square, err := world.ParsePolygon("POLYGON ((20 20, 30 20, 30 30, 20 30, 20 20))")
+		assert.NoError(t, err)
+
+		assert.NoError(t, original.AddFence("ns", "depot", donut, time.Minute))
+		assert.NoError(t, original.AddFence("ns", "yard", square, 0))
+		assert.NoError(t, original.DeleteFence("ns", "yard"))
+		assert.NoError(t, wal.Close())
+
+		restored := world.NewWorld()
+		replayed, err := openTestWAL(t, dir, Options{Sync: SyncAlways}).Replay(restored)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, replayed)
+
+		fences := restored.Fences("ns")
+		assert.Len(t, fences, 1)
+		assert.Equal(t, "depot", fences[0].Id)
+		assert.Equal(t, time.Minute, fences[0].Dwell)
+		assert.Equal(t, donut.String(), fences[0].Polygon.String())
+	})
+
+	t.Run("should replay created namespaces", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways})
+
+		original := world.NewWorld()
+		original.SetJournal(wal)
+
+		options := world.IndexOptions{
+			Backend:   world.IndexQuadtree,
+			Capacity:  100,
+			PreDivide: 2,
+			MaxDepth:  12,
+			Extent:    world.Extent{Lat1: 48.8, Lat2: 48.9, Lon1: 2.2, Lon2: 2.5},
+		}
+		assert.NoError(t, original.CreateNamespace("paris", options))
+		assert.NoError(t, original.Save("paris", "a", 48.85, 2.35))
+
+		sparse := world.DefaultIndexOptions()
+		sparse.Backend = world.IndexRTree
+		sparse.Capacity = 16
+		assert.NoError(t, original.CreateNamespace("ships", sparse))
+		assert.NoError(t, wal.Close())
+
+		restored := world.NewWorld()
+		replayed, err := openTestWAL(t, dir, Options{Sync: SyncAlways}).Replay(restored)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, replayed)
+
+		assert.Equal(t, []world.NamespaceOptions{{Name: "paris", Options: options}, {Name: "ships", Options: sparse}}, restored.Catalog())
+		assert.ErrorIs(t, restored.Save("paris", "b", 10, 10), world.ErrLocationOutOfExtent)
+	})
+
+	t.Run("should replay dropped and renamed namespaces", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways})
+
+		original := world.NewWorld()
+		original.SetJournal(wal)
+
+		assert.NoError(t, original.Save("gone", "a", 1, 1))
+		assert.NoError(t, original.Save("old", "b", 2, 2))
+		assert.NoError(t, original.DropNamespace("gone"))
+		assert.NoError(t, original.RenameNamespace("old", "new"))
+		assert.NoError(t, wal.Close())
+
+		restored := world.NewWorld()
+		replayed, err := openTestWAL(t, dir, Options{Sync: SyncAlways}).Replay(restored)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, replayed)
+
+		assert.Equal(t, []world.NamespaceCount{{Name: "new", Locations: 1}}, restored.Namespaces())
+	})
+
+	t.Run("should replay attributes", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways})
+
+		original := world.NewWorld()
+		original.SetJournal(wal)
+
+		assert.NoError(t, original.SaveWithAttributes("ns", "a", 1, 2, 0, world.Attributes{"status": "busy", "type": "van"}))
+		assert.NoError(t, original.SaveWithAttributes("ns", "a", 1, 3, time.Hour, world.Attributes{"status": "available"}))
+		assert.NoError(t, original.SaveWithAttributes("ns", "b", 1, 2, 0, world.Attributes{"type": "car"}))
+		assert.NoError(t, original.SaveWithAttributes("ns", "b", 1, 2, 0, world.Attributes{"type": ""}))
+		assert.NoError(t, wal.Close())
+
+		restored := world.NewWorld()
+		_, err := openTestWAL(t, dir, Options{Sync: SyncAlways}).Replay(restored)
+		assert.NoError(t, err)
+
+		a, _ := restored.GetLocation("ns", "a")
+		assert.Equal(t, world.Attributes{"status": "available", "type": "van"}, a.Attributes())
+		assert.False(t, a.ExpiresAt().IsZero())
+
+		b, _ := restored.GetLocation("ns", "b")
+		assert.Nil(t, b.Attributes())
+	})
+
+	t.Run("should not journal invalid saves or deletes of unknown locations", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways})
+
+		original := world.NewWorld()
+		original.SetJournal(wal)
+
+		assert.Error(t, original.Save("ns", "bad", 200, 0))
+		assert.NoError(t, original.Delete("ns", "unknown"))
+		assert.NoError(t, wal.Close())
+
+		replayed, err := openTestWAL(t, dir, Options{Sync: SyncAlways}).Replay(world.NewWorld())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, replayed)
+	})
+
+	t.Run("should not journal saves of an empty id", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways})
+
+		original := world.NewWorld()
+		original.SetJournal(wal)
+
+		engine := query.NewWriteQueryEngine(original)
+		assert.Equal(t, "1.0,ERR,E_BAD_ID,\"location id is required\"\n", engine.ExecuteQuery(`SAVE ns "" 1 2`))
+		assert.Equal(t, "1.0,saved\n", engine.ExecuteQuery("SAVE ns a 1 2"))
+		assert.NoError(t, wal.Close())
+
+		restored := world.NewWorld()
+		replayed, err := openTestWAL(t, dir, Options{Sync: SyncAlways}).Replay(restored)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, replayed)
+
+		_, ok := restored.GetLocation("ns", "a")
+		assert.True(t, ok)
+	})
+
+	t.Run("should cut off a torn final record and keep appending after it", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways})
+		assert.NoError(t, wal.Append(world.Mutation{Op: world.OpSave, Ns: "ns", Id: "a", Lat: 1, Lon: 1}))
+		assert.NoError(t, wal.Append(world.Mutation{Op: world.OpSave, Ns: "ns", Id: "b", Lat: 2, Lon: 2}))
+		assert.NoError(t, wal.Close())
+
+		path := segmentPath(dir, 1)
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.NoError(t, os.Truncate(path, info.Size()-3))
+
+		wal = openTestWAL(t, dir, Options{Sync: SyncAlways})
+		restored := world.NewWorld()
+		replayed, err := wal.Replay(restored)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, replayed)
+
+		_, ok := restored.GetLocation("ns", "b")
+		assert.False(t, ok)
+
+		assert.NoError(t, wal.Append(world.Mutation{Op: world.OpSave, Ns: "ns", Id: "c", Lat: 3, Lon: 3}))
+		assert.NoError(t, wal.Close())
+
+		replayed, err = openTestWAL(t, dir, Options{Sync: SyncAlways}).Replay(world.NewWorld())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, replayed)
+	})
+
+	t.Run("should cut off a final record with a bad checksum", func(t *testing.T) {
+		dir := t.TempDir()
+		wal := openTestWAL(t, dir, Options{Sync: SyncAlways})
+		assert.NoError(t, wal.Append(world.Mutation{Op: world.OpSave, Ns: "ns", Id: "a", Lat: 1, Lon: 1}))
+		assert.NoError(t, wal.Append(world.Mutation{Op: world.OpDelete, Ns: "ns", Id: "a"}))
+		assert.NoError(t, wal.Close())
+
+		path := segmentPath(dir, 1)
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		content[len(content)-1] ^= 0xff
+		assert.NoError(t, os.WriteFile(path, content, 0o640))
+
+		restored := world.NewWorld()
+		replayed, err := openTestWAL(t, dir, Options{Sync: SyncAlways}).Replay(restored)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, replayed)
+
+		_, ok := restored.GetLocation("ns", "a")
+		assert.True(t, ok)
+	})
+}
+
+func TestWALSyncPolicies(t *testing.T) {
+	t.Run("should parse the sync policies", func(t *testing.T) {
+		policy, err := ParseSyncPolicy("always")
+		assert.NoError(t, err)
+		assert.Equal(t, SyncAlways, policy)
+
+		policy, err = ParseSyncPolicy("BATCH")
+		assert.NoError(t, err)
+		assert.Equal(t, SyncBatch, policy)
+
+		policy, err = ParseSyncPolicy("interval")
+		assert.NoError(t, err)
+		assert.Equal(t, SyncInterval, policy)
+
+		_, err = ParseSyncPolicy("sometimes")
+		assert.ErrorIs(t, err, ErrUnknownSyncPolicy)
+	})
+
+	t.Run("should sync once the batch is full", func(t *testing.T) {
+		wal := openTestWAL(t, t.TempDir(), Options{Sync: SyncBatch, BatchSize: 2})
+		defer wal.Close()
+
+		assert.NoError(t, wal.Append(world.Mutation{Op: world.OpDelete, Ns: "ns", Id: "a"}))
+		assert.Equal(t, 1, wal.pending)
+
+		assert.NoError(t, wal.Append(world.Mutation{Op: world.OpDelete, Ns: "ns", Id: "b"}))
+		assert.Equal(t, 0, wal.pending)
+	})
+
+	t.Run("should sync on the interval", func(t *testing.T) {
+		wal := openTestWAL(t, t.TempDir(), Options{Sync: SyncInterval, Interval: 5 * time.Millisecond})
+		defer wal.Close()
+
+		assert.NoError(t, wal.Append(world.Mutation{Op: world.OpDelete, Ns: "ns", Id: "a"}))
+
+		assert.Eventually(t, func() bool {
+			wal.mu.Lock()
+			defer wal.mu.Unlock()
+
+			return wal.pending == 0
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("should refuse appends once closed", func(t *testing.T) {
+		wal := openTestWAL(t, t.TempDir(), Options{Sync: SyncAlways})
+		assert.NoError(t, wal.Close())
+		assert.NoError(t, wal.Close())
+
+		assert.ErrorIs(t, wal.Append(world.Mutation{Op: world.OpDelete, Ns: "ns", Id: "a"}), ErrLogClosed)
+		assert.ErrorIs(t, wal.Sync(), ErrLogClosed)
+	})
+}
@@ -0,0 +1,105 @@
+package world
+
+import (
+	"errors"
+	"maps"
+	"sort"
+	"strings"
+)
+
+const (
+	maxAttributes          = 32
+	maxAttributeKeySize    = 64
+	maxAttributeValueSize  = 256
+	attributeForbiddenRune = ",\"= \t\r\n"
+)
+
+var (
+	ErrTooManyAttributes   = errors.New("a location can have at most 32 attributes")
+	ErrInvalidAttributeKey = errors.New("attribute keys are made of 1 to 64 letters, digits, '_', '-' or '.'")
+	ErrInvalidAttribute    = errors.New("attribute values are at most 256 characters, without spaces, commas, quotes or '='")
+)
+
+// Attributes are the key/value pairs a location carries, such as its status or vehicle type.
+// A location's attributes are never modified in place, saves replace them, so they can be read while traversing the tree.
+type Attributes map[string]string
+
+// validate checks the keys and values. Empty values are allowed: in a save they remove the key.
+func (a Attributes) validate() error {
+	if len(a) > maxAttributes {
+		return ErrTooManyAttributes
+	}
+
+	for key, value := range a {
+		if !isAttributeKey(key) {
+			return ErrInvalidAttributeKey
+		}
+
+		if len(value) > maxAttributeValueSize || strings.ContainsAny(value, attributeForbiddenRune) {
+			return ErrInvalidAttribute
+		}
+	}
+
+	return nil
+}
+
+func isNotAttributeKeyRune(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.')
+}
+
+// merge returns the attributes updated by changes, where an empty value removes the key. It returns nil when
+// nothing is left, and the receiver itself when there are no changes.
+func (a Attributes) merge(changes Attributes) (Attributes, error) {
+	if len(changes) == 0 {
+		return a, nil
+	}
+
+	merged := maps.Clone(a)
+	if merged == nil {
+		merged = Attributes{}
+	}
+
+	for key, value := range changes {
+		if value == "" {
+			delete(merged, key)
+			continue
+		}
+		merged[key] = value
+	}
+
+	if len(merged) > maxAttributes {
+		return nil, ErrTooManyAttributes
+	}
+
+	if len(merged) == 0 {
+		return nil, nil
+	}
+
+	return merged, nil
+}
+
+// Keys returns the keys of the attributes, sorted.
+func (a Attributes) Keys() []string {
+	keys := make([]string, 0, len(a))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// String returns the attributes as "key=value" pairs sorted by key and separated by commas.
+func (a Attributes) String() string {
+	var builder strings.Builder
+	for i, key := range a.Keys() {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(key)
+		builder.WriteByte('=')
+		builder.WriteString(a[key])
+	}
+
+	return builder.String()
+}
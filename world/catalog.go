@@ -0,0 +1,458 @@
+package world
+
+import (
+	"errors"
+	"sort"
+)
+
+const (
+	// maxPreDivide keeps the nodes divided up front to a few tens of thousands: each level multiplies them by 4.
+	maxPreDivide = 8
+	// maxIndexDepth is about where halving a degree stops making a difference to float64 coordinates.
+	maxIndexDepth = 48
+)
+
+var (
+	ErrInvalidIndexCapacity  = errors.New("leaf capacity must be a positive number of locations")
+	ErrInvalidIndexPreDivide = errors.New("pre-division depth must be between 0 and 8")
+	ErrInvalidIndexMaxDepth  = errors.New("maximum depth must be between the pre-division depth and 48, 0 for no limit")
+	ErrInvalidIndexExtent    = errors.New("extent must be valid latitudes and longitudes with lat1 < lat2 and lon1 < lon2")
+	ErrLocationOutOfExtent   = errors.New("location is outside of the namespace's extent")
+	ErrNamespaceNotFound     = errors.New("namespace not found")
+	ErrNamespaceExists       = errors.New("namespace already exists")
+
+	// errNamespaceDropped is returned by the changes made to a namespace after it was dropped or renamed.
+	errNamespaceDropped = errors.New("namespace was dropped")
+)
+
+// Extent is the rectangle a namespace's index covers. Locations outside of it cannot be saved.
+type Extent struct {
+	Lat1 float64 `json:"lat1" yaml:"lat1"`
+	Lat2 float64 `json:"lat2" yaml:"lat2"`
+	Lon1 float64 `json:"lon1" yaml:"lon1"`
+	Lon2 float64 `json:"lon2" yaml:"lon2"`
+}
+
+func (e Extent) contains(lat, lon float64) bool {
+	return e.Lat1 <= lat && lat <= e.Lat2 && e.Lon1 <= lon && lon <= e.Lon2
+}
+
+// IndexOptions shape the index of a namespace: its backend (quadtree when empty), how many locations a leaf
+// holds before dividing, how many levels are divided up front, how deep leaves can divide (0 for no limit) and
+// the area covered. A grid is cut in 2^PreDivide by 2^PreDivide cells, and an R-tree's nodes hold up to
+// Capacity entries.
+type IndexOptions struct {
+	Backend   string `json:"backend" yaml:"backend"`
+	Capacity  int    `json:"capacity" yaml:"capacity"`
+	PreDivide int    `json:"predivide" yaml:"predivide"`
+	MaxDepth  int    `json:"maxdepth" yaml:"maxdepth"`
+	Extent    Extent `json:"extent" yaml:"extent"`
+}
+
+// DefaultIndexOptions returns the options of a namespace nobody created: a quadtree of 500 locations per leaf,
+// 5 levels divided up front over the whole globe and at most 32 levels.
+func DefaultIndexOptions() IndexOptions {
+	return IndexOptions{
+		Backend:   IndexQuadtree,
+		Capacity:  500,
+		PreDivide: 5,
+		MaxDepth:  32,
+		Extent:    Extent{Lat1: -90, Lat2: 90, Lon1: -180, Lon2: 180},
+	}
+}
+
+// Validate returns the first problem with the options, if any.
+func (o IndexOptions) Validate() error {
+	switch o.Backend {
+	case "", IndexQuadtree, IndexGrid, IndexRTree:
+	default:
+		return ErrInvalidIndexBackend
+	}
+
+	if o.Capacity <= 0 {
+		return ErrInvalidIndexCapacity
+	}
+
+	if o.PreDivide < 0 || o.PreDivide > maxPreDivide {
+		return ErrInvalidIndexPreDivide
+	}
+
+	if o.MaxDepth < 0 || o.MaxDepth > maxIndexDepth || (o.MaxDepth != 0 && o.MaxDepth < o.PreDivide) {
+		return ErrInvalidIndexMaxDepth
+	}
+
+	for _, corner := range [][2]float64{{o.Extent.Lat1, o.Extent.Lon1}, {o.Extent.Lat2, o.Extent.Lon2}} {
+		if validateLatLon(corner[0], corner[1]) != nil {
+			return ErrInvalidIndexExtent
+		}
+	}
+
+	if o.Extent.Lat1 >= o.Extent.Lat2 || o.Extent.Lon1 >= o.Extent.Lon2 {
+		return ErrInvalidIndexExtent
+	}
+
+	return nil
+}
+
+// normalized returns the options with their backend spelled out.
+func (o IndexOptions) normalized() IndexOptions {
+	if o.Backend == "" {
+		o.Backend = IndexQuadtree
+	}
+
+	return o
+}
+
+// NamespaceOptions is an entry of the namespace catalog.
+type NamespaceOptions struct {
+	Name    string       `json:"name"`
+	Options IndexOptions `json:"options"`
+}
+
+// create adds the namespace to the catalog with its index options, rebuilding its index if they changed. The
+// locations are checked against the new extent, and the creation journaled, before anything changes, so a failed
+// creation leaves the namespace untouched. Readers keep walking the old index until the new one replaces it whole.
+func (n *Namespace) create(options IndexOptions) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.dropped {
+		return errNamespaceDropped
+	}
+
+	if n.created && n.options == options {
+		return nil
+	}
+
+	if n.options != options {
+		for loc := range n.locations.all() {
+			if !options.Extent.contains(loc.lat, loc.lon) {
+				return ErrLocationOutOfExtent
+			}
+		}
+	}
+
+	if n.journal != nil {
+		err := n.journal.Append(Mutation{Op: OpCreateNamespace, Ns: n.Name, Options: options})
+		if err != nil {
+			return err
+		}
+	}
+
+	if n.options != options {
+		index := newSpatialIndex(options)
+		for loc := range n.locations.all() {
+			// The location stays in the old tree's leaf for the readers still walking it.
+			loc.node.Store(nil)
+
+			err := index.Insert(loc)
+			if err != nil {
+				return err
+			}
+		}
+
+		n.options = options
+		n.spatial.Store(&index)
+	}
+
+	n.created = true
+
+	return nil
+}
+
+// Options returns the namespace's index options.
+func (n *Namespace) Options() IndexOptions {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.options
+}
+
+// CreateNamespace adds a namespace to the catalog with its index options. Creating a namespace that exists
+// rebuilds its index with the new options, and creating it again with the same options does nothing, so a
+// replayed or repeated creation is harmless.
+func (m *World) CreateNamespace(ns string, options IndexOptions) error {
+	if ns == "" {
+		return ErrLocationRequiredNamespace
+	}
+
+	err := options.Validate()
+	if err != nil {
+		return err
+	}
+
+	options = options.normalized()
+
+	m.catalog.RLock()
+	defer m.catalog.RUnlock()
+
+	return m.write(ns, func(namespace *Namespace) error {
+		return namespace.create(options)
+	})
+}
+
+// SetDefaultIndexOptions sets the options of the namespaces created from now on without CREATE NAMESPACE.
+func (m *World) SetDefaultIndexOptions(options IndexOptions) error {
+	err := options.Validate()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.defaultOptions = options.normalized()
+	m.mu.Unlock()
+
+	return nil
+}
+
+// DefaultIndexOptions returns the options of the namespaces created without CREATE NAMESPACE.
+func (m *World) DefaultIndexOptions() IndexOptions {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.defaultOptions
+}
+
+// Catalog returns the namespaces created with CREATE NAMESPACE and their options, sorted by name.
+func (m *World) Catalog() []NamespaceOptions {
+	var catalog []NamespaceOptions
+	for _, namespace := range m.allNamespaces() {
+		namespace.mu.RLock()
+		if namespace.created {
+			catalog = append(catalog, NamespaceOptions{Name: namespace.Name, Options: namespace.options})
+		}
+		namespace.mu.RUnlock()
+	}
+
+	sort.Slice(catalog, func(i, j int) bool {
+		return catalog[i].Name < catalog[j].Name
+	})
+
+	return catalog
+}
+
+// NamespaceCount is an entry of the list of namespaces.
+type NamespaceCount struct {
+	Name      string
+	Locations int
+}
+
+// Namespaces returns every namespace of the world, created or not, with its number of locations, sorted by name.
+func (m *World) Namespaces() []NamespaceCount {
+	var namespaces []NamespaceCount
+	for _, namespace := range m.allNamespaces() {
+		namespace.mu.RLock()
+		namespaces = append(namespaces, NamespaceCount{Name: namespace.Name, Locations: namespace.locations.len()})
+		namespace.mu.RUnlock()
+	}
+
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+
+	return namespaces
+}
+
+// SetStrict makes reads and subscriptions against a namespace the world does not have fail with
+// ErrNamespaceNotFound. Reads never create namespaces; without strict mode they find nothing.
+func (m *World) SetStrict(strict bool) {
+	m.strict.Store(strict)
+}
+
+// CheckNamespace returns ErrNamespaceNotFound if the world is strict and does not have the namespace.
+func (m *World) CheckNamespace(ns string) error {
+	if !m.strict.Load() {
+		return nil
+	}
+
+	if _, ok := m.lookupNamespace(ns); !ok {
+		return ErrNamespaceNotFound
+	}
+
+	return nil
+}
+
+// DropNamespace removes a namespace with its locations, settings and fences, and closes its subscriptions. The
+// next write to its name starts a new namespace with the default options.
+func (m *World) DropNamespace(ns string) error {
+	m.catalog.RLock()
+	defer m.catalog.RUnlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return ErrNamespaceNotFound
+	}
+
+	namespace.mu.Lock()
+	defer namespace.mu.Unlock()
+
+	err := namespace.drop(Mutation{Op: OpDropNamespace, Ns: ns})
+	if err != nil {
+		return err
+	}
+
+	m.namespaces.Delete(ns)
+
+	return nil
+}
+
+// RenameNamespace moves a namespace to a name no other namespace has. Its locations are copied under the new name,
+// so the ones already handed to readers keep the old one, and its subscriptions are closed like a drop's.
+func (m *World) RenameNamespace(ns, to string) error {
+	if to == "" {
+		return ErrLocationRequiredNamespace
+	}
+
+	m.catalog.RLock()
+	defer m.catalog.RUnlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return ErrNamespaceNotFound
+	}
+
+	if _, ok := m.lookupNamespace(to); ok {
+		return ErrNamespaceExists
+	}
+
+	// The namespace stays locked until it is replaced, so no write lands in it after it was copied.
+	namespace.mu.Lock()
+	defer namespace.mu.Unlock()
+
+	renamed, err := namespace.copyAs(to)
+	if err != nil {
+		return err
+	}
+
+	// The new name is only taken once the rename is journaled: the writes to it wait on the world's lock until
+	// then, so none of them is logged ahead of the rename.
+	err = namespace.drop(Mutation{Op: OpRenameNamespace, Ns: ns, Id: to})
+	if err != nil {
+		return err
+	}
+
+	m.namespaces.Store(to, renamed)
+	m.namespaces.Delete(ns)
+
+	return nil
+}
+
+// FreezeCatalog runs freeze while no namespace is created, dropped or renamed; those wait for it to return. The
+// other writes carry on.
+func (m *World) FreezeCatalog(freeze func() error) error {
+	m.catalog.Lock()
+	defer m.catalog.Unlock()
+
+	return freeze()
+}
+
+// drop journals the mutation that drops the namespace, then marks it dropped and closes its subscriptions. A
+// namespace the journal could not take is left as it was. Call it under n.mu, and take the namespace out of the
+// world before letting go of n.mu, so the writers retrying find the one replacing it.
+func (n *Namespace) drop(mutation Mutation) error {
+	if n.journal != nil {
+		err := n.journal.Append(mutation)
+		if err != nil {
+			return err
+		}
+	}
+
+	n.dropped = true
+
+	if n.subscriptions != nil {
+		for _, subscription := range n.subscriptions.all(nil) {
+			subscription.stop()
+		}
+	}
+
+	return nil
+}
+
+// copyAs returns a copy of the namespace under another name, with copies of its locations. Call it under n.mu.
+func (n *Namespace) copyAs(name string) (*Namespace, error) {
+	namespace := newNamespaceWithOptions(name, n.options)
+	namespace.created = n.created
+	namespace.journal = n.journal
+	namespace.fenceHub = n.fenceHub
+	namespace.defaultTTL = n.defaultTTL
+	namespace.historySize = n.historySize
+	namespace.historyAge = n.historyAge
+
+	if n.fences != nil {
+		namespace.fences = make(map[string]*Fence, len(n.fences))
+		namespace.fenceIndex = newRegionTree[*Fence]()
+		for id, fence := range n.fences {
+			namespace.fences[id] = fence
+			namespace.fenceIndex.insert(fence.Polygon.bounds, fence)
+		}
+	}
+
+	for loc := range n.locations.all() {
+		copied := &Location{
+			id:         loc.id,
+			lat:        loc.lat,
+			lon:        loc.lon,
+			ns:         name,
+			updatedAt:  loc.updatedAt,
+			attributes: loc.attributes,
+			trail:      loc.trail.clone(),
+		}
+
+		if loc.fences != nil {
+			copied.fences = make(map[string]*fenceVisit, len(loc.fences))
+			for fence, visit := range loc.fences {
+				stay := *visit
+				copied.fences[fence] = &stay
+			}
+		}
+
+		err := namespace.index().Insert(copied)
+		if err != nil {
+			return nil, err
+		}
+
+		namespace.locations.put(copied)
+		namespace.setExpiry(copied, loc.expiresAt)
+	}
+
+	return namespace, nil
+}
+
+// Stats returns the namespace's statistics.
+func (n *Namespace) Stats() Stats {
+	n.mu.RLock()
+	stats := Stats{
+		Locations:   n.locations.len(),
+		Expiring:    len(n.expiries),
+		Fences:      len(n.fences),
+		Options:     n.options,
+		Created:     n.created,
+		DefaultTTL:  n.defaultTTL,
+		HistorySize: n.historySize,
+		HistoryAge:  n.historyAge,
+	}
+	if n.subscriptions != nil {
+		stats.Subscriptions = len(n.subscriptions.all(nil))
+	}
+	n.mu.RUnlock()
+
+	n.index().stats(&stats)
+
+	return stats
+}
+
+// Stats returns the statistics of a namespace, or ErrNamespaceNotFound if the world does not have it.
+func (m *World) Stats(ns string) (Stats, error) {
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return Stats{}, ErrNamespaceNotFound
+	}
+
+	return namespace.Stats(), nil
+}
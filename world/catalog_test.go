@@ -0,0 +1,395 @@
+package world
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func cityOptions() IndexOptions {
+	return IndexOptions{
+		Backend:   IndexQuadtree,
+		Capacity:  4,
+		PreDivide: 1,
+		MaxDepth:  6,
+		Extent:    Extent{Lat1: 48.8, Lat2: 48.9, Lon1: 2.2, Lon2: 2.5},
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should shape the namespace's tree with its options", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.CreateNamespace("paris", cityOptions()))
+
+		root := world.getNamespace("paris").index().(*QuadTree).Root
+		assert.Equal(t, 48.8, root.Lat1)
+		assert.Equal(t, 2.5, root.Lon2)
+		assert.True(t, root.IsDivided)
+		assert.False(t, root.NE.IsDivided)
+		assert.Equal(t, 4, root.NE.Capacity)
+
+		assert.ErrorIs(t, world.Save("paris", "a", 40, 2.3), ErrLocationOutOfExtent)
+		_, ok := world.GetLocation("paris", "a")
+		assert.False(t, ok)
+
+		assert.NoError(t, world.Save("paris", "a", 48.85, 2.35))
+		assert.Len(t, world.QueryRange("paris", 48.8, 48.9, 2.2, 2.5), 1)
+	})
+
+	t.Run("should stop dividing at the maximum depth", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.CreateNamespace("paris", cityOptions()))
+
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, world.Save("paris", strconv.Itoa(i), 48.85, 2.35))
+		}
+
+		loc, _ := world.getNamespace("paris").locations.get("0")
+		assert.Equal(t, 6, loc.Node().depth)
+		assert.Len(t, loc.Node().Objects, 20)
+		assert.Len(t, world.QueryRange("paris", 48.8, 48.9, 2.2, 2.5), 20)
+	})
+
+	t.Run("should rebuild an existing namespace with new options", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.Save("paris", "a", 48.85, 2.35))
+		assert.NoError(t, world.Save("paris", "b", 10, 10))
+		assert.Empty(t, world.Catalog())
+
+		assert.ErrorIs(t, world.CreateNamespace("paris", cityOptions()), ErrLocationOutOfExtent)
+		assert.Equal(t, DefaultIndexOptions(), world.getNamespace("paris").Options())
+		assert.Len(t, world.QueryRange("paris", -90, 90, -180, 180), 2)
+
+		assert.NoError(t, world.Delete("paris", "b"))
+		assert.NoError(t, world.CreateNamespace("paris", cityOptions()))
+		assert.Equal(t, cityOptions(), world.getNamespace("paris").Options())
+		assert.Len(t, world.QueryRange("paris", 48.8, 48.9, 2.2, 2.5), 1)
+
+		assert.NoError(t, world.Delete("paris", "a"))
+		assert.Empty(t, world.QueryRange("paris", 48.8, 48.9, 2.2, 2.5))
+	})
+
+	t.Run("should only journal creations that change something", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		journal := &recordingJournal{}
+		world.SetJournal(journal)
+
+		assert.NoError(t, world.CreateNamespace("paris", cityOptions()))
+		assert.NoError(t, world.CreateNamespace("paris", cityOptions()))
+		assert.NoError(t, world.CreateNamespace("default", DefaultIndexOptions()))
+
+		assert.Equal(t, []Mutation{
+			{Op: OpCreateNamespace, Ns: "paris", Options: cityOptions()},
+			{Op: OpCreateNamespace, Ns: "default", Options: DefaultIndexOptions()},
+		}, journal.mutations)
+	})
+
+	t.Run("should leave the namespace as it was when the creation cannot be journaled", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.Save("paris", "a", 48.85, 2.35))
+
+		failure := errors.New("disk full")
+		world.SetJournal(&recordingJournal{err: failure})
+
+		assert.ErrorIs(t, world.CreateNamespace("paris", cityOptions()), failure)
+		assert.Empty(t, world.Catalog())
+
+		stats, err := world.Stats("paris")
+		assert.NoError(t, err)
+		assert.Equal(t, world.DefaultIndexOptions(), stats.Options)
+	})
+
+	t.Run("should list the created namespaces sorted by name", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.CreateNamespace("paris", cityOptions()))
+		assert.NoError(t, world.CreateNamespace("lyon", DefaultIndexOptions()))
+		assert.NoError(t, world.Save("implicit", "a", 1, 1))
+
+		assert.Equal(t, []NamespaceOptions{
+			{Name: "lyon", Options: DefaultIndexOptions()},
+			{Name: "paris", Options: cityOptions()},
+		}, world.Catalog())
+	})
+
+	t.Run("should give the default options to the namespaces created by their first use", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.SetDefaultIndexOptions(cityOptions()))
+
+		assert.ErrorIs(t, world.Save("paris", "a", 10, 10), ErrLocationOutOfExtent)
+		assert.Equal(t, cityOptions(), world.getNamespace("paris").Options())
+		assert.Empty(t, world.Catalog())
+	})
+
+	t.Run("should reject invalid options", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		invalid := map[error]func(options *IndexOptions){
+			ErrInvalidIndexCapacity:  func(options *IndexOptions) { options.Capacity = 0 },
+			ErrInvalidIndexPreDivide: func(options *IndexOptions) { options.PreDivide = 9 },
+			ErrInvalidIndexMaxDepth:  func(options *IndexOptions) { options.MaxDepth = 3; options.PreDivide = 4 },
+			ErrInvalidIndexExtent:    func(options *IndexOptions) { options.Extent.Lat1 = 10; options.Extent.Lat2 = 10 },
+		}
+
+		for expected, change := range invalid {
+			options := DefaultIndexOptions()
+			change(&options)
+			assert.ErrorIs(t, world.CreateNamespace("ns", options), expected)
+			assert.ErrorIs(t, world.SetDefaultIndexOptions(options), expected)
+		}
+
+		unlimited := DefaultIndexOptions()
+		unlimited.MaxDepth = 0
+		assert.NoError(t, unlimited.Validate())
+
+		assert.ErrorIs(t, world.CreateNamespace("", DefaultIndexOptions()), ErrLocationRequiredNamespace)
+		assert.Empty(t, world.Catalog())
+	})
+}
+
+// visibilityJournal records if the new name of each rename it takes is already in the world.
+type visibilityJournal struct {
+	world   *World
+	visible []bool
+}
+
+func (j *visibilityJournal) Append(mutation Mutation) error {
+	if mutation.Op == OpRenameNamespace {
+		_, ok := j.world.lookupNamespace(mutation.Id)
+		j.visible = append(j.visible, ok)
+	}
+
+	return nil
+}
+
+func TestNamespaceManagement(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should list the namespaces with their number of locations", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.Save("b", "1", 1, 1))
+		assert.NoError(t, world.Save("b", "2", 1, 1))
+		assert.NoError(t, world.CreateNamespace("a", cityOptions()))
+
+		assert.Equal(t, []NamespaceCount{{Name: "a", Locations: 0}, {Name: "b", Locations: 2}}, world.Namespaces())
+	})
+
+	t.Run("should not create namespaces on reads", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		_, ok := world.GetLocation("typo", "a")
+		assert.False(t, ok)
+		assert.Empty(t, world.QueryRange("typo", -90, 90, -180, 180))
+		assert.Empty(t, world.Fences("typo"))
+		neighbors, err := world.Nearest("typo", 0, 0, 1, 0)
+		assert.NoError(t, err)
+		assert.Empty(t, neighbors)
+		_, err = world.History("typo", "a", time.Time{}, time.Time{})
+		assert.ErrorIs(t, err, ErrHistoryDisabled)
+		assert.NoError(t, world.Delete("typo", "a"))
+		assert.NoError(t, world.DeleteFence("typo", "a"))
+
+		assert.Empty(t, world.Namespaces())
+		assert.NoError(t, world.CheckNamespace("typo"))
+	})
+
+	t.Run("should refuse unknown namespaces when strict", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		world.SetStrict(true)
+		assert.NoError(t, world.Save("ns", "a", 1, 1))
+
+		assert.NoError(t, world.CheckNamespace("ns"))
+		assert.ErrorIs(t, world.CheckNamespace("typo"), ErrNamespaceNotFound)
+
+		_, err := world.Subscribe("typo", -90, 90, -180, 180, 1, DropEvents)
+		assert.ErrorIs(t, err, ErrNamespaceNotFound)
+		_, err = world.WatchFences("typo", 1, DropEvents)
+		assert.ErrorIs(t, err, ErrNamespaceNotFound)
+
+		subscription, err := world.WatchFences("", 1, DropEvents)
+		assert.NoError(t, err)
+		subscription.Close()
+
+		assert.Equal(t, []NamespaceCount{{Name: "ns", Locations: 1}}, world.Namespaces())
+	})
+
+	t.Run("should drop a namespace and close its subscriptions", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.CreateNamespace("paris", cityOptions()))
+		assert.NoError(t, world.SaveWithTTL("paris", "a", 48.85, 2.35, time.Minute))
+		subscription, err := world.Subscribe("paris", 48.8, 48.9, 2.2, 2.5, 1, DropEvents)
+		assert.NoError(t, err)
+		dropped := world.getNamespace("paris")
+
+		journal := &recordingJournal{}
+		world.SetJournal(journal)
+
+		assert.NoError(t, world.DropNamespace("paris"))
+		assert.ErrorIs(t, world.DropNamespace("paris"), ErrNamespaceNotFound)
+
+		_, open := <-subscription.Events()
+		assert.False(t, open)
+		assert.Empty(t, world.Namespaces())
+		assert.Empty(t, world.Catalog())
+		assert.Equal(t, 0, world.Expire(time.Now().Add(time.Hour), nil))
+		assert.Equal(t, []Mutation{{Op: OpDropNamespace, Ns: "paris"}}, journal.mutations)
+
+		_, err = dropped.SaveLocation("b", 48.85, 2.35)
+		assert.ErrorIs(t, err, errNamespaceDropped)
+
+		assert.NoError(t, world.Save("paris", "b", 10, 10))
+		assert.Equal(t, DefaultIndexOptions(), world.getNamespace("paris").Options())
+		_, ok := world.GetLocation("paris", "a")
+		assert.False(t, ok)
+	})
+
+	t.Run("should rename a namespace with everything in it", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.CreateNamespace("paris", cityOptions()))
+		assert.NoError(t, world.SetHistory("paris", 5, 0))
+		assert.NoError(t, world.SetDefaultTTL("paris", time.Hour))
+		polygon, err := ParsePolygon("POLYGON((2.2 48.8, 2.5 48.8, 2.5 48.9, 2.2 48.9, 2.2 48.8))")
+		assert.NoError(t, err)
+		assert.NoError(t, world.AddFence("paris", "city", polygon, 0))
+		assert.NoError(t, world.SaveWithAttributes("paris", "a", 48.85, 2.35, 0, Attributes{"status": "free"}))
+		assert.NoError(t, world.Save("paris", "a", 48.86, 2.36))
+		assert.NoError(t, world.Save("other", "a", 1, 1))
+
+		journal := &recordingJournal{}
+		world.SetJournal(journal)
+
+		assert.ErrorIs(t, world.RenameNamespace("paris", "other"), ErrNamespaceExists)
+		assert.ErrorIs(t, world.RenameNamespace("lyon", "marseille"), ErrNamespaceNotFound)
+		assert.NoError(t, world.RenameNamespace("paris", "capital"))
+		assert.Equal(t, []Mutation{{Op: OpRenameNamespace, Ns: "paris", Id: "capital"}}, journal.mutations)
+
+		_, ok := world.GetLocation("paris", "a")
+		assert.False(t, ok)
+
+		loc, ok := world.GetLocation("capital", "a")
+		assert.True(t, ok)
+		assert.Equal(t, "capital", loc.ns)
+		assert.Equal(t, Attributes{"status": "free"}, loc.Attributes())
+		assert.Len(t, world.QueryRange("capital", 48.8, 48.9, 2.2, 2.5), 1)
+
+		history, err := world.History("capital", "a", time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		assert.Len(t, history, 2)
+
+		assert.Equal(t, []NamespaceOptions{{Name: "capital", Options: cityOptions()}}, world.Catalog())
+		assert.Equal(t, 1, world.Expire(time.Now().Add(2*time.Hour), nil))
+
+		fences, err := world.WatchFences("capital", 1, DropEvents)
+		assert.NoError(t, err)
+		defer fences.Close()
+		assert.NoError(t, world.Delete("capital", "b"))
+		assert.NoError(t, world.Save("capital", "b", 48.85, 2.35))
+		event := <-fences.Events()
+		assert.Equal(t, EventEnter, event.Kind)
+		assert.Equal(t, "capital", event.Ns)
+	})
+
+	t.Run("should describe a namespace", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.CreateNamespace("paris", cityOptions()))
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, world.Save("paris", strconv.Itoa(i), 48.81, 2.21))
+		}
+		assert.NoError(t, world.SaveWithTTL("paris", "later", 48.85, 2.35, time.Minute))
+
+		stats, err := world.Stats("paris")
+		assert.NoError(t, err)
+		assert.Equal(t, 6, stats.Locations)
+		assert.Equal(t, 1, stats.Expiring)
+		// The 5 identical locations divide their corner down to the maximum depth, 4 nodes per level.
+		assert.Equal(t, 1+4*6, stats.Grids)
+		assert.Equal(t, 1+3*6, stats.Leaves)
+		assert.Equal(t, 6, stats.Depth)
+		assert.True(t, stats.Created)
+		assert.Equal(t, cityOptions(), stats.Options)
+
+		_, err = world.Stats("typo")
+		assert.ErrorIs(t, err, ErrNamespaceNotFound)
+	})
+
+	t.Run("should refuse to replay the drops and renames that cannot apply", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.Save("a", "1", 1, 1))
+		assert.NoError(t, world.Save("b", "2", 2, 2))
+
+		assert.ErrorIs(t, world.Apply(Mutation{Op: OpRenameNamespace, Ns: "a", Id: "b"}), ErrNamespaceExists)
+		assert.ErrorIs(t, world.Apply(Mutation{Op: OpRenameNamespace, Ns: "c", Id: "d"}), ErrNamespaceNotFound)
+		assert.ErrorIs(t, world.Apply(Mutation{Op: OpDropNamespace, Ns: "c"}), ErrNamespaceNotFound)
+		assert.Equal(t, []NamespaceCount{{Name: "a", Locations: 1}, {Name: "b", Locations: 1}}, world.Namespaces())
+
+		assert.NoError(t, world.Apply(Mutation{Op: OpRenameNamespace, Ns: "a", Id: "c"}))
+		assert.NoError(t, world.Apply(Mutation{Op: OpDropNamespace, Ns: "b"}))
+		assert.Equal(t, []NamespaceCount{{Name: "c", Locations: 1}}, world.Namespaces())
+	})
+
+	t.Run("should journal drops and renames before applying them", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.Save("a", "1", 1, 1))
+		assert.NoError(t, world.Save("b", "2", 2, 2))
+
+		failure := errors.New("disk full")
+		world.SetJournal(&recordingJournal{err: failure})
+
+		assert.ErrorIs(t, world.RenameNamespace("a", "c"), failure)
+		assert.ErrorIs(t, world.DropNamespace("b"), failure)
+		assert.Equal(t, []NamespaceCount{{Name: "a", Locations: 1}, {Name: "b", Locations: 1}}, world.Namespaces())
+
+		journal := &visibilityJournal{world: world}
+		world.SetJournal(journal)
+		assert.NoError(t, world.Save("a", "3", 3, 3))
+
+		assert.NoError(t, world.RenameNamespace("a", "c"))
+		assert.Equal(t, []bool{false}, journal.visible)
+	})
+
+	t.Run("should hold the catalog changes while it is frozen", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.Save("a", "1", 1, 1))
+
+		renamed := make(chan error, 1)
+		assert.NoError(t, world.FreezeCatalog(func() error {
+			go func() {
+				renamed <- world.RenameNamespace("a", "b")
+			}()
+
+			select {
+			case <-renamed:
+				t.Error("expected the rename to wait for the catalog")
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			assert.NoError(t, world.Save("a", "2", 2, 2))
+
+			return nil
+		}))
+
+		assert.NoError(t, <-renamed)
+		assert.Equal(t, []NamespaceCount{{Name: "b", Locations: 2}}, world.Namespaces())
+	})
+}
@@ -0,0 +1,181 @@
+package world
+
+import (
+	"errors"
+	"math"
+)
+
+// maxHeatmapCells bounds the cells of a heatmap, which are all counted and answered at once.
+const maxHeatmapCells = 10000
+
+var (
+	ErrInvalidCellSize = errors.New("cell size must be a positive number of degrees")
+	ErrTooManyCells    = errors.New("too many cells, use a larger cell size or a smaller area")
+	ErrInvalidRange    = errors.New("latitude1 must not be greater than latitude2")
+)
+
+// CellCount is a cell of a heatmap with the number of locations it holds. The cell holds its south and west
+// edges; the north and east edges belong to the next cells, except on the last row and column. A cell across the
+// antimeridian has Lon1 > Lon2.
+type CellCount struct {
+	Lat1, Lat2 float64
+	Lon1, Lon2 float64
+	Count      int
+}
+
+// Count returns how many locations within the range match the filter. Without a filter, the index takes the
+// parts the range covers whole by their count, without visiting their locations.
+func (n *Namespace) Count(lat1, lat2, lon1, lon2 float64, filter Filter) int {
+	count := 0
+
+	if filter == nil {
+		for _, b := range splitRange(lat1, lat2, lon1, lon2) {
+			count += n.index().Count(b.lat1, b.lat2, b.lon1, b.lon2)
+		}
+
+		return count
+	}
+
+	n.WalkRange(lat1, lat2, lon1, lon2, filter, func(*Location) bool {
+		count++
+		return true
+	})
+
+	return count
+}
+
+// CountPolygon is Count for the locations inside the polygon.
+func (n *Namespace) CountPolygon(polygon *Polygon, filter Filter) int {
+	if filter == nil {
+		return n.index().CountPolygon(polygon)
+	}
+
+	count := 0
+	n.WalkPolygon(polygon, filter, func(*Location) bool {
+		count++
+		return true
+	})
+
+	return count
+}
+
+// Heatmap cuts the range into cells of cellSize degrees, from its south-west corner, and counts the locations
+// matching the filter in each of them. The cells on the north and east edges are cut short by the range. Only the
+// cells holding locations are returned, row by row from the south.
+func (n *Namespace) Heatmap(lat1, lat2, lon1, lon2, cellSize float64, filter Filter) ([]CellCount, error) {
+	rows, columns, err := heatmapSize(lat1, lat2, lon1, lon2, cellSize)
+	if err != nil {
+		return nil, err
+	}
+
+	width := lon2 - lon1
+	if lon1 > lon2 {
+		width += 360
+	}
+
+	var cells []CellCount
+	for row := 0; row < rows; row++ {
+		cellLat1 := lat1 + float64(row)*cellSize
+		cellLat2, northLat := lat2, lat2
+		if row < rows-1 {
+			cellLat2 = lat1 + float64(row+1)*cellSize
+			northLat = math.Nextafter(cellLat2, math.Inf(-1))
+		}
+
+		for column := 0; column < columns; column++ {
+			// The longitudes go on past 180 across the antimeridian, and are brought back once the cell is cut.
+			cellLon1 := lon1 + float64(column)*cellSize
+			cellLon2, eastLon := lon1+width, lon1+width
+			if column < columns-1 {
+				cellLon2 = lon1 + float64(column+1)*cellSize
+				eastLon = math.Nextafter(cellLon2, math.Inf(-1))
+			}
+
+			count := n.Count(cellLat1, northLat, wrapLongitude(cellLon1), wrapLongitude(eastLon), filter)
+			if count > 0 {
+				cells = append(cells, CellCount{
+					Lat1: cellLat1, Lat2: cellLat2,
+					Lon1: wrapLongitude(cellLon1), Lon2: wrapLongitude(cellLon2),
+					Count: count,
+				})
+			}
+		}
+	}
+
+	return cells, nil
+}
+
+// heatmapSize returns the rows and columns of cellSize degrees the range is cut into.
+func heatmapSize(lat1, lat2, lon1, lon2, cellSize float64) (int, int, error) {
+	err := validateLatLon(lat1, lon1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = validateLatLon(lat2, lon2)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if lat1 > lat2 {
+		return 0, 0, ErrInvalidRange
+	}
+
+	if !(cellSize > 0) || math.IsInf(cellSize, 1) {
+		return 0, 0, ErrInvalidCellSize
+	}
+
+	width := lon2 - lon1
+	if lon1 > lon2 {
+		width += 360
+	}
+
+	rows := math.Max(1, math.Ceil((lat2-lat1)/cellSize))
+	columns := math.Max(1, math.Ceil(width/cellSize))
+	if rows*columns > maxHeatmapCells {
+		return 0, 0, ErrTooManyCells
+	}
+
+	return int(rows), int(columns), nil
+}
+
+// wrapLongitude brings a longitude past 180 back into [-180, 180]. 180 itself is kept.
+func wrapLongitude(lon float64) float64 {
+	if lon > 180 {
+		return lon - 360
+	}
+
+	return lon
+}
+
+// Count returns how many locations of the namespace within the range match the filter. See Namespace.Count.
+func (m *World) Count(ns string, lat1, lat2, lon1, lon2 float64, filter Filter) int {
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return 0
+	}
+
+	return namespace.Count(lat1, lat2, lon1, lon2, filter)
+}
+
+// CountPolygon returns how many locations of the namespace inside the polygon match the filter.
+func (m *World) CountPolygon(ns string, polygon *Polygon, filter Filter) int {
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return 0
+	}
+
+	return namespace.CountPolygon(polygon, filter)
+}
+
+// Heatmap counts the locations of the namespace matching the filter in cells of cellSize degrees over the range.
+// See Namespace.Heatmap.
+func (m *World) Heatmap(ns string, lat1, lat2, lon1, lon2, cellSize float64, filter Filter) ([]CellCount, error) {
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		_, _, err := heatmapSize(lat1, lat2, lon1, lon2, cellSize)
+		return nil, err
+	}
+
+	return namespace.Heatmap(lat1, lat2, lon1, lon2, cellSize, filter)
+}
@@ -0,0 +1,103 @@
+package world
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCount(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should count the locations matching the filter", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.SaveWithAttributes("ns", "car", 1, 1, 0, Attributes{"type": "car"}))
+		assert.NoError(t, world.SaveWithAttributes("ns", "van", 2, 2, 0, Attributes{"type": "van"}))
+		assert.NoError(t, world.SaveWithAttributes("ns", "far", 50, 50, 0, Attributes{"type": "car"}))
+
+		filter, err := ParseFilter([]string{"type=car"})
+		assert.NoError(t, err)
+
+		assert.Equal(t, 2, world.Count("ns", 0, 10, 0, 10, nil))
+		assert.Equal(t, 1, world.Count("ns", 0, 10, 0, 10, filter))
+		assert.Equal(t, 0, world.Count("unknown", 0, 10, 0, 10, nil))
+	})
+
+	t.Run("should count across the antimeridian", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.Save("ns", "fiji", -17.7, 178.1))
+		assert.NoError(t, world.Save("ns", "samoa", -13.8, -171.8))
+		assert.NoError(t, world.Save("ns", "sydney", -33.9, 151.2))
+
+		assert.Equal(t, 2, world.Count("ns", -20, -10, 170, -170, nil))
+	})
+
+	t.Run("should keep the counts through divisions and merges", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.CreateNamespace("ns", IndexOptions{Capacity: 2, MaxDepth: 10, Extent: DefaultIndexOptions().Extent}))
+
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, world.Save("ns", string(rune('a'+i)), float64(i), float64(i)))
+		}
+		assert.Equal(t, 20, world.Count("ns", -90, 90, -180, 180, nil))
+
+		for i := 0; i < 15; i++ {
+			assert.NoError(t, world.Delete("ns", string(rune('a'+i))))
+		}
+		assert.Equal(t, 5, world.Count("ns", -90, 90, -180, 180, nil))
+		assert.Equal(t, 4, world.Count("ns", 15.5, 90, 15.5, 180, nil))
+		assert.NoError(t, world.Verify())
+	})
+}
+
+func TestHeatmap(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should count each location in one cell", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.Save("ns", "corner", 0, 0))
+		assert.NoError(t, world.Save("ns", "edge", 10, 5))
+		assert.NoError(t, world.Save("ns", "inside", 12, 12))
+		assert.NoError(t, world.Save("ns", "north-east", 20, 20))
+
+		cells, err := world.Heatmap("ns", 0, 20, 0, 20, 10, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []CellCount{
+			{Lat1: 0, Lat2: 10, Lon1: 0, Lon2: 10, Count: 1},
+			{Lat1: 10, Lat2: 20, Lon1: 0, Lon2: 10, Count: 1},
+			{Lat1: 10, Lat2: 20, Lon1: 10, Lon2: 20, Count: 2},
+		}, cells)
+	})
+
+	t.Run("should cut the cells across the antimeridian", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.Save("ns", "fiji", -17.7, 178.1))
+		assert.NoError(t, world.Save("ns", "samoa", -13.8, -171.8))
+
+		cells, err := world.Heatmap("ns", -20, -10, 175, -165, 10, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []CellCount{
+			{Lat1: -20, Lat2: -10, Lon1: 175, Lon2: -175, Count: 1},
+			{Lat1: -20, Lat2: -10, Lon1: -175, Lon2: -165, Count: 1},
+		}, cells)
+	})
+
+	t.Run("should refuse bad cell sizes and too many cells", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		_, err := world.Heatmap("ns", 0, 10, 0, 10, 0, nil)
+		assert.ErrorIs(t, err, ErrInvalidCellSize)
+
+		_, err = world.Heatmap("ns", -90, 90, -180, 180, 0.1, nil)
+		assert.ErrorIs(t, err, ErrTooManyCells)
+
+		_, err = world.Heatmap("ns", 10, 0, 0, 10, 1, nil)
+		assert.ErrorIs(t, err, ErrInvalidRange)
+	})
+}
@@ -2,7 +2,6 @@ package world
 
 import (
 	"bytes"
-	"encoding/gob"
 	"sync"
 	"testing"
 
@@ -66,27 +65,18 @@ func TestWorldFromBytesInvalidPayload(t *testing.T) {
 }
 
 func TestWorldFromBytesSaveError(t *testing.T) {
-	type serialLocation struct {
-		ID  string
-		Lat float64
-		Lon float64
-	}
-
-	type serialNamespace struct {
-		Name      string
-		Locations []serialLocation
-	}
-
-	type serialWorld struct {
-		Namespaces []serialNamespace
-	}
-
-	invalid := serialWorld{Namespaces: []serialNamespace{{Name: "ns", Locations: []serialLocation{{ID: "bad", Lat: 200, Lon: 0}}}}}
-
 	var buf bytes.Buffer
-	encoder := gob.NewEncoder(&buf)
-	err := encoder.Encode(invalid)
-	assert.NoError(t, err)
+	enc := newSnapshotEncoder(&buf)
+	enc.writeBytes([]byte(snapshotMagic))
+	enc.writeUint16(1)
+	enc.writeByte(snapshotNamespaceMarker)
+	enc.writeString("ns")
+	enc.writeUvarint(1)
+	enc.writeString("bad")
+	enc.writeFloat64(200)
+	enc.writeFloat64(0)
+	enc.writeByte(snapshotEndMarker)
+	assert.NoError(t, enc.finish())
 
 	assert.Panics(t, func() {
 		_ = NewWorldFromBytes(buf.Bytes())
@@ -94,7 +84,8 @@ func TestWorldFromBytesSaveError(t *testing.T) {
 }
 
 func TestWorldNamespacePanics(t *testing.T) {
-	world := &World{namespaces: map[string]*Namespace{"panicNS": nil}, mu: sync.RWMutex{}}
+	world := &World{mu: sync.RWMutex{}}
+	world.namespaces.Store("panicNS", (*Namespace)(nil))
 
 	assert.PanicsWithValue(t, ErrUnexpectedNilNamespace, func() {
 		world.Save("panicNS", "id", 0, 0)
@@ -130,10 +121,15 @@ func TestNamespaceUpdateError(t *testing.T) {
 }
 
 func TestNamespaceInsertOutOfBounds(t *testing.T) {
-	ns := &Namespace{Name: "ns", locations: map[string]*Location{}, tree: NewQuadTree(0, 1, 0, 1)}
+	options := DefaultIndexOptions()
+	options.Extent = Extent{Lat1: 0, Lat2: 1, Lon1: 0, Lon2: 1}
+	ns := newNamespaceWithOptions("ns", options)
 
 	_, err := ns.SaveLocation("id", 2, 2)
-	assert.ErrorIs(t, err, ErrTreeLocationOutOfBounds)
+	assert.ErrorIs(t, err, ErrLocationOutOfExtent)
+
+	_, ok := ns.GetLocation("id")
+	assert.False(t, ok)
 }
 
 func TestMergeErrorPath(t *testing.T) {
@@ -141,7 +137,9 @@ func TestMergeErrorPath(t *testing.T) {
 	badWorld := NewWorld()
 
 	// Inject an invalid location directly to bypass validation and force a merge failure.
-	badWorld.namespaces["ns"] = &Namespace{locations: map[string]*Location{"bad": {id: "bad", lat: 200, lon: 0, ns: "ns"}}, tree: NewQuadTree(-90, 90, -180, 180)}
+	namespace := &Namespace{Name: "ns"}
+	namespace.locations.put(&Location{id: "bad", lat: 200, lon: 0, ns: "ns"})
+	badWorld.namespaces.Store("ns", namespace)
 
 	assert.Panics(t, func() {
 		world1.Merge(badWorld)
@@ -184,7 +182,7 @@ func TestTreeDivideFallbacks(t *testing.T) {
 	assert.NoError(t, node.insert(locSE))
 
 	assert.True(t, node.IsDivided)
-	assert.Equal(t, node.SE, locSE.Node)
+	assert.Equal(t, node.SE, locSE.Node())
 }
 
 func TestTreeInsertRelocatesExistingNode(t *testing.T) {
@@ -198,8 +196,8 @@ func TestTreeInsertRelocatesExistingNode(t *testing.T) {
 	assert.NoError(t, err)
 
 	assert.NoError(t, tree.Insert(loc))
-	assert.NotNil(t, loc.Node)
-	assert.True(t, loc.Node.Lat1 >= 0)
+	assert.NotNil(t, loc.Node())
+	assert.True(t, loc.Node().Lat1 >= 0)
 }
 
 func TestTreeDivideWithNilLocationPanics(t *testing.T) {
@@ -7,4 +7,5 @@ import (
 var (
 	ErrTreeLocationNil         = errors.New("insertion failed because location is nil")
 	ErrTreeLocationOutOfBounds = errors.New("insertion failed because location is out of bounds")
+	ErrUnknownOperation        = errors.New("unknown mutation operation")
 )
@@ -0,0 +1,192 @@
+package world
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ErrInvalidTTL = errors.New("ttl must be a positive duration")
+
+	expiredLocations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_world_expired_locations",
+		Help: "Total locations removed because their time-to-live ran out",
+	})
+)
+
+// expiryHeap orders a namespace's expiring locations, soonest first. A location is in it exactly when its
+// expiresAt is set, and its expiryIndex is its position, so updates and deletes reach it without a search.
+type expiryHeap []*Location
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].expiryIndex = i
+	h[j].expiryIndex = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	loc := x.(*Location)
+	loc.expiryIndex = len(*h)
+	*h = append(*h, loc)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+
+	return last
+}
+
+// setExpiry schedules the location to expire at the given time, or never if it is zero. Call it under n.mu and
+// the write lock of the location's shard.
+func (n *Namespace) setExpiry(loc *Location, expiresAt time.Time) {
+	scheduled := !loc.expiresAt.IsZero()
+	loc.expiresAt = expiresAt
+
+	switch {
+	case scheduled && expiresAt.IsZero():
+		heap.Remove(&n.expiries, loc.expiryIndex)
+	case scheduled:
+		heap.Fix(&n.expiries, loc.expiryIndex)
+	case !expiresAt.IsZero():
+		heap.Push(&n.expiries, loc)
+	}
+}
+
+// expiryFor returns when a location saved now with the given TTL expires. A zero TTL falls back to the
+// namespace default, and a zero default means never.
+func (n *Namespace) expiryFor(ttl time.Duration) time.Time {
+	if ttl == 0 {
+		ttl = n.defaultTTL
+	}
+
+	if ttl == 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(ttl)
+}
+
+// SetDefaultTTL sets the TTL given to locations saved without one. Zero means they never expire.
+// Locations already saved keep their expiry.
+func (n *Namespace) SetDefaultTTL(ttl time.Duration) error {
+	if ttl < 0 {
+		return ErrInvalidTTL
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.dropped {
+		return errNamespaceDropped
+	}
+
+	if n.journal != nil {
+		err := n.journal.Append(Mutation{Op: OpDefaultTTL, Ns: n.Name, TTL: ttl})
+		if err != nil {
+			return err
+		}
+	}
+
+	n.defaultTTL = ttl
+
+	return nil
+}
+
+func (n *Namespace) DefaultTTL() time.Duration {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.defaultTTL
+}
+
+// Expire removes the locations whose TTL ran out by now and returns their ids.
+// Only the expired locations are visited, popped from the expiry heap. Each removal is journaled first; once the
+// journal fails, the locations left expire on a later call.
+func (n *Namespace) Expire(now time.Time) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.dropped {
+		return nil
+	}
+
+	var expired []string
+
+	for len(n.expiries) > 0 && !n.expiries[0].expiresAt.After(now) {
+		loc := n.expiries[0]
+
+		if n.journal != nil {
+			err := n.journal.Append(Mutation{Op: OpDelete, Ns: n.Name, Id: loc.id})
+			if err != nil {
+				break
+			}
+		}
+
+		heap.Pop(&n.expiries)
+
+		shard := n.locations.lock(loc.id)
+		loc.expiresAt = time.Time{}
+		n.index().Remove(loc)
+		delete(shard.locations, loc.id)
+		shard.mu.Unlock()
+
+		n.publishDelete(loc)
+		n.exitFences(loc)
+		expired = append(expired, loc.Id())
+	}
+
+	expiredLocations.Add(float64(len(expired)))
+
+	return expired
+}
+
+// SetDefaultTTL sets the default TTL of a namespace. See Namespace.SetDefaultTTL.
+func (m *World) SetDefaultTTL(ns string, ttl time.Duration) error {
+	return m.write(ns, func(namespace *Namespace) error {
+		return namespace.SetDefaultTTL(ttl)
+	})
+}
+
+// Expire removes every location whose TTL ran out by now and calls onExpire, if not nil, for each of them.
+func (m *World) Expire(now time.Time, onExpire func(ns, id string)) int {
+	count := 0
+
+	for _, namespace := range m.allNamespaces() {
+		expired := namespace.Expire(now)
+		count += len(expired)
+
+		if onExpire != nil {
+			for _, id := range expired {
+				onExpire(namespace.Name, id)
+			}
+		}
+	}
+
+	return count
+}
+
+// RunExpiry expires locations every interval until the context is done.
+func (m *World) RunExpiry(ctx context.Context, interval time.Duration, onExpire func(ns, id string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.Expire(now, onExpire)
+		}
+	}
+}
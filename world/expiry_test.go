@@ -0,0 +1,154 @@
+package world
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiry(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should remove expired locations from the namespace and the tree", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		now := time.Now()
+
+		assert.NoError(t, world.SaveWithTTL("ns", "short", 1, 1, time.Second))
+		assert.NoError(t, world.SaveWithTTL("ns", "long", 2, 2, time.Hour))
+		assert.NoError(t, world.Save("ns", "forever", 3, 3))
+
+		var expired []string
+		count := world.Expire(now.Add(time.Minute), func(ns, id string) {
+			expired = append(expired, ns+"/"+id)
+		})
+
+		assert.Equal(t, 1, count)
+		assert.Equal(t, []string{"ns/short"}, expired)
+
+		_, ok := world.GetLocation("ns", "short")
+		assert.False(t, ok)
+		assert.Len(t, world.QueryRange("ns", -90, 90, -180, 180), 2)
+
+		assert.Equal(t, 1, world.Expire(now.Add(2*time.Hour), nil))
+		assert.Len(t, world.QueryRange("ns", -90, 90, -180, 180), 1)
+	})
+
+	t.Run("should reset the expiry when a location is saved again", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		now := time.Now()
+
+		assert.NoError(t, world.SaveWithTTL("ns", "a", 1, 1, time.Second))
+		assert.NoError(t, world.SaveWithTTL("ns", "a", 1, 2, time.Hour))
+		assert.Equal(t, 0, world.Expire(now.Add(time.Minute), nil))
+
+		assert.NoError(t, world.Save("ns", "a", 1, 3))
+		assert.Equal(t, 0, world.Expire(now.Add(2*time.Hour), nil))
+
+		a, ok := world.GetLocation("ns", "a")
+		assert.True(t, ok)
+		assert.True(t, a.ExpiresAt().IsZero())
+	})
+
+	t.Run("should give the namespace default TTL to locations saved without one", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		now := time.Now()
+
+		assert.NoError(t, world.Save("ns", "before", 1, 1))
+		assert.NoError(t, world.SetDefaultTTL("ns", time.Minute))
+		assert.NoError(t, world.Save("ns", "after", 2, 2))
+		assert.NoError(t, world.SaveWithTTL("ns", "own", 3, 3, time.Hour))
+		assert.NoError(t, world.Save("other", "elsewhere", 4, 4))
+
+		assert.Equal(t, 1, world.Expire(now.Add(10*time.Minute), nil))
+
+		_, ok := world.GetLocation("ns", "after")
+		assert.False(t, ok)
+		for _, id := range []string{"before", "own"} {
+			_, ok = world.GetLocation("ns", id)
+			assert.True(t, ok, id)
+		}
+		_, ok = world.GetLocation("other", "elsewhere")
+		assert.True(t, ok)
+	})
+
+	t.Run("should forget the expiry of deleted locations", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		assert.NoError(t, world.SaveWithTTL("ns", "a", 1, 1, time.Second))
+		assert.NoError(t, world.Delete("ns", "a"))
+		assert.Empty(t, world.getNamespace("ns").expiries)
+		assert.Equal(t, 0, world.Expire(time.Now().Add(time.Minute), nil))
+	})
+
+	t.Run("should expire in order without visiting the others", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		now := time.Now()
+
+		for i := 1; i <= 1000; i++ {
+			assert.NoError(t, world.SaveWithTTL("ns", strconv.Itoa(i), float64(i%90), float64(i%180), time.Duration(i)*time.Second))
+		}
+
+		expired := world.getNamespace("ns").Expire(now.Add(10*time.Second + 500*time.Millisecond))
+		assert.Len(t, expired, 10)
+		assert.Len(t, world.getNamespace("ns").expiries, 990)
+	})
+
+	t.Run("should reject negative TTLs", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		assert.ErrorIs(t, world.SaveWithTTL("ns", "a", 1, 1, -time.Second), ErrInvalidTTL)
+		assert.ErrorIs(t, world.SetDefaultTTL("ns", -time.Second), ErrInvalidTTL)
+	})
+
+	t.Run("should keep the TTLs and the locations the journal could not take", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		now := time.Now()
+		assert.NoError(t, world.SaveWithTTL("ns", "a", 1, 1, time.Second))
+
+		journal := &recordingJournal{err: errors.New("disk full")}
+		world.SetJournal(journal)
+
+		assert.Error(t, world.SetDefaultTTL("ns", time.Minute))
+		assert.Zero(t, world.getNamespace("ns").DefaultTTL())
+
+		assert.Equal(t, 0, world.Expire(now.Add(time.Minute), nil))
+		_, ok := world.GetLocation("ns", "a")
+		assert.True(t, ok)
+
+		journal.err = nil
+		assert.Equal(t, 1, world.Expire(now.Add(time.Minute), nil))
+		assert.Equal(t, Mutation{Op: OpDelete, Ns: "ns", Id: "a"}, journal.mutations[len(journal.mutations)-1])
+	})
+
+	t.Run("should expire in the background until cancelled", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		assert.NoError(t, world.SaveWithTTL("ns", "a", 1, 1, time.Millisecond))
+
+		expired := make(chan string, 1)
+		go world.RunExpiry(ctx, time.Millisecond, func(ns, id string) {
+			expired <- id
+		})
+
+		select {
+		case id := <-expired:
+			assert.Equal(t, "a", id)
+		case <-time.After(time.Second):
+			t.Fatal("the location did not expire")
+		}
+	})
+}
@@ -0,0 +1,266 @@
+package world
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidFenceId    = errors.New("fence ids are made of 1 to 64 letters, digits, '_', '-' or '.'")
+	ErrInvalidFenceDwell = errors.New("fence dwell time must be positive, 0 for no DWELL events")
+)
+
+// Fence is a named polygon of a namespace. Saves report the locations entering and exiting it, and staying in it
+// for Dwell if it is not 0.
+type Fence struct {
+	Id      string
+	Polygon *Polygon
+	Dwell   time.Duration
+}
+
+// fenceVisit is a location's stay in a fence.
+type fenceVisit struct {
+	enteredAt time.Time
+	dwelt     bool
+}
+
+// fenceHub delivers the fence events of every namespace of a world to the subscriptions watching them. Its lock
+// is taken under the namespaces' ones.
+type fenceHub struct {
+	mu       sync.Mutex
+	watchers map[*Subscription]string
+}
+
+func newFenceHub() *fenceHub {
+	return &fenceHub{watchers: map[*Subscription]string{}}
+}
+
+func (h *fenceHub) publish(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for watcher, ns := range h.watchers {
+		if ns == "" || ns == event.Ns {
+			watcher.send(event)
+		}
+	}
+}
+
+// unwatch removes a subscription from the hub. Call it under h.mu.
+func (h *fenceHub) unwatch(subscription *Subscription) {
+	delete(h.watchers, subscription)
+}
+
+// AddFence adds a fence to the namespace, or replaces the fence with the same id. Locations already inside it
+// enter it on their next save.
+func (n *Namespace) AddFence(id string, polygon *Polygon, dwell time.Duration) error {
+	if !isAttributeKey(id) {
+		return ErrInvalidFenceId
+	}
+
+	if polygon == nil {
+		return ErrPolygonInvalid
+	}
+
+	if dwell < 0 {
+		return ErrInvalidFenceDwell
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.dropped {
+		return errNamespaceDropped
+	}
+
+	if n.fences == nil {
+		n.fences = map[string]*Fence{}
+		n.fenceIndex = newRegionTree[*Fence]()
+	}
+
+	if previous, ok := n.fences[id]; ok {
+		n.fenceIndex.remove(previous.Polygon.bounds, previous)
+	}
+
+	fence := &Fence{Id: id, Polygon: polygon, Dwell: dwell}
+	n.fences[id] = fence
+	n.fenceIndex.insert(polygon.bounds, fence)
+
+	if n.journal != nil {
+		return n.journal.Append(Mutation{Op: OpFenceAdd, Ns: n.Name, Id: id, Polygon: polygon, Dwell: dwell})
+	}
+
+	return nil
+}
+
+// DeleteFence removes a fence. The locations inside it do not exit it.
+func (n *Namespace) DeleteFence(id string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.dropped {
+		return errNamespaceDropped
+	}
+
+	fence, ok := n.fences[id]
+	if !ok {
+		return nil
+	}
+
+	n.fenceIndex.remove(fence.Polygon.bounds, fence)
+	delete(n.fences, id)
+
+	if n.journal != nil {
+		return n.journal.Append(Mutation{Op: OpFenceDelete, Ns: n.Name, Id: id})
+	}
+
+	return nil
+}
+
+// Fences returns the namespace's fences sorted by id.
+func (n *Namespace) Fences() []Fence {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	fences := make([]Fence, 0, len(n.fences))
+	for _, fence := range n.fences {
+		fences = append(fences, *fence)
+	}
+
+	sort.Slice(fences, func(i, j int) bool {
+		return fences[i].Id < fences[j].Id
+	})
+
+	return fences
+}
+
+// evaluateFences updates the fences the location is in after a save and reports the changes: exits first, then
+// entries and dwells. Only the fences whose bounds hold the new position are tested. Call it under n.mu.
+func (n *Namespace) evaluateFences(loc *Location) {
+	if len(n.fences) == 0 && len(loc.fences) == 0 {
+		return
+	}
+
+	var inside []*Fence
+	if n.fenceIndex != nil {
+		for _, fence := range n.fenceIndex.match(loc.lat, loc.lon, nil) {
+			if fence.Polygon.Contains(loc.lat, loc.lon) {
+				inside = append(inside, fence)
+			}
+		}
+	}
+
+	for id := range loc.fences {
+		fence, ok := n.fences[id]
+		if !ok {
+			// Deleted, or replaced by a fence the location will enter anew.
+			delete(loc.fences, id)
+			continue
+		}
+
+		if !containsFence(inside, fence) {
+			delete(loc.fences, id)
+			n.publishFence(EventExit, fence.Id, loc)
+		}
+	}
+
+	for _, fence := range inside {
+		visit, ok := loc.fences[fence.Id]
+		if !ok {
+			if loc.fences == nil {
+				loc.fences = map[string]*fenceVisit{}
+			}
+			loc.fences[fence.Id] = &fenceVisit{enteredAt: loc.updatedAt}
+			n.publishFence(EventEnter, fence.Id, loc)
+			continue
+		}
+
+		if fence.Dwell > 0 && !visit.dwelt && loc.updatedAt.Sub(visit.enteredAt) >= fence.Dwell {
+			visit.dwelt = true
+			n.publishFence(EventDwell, fence.Id, loc)
+		}
+	}
+}
+
+// exitFences reports a deleted location exiting the fences it was in. Call it under n.mu.
+func (n *Namespace) exitFences(loc *Location) {
+	for id := range loc.fences {
+		if _, ok := n.fences[id]; ok {
+			n.publishFence(EventExit, id, loc)
+		}
+	}
+	loc.fences = nil
+}
+
+func (n *Namespace) publishFence(kind EventKind, fence string, loc *Location) {
+	if n.fenceHub == nil {
+		return
+	}
+
+	n.fenceHub.publish(Event{Kind: kind, Ns: n.Name, Id: loc.id, Lat: loc.lat, Lon: loc.lon, Attributes: loc.attributes, Fence: fence})
+}
+
+func containsFence(fences []*Fence, fence *Fence) bool {
+	for _, f := range fences {
+		if f == fence {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddFence adds or replaces a fence of a namespace. See Namespace.AddFence.
+func (m *World) AddFence(ns, id string, polygon *Polygon, dwell time.Duration) error {
+	return m.write(ns, func(namespace *Namespace) error {
+		return namespace.AddFence(id, polygon, dwell)
+	})
+}
+
+// DeleteFence removes a fence of a namespace.
+func (m *World) DeleteFence(ns, id string) error {
+	return m.update(ns, func(namespace *Namespace) error {
+		return namespace.DeleteFence(id)
+	})
+}
+
+// Fences returns the fences of a namespace sorted by id.
+func (m *World) Fences(ns string) []Fence {
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return nil
+	}
+
+	return namespace.Fences()
+}
+
+// WatchFences opens a subscription to the ENTER, EXIT and DWELL events of the fences of a namespace, or of every
+// namespace if ns is empty.
+func (m *World) WatchFences(ns string, buffer int, policy SlowConsumerPolicy) (*Subscription, error) {
+	if buffer <= 0 {
+		return nil, ErrInvalidSubscriptionBuffer
+	}
+
+	if ns != "" {
+		err := m.CheckNamespace(ns)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	subscription := &Subscription{
+		events: make(chan Event, buffer),
+		lock:   &m.fenceHub.mu,
+		detach: m.fenceHub.unwatch,
+		policy: policy,
+	}
+
+	m.fenceHub.mu.Lock()
+	m.fenceHub.watchers[subscription] = ns
+	m.fenceHub.mu.Unlock()
+	subscriptionsGauge.Inc()
+
+	return subscription, nil
+}
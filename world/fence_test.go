@@ -0,0 +1,134 @@
+package world
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustParsePolygon(t *testing.T, wkt string) *Polygon {
+	polygon, err := ParsePolygon(wkt)
+	assert.NoError(t, err)
+
+	return polygon
+}
+
+func TestFence(t *testing.T) {
+	t.Parallel()
+
+	square := "POLYGON ((0 0, 10 0, 10 10, 0 10, 0 0))"
+
+	t.Run("should report entering, dwelling in and exiting fences", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		events, err := world.WatchFences("ns", 16, DropEvents)
+		assert.NoError(t, err)
+
+		assert.NoError(t, world.AddFence("ns", "square", mustParsePolygon(t, square), time.Millisecond))
+		assert.NoError(t, world.AddFence("ns", "far", mustParsePolygon(t, "POLYGON ((50 50, 60 50, 60 60, 50 60, 50 50))"), 0))
+
+		assert.NoError(t, world.Save("ns", "a", 20, 20))
+		assert.NoError(t, world.Save("ns", "a", 5, 5))
+		time.Sleep(2 * time.Millisecond)
+		assert.NoError(t, world.Save("ns", "a", 6, 6))
+		assert.NoError(t, world.Save("ns", "a", 7, 7))
+		assert.NoError(t, world.Save("ns", "a", 55, 55))
+		assert.NoError(t, world.Delete("ns", "a"))
+
+		expected := []Event{
+			{Kind: EventEnter, Ns: "ns", Id: "a", Lat: 5, Lon: 5, Fence: "square"},
+			{Kind: EventDwell, Ns: "ns", Id: "a", Lat: 6, Lon: 6, Fence: "square"},
+			{Kind: EventExit, Ns: "ns", Id: "a", Lat: 55, Lon: 55, Fence: "square"},
+			{Kind: EventEnter, Ns: "ns", Id: "a", Lat: 55, Lon: 55, Fence: "far"},
+			{Kind: EventExit, Ns: "ns", Id: "a", Lat: 55, Lon: 55, Fence: "far"},
+		}
+		for _, event := range expected {
+			assert.Equal(t, event, <-events.Events())
+		}
+		assert.Empty(t, events.Events())
+		events.Close()
+	})
+
+	t.Run("should respect holes and only watch the asked namespace", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		events, err := world.WatchFences("ns", 16, DropEvents)
+		assert.NoError(t, err)
+		all, err := world.WatchFences("", 16, DropEvents)
+		assert.NoError(t, err)
+
+		donut := mustParsePolygon(t, "POLYGON ((0 0, 10 0, 10 10, 0 10, 0 0), (4 4, 6 4, 6 6, 4 6, 4 4))")
+		assert.NoError(t, world.AddFence("ns", "donut", donut, 0))
+		assert.NoError(t, world.AddFence("other", "donut", donut, 0))
+
+		assert.NoError(t, world.Save("ns", "a", 5, 5))
+		assert.NoError(t, world.Save("other", "b", 1, 1))
+
+		assert.Empty(t, events.Events())
+		assert.Equal(t, "other", (<-all.Events()).Ns)
+	})
+
+	t.Run("should forget deleted fences without exiting them", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		events, err := world.WatchFences("ns", 16, DropEvents)
+		assert.NoError(t, err)
+
+		assert.NoError(t, world.AddFence("ns", "square", mustParsePolygon(t, square), 0))
+		assert.NoError(t, world.Save("ns", "a", 5, 5))
+		assert.Equal(t, EventEnter, (<-events.Events()).Kind)
+
+		assert.NoError(t, world.DeleteFence("ns", "square"))
+		assert.NoError(t, world.DeleteFence("ns", "unknown"))
+		assert.Empty(t, world.Fences("ns"))
+
+		assert.NoError(t, world.Save("ns", "a", 50, 50))
+		assert.NoError(t, world.Delete("ns", "a"))
+		assert.Empty(t, events.Events())
+
+		loc, _ := world.getNamespace("ns").GetLocation("a")
+		assert.Nil(t, loc)
+	})
+
+	t.Run("should exit fences on expiry", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		events, err := world.WatchFences("ns", 16, DropEvents)
+		assert.NoError(t, err)
+
+		assert.NoError(t, world.AddFence("ns", "square", mustParsePolygon(t, square), 0))
+		assert.NoError(t, world.SaveWithTTL("ns", "a", 5, 5, time.Minute))
+		assert.Equal(t, 1, world.Expire(time.Now().Add(time.Hour), nil))
+
+		assert.Equal(t, EventEnter, (<-events.Events()).Kind)
+		assert.Equal(t, EventExit, (<-events.Events()).Kind)
+	})
+
+	t.Run("should list fences sorted by id and replace them by id", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		assert.NoError(t, world.AddFence("ns", "b", mustParsePolygon(t, square), 0))
+		assert.NoError(t, world.AddFence("ns", "a", mustParsePolygon(t, square), 0))
+		assert.NoError(t, world.AddFence("ns", "b", mustParsePolygon(t, "POLYGON ((20 20, 30 20, 30 30, 20 20))"), time.Minute))
+
+		fences := world.Fences("ns")
+		assert.Len(t, fences, 2)
+		assert.Equal(t, "a", fences[0].Id)
+		assert.Equal(t, "b", fences[1].Id)
+		assert.Equal(t, time.Minute, fences[1].Dwell)
+		assert.Equal(t, "POLYGON ((20 20, 30 20, 30 30, 20 20))", fences[1].Polygon.String())
+		assert.Equal(t, 2, world.getNamespace("ns").fenceIndex.size)
+	})
+
+	t.Run("should reject invalid fences", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		assert.ErrorIs(t, world.AddFence("ns", "a b", mustParsePolygon(t, square), 0), ErrInvalidFenceId)
+		assert.ErrorIs(t, world.AddFence("ns", "", mustParsePolygon(t, square), 0), ErrInvalidFenceId)
+		assert.ErrorIs(t, world.AddFence("ns", "a", mustParsePolygon(t, square), -time.Second), ErrInvalidFenceDwell)
+		assert.ErrorIs(t, world.AddFence("ns", "a", nil, 0), ErrPolygonInvalid)
+	})
+}
@@ -0,0 +1,162 @@
+package world
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidFilter       = errors.New("invalid WHERE clause, expected conditions like key=value, key IN a,b or key>=10 joined by AND")
+	ErrInvalidFilterNumber = errors.New("numeric comparisons in WHERE clauses need a number")
+)
+
+type Comparison int
+
+const (
+	CompareEqual Comparison = iota
+	CompareNotEqual
+	CompareIn
+	CompareLess
+	CompareLessOrEqual
+	CompareGreater
+	CompareGreaterOrEqual
+)
+
+// Condition compares one attribute. Equality and IN compare text against Values, the other comparisons
+// compare the attribute as a number against Number. A missing attribute reads as the empty string.
+type Condition struct {
+	Key        string
+	Comparison Comparison
+	Values     []string
+	Number     float64
+}
+
+// Filter keeps the locations matching all its conditions. A nil filter keeps everything.
+type Filter []Condition
+
+var comparisonOperators = []struct {
+	operator   string
+	comparison Comparison
+}{
+	// Two-character operators first, so "<=" is not read as "<".
+	{"!=", CompareNotEqual},
+	{"<=", CompareLessOrEqual},
+	{">=", CompareGreaterOrEqual},
+	{"=", CompareEqual},
+	{"<", CompareLess},
+	{">", CompareGreater},
+}
+
+// ParseFilter reads the tokens following WHERE, such as ["status=available", "AND", "type", "IN", "car,van",
+// "AND", "battery>=20"]. AND and IN are read in any case.
+func ParseFilter(tokens []string) (Filter, error) {
+	if len(tokens) == 0 {
+		return nil, ErrInvalidFilter
+	}
+
+	var filter Filter
+
+	for i := 0; i < len(tokens); i++ {
+		if len(filter) > 0 {
+			if !strings.EqualFold(tokens[i], "AND") || i+1 == len(tokens) {
+				return nil, ErrInvalidFilter
+			}
+			i++
+		}
+
+		if i+2 < len(tokens) && strings.EqualFold(tokens[i+1], "IN") {
+			if !isAttributeKey(tokens[i]) || tokens[i+2] == "" {
+				return nil, ErrInvalidFilter
+			}
+
+			filter = append(filter, Condition{Key: tokens[i], Comparison: CompareIn, Values: strings.Split(tokens[i+2], ",")})
+			i += 2
+			continue
+		}
+
+		condition, err := parseCondition(tokens[i])
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, condition)
+	}
+
+	return filter, nil
+}
+
+func parseCondition(token string) (Condition, error) {
+	at := strings.IndexAny(token, "!<>=")
+	if at <= 0 || !isAttributeKey(token[:at]) {
+		return Condition{}, ErrInvalidFilter
+	}
+
+	for _, candidate := range comparisonOperators {
+		if !strings.HasPrefix(token[at:], candidate.operator) {
+			continue
+		}
+
+		condition := Condition{Key: token[:at], Comparison: candidate.comparison}
+		value := token[at+len(candidate.operator):]
+
+		switch candidate.comparison {
+		case CompareEqual, CompareNotEqual:
+			condition.Values = []string{value}
+		default:
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return Condition{}, ErrInvalidFilterNumber
+			}
+			condition.Number = number
+		}
+
+		return condition, nil
+	}
+
+	return Condition{}, ErrInvalidFilter
+}
+
+func isAttributeKey(key string) bool {
+	return key != "" && len(key) <= maxAttributeKeySize && strings.IndexFunc(key, isNotAttributeKeyRune) < 0
+}
+
+// Match tells if the location satisfies every condition.
+func (f Filter) Match(location *Location) bool {
+	for _, condition := range f {
+		if !condition.match(location.attributes[condition.Key]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c Condition) match(value string) bool {
+	switch c.Comparison {
+	case CompareEqual:
+		return value == c.Values[0]
+	case CompareNotEqual:
+		return value != c.Values[0]
+	case CompareIn:
+		return slices.Contains(c.Values, value)
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return false
+	}
+
+	switch c.Comparison {
+	case CompareLess:
+		return number < c.Number
+	case CompareLessOrEqual:
+		return number <= c.Number
+	case CompareGreater:
+		return number > c.Number
+	case CompareGreaterOrEqual:
+		return number >= c.Number
+	}
+
+	return false
+}
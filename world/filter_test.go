@@ -0,0 +1,155 @@
+package world
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttributes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should merge attributes on save and keep them when saved without", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		assert.NoError(t, world.SaveWithAttributes("ns", "a", 1, 1, 0, Attributes{"status": "busy", "type": "van"}))
+		assert.NoError(t, world.Save("ns", "a", 2, 2))
+		assert.NoError(t, world.SaveWithAttributes("ns", "a", 3, 3, 0, Attributes{"status": "available", "type": ""}))
+
+		a, ok := world.GetLocation("ns", "a")
+		assert.True(t, ok)
+		assert.Equal(t, Attributes{"status": "available"}, a.Attributes())
+		assert.Equal(t, 3.0, a.Lat())
+	})
+
+	t.Run("should reject invalid attributes", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		assert.ErrorIs(t, world.SaveWithAttributes("ns", "a", 1, 1, 0, Attributes{"": "x"}), ErrInvalidAttributeKey)
+		assert.ErrorIs(t, world.SaveWithAttributes("ns", "a", 1, 1, 0, Attributes{"a b": "x"}), ErrInvalidAttributeKey)
+		assert.ErrorIs(t, world.SaveWithAttributes("ns", "a", 1, 1, 0, Attributes{"k": "x,y"}), ErrInvalidAttribute)
+		assert.ErrorIs(t, world.SaveWithAttributes("ns", "a", 1, 1, 0, Attributes{"k": strings.Repeat("x", 257)}), ErrInvalidAttribute)
+
+		many := Attributes{}
+		for i := 0; i < 33; i++ {
+			many["k"+strconv.Itoa(i)] = "v"
+		}
+		assert.ErrorIs(t, world.SaveWithAttributes("ns", "a", 1, 1, 0, many), ErrTooManyAttributes)
+
+		_, ok := world.GetLocation("ns", "a")
+		assert.False(t, ok)
+	})
+
+	t.Run("should print attributes sorted by key", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, "a=1,b=2,c=3", Attributes{"c": "3", "a": "1", "b": "2"}.String())
+		assert.Equal(t, "", Attributes(nil).String())
+	})
+}
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should parse conditions joined by AND", func(t *testing.T) {
+		t.Parallel()
+		filter, err := ParseFilter(strings.Split("status=available AND type IN car,van AND battery>=20 AND battery<80.5 AND zone!=north", " "))
+		assert.NoError(t, err)
+		assert.Equal(t, Filter{
+			{Key: "status", Comparison: CompareEqual, Values: []string{"available"}},
+			{Key: "type", Comparison: CompareIn, Values: []string{"car", "van"}},
+			{Key: "battery", Comparison: CompareGreaterOrEqual, Number: 20},
+			{Key: "battery", Comparison: CompareLess, Number: 80.5},
+			{Key: "zone", Comparison: CompareNotEqual, Values: []string{"north"}},
+		}, filter)
+	})
+
+	t.Run("should reject malformed clauses", func(t *testing.T) {
+		t.Parallel()
+		clauses := map[string]error{
+			"":                    ErrInvalidFilter,
+			"status":              ErrInvalidFilter,
+			"=available":          ErrInvalidFilter,
+			"a=1 b=2":             ErrInvalidFilter,
+			"a=1 AND":             ErrInvalidFilter,
+			"a=1 OR b=2":          ErrInvalidFilter,
+			"type IN":             ErrInvalidFilter,
+			"battery>=full":       ErrInvalidFilterNumber,
+			"bad key=1":           ErrInvalidFilter,
+			"a=1 AND type IN car": nil,
+		}
+
+		for clause, expected := range clauses {
+			_, err := ParseFilter(strings.Fields(clause))
+			if expected == nil {
+				assert.NoError(t, err, clause)
+				continue
+			}
+			assert.ErrorIs(t, err, expected, clause)
+		}
+	})
+
+	t.Run("should match attributes", func(t *testing.T) {
+		t.Parallel()
+		location := &Location{attributes: Attributes{"status": "available", "type": "van", "battery": "42"}}
+
+		matches := map[string]bool{
+			"status=available":                 true,
+			"status=busy":                      false,
+			"status!=busy":                     true,
+			"type IN car,van":                  true,
+			"type IN car,bike":                 false,
+			"battery>=42 AND battery<50":       true,
+			"battery>42":                       false,
+			"status>1":                         false,
+			"driver=":                          true,
+			"status=available AND type IN car": false,
+		}
+
+		for clause, expected := range matches {
+			filter, err := ParseFilter(strings.Fields(clause))
+			assert.NoError(t, err, clause)
+			assert.Equal(t, expected, filter.Match(location), clause)
+		}
+
+		assert.True(t, Filter(nil).Match(location))
+	})
+
+	t.Run("should filter every kind of query", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		for i := 0; i < 2000; i++ {
+			attributes := Attributes{"status": "busy"}
+			if i%100 == 0 {
+				attributes["status"] = "available"
+			}
+			assert.NoError(t, world.SaveWithAttributes("ns", strconv.Itoa(i), float64(i%40), float64(i%50), 0, attributes))
+		}
+
+		filter, err := ParseFilter([]string{"status=available"})
+		assert.NoError(t, err)
+
+		assert.Len(t, world.QueryRangeWhere("ns", -90, 90, -180, 180, filter), 20)
+		assert.Len(t, world.QueryRangeWhere("ns", -90, 90, 170, 10, filter), 20, "across the antimeridian")
+
+		polygon, err := ParsePolygon("POLYGON ((-1 -1, 60 -1, 60 50, -1 50, -1 -1))")
+		assert.NoError(t, err)
+		assert.Len(t, world.QueryPolygonWhere("ns", polygon, filter), 20)
+
+		neighbors, err := world.QueryRadiusWhere("ns", 0, 0, 20000000, filter)
+		assert.NoError(t, err)
+		assert.Len(t, neighbors, 20)
+
+		// Most of the closest locations are busy, the filter must not eat into k.
+		neighbors, err = world.NearestWhere("ns", 1, 1, 5, 0, filter)
+		assert.NoError(t, err)
+		assert.Len(t, neighbors, 5)
+		for _, neighbor := range neighbors {
+			assert.Equal(t, "available", neighbor.Location.Attributes()["status"])
+		}
+	})
+}
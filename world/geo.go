@@ -0,0 +1,104 @@
+package world
+
+import (
+	"errors"
+	"math"
+)
+
+// earthRadius is the mean radius of the earth in meters.
+const earthRadius = 6371008.8
+
+var (
+	ErrInvalidRadius = errors.New("radius must be a positive number of meters")
+)
+
+// Neighbor is a location found around a point, with its great-circle distance to that point in meters.
+type Neighbor struct {
+	Location *Location
+	Distance float64
+}
+
+// box is a latitude/longitude rectangle with lon1 <= lon2. Areas crossing the antimeridian are split in two boxes.
+type box struct {
+	lat1, lat2, lon1, lon2 float64
+}
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func toDegrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// haversine returns the great-circle distance in meters between two points.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// circleBounds returns the boxes covering a circle of radius meters around (lat, lon).
+// A circle reaching a pole covers every longitude above it, and a circle crossing the antimeridian gets two boxes.
+func circleBounds(lat, lon, meters float64) []box {
+	angular := meters / earthRadius
+	if angular >= math.Pi {
+		return []box{{lat1: -90, lat2: 90, lon1: -180, lon2: 180}}
+	}
+
+	latRad := toRadians(lat)
+	minLat := latRad - angular
+	maxLat := latRad + angular
+
+	if maxLat >= math.Pi/2 || minLat <= -math.Pi/2 {
+		return []box{{
+			lat1: math.Max(toDegrees(minLat), -90),
+			lat2: math.Min(toDegrees(maxLat), 90),
+			lon1: -180,
+			lon2: 180,
+		}}
+	}
+
+	deltaLon := toDegrees(math.Asin(math.Sin(angular) / math.Cos(latRad)))
+	lat1, lat2 := toDegrees(minLat), toDegrees(maxLat)
+
+	return splitAntimeridian(lat1, lat2, lon-deltaLon, lon+deltaLon)
+}
+
+// splitAntimeridian turns a longitude range that may run past ±180 into boxes within [-180, 180].
+func splitAntimeridian(lat1, lat2, lon1, lon2 float64) []box {
+	if lon2-lon1 >= 360 {
+		return []box{{lat1: lat1, lat2: lat2, lon1: -180, lon2: 180}}
+	}
+
+	switch {
+	case lon1 < -180:
+		return []box{
+			{lat1: lat1, lat2: lat2, lon1: lon1 + 360, lon2: 180},
+			{lat1: lat1, lat2: lat2, lon1: -180, lon2: lon2},
+		}
+	case lon2 > 180:
+		return []box{
+			{lat1: lat1, lat2: lat2, lon1: lon1, lon2: 180},
+			{lat1: lat1, lat2: lat2, lon1: -180, lon2: lon2 - 360},
+		}
+	}
+
+	return []box{{lat1: lat1, lat2: lat2, lon1: lon1, lon2: lon2}}
+}
+
+// splitRange returns the boxes of a queried range: two when lon1 > lon2, as the range then crosses the antimeridian.
+func splitRange(lat1, lat2, lon1, lon2 float64) []box {
+	if lon1 > lon2 {
+		return []box{
+			{lat1: lat1, lat2: lat2, lon1: lon1, lon2: 180},
+			{lat1: lat1, lat2: lat2, lon1: -180, lon2: lon2},
+		}
+	}
+
+	return []box{{lat1: lat1, lat2: lat2, lon1: lon1, lon2: lon2}}
+}
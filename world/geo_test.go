@@ -0,0 +1,61 @@
+package world
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHaversine(t *testing.T) {
+	t.Run("should measure great-circle distances", func(t *testing.T) {
+		assert.InDelta(t, 0, haversine(10, 10, 10, 10), 1e-9)
+		assert.InDelta(t, 111195, haversine(0, 0, 1, 0), 1)
+		assert.InDelta(t, math.Pi*earthRadius, haversine(0, 0, 0, 180), 1)
+		assert.InDelta(t, 2223.9, haversine(0, 179.99, 0, -179.99), 1, "across the antimeridian")
+		assert.InDelta(t, 22239, haversine(89.9, 0, 89.9, 180), 1, "across the pole")
+	})
+}
+
+func TestCircleBounds(t *testing.T) {
+	t.Run("should return a single box away from the poles and the antimeridian", func(t *testing.T) {
+		boxes := circleBounds(0, 0, 111195)
+		assert.Len(t, boxes, 1)
+		assert.InDelta(t, -1, boxes[0].lat1, 1e-3)
+		assert.InDelta(t, 1, boxes[0].lat2, 1e-3)
+		assert.InDelta(t, -1, boxes[0].lon1, 1e-3)
+		assert.InDelta(t, 1, boxes[0].lon2, 1e-3)
+	})
+
+	t.Run("should widen longitudes away from the equator", func(t *testing.T) {
+		boxes := circleBounds(60, 0, 111195)
+		assert.Len(t, boxes, 1)
+		assert.Greater(t, boxes[0].lon2, 1.9)
+	})
+
+	t.Run("should cover every longitude when a pole is inside the circle", func(t *testing.T) {
+		boxes := circleBounds(89.5, 20, 111195)
+		assert.Equal(t, []box{{lat1: boxes[0].lat1, lat2: 90, lon1: -180, lon2: 180}}, boxes)
+		assert.InDelta(t, 88.5, boxes[0].lat1, 1e-3)
+	})
+
+	t.Run("should split the circle across the antimeridian", func(t *testing.T) {
+		boxes := circleBounds(0, 179.5, 111195)
+		assert.Len(t, boxes, 2)
+		assert.InDelta(t, 178.5, boxes[0].lon1, 1e-3)
+		assert.Equal(t, 180.0, boxes[0].lon2)
+		assert.Equal(t, -180.0, boxes[1].lon1)
+		assert.InDelta(t, -179.5, boxes[1].lon2, 1e-3)
+
+		boxes = circleBounds(0, -179.5, 111195)
+		assert.Len(t, boxes, 2)
+		assert.InDelta(t, 179.5, boxes[0].lon1, 1e-3)
+		assert.Equal(t, 180.0, boxes[0].lon2)
+		assert.Equal(t, -180.0, boxes[1].lon1)
+		assert.InDelta(t, -178.5, boxes[1].lon2, 1e-3)
+	})
+
+	t.Run("should cover the whole world for huge circles", func(t *testing.T) {
+		assert.Equal(t, []box{{lat1: -90, lat2: 90, lon1: -180, lon2: 180}}, circleBounds(0, 0, 2*math.Pi*earthRadius))
+	})
+}
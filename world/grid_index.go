@@ -0,0 +1,425 @@
+package world
+
+import (
+	"fmt"
+	"sync"
+)
+
+// gridIndex cuts a namespace's extent in side by side cells of the same size, like geohashes of one precision:
+// the cell of a point is found by arithmetic rather than by walking a tree, so saves and moves cost the same
+// however crowded the namespace is. Only the cells holding locations, or that once did, are allocated.
+//
+// The changes take the locks of the cells they touch, in the order of their keys when they touch two. The map of
+// cells has its own lock, only written to when a cell is allocated.
+type gridIndex struct {
+	extent  Extent
+	level   int
+	side    int
+	cellLat float64
+	cellLon float64
+
+	mu    sync.RWMutex
+	cells map[int]*gridCell
+}
+
+type gridCell struct {
+	x, y    int
+	mu      sync.RWMutex
+	objects map[string]*Location
+}
+
+// newGridIndex returns a grid of 2^PreDivide by 2^PreDivide cells over the options' extent.
+func newGridIndex(options IndexOptions) *gridIndex {
+	side := 1 << options.PreDivide
+
+	return &gridIndex{
+		extent:  options.Extent,
+		level:   options.PreDivide,
+		side:    side,
+		cellLat: (options.Extent.Lat2 - options.Extent.Lat1) / float64(side),
+		cellLon: (options.Extent.Lon2 - options.Extent.Lon1) / float64(side),
+		cells:   map[int]*gridCell{},
+	}
+}
+
+// cellOf returns the column and row of the cell a point goes to. A point on the edge between two cells goes to
+// the one after, and the points on the extent's far edges, or out of it, to the closest cells. It only grows
+// with the coordinates, so the cells between those of a range's corners hold all of the range's points.
+func (g *gridIndex) cellOf(lat, lon float64) (int, int) {
+	return g.clamp((lon - g.extent.Lon1) / g.cellLon), g.clamp((lat - g.extent.Lat1) / g.cellLat)
+}
+
+func (g *gridIndex) clamp(position float64) int {
+	switch {
+	case !(position > 0):
+		return 0
+	case position >= float64(g.side):
+		return g.side - 1
+	default:
+		return int(position)
+	}
+}
+
+func (g *gridIndex) key(x, y int) int {
+	return y*g.side + x
+}
+
+// bounds returns the rectangle of the cells from (x1, y1) to (x2, y2), both included.
+func (g *gridIndex) bounds(x1, y1, x2, y2 int) (float64, float64, float64, float64) {
+	return g.extent.Lat1 + float64(y1)*g.cellLat, g.extent.Lat1 + float64(y2+1)*g.cellLat,
+		g.extent.Lon1 + float64(x1)*g.cellLon, g.extent.Lon1 + float64(x2+1)*g.cellLon
+}
+
+// cell returns the cell at (x, y), allocating it if create is set. It returns nil for a cell never allocated.
+func (g *gridIndex) cell(x, y int, create bool) *gridCell {
+	key := g.key(x, y)
+
+	g.mu.RLock()
+	cell := g.cells[key]
+	g.mu.RUnlock()
+
+	if cell != nil || !create {
+		return cell
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	cell = g.cells[key]
+	if cell == nil {
+		cell = &gridCell{x: x, y: y, objects: map[string]*Location{}}
+		g.cells[key] = cell
+	}
+
+	return cell
+}
+
+// cellAt returns the cell holding the location, nil if it was never allocated.
+func (g *gridIndex) cellAt(location *Location) *gridCell {
+	x, y := g.cellOf(location.lat, location.lon)
+
+	return g.cell(x, y, false)
+}
+
+// cellsIn returns the allocated cells from (x1, y1) to (x2, y2), both included. A range covering more cells than
+// are allocated is answered from the allocated ones.
+func (g *gridIndex) cellsIn(x1, y1, x2, y2 int) []*gridCell {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	var cells []*gridCell
+
+	if (x2-x1+1)*(y2-y1+1) > len(g.cells) {
+		for _, cell := range g.cells {
+			if x1 <= cell.x && cell.x <= x2 && y1 <= cell.y && cell.y <= y2 {
+				cells = append(cells, cell)
+			}
+		}
+
+		return cells
+	}
+
+	for y := y1; y <= y2; y++ {
+		for x := x1; x <= x2; x++ {
+			if cell := g.cells[g.key(x, y)]; cell != nil {
+				cells = append(cells, cell)
+			}
+		}
+	}
+
+	return cells
+}
+
+func (g *gridIndex) Insert(location *Location) error {
+	if location == nil {
+		return ErrTreeLocationNil
+	}
+
+	x, y := g.cellOf(location.lat, location.lon)
+	cell := g.cell(x, y, true)
+
+	cell.mu.Lock()
+	cell.objects[location.id] = location
+	cell.mu.Unlock()
+
+	return nil
+}
+
+// Move changes the location's coordinates while the locks of the cell it leaves and of the one it goes to are
+// both held, so the walks find it in the one or the other.
+func (g *gridIndex) Move(location *Location, lat, lon float64) error {
+	if location == nil {
+		return ErrTreeLocationNil
+	}
+
+	err := validateLatLon(lat, lon)
+	if err != nil {
+		return err
+	}
+
+	from := g.cellAt(location)
+	if from == nil {
+		err = location.Update(lat, lon)
+		if err != nil {
+			return err
+		}
+
+		return g.Insert(location)
+	}
+
+	x, y := g.cellOf(lat, lon)
+	to := g.cell(x, y, true)
+
+	if from == to {
+		from.mu.Lock()
+		err = location.Update(lat, lon)
+		from.mu.Unlock()
+
+		return err
+	}
+
+	first, second := from, to
+	if g.key(to.x, to.y) < g.key(from.x, from.y) {
+		first, second = to, from
+	}
+
+	first.mu.Lock()
+	second.mu.Lock()
+	err = location.Update(lat, lon)
+	if err == nil {
+		delete(from.objects, location.id)
+		to.objects[location.id] = location
+	}
+	second.mu.Unlock()
+	first.mu.Unlock()
+
+	return err
+}
+
+func (g *gridIndex) Remove(location *Location) {
+	if location == nil {
+		return
+	}
+
+	cell := g.cellAt(location)
+	if cell == nil {
+		return
+	}
+
+	cell.mu.Lock()
+	if cell.objects[location.id] == location {
+		delete(cell.objects, location.id)
+	}
+	cell.mu.Unlock()
+}
+
+// Walk visits the cells under the range, copying the locations of one cell at a time.
+func (g *gridIndex) Walk(lat1, lat2, lon1, lon2 float64, filter Filter, visit func(location *Location) bool) {
+	x1, y1 := g.cellOf(lat1, lon1)
+	x2, y2 := g.cellOf(lat2, lon2)
+
+	for _, cell := range g.cellsIn(x1, y1, x2, y2) {
+		var found []*Location
+
+		cell.mu.RLock()
+		for _, location := range cell.objects {
+			if location.lon >= lon1 && location.lon <= lon2 && location.lat >= lat1 && location.lat <= lat2 && filter.Match(location) {
+				found = append(found, location.walkCopy())
+			}
+		}
+		cell.mu.RUnlock()
+
+		if !visitAll(found, visit) {
+			return
+		}
+	}
+}
+
+// WalkPolygon visits the cells under the polygon's bounds. The locations of the cells fully inside it are visited
+// without testing them, and those of the cells crossed by its boundary are tested one by one.
+func (g *gridIndex) WalkPolygon(polygon *Polygon, filter Filter, visit func(location *Location) bool) {
+	x1, y1 := g.cellOf(polygon.bounds.lat1, polygon.bounds.lon1)
+	x2, y2 := g.cellOf(polygon.bounds.lat2, polygon.bounds.lon2)
+
+	for _, cell := range g.cellsIn(x1, y1, x2, y2) {
+		relation := polygon.classify(g.bounds(cell.x, cell.y, cell.x, cell.y))
+		if relation == cellOutside {
+			continue
+		}
+
+		var found []*Location
+
+		cell.mu.RLock()
+		for _, location := range cell.objects {
+			if (relation == cellInside || polygon.Contains(location.lat, location.lon)) && filter.Match(location) {
+				found = append(found, location.walkCopy())
+			}
+		}
+		cell.mu.RUnlock()
+
+		if !visitAll(found, visit) {
+			return
+		}
+	}
+}
+
+// Count returns how many locations are within the range. The cells the range covers whole are taken by their
+// size, without visiting their locations.
+func (g *gridIndex) Count(lat1, lat2, lon1, lon2 float64) int {
+	x1, y1 := g.cellOf(lat1, lon1)
+	x2, y2 := g.cellOf(lat2, lon2)
+
+	count := 0
+	for _, cell := range g.cellsIn(x1, y1, x2, y2) {
+		cellLat1, cellLat2, cellLon1, cellLon2 := g.bounds(cell.x, cell.y, cell.x, cell.y)
+		whole := lat1 <= cellLat1 && cellLat2 <= lat2 && lon1 <= cellLon1 && cellLon2 <= lon2
+
+		cell.mu.RLock()
+		if whole {
+			count += len(cell.objects)
+		} else {
+			for _, location := range cell.objects {
+				if location.lon >= lon1 && location.lon <= lon2 && location.lat >= lat1 && location.lat <= lat2 {
+					count++
+				}
+			}
+		}
+		cell.mu.RUnlock()
+	}
+
+	return count
+}
+
+// CountPolygon returns how many locations are inside the polygon, taking the cells fully inside it by their size.
+func (g *gridIndex) CountPolygon(polygon *Polygon) int {
+	x1, y1 := g.cellOf(polygon.bounds.lat1, polygon.bounds.lon1)
+	x2, y2 := g.cellOf(polygon.bounds.lat2, polygon.bounds.lon2)
+
+	count := 0
+	for _, cell := range g.cellsIn(x1, y1, x2, y2) {
+		relation := polygon.classify(g.bounds(cell.x, cell.y, cell.x, cell.y))
+		if relation == cellOutside {
+			continue
+		}
+
+		cell.mu.RLock()
+		if relation == cellInside {
+			count += len(cell.objects)
+		} else {
+			for _, location := range cell.objects {
+				if polygon.Contains(location.lat, location.lon) {
+					count++
+				}
+			}
+		}
+		cell.mu.RUnlock()
+	}
+
+	return count
+}
+
+func (g *gridIndex) Nearest(lat, lon float64, k int, maxDistance float64, filter Filter) []Neighbor {
+	return nearest(gridBlock{grid: g, size: g.side}, lat, lon, k, maxDistance, filter)
+}
+
+// gridBlock is a square of size by size cells from (x, y) the nearest search opens into four smaller ones, down
+// to single cells. The grid is searched as if it was a quadtree divided all the way down.
+type gridBlock struct {
+	grid *gridIndex
+	x, y int
+	size int
+}
+
+// gridBlockScan is the size of the largest blocks checked for allocated cells before they are queued: the larger
+// ones are few, and are queued without scanning their cells.
+const gridBlockScan = 8
+
+func (b gridBlock) open(region func(region nearestRegion, lat1, lat2, lon1, lon2 float64), location func(location *Location)) {
+	if b.size == 1 {
+		cell := b.grid.cell(b.x, b.y, false)
+		if cell == nil {
+			return
+		}
+
+		cell.mu.RLock()
+		for _, loc := range cell.objects {
+			location(loc)
+		}
+		cell.mu.RUnlock()
+
+		return
+	}
+
+	half := b.size / 2
+	for _, child := range []gridBlock{
+		{grid: b.grid, x: b.x, y: b.y, size: half},
+		{grid: b.grid, x: b.x + half, y: b.y, size: half},
+		{grid: b.grid, x: b.x, y: b.y + half, size: half},
+		{grid: b.grid, x: b.x + half, y: b.y + half, size: half},
+	} {
+		if half <= gridBlockScan && len(b.grid.cellsIn(child.x, child.y, child.x+half-1, child.y+half-1)) == 0 {
+			continue
+		}
+
+		lat1, lat2, lon1, lon2 := b.grid.bounds(child.x, child.y, child.x+half-1, child.y+half-1)
+		region(child, lat1, lat2, lon1, lon2)
+	}
+}
+
+// change runs change under the lock of the cell holding the location.
+func (g *gridIndex) change(location *Location, change func()) {
+	cell := g.cellAt(location)
+	if cell == nil {
+		change()
+		return
+	}
+
+	cell.mu.Lock()
+	change()
+	cell.mu.Unlock()
+}
+
+// stats counts the allocated cells as grids and those holding locations as leaves. The depth is the grid's level.
+func (g *gridIndex) stats(stats *Stats) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	stats.Grids += len(g.cells)
+	for _, cell := range g.cells {
+		cell.mu.RLock()
+		if len(cell.objects) > 0 {
+			stats.Leaves++
+		}
+		cell.mu.RUnlock()
+	}
+	stats.Depth = max(stats.Depth, g.level)
+}
+
+func (g *gridIndex) verify(visit func(id string, location *Location), report func(format string, args ...any)) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	for key, cell := range g.cells {
+		if key != g.key(cell.x, cell.y) {
+			report("cell %d,%d is stored under %d", cell.x, cell.y, key)
+		}
+
+		cell.mu.RLock()
+		for id, location := range cell.objects {
+			visit(id, location)
+
+			if location == nil {
+				continue
+			}
+
+			if x, y := g.cellOf(location.lat, location.lon); x != cell.x || y != cell.y {
+				report("cell %s holds location %s at %f,%f, which belongs to cell %d,%d", cell, id, location.lat, location.lon, x, y)
+			}
+		}
+		cell.mu.RUnlock()
+	}
+}
+
+func (c *gridCell) String() string {
+	return fmt.Sprintf("%d,%d", c.x, c.y)
+}
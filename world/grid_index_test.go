@@ -0,0 +1,63 @@
+package world
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGridIndex(t *testing.T) {
+	t.Parallel()
+
+	options := backendOptions(IndexGrid, Extent{Lat1: 0, Lat2: 16, Lon1: 0, Lon2: 16})
+
+	t.Run("should put a point on an edge in the cell after it", func(t *testing.T) {
+		t.Parallel()
+		grid := newGridIndex(options)
+
+		x, y := grid.cellOf(0, 0)
+		assert.Equal(t, []int{0, 0}, []int{x, y})
+		x, y = grid.cellOf(1, 2)
+		assert.Equal(t, []int{2, 1}, []int{x, y})
+		x, y = grid.cellOf(16, 16)
+		assert.Equal(t, []int{15, 15}, []int{x, y}, "the far edges belong to the last cells")
+		x, y = grid.cellOf(-5, 40)
+		assert.Equal(t, []int{15, 0}, []int{x, y})
+	})
+
+	t.Run("should only allocate the cells it uses", func(t *testing.T) {
+		t.Parallel()
+		grid := newGridIndex(options)
+
+		for i := 0; i < 10; i++ {
+			location, err := NewLocation("ns", strconv.Itoa(i), 3.5, 4.5)
+			assert.NoError(t, err)
+			assert.NoError(t, grid.Insert(location))
+		}
+
+		var stats Stats
+		grid.stats(&stats)
+		assert.Equal(t, 1, stats.Grids)
+		assert.Equal(t, 1, stats.Leaves)
+		assert.Equal(t, 4, stats.Depth)
+	})
+
+	t.Run("should move a location to the cell of its new coordinates", func(t *testing.T) {
+		t.Parallel()
+		grid := newGridIndex(options)
+		location, err := NewLocation("ns", "a", 3.5, 4.5)
+		assert.NoError(t, err)
+		assert.NoError(t, grid.Insert(location))
+
+		assert.NoError(t, grid.Move(location, 12.5, 1.5))
+		assert.Empty(t, grid.cell(4, 3, false).objects)
+		assert.Same(t, location, grid.cell(1, 12, false).objects["a"])
+
+		assert.ErrorIs(t, grid.Move(location, 100, 1.5), ErrLocationInvalidLatitude)
+		assert.Same(t, location, grid.cell(1, 12, false).objects["a"])
+
+		grid.Remove(location)
+		assert.Empty(t, grid.cell(1, 12, false).objects)
+	})
+}
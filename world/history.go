@@ -0,0 +1,266 @@
+package world
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"time"
+)
+
+var (
+	ErrInvalidHistory  = errors.New("history size and age must be positive, both 0 turn history off")
+	ErrHistoryDisabled = errors.New("history is not kept for this namespace")
+)
+
+// Position is where a location was reported, and when.
+type Position struct {
+	Lat float64
+	Lon float64
+	At  time.Time
+}
+
+// Sighting is a position of a location found by a time-sliced query.
+type Sighting struct {
+	Id       string
+	Position Position
+}
+
+// minTrailCapacity is the room a trail with no count limit starts with.
+const minTrailCapacity = 8
+
+// trail is a ring buffer of a location's latest positions, oldest first.
+type trail struct {
+	positions []Position
+	start     int
+	size      int
+}
+
+// push records a position, dropping the oldest one when the trail holds limit positions already (0 for no count
+// limit, the trail then growing as needed), and the ones older than maxAge (0 for no age limit). The latest
+// position is always kept.
+func (t *trail) push(position Position, limit int, maxAge time.Duration) {
+	capacity := limit
+	if limit == 0 {
+		capacity = len(t.positions)
+		if t.size == capacity {
+			capacity = max(2*capacity, minTrailCapacity)
+		}
+	}
+
+	if len(t.positions) != capacity {
+		t.resize(capacity)
+	}
+
+	end := (t.start + t.size) % capacity
+	t.positions[end] = position
+	if t.size < capacity {
+		t.size++
+	} else {
+		t.start = (t.start + 1) % capacity
+	}
+
+	if maxAge > 0 {
+		oldest := position.At.Add(-maxAge)
+		for t.size > 1 && t.positions[t.start].At.Before(oldest) {
+			t.positions[t.start] = Position{}
+			t.start = (t.start + 1) % capacity
+			t.size--
+		}
+	}
+}
+
+// resize keeps the latest positions that fit in a trail of the new limit.
+func (t *trail) resize(limit int) {
+	kept := t.all()
+	if len(kept) > limit {
+		kept = kept[len(kept)-limit:]
+	}
+
+	t.positions = make([]Position, limit)
+	t.start = 0
+	t.size = copy(t.positions, kept)
+}
+
+// clone returns a copy of the trail, nil for none.
+func (t *trail) clone() *trail {
+	if t == nil {
+		return nil
+	}
+
+	return &trail{positions: slices.Clone(t.positions), start: t.start, size: t.size}
+}
+
+func (t *trail) all() []Position {
+	positions := make([]Position, 0, t.size)
+	for i := 0; i < t.size; i++ {
+		positions = append(positions, t.positions[(t.start+i)%len(t.positions)])
+	}
+
+	return positions
+}
+
+// SetHistory makes the namespace keep the last size positions of each location (0 for no count limit), none older
+// than maxAge if it is not 0. A size and an age of 0 turn history off and forget it. The setting is logged and
+// snapshotted like the default TTL, but the positions are kept in memory only, from the saves this node receives.
+func (n *Namespace) SetHistory(size int, maxAge time.Duration) error {
+	if size < 0 || maxAge < 0 {
+		return ErrInvalidHistory
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.dropped {
+		return errNamespaceDropped
+	}
+
+	if n.journal != nil {
+		err := n.journal.Append(Mutation{Op: OpHistory, Ns: n.Name, HistorySize: size, HistoryAge: maxAge})
+		if err != nil {
+			return err
+		}
+	}
+
+	n.historySize = size
+	n.historyAge = maxAge
+
+	if !n.keepsHistory() {
+		for loc := range n.locations.all() {
+			loc.trail = nil
+		}
+	}
+
+	return nil
+}
+
+// keepsHistory tells if the namespace keeps the positions of its locations, bounded by their count, their age or
+// both. Call it under n.mu.
+func (n *Namespace) keepsHistory() bool {
+	return n.historySize > 0 || n.historyAge > 0
+}
+
+// record adds the location's current position to its trail. Call it under n.mu.
+func (n *Namespace) record(loc *Location) {
+	if !n.keepsHistory() {
+		return
+	}
+
+	if loc.trail == nil {
+		loc.trail = &trail{}
+	}
+
+	loc.trail.push(Position{Lat: loc.lat, Lon: loc.lon, At: loc.updatedAt}, n.historySize, n.historyAge)
+}
+
+// History returns the positions of a location reported between since and until, both included, oldest first.
+// A zero since or until leaves that side open.
+func (n *Namespace) History(id string, since, until time.Time) ([]Position, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if !n.keepsHistory() {
+		return nil, ErrHistoryDisabled
+	}
+
+	loc, ok := n.locations.get(id)
+	if !ok || loc.trail == nil {
+		return nil, nil
+	}
+
+	var positions []Position
+	for _, position := range loc.trail.all() {
+		if !since.IsZero() && position.At.Before(since) || !until.IsZero() && position.At.After(until) {
+			continue
+		}
+		positions = append(positions, position)
+	}
+
+	return positions, nil
+}
+
+// QueryRangeBetween returns the positions in the range held at some point between since and until. A position
+// holds from the time it was reported until the next one, so a location that stood still in the range the whole
+// time is found even though it did not report then. A zero since or until leaves that side open. Results are
+// sorted by id, then oldest first.
+//
+// Historic positions are not in the tree, so every trail of the namespace is checked.
+func (n *Namespace) QueryRangeBetween(lat1, lat2, lon1, lon2 float64, since, until time.Time) ([]Sighting, error) {
+	boxes := splitRange(lat1, lat2, lon1, lon2)
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if !n.keepsHistory() {
+		return nil, ErrHistoryDisabled
+	}
+
+	var sightings []Sighting
+
+	for loc := range n.locations.all() {
+		id := loc.id
+		if loc.trail == nil {
+			continue
+		}
+
+		positions := loc.trail.all()
+		for i, position := range positions {
+			if !until.IsZero() && position.At.After(until) {
+				break
+			}
+
+			if i+1 < len(positions) && !positions[i+1].At.After(since) {
+				continue
+			}
+
+			if boxesContain(boxes, position.Lat, position.Lon) {
+				sightings = append(sightings, Sighting{Id: id, Position: position})
+			}
+		}
+	}
+
+	sort.Slice(sightings, func(i, j int) bool {
+		if sightings[i].Id != sightings[j].Id {
+			return sightings[i].Id < sightings[j].Id
+		}
+		return sightings[i].Position.At.Before(sightings[j].Position.At)
+	})
+
+	return sightings, nil
+}
+
+func boxesContain(boxes []box, lat, lon float64) bool {
+	for _, b := range boxes {
+		if b.lat1 <= lat && lat <= b.lat2 && b.lon1 <= lon && lon <= b.lon2 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetHistory sets how much history a namespace keeps. See Namespace.SetHistory.
+func (m *World) SetHistory(ns string, size int, maxAge time.Duration) error {
+	return m.write(ns, func(namespace *Namespace) error {
+		return namespace.SetHistory(size, maxAge)
+	})
+}
+
+// History returns the positions of a location reported between since and until. See Namespace.History.
+func (m *World) History(ns, id string, since, until time.Time) ([]Position, error) {
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return nil, ErrHistoryDisabled
+	}
+
+	return namespace.History(id, since, until)
+}
+
+// QueryRangeBetween returns who was in the range between since and until. See Namespace.QueryRangeBetween.
+func (m *World) QueryRangeBetween(ns string, lat1, lat2, lon1, lon2 float64, since, until time.Time) ([]Sighting, error) {
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return nil, ErrHistoryDisabled
+	}
+
+	return namespace.QueryRangeBetween(lat1, lat2, lon1, lon2, since, until)
+}
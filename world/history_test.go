@@ -0,0 +1,182 @@
+package world
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should keep the latest positions, oldest first", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.SetHistory("ns", 3, 0))
+
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, world.Save("ns", "a", float64(i), float64(i)))
+		}
+
+		positions, err := world.History("ns", "a", time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		assert.Len(t, positions, 3)
+		for i, position := range positions {
+			assert.Equal(t, float64(i+2), position.Lat)
+		}
+		assert.False(t, positions[2].At.Before(positions[0].At))
+
+		positions, err = world.History("ns", "a", positions[2].At, time.Time{})
+		assert.NoError(t, err)
+		assert.Equal(t, 4.0, positions[len(positions)-1].Lat)
+
+		positions, err = world.History("ns", "unknown", time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		assert.Empty(t, positions)
+	})
+
+	t.Run("should drop positions older than the max age but the latest", func(t *testing.T) {
+		t.Parallel()
+		now := time.Now()
+		trail := &trail{}
+
+		trail.push(Position{Lat: 1, At: now.Add(-time.Hour)}, 10, time.Minute)
+		trail.push(Position{Lat: 2, At: now.Add(-2 * time.Minute)}, 10, time.Minute)
+		assert.Equal(t, []Position{{Lat: 2, At: now.Add(-2 * time.Minute)}}, trail.all())
+
+		trail.push(Position{Lat: 3, At: now.Add(-30 * time.Second)}, 10, time.Minute)
+		trail.push(Position{Lat: 4, At: now}, 10, time.Minute)
+		assert.Equal(t, []Position{{Lat: 3, At: now.Add(-30 * time.Second)}, {Lat: 4, At: now}}, trail.all())
+	})
+
+	t.Run("should bound the history by age only when its size is 0", func(t *testing.T) {
+		t.Parallel()
+		now := time.Now()
+		trail := &trail{}
+
+		for i := 0; i < 20; i++ {
+			trail.push(Position{Lat: float64(i), At: now.Add(time.Duration(i-20) * time.Minute)}, 0, time.Hour)
+		}
+		assert.Len(t, trail.all(), 20)
+		assert.Equal(t, 0.0, trail.all()[0].Lat)
+
+		trail.push(Position{Lat: 20, At: now.Add(50 * time.Minute)}, 0, time.Hour)
+		assert.Len(t, trail.all(), 11)
+		assert.Equal(t, 10.0, trail.all()[0].Lat)
+
+		world := NewWorld()
+		assert.NoError(t, world.SetHistory("ns", 0, time.Hour))
+		assert.NoError(t, world.Save("ns", "a", 1, 1))
+		assert.NoError(t, world.Save("ns", "a", 2, 2))
+
+		positions, err := world.History("ns", "a", time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		assert.Len(t, positions, 2)
+	})
+
+	t.Run("should keep the latest positions when resized", func(t *testing.T) {
+		t.Parallel()
+		trail := &trail{}
+		for i := 0; i < 7; i++ {
+			trail.push(Position{Lat: float64(i)}, 5, 0)
+		}
+
+		trail.resize(2)
+		assert.Equal(t, []Position{{Lat: 5}, {Lat: 6}}, trail.all())
+
+		trail.push(Position{Lat: 7}, 4, 0)
+		assert.Equal(t, []Position{{Lat: 5}, {Lat: 6}, {Lat: 7}}, trail.all())
+	})
+
+	t.Run("should refuse history queries when it is off", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.Save("ns", "a", 1, 1))
+
+		_, err := world.History("ns", "a", time.Time{}, time.Time{})
+		assert.ErrorIs(t, err, ErrHistoryDisabled)
+
+		_, err = world.QueryRangeBetween("ns", -90, 90, -180, 180, time.Time{}, time.Time{})
+		assert.ErrorIs(t, err, ErrHistoryDisabled)
+
+		assert.ErrorIs(t, world.SetHistory("ns", -1, 0), ErrInvalidHistory)
+		assert.ErrorIs(t, world.SetHistory("ns", 1, -time.Second), ErrInvalidHistory)
+	})
+
+	t.Run("should forget the history when turned off", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.SetHistory("ns", 10, 0))
+		assert.NoError(t, world.Save("ns", "a", 1, 1))
+		assert.NoError(t, world.SetHistory("ns", 0, 0))
+		assert.NoError(t, world.SetHistory("ns", 10, 0))
+
+		positions, err := world.History("ns", "a", time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		assert.Empty(t, positions)
+	})
+
+	t.Run("should find who was in the range between two times", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.SetHistory("ns", 10, 0))
+
+		assert.NoError(t, world.Save("ns", "still", 1, 1))
+		assert.NoError(t, world.Save("ns", "moving", 1, 2))
+		first, _ := world.History("ns", "moving", time.Time{}, time.Time{})
+		time.Sleep(time.Millisecond)
+		assert.NoError(t, world.Save("ns", "moving", 50, 50))
+		moved, _ := world.History("ns", "moving", time.Time{}, time.Time{})
+		time.Sleep(time.Millisecond)
+		assert.NoError(t, world.Save("ns", "late", 1, 3))
+
+		left := moved[1].At
+		sightings, err := world.QueryRangeBetween("ns", 0, 10, 0, 10, first[0].At, first[0].At)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"moving", "still"}, sightingIds(sightings))
+
+		// Once moving left, only still stood in the range, late had not arrived yet.
+		sightings, err = world.QueryRangeBetween("ns", 0, 10, 0, 10, left, left)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"still"}, sightingIds(sightings))
+
+		sightings, err = world.QueryRangeBetween("ns", 0, 10, 0, 10, time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"late", "moving", "still"}, sightingIds(sightings))
+
+		sightings, err = world.QueryRangeBetween("ns", 40, 60, 40, 60, time.Time{}, first[0].At)
+		assert.NoError(t, err)
+		assert.Empty(t, sightings)
+	})
+
+	t.Run("should sort sightings by id then time", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.SetHistory("ns", 100, 0))
+
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, world.Save("ns", strconv.Itoa(i%4), float64(i), float64(i)))
+		}
+
+		sightings, err := world.QueryRangeBetween("ns", -90, 90, -180, 180, time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		assert.Len(t, sightings, 20)
+		for i := 1; i < len(sightings); i++ {
+			previous, current := sightings[i-1], sightings[i]
+			assert.True(t, previous.Id < current.Id || previous.Id == current.Id && previous.Position.Lat < current.Position.Lat)
+		}
+	})
+}
+
+func sightingIds(sightings []Sighting) []string {
+	var ids []string
+	for _, sighting := range sightings {
+		if len(ids) == 0 || ids[len(ids)-1] != sighting.Id {
+			ids = append(ids, sighting.Id)
+		}
+	}
+
+	return ids
+}
@@ -0,0 +1,131 @@
+package world
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+)
+
+const (
+	// IndexQuadtree divides cells into four as they fill up and merges them back as they empty. It suits
+	// namespaces whose crowds move around, dense in places and sparse elsewhere.
+	IndexQuadtree = "quadtree"
+	// IndexGrid cuts the extent into a fixed grid of cells, like geohashes of one precision. Saves and moves cost
+	// the same wherever the locations are, which suits dense namespaces over a small extent.
+	IndexGrid = "grid"
+	// IndexRTree groups nearby locations into bounding rectangles, which follow the data rather than the extent.
+	// It suits sparse namespaces spread over the globe.
+	IndexRTree = "rtree"
+)
+
+var (
+	ErrInvalidIndexBackend = errors.New("index backend must be quadtree, grid or rtree")
+)
+
+// SpatialIndex is where a namespace keeps its locations for the area and nearest queries. The namespace calls
+// the changes under its own lock, one at a time; the walks run alongside them and only see whole changes.
+type SpatialIndex interface {
+	// Insert adds a location the index does not have yet.
+	Insert(location *Location) error
+	// Move changes the coordinates of a location of the index and moves it where they now put it.
+	Move(location *Location, lat, lon float64) error
+	// Remove takes the location out of the index.
+	Remove(location *Location)
+	// Walk calls visit for the locations within the range, bounds included, that match the filter, until visit
+	// returns false. It visits copies, taken a part of the index at a time under that part's lock and visited once
+	// the lock is released, so visit holds up no change however long it takes. The range must not cross the
+	// antimeridian: see splitRange.
+	Walk(lat1, lat2, lon1, lon2 float64, filter Filter, visit func(location *Location) bool)
+	// WalkPolygon is Walk for the locations inside the polygon.
+	WalkPolygon(polygon *Polygon, filter Filter, visit func(location *Location) bool)
+	// Count returns how many locations Walk would visit. The parts of the index the range covers whole are
+	// counted without visiting their locations.
+	Count(lat1, lat2, lon1, lon2 float64) int
+	// CountPolygon returns how many locations WalkPolygon would visit, the same way.
+	CountPolygon(polygon *Polygon) int
+	// Nearest returns the k locations closest to (lat, lon) that match the filter, closest first. Locations
+	// further than maxDistance meters are ignored, unless maxDistance is 0.
+	Nearest(lat, lon float64, k int, maxDistance float64, filter Filter) []Neighbor
+
+	// change runs change under the lock the walks read the location under, so they never see it half changed.
+	change(location *Location, change func())
+	// stats adds the shape of the index to the namespace's statistics.
+	stats(stats *Stats)
+	// verify calls visit for every location the index holds, under the id it holds it under, and report for
+	// whatever is out of shape in the index itself. The namespace's lock is held.
+	verify(visit func(id string, location *Location), report func(format string, args ...any))
+}
+
+// newSpatialIndex returns an empty index of the options' backend. The options are expected to be valid.
+func newSpatialIndex(options IndexOptions) SpatialIndex {
+	switch options.Backend {
+	case IndexGrid:
+		return newGridIndex(options)
+	case IndexRTree:
+		return newRTree(options)
+	default:
+		return NewQuadTreeWithOptions(options)
+	}
+}
+
+// visitAll calls visit for the locations until it returns false, and returns false if it did.
+func visitAll(locations []*Location, visit func(location *Location) bool) bool {
+	for _, location := range locations {
+		if !visit(location) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// nearestRegion is a part of an index the nearest search opens: into smaller regions, along with their bounds,
+// or into locations.
+type nearestRegion interface {
+	open(region func(region nearestRegion, lat1, lat2, lon1, lon2 float64), location func(location *Location))
+}
+
+// nearest returns the k locations under the root closest to (lat, lon) that match the filter, closest first.
+// Locations further than maxDistance meters are ignored, unless maxDistance is 0.
+//
+// The index is walked best-first: regions and locations share one priority queue keyed by distance, and a
+// region's key is a lower bound of the distance to anything inside it. So when a location comes out of the
+// queue, nothing left in it can be closer, and the walk stops as soon as k locations came out. The locations
+// are queued as copies, taken while their region is open, so the saves going on meanwhile do not change them.
+func nearest(root nearestRegion, lat, lon float64, k int, maxDistance float64, filter Filter) []Neighbor {
+	if maxDistance <= 0 {
+		maxDistance = math.Inf(1)
+	}
+
+	queue := &nearestQueue{}
+	heap.Push(queue, nearestCandidate{region: root})
+
+	neighbors := make([]Neighbor, 0, k)
+
+	for queue.Len() > 0 && len(neighbors) < k {
+		candidate := heap.Pop(queue).(nearestCandidate)
+
+		if candidate.location != nil {
+			neighbors = append(neighbors, Neighbor{Location: candidate.location, Distance: candidate.distance})
+			continue
+		}
+
+		candidate.region.open(func(region nearestRegion, lat1, lat2, lon1, lon2 float64) {
+			distance := minDistanceToRect(lat, lon, lat1, lat2, lon1, lon2)
+			if distance <= maxDistance {
+				heap.Push(queue, nearestCandidate{region: region, distance: distance})
+			}
+		}, func(location *Location) {
+			if !filter.Match(location) {
+				return
+			}
+
+			distance := haversine(lat, lon, location.Lat(), location.Lon())
+			if distance <= maxDistance {
+				heap.Push(queue, nearestCandidate{location: location.walkCopy(), distance: distance})
+			}
+		})
+	}
+
+	return neighbors
+}
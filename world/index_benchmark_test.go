@@ -0,0 +1,94 @@
+package world
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// BenchmarkSpatialIndex compares the backends on a dense city namespace and a sparse global one:
+//
+//	go test ./world -run xxx -bench SpatialIndex
+func BenchmarkSpatialIndex(b *testing.B) {
+	const locations = 50000
+
+	scenarios := []struct {
+		name    string
+		options func(backend string) IndexOptions
+		// query is a range about a hundredth of the extent wide.
+		query Extent
+	}{
+		{
+			name: "dense city",
+			options: func(backend string) IndexOptions {
+				return IndexOptions{Backend: backend, Capacity: 64, PreDivide: 7, MaxDepth: 20, Extent: Extent{Lat1: 48.8, Lat2: 48.9, Lon1: 2.2, Lon2: 2.5}}
+			},
+			query: Extent{Lat1: 48.85, Lat2: 48.851, Lon1: 2.35, Lon2: 2.353},
+		},
+		{
+			name: "sparse globe",
+			options: func(backend string) IndexOptions {
+				options := DefaultIndexOptions()
+				options.Backend = backend
+				if backend == IndexRTree {
+					options.Capacity = 64
+				}
+
+				return options
+			},
+			query: Extent{Lat1: 1.16, Lat2: 2.96, Lon1: 103.6, Lon2: 107.2},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		for _, backend := range backends {
+			options := scenario.options(backend)
+			extent := options.Extent
+
+			world := NewWorld()
+			err := world.CreateNamespace("ns", options)
+			if err != nil {
+				b.Fatalf("Error creating namespace: %v", err)
+			}
+
+			random := rand.New(rand.NewSource(1))
+			randomPoint := func() (float64, float64) {
+				return extent.Lat1 + random.Float64()*(extent.Lat2-extent.Lat1), extent.Lon1 + random.Float64()*(extent.Lon2-extent.Lon1)
+			}
+
+			for i := 0; i < locations; i++ {
+				lat, lon := randomPoint()
+				err := world.Save("ns", strconv.Itoa(i), lat, lon)
+				if err != nil {
+					b.Fatalf("Error saving location: %v", err)
+				}
+			}
+
+			b.Run(scenario.name+"/"+backend+"/Move", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					lat, lon := randomPoint()
+					err := world.Save("ns", strconv.Itoa(i%locations), lat, lon)
+					if err != nil {
+						b.Fatalf("Error saving location: %v", err)
+					}
+				}
+			})
+
+			b.Run(scenario.name+"/"+backend+"/QueryRange", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					_ = world.QueryRange("ns", scenario.query.Lat1, scenario.query.Lat2, scenario.query.Lon1, scenario.query.Lon2)
+				}
+			})
+
+			b.Run(scenario.name+"/"+backend+"/Nearest 10", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					lat, lon := randomPoint()
+					_, err := world.Nearest("ns", lat, lon, 10, 0)
+					if err != nil {
+						b.Fatalf("Error searching neighbors: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
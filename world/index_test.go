@@ -0,0 +1,196 @@
+package world
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var backends = []string{IndexQuadtree, IndexGrid, IndexRTree}
+
+// backendOptions returns small index options of the backend over the extent, so a few hundred locations
+// divide, split and merge them.
+func backendOptions(backend string, extent Extent) IndexOptions {
+	return IndexOptions{Backend: backend, Capacity: 8, PreDivide: 4, MaxDepth: 12, Extent: extent}
+}
+
+func ids(locations []*Location) []string {
+	var ids []string
+	for _, location := range locations {
+		ids = append(ids, location.Id())
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func TestSpatialIndex(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			t.Parallel()
+
+			for name, extent := range map[string]Extent{
+				"city":  {Lat1: 48.8, Lat2: 48.9, Lon1: 2.2, Lon2: 2.5},
+				"globe": {Lat1: -90, Lat2: 90, Lon1: -180, Lon2: 180},
+			} {
+				t.Run("should answer like a scan over the "+name, func(t *testing.T) {
+					t.Parallel()
+					world := NewWorld()
+					assert.NoError(t, world.CreateNamespace("ns", backendOptions(backend, extent)))
+
+					random := rand.New(rand.NewSource(1))
+					randomPoint := func() (float64, float64) {
+						return extent.Lat1 + random.Float64()*(extent.Lat2-extent.Lat1), extent.Lon1 + random.Float64()*(extent.Lon2-extent.Lon1)
+					}
+
+					for i := 0; i < 300; i++ {
+						lat, lon := randomPoint()
+						assert.NoError(t, world.Save("ns", strconv.Itoa(i), lat, lon))
+					}
+					for i := 0; i < 300; i += 3 {
+						lat, lon := randomPoint()
+						assert.NoError(t, world.Save("ns", strconv.Itoa(i), lat, lon))
+					}
+					for i := 1; i < 300; i += 5 {
+						assert.NoError(t, world.Delete("ns", strconv.Itoa(i)))
+					}
+					assert.NoError(t, world.Verify())
+
+					all := world.QueryRange("ns", extent.Lat1, extent.Lat2, extent.Lon1, extent.Lon2)
+					assert.Len(t, all, 240)
+					assert.Equal(t, 240, world.Count("ns", extent.Lat1, extent.Lat2, extent.Lon1, extent.Lon2, nil))
+
+					for q := 0; q < 20; q++ {
+						lat1, lon1 := randomPoint()
+						lat2, lon2 := randomPoint()
+						lat1, lat2 = min(lat1, lat2), max(lat1, lat2)
+						lon1, lon2 = min(lon1, lon2), max(lon1, lon2)
+
+						var expected []*Location
+						for _, location := range all {
+							if lat1 <= location.Lat() && location.Lat() <= lat2 && lon1 <= location.Lon() && location.Lon() <= lon2 {
+								expected = append(expected, location)
+							}
+						}
+						assert.Equal(t, ids(expected), ids(world.QueryRange("ns", lat1, lat2, lon1, lon2)))
+						assert.Equal(t, len(expected), world.Count("ns", lat1, lat2, lon1, lon2, nil))
+
+						polygon, err := NewPolygon([][]Point{{{Lat: lat1, Lon: lon1}, {Lat: lat2, Lon: lon1}, {Lat: lat2, Lon: lon2}, {Lat: lat1, Lon: lon1}}})
+						assert.NoError(t, err)
+
+						expected = nil
+						for _, location := range all {
+							if polygon.Contains(location.Lat(), location.Lon()) {
+								expected = append(expected, location)
+							}
+						}
+						assert.Equal(t, ids(expected), ids(world.QueryPolygon("ns", polygon)))
+						assert.Equal(t, len(expected), world.CountPolygon("ns", polygon, nil))
+
+						lat, lon := randomPoint()
+						sort.Slice(all, func(i, j int) bool {
+							return haversine(lat, lon, all[i].Lat(), all[i].Lon()) < haversine(lat, lon, all[j].Lat(), all[j].Lon())
+						})
+
+						neighbors, err := world.Nearest("ns", lat, lon, 5, 0)
+						assert.NoError(t, err)
+						assert.Len(t, neighbors, 5)
+						for i, neighbor := range neighbors {
+							assert.Equal(t, all[i].Id(), neighbor.Location.Id())
+						}
+					}
+				})
+			}
+
+			t.Run("should walk a range across the antimeridian", func(t *testing.T) {
+				t.Parallel()
+				world := NewWorld()
+				assert.NoError(t, world.CreateNamespace("ns", backendOptions(backend, DefaultIndexOptions().Extent)))
+				assert.NoError(t, world.Save("ns", "fiji", -17.7, 178.1))
+				assert.NoError(t, world.Save("ns", "samoa", -13.8, -171.8))
+				assert.NoError(t, world.Save("ns", "sydney", -33.9, 151.2))
+
+				assert.Equal(t, []string{"fiji", "samoa"}, ids(world.QueryRange("ns", -20, -10, 170, -170)))
+
+				neighbors, err := world.Nearest("ns", -15, 179.9, 2, 0)
+				assert.NoError(t, err)
+				assert.Equal(t, "fiji", neighbors[0].Location.Id())
+				assert.Equal(t, "samoa", neighbors[1].Location.Id())
+			})
+
+			t.Run("should visit copies outside of the index's locks until told to stop", func(t *testing.T) {
+				t.Parallel()
+				world := NewWorld()
+				assert.NoError(t, world.CreateNamespace("ns", backendOptions(backend, DefaultIndexOptions().Extent)))
+				for i := 0; i < 100; i++ {
+					assert.NoError(t, world.Save("ns", strconv.Itoa(i), float64(i%80), float64(i)))
+				}
+
+				polygon, err := NewPolygon([][]Point{{{Lat: -1, Lon: -1}, {Lat: 89, Lon: -1}, {Lat: 89, Lon: 101}, {Lat: -1, Lon: -1}}})
+				assert.NoError(t, err)
+
+				for name, walk := range map[string]func(visit func(location *Location) bool){
+					"range": func(visit func(location *Location) bool) {
+						world.WalkRange("ns", -90, 90, -180, 180, nil, visit)
+					},
+					"polygon": func(visit func(location *Location) bool) {
+						world.WalkPolygon("ns", polygon, nil, visit)
+					},
+				} {
+					done := make(chan int)
+					go func() {
+						visits := 0
+						walk(func(location *Location) bool {
+							visits++
+							lon := location.Lon()
+
+							// The save locks the part of the index holding the location: it would wait forever on a
+							// visit running under that part's read lock.
+							assert.NoError(t, world.Save("ns", location.Id(), location.Lat(), lon+0.5))
+							assert.Equal(t, lon, location.Lon(), "the visit should get a copy")
+
+							return visits < 3
+						})
+						done <- visits
+					}()
+
+					select {
+					case visits := <-done:
+						assert.Equal(t, 3, visits, name)
+					case <-time.After(5 * time.Second):
+						t.Fatalf("the %s walk still holds a lock of the index while visiting", name)
+					}
+				}
+			})
+
+			t.Run("should rebuild the namespace's index with another backend", func(t *testing.T) {
+				t.Parallel()
+				world := NewWorld()
+				for i := 0; i < 50; i++ {
+					assert.NoError(t, world.Save("ns", strconv.Itoa(i), float64(i), float64(i)))
+				}
+
+				assert.NoError(t, world.CreateNamespace("ns", backendOptions(backend, DefaultIndexOptions().Extent)))
+				assert.Len(t, world.QueryRange("ns", -90, 90, -180, 180), 50)
+				assert.NoError(t, world.Verify())
+
+				stats, err := world.Stats("ns")
+				assert.NoError(t, err)
+				assert.Equal(t, backend, stats.Options.Backend)
+				assert.Positive(t, stats.Leaves)
+			})
+		})
+	}
+
+	t.Run("should refuse an unknown backend", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.ErrorIs(t, world.CreateNamespace("ns", backendOptions("btree", DefaultIndexOptions().Extent)), ErrInvalidIndexBackend)
+	})
+}
@@ -0,0 +1,85 @@
+package world
+
+import "time"
+
+// Operation identifies the kind of change carried by a Mutation.
+type Operation uint8
+
+const (
+	OpSave Operation = iota + 1
+	OpDelete
+	OpDefaultTTL
+	OpHistory
+	OpFenceAdd
+	OpFenceDelete
+	OpCreateNamespace
+	OpDropNamespace
+	OpRenameNamespace
+)
+
+// Mutation is a single change successfully applied to the world.
+// Saves carry the time the location expires, zero for never, and all of its attributes after the save;
+// OpDefaultTTL carries the namespace's new default TTL and OpHistory how much history it keeps.
+// Fence changes carry the fence id in Id, and OpFenceAdd its polygon and dwell time. OpCreateNamespace carries
+// the namespace's index options, and OpRenameNamespace the new name of the namespace in Id.
+type Mutation struct {
+	Op          Operation
+	Ns          string
+	Id          string
+	Lat         float64
+	Lon         float64
+	ExpiresAt   time.Time
+	Attributes  Attributes
+	TTL         time.Duration
+	HistorySize int
+	HistoryAge  time.Duration
+	Polygon     *Polygon
+	Dwell       time.Duration
+	Options     IndexOptions
+}
+
+// Journal receives every mutation applied to the world, in the order it was applied to each location.
+// It is how the write-ahead log gets its records.
+type Journal interface {
+	Append(mutation Mutation) error
+}
+
+// SetJournal attaches a journal to the world. Attach it after replaying, otherwise the replay is logged again.
+func (m *World) SetJournal(journal Journal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.journal = journal
+	for _, namespace := range m.allNamespaces() {
+		namespace.setJournal(journal)
+	}
+}
+
+// Apply replays a mutation against the world.
+func (m *World) Apply(mutation Mutation) error {
+	switch mutation.Op {
+	case OpSave:
+		return m.write(mutation.Ns, func(namespace *Namespace) error {
+			_, err := namespace.saveLocationUntil(mutation.Id, mutation.Lat, mutation.Lon, mutation.ExpiresAt, mutation.Attributes)
+			return err
+		})
+	case OpDelete:
+		return m.Delete(mutation.Ns, mutation.Id)
+	case OpDefaultTTL:
+		return m.SetDefaultTTL(mutation.Ns, mutation.TTL)
+	case OpHistory:
+		return m.SetHistory(mutation.Ns, mutation.HistorySize, mutation.HistoryAge)
+	case OpFenceAdd:
+		return m.AddFence(mutation.Ns, mutation.Id, mutation.Polygon, mutation.Dwell)
+	case OpFenceDelete:
+		return m.DeleteFence(mutation.Ns, mutation.Id)
+	case OpCreateNamespace:
+		return m.CreateNamespace(mutation.Ns, mutation.Options)
+	case OpDropNamespace:
+		return m.DropNamespace(mutation.Ns)
+	case OpRenameNamespace:
+		return m.RenameNamespace(mutation.Ns, mutation.Id)
+	}
+
+	return ErrUnknownOperation
+}
@@ -0,0 +1,72 @@
+package world
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingJournal struct {
+	mutations []Mutation
+	err       error
+}
+
+func (j *recordingJournal) Append(mutation Mutation) error {
+	j.mutations = append(j.mutations, mutation)
+	return j.err
+}
+
+func TestJournal(t *testing.T) {
+	t.Run("should record saves and deletes on existing and new namespaces", func(t *testing.T) {
+		world := NewWorld()
+		assert.NoError(t, world.Save("existing", "a", 1, 2))
+
+		journal := &recordingJournal{}
+		world.SetJournal(journal)
+
+		assert.NoError(t, world.Save("existing", "a", 3, 4))
+		assert.NoError(t, world.Save("new", "b", 5, 6))
+		assert.NoError(t, world.Delete("existing", "a"))
+		assert.NoError(t, world.Delete("new", "unknown"))
+
+		assert.Equal(t, []Mutation{
+			{Op: OpSave, Ns: "existing", Id: "a", Lat: 3, Lon: 4},
+			{Op: OpSave, Ns: "new", Id: "b", Lat: 5, Lon: 6},
+			{Op: OpDelete, Ns: "existing", Id: "a"},
+		}, journal.mutations)
+	})
+
+	t.Run("should surface journal failures without applying the change", func(t *testing.T) {
+		world := NewWorld()
+		assert.NoError(t, world.Save("ns", "a", 1, 2))
+
+		failure := errors.New("disk full")
+		world.SetJournal(&recordingJournal{err: failure})
+
+		assert.ErrorIs(t, world.Save("ns", "a", 3, 4), failure)
+		assert.ErrorIs(t, world.Save("ns", "b", 3, 4), failure)
+		assert.ErrorIs(t, world.Delete("ns", "a"), failure)
+
+		location, ok := world.GetLocation("ns", "a")
+		assert.True(t, ok)
+		assert.Equal(t, 1.0, location.Lat())
+
+		_, ok = world.GetLocation("ns", "b")
+		assert.False(t, ok)
+	})
+
+	t.Run("should apply mutations", func(t *testing.T) {
+		world := NewWorld()
+
+		assert.NoError(t, world.Apply(Mutation{Op: OpSave, Ns: "ns", Id: "a", Lat: 1, Lon: 2}))
+		_, ok := world.GetLocation("ns", "a")
+		assert.True(t, ok)
+
+		assert.NoError(t, world.Apply(Mutation{Op: OpDelete, Ns: "ns", Id: "a"}))
+		_, ok = world.GetLocation("ns", "a")
+		assert.False(t, ok)
+
+		assert.ErrorIs(t, world.Apply(Mutation{Op: Operation(99)}), ErrUnknownOperation)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -31,20 +32,19 @@ type Location struct {
 	lon       float64
 	ns        string
 	updatedAt time.Time
-	Node      *TreeNode
+	// node is the leaf owning the location. It only changes while the locks of the leaf it leaves and of the
+	// one it goes to are both held, so whoever holds the lock of the leaf it points to owns the location.
+	node atomic.Pointer[TreeNode]
+
+	expiresAt   time.Time
+	expiryIndex int
+	attributes  Attributes
+	trail       *trail
+	fences      map[string]*fenceVisit
 }
 
 func NewLocation(ns, id string, lat, lon float64) (*Location, error) {
-	if id == "" {
-		validationOps.Inc()
-		return nil, ErrLocationRequiredId
-	}
-	if ns == "" {
-		validationOps.Inc()
-		return nil, ErrLocationRequiredNamespace
-	}
-
-	if err := validateLatLon(lat, lon); err != nil {
+	if err := validateLocation(ns, id, lat, lon); err != nil {
 		return nil, err
 	}
 
@@ -60,16 +60,7 @@ func NewLocation(ns, id string, lat, lon float64) (*Location, error) {
 }
 
 func (*Location) init(ns, id string, lat, lon float64) (*Location, error) {
-	if id == "" {
-		validationOps.Inc()
-		return nil, ErrLocationRequiredId
-	}
-	if ns == "" {
-		validationOps.Inc()
-		return nil, ErrLocationRequiredNamespace
-	}
-
-	if err := validateLatLon(lat, lon); err != nil {
+	if err := validateLocation(ns, id, lat, lon); err != nil {
 		return nil, err
 	}
 
@@ -98,6 +89,20 @@ func (l *Location) Update(lat, lon float64) error {
 	return nil
 }
 
+// validateLocation checks what NewLocation needs of a location: its namespace, its id and valid coordinates.
+func validateLocation(ns, id string, lat, lon float64) error {
+	if id == "" {
+		validationOps.Inc()
+		return ErrLocationRequiredId
+	}
+	if ns == "" {
+		validationOps.Inc()
+		return ErrLocationRequiredNamespace
+	}
+
+	return validateLatLon(lat, lon)
+}
+
 func validateLatLon(lat, lon float64) error {
 
 	if lat < -90 || lat > 90 {
@@ -118,7 +123,26 @@ func (l *Location) SetNode(node *TreeNode) {
 		panic("cannot set nil node to location")
 	}
 
-	l.Node = node
+	l.node.Store(node)
+}
+
+// Node returns the leaf holding the location, nil if it was never inserted.
+func (l *Location) Node() *TreeNode {
+	return l.node.Load()
+}
+
+// walkCopy returns a copy of what the index guards of the location: its position, when it was saved and its
+// attributes. Walks take it under the lock of the part of the index holding the location, without the
+// namespace's, so it leaves out the expiry, the trail and the fences that only the namespace's lock guards.
+func (l *Location) walkCopy() *Location {
+	return &Location{
+		id:         l.id,
+		lat:        l.lat,
+		lon:        l.lon,
+		ns:         l.ns,
+		updatedAt:  l.updatedAt,
+		attributes: l.attributes,
+	}
 }
 
 func (l *Location) String() string {
@@ -140,3 +164,18 @@ func (l *Location) Lon() float64 {
 func (l *Location) Ns() string {
 	return l.ns
 }
+
+// UpdatedAt returns when the location was last saved.
+func (l *Location) UpdatedAt() time.Time {
+	return l.updatedAt
+}
+
+// ExpiresAt returns when the location expires, or the zero time if it never does.
+func (l *Location) ExpiresAt() time.Time {
+	return l.expiresAt
+}
+
+// Attributes returns the location's attributes. They must not be modified.
+func (l *Location) Attributes() Attributes {
+	return l.attributes
+}
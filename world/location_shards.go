@@ -0,0 +1,120 @@
+package world
+
+import (
+	"hash/maphash"
+	"iter"
+	"sync"
+)
+
+// locationShardCount is how many shards a namespace's locations are split in. It is a power of two, so a hash
+// picks its shard with a mask.
+const locationShardCount = 64
+
+var locationShardSeed = maphash.MakeSeed()
+
+// locationShards are a namespace's locations split by the hash of their id, so reads of different locations do
+// not contend on one lock. The writers change a location, and put it in or delete it from its shard, under the
+// namespace's lock and the shard's write lock; the readers of a single location take the shard's read lock only,
+// so they wait on no writer but the ones of their shard. Walking all of them, or counting them, is done under the
+// namespace's lock.
+type locationShards struct {
+	shards [locationShardCount]locationShard
+}
+
+type locationShard struct {
+	mu        sync.RWMutex
+	locations map[string]*Location
+	// The padding keeps the shards' locks on their own cache lines.
+	_ [32]byte
+}
+
+func (s *locationShards) shard(id string) *locationShard {
+	return &s.shards[maphash.String(locationShardSeed, id)&(locationShardCount-1)]
+}
+
+func (s *locationShards) get(id string) (*Location, bool) {
+	shard := s.shard(id)
+
+	shard.mu.RLock()
+	loc, ok := shard.locations[id]
+	shard.mu.RUnlock()
+
+	return loc, ok
+}
+
+// copy returns a copy of the location of the id as a read answers it: its position, when it was saved, when it
+// expires and its attributes. The writers change those under the shard's write lock, so the copy is whole
+// without the namespace's lock.
+func (s *locationShards) copy(id string) (Location, bool) {
+	shard := s.shard(id)
+
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	loc, ok := shard.locations[id]
+	if !ok {
+		return Location{}, false
+	}
+
+	return Location{
+		id:         loc.id,
+		lat:        loc.lat,
+		lon:        loc.lon,
+		ns:         loc.ns,
+		updatedAt:  loc.updatedAt,
+		expiresAt:  loc.expiresAt,
+		attributes: loc.attributes,
+	}, true
+}
+
+// lock write-locks the shard of the id and returns it, for a writer to change the location of the id, and put or
+// delete it, before unlocking it.
+func (s *locationShards) lock(id string) *locationShard {
+	shard := s.shard(id)
+	shard.mu.Lock()
+
+	return shard
+}
+
+func (s *locationShards) put(loc *Location) {
+	shard := s.lock(loc.id)
+	shard.put(loc)
+	shard.mu.Unlock()
+}
+
+func (s *locationShards) delete(id string) {
+	shard := s.lock(id)
+	delete(shard.locations, id)
+	shard.mu.Unlock()
+}
+
+// put adds the location to the shard. Call it under the shard's write lock.
+func (shard *locationShard) put(loc *Location) {
+	if shard.locations == nil {
+		shard.locations = map[string]*Location{}
+	}
+	shard.locations[loc.id] = loc
+}
+
+// len returns the number of locations. Call it under the namespace's lock.
+func (s *locationShards) len() int {
+	count := 0
+	for i := range s.shards {
+		count += len(s.shards[i].locations)
+	}
+
+	return count
+}
+
+// all iterates over the locations, in no particular order. Call it under the namespace's lock.
+func (s *locationShards) all() iter.Seq[*Location] {
+	return func(yield func(*Location) bool) {
+		for i := range s.shards {
+			for _, loc := range s.shards[i].locations {
+				if !yield(loc) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package world
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocationShards(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should keep locations across the shards", func(t *testing.T) {
+		t.Parallel()
+		var shards locationShards
+
+		for i := 0; i < 1000; i++ {
+			shards.put(&Location{id: strconv.Itoa(i)})
+		}
+		shards.delete("10")
+		shards.delete("unknown")
+
+		assert.Equal(t, 999, shards.len())
+
+		loc, ok := shards.get("999")
+		assert.True(t, ok)
+		assert.Equal(t, "999", loc.id)
+
+		_, ok = shards.get("10")
+		assert.False(t, ok)
+
+		used := 0
+		for i := range shards.shards {
+			if len(shards.shards[i].locations) > 0 {
+				used++
+			}
+		}
+		assert.Equal(t, locationShardCount, used)
+
+		seen := map[string]bool{}
+		for loc := range shards.all() {
+			seen[loc.id] = true
+		}
+		assert.Len(t, seen, 999)
+
+		count := 0
+		for range shards.all() {
+			count++
+			if count == 3 {
+				break
+			}
+		}
+		assert.Equal(t, 3, count)
+	})
+
+	t.Run("should read locations while a namespace writes others", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.Save("ns", "read", 1, 1))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					_, ok := world.GetLocation("ns", "read")
+					assert.True(t, ok)
+				}
+			}()
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					assert.NoError(t, world.Save("ns", strconv.Itoa(i*1000+j), 50, 50))
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		assert.Equal(t, []NamespaceCount{{Name: "ns", Locations: 801}}, world.Namespaces())
+	})
+
+	t.Run("should read a location without the namespace's lock", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.SaveWithAttributes("ns", "moving", 1, 1, time.Minute, Attributes{"n": "0"}))
+
+		namespace, _ := world.lookupNamespace("ns")
+		namespace.mu.Lock()
+		location, ok := world.GetLocation("ns", "moving")
+		namespace.mu.Unlock()
+
+		assert.True(t, ok)
+		assert.Equal(t, "0", location.Attributes()["n"])
+		assert.False(t, location.ExpiresAt().IsZero())
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 200; i++ {
+				assert.NoError(t, world.SaveWithAttributes("ns", "moving", float64(i%2), 1, time.Minute, Attributes{"n": strconv.Itoa(i)}))
+			}
+			assert.NoError(t, world.Delete("ns", "moving"))
+		}()
+
+		for i := 0; i < 200; i++ {
+			location, ok := world.GetLocation("ns", "moving")
+			if ok {
+				assert.NotEmpty(t, location.Attributes()["n"])
+			}
+		}
+		<-done
+	})
+}
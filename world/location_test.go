@@ -38,13 +38,13 @@ func TestLocation(t *testing.T) {
 				t.Fatalf("unexpected error: %v", err)
 			}
 
-			if loc.Node != nil {
+			if loc.Node() != nil {
 				t.Fatalf("expected node to be nil")
 			}
 			node := &TreeNode{}
 			loc.SetNode(node)
 
-			if loc.Node != node {
+			if loc.Node() != node {
 				t.Fatalf("expected node to be set")
 			}
 		})
@@ -2,7 +2,10 @@ package world
 
 import (
 	"encoding/gob"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 func init() {
@@ -11,75 +14,364 @@ func init() {
 
 type Namespace struct {
 	Name      string
-	locations map[string]*Location
-	tree      *QuadTree
-	mu        sync.RWMutex
+	locations locationShards
+	// spatial is the index, swapped whole when the namespace is created with new options, so readers load it
+	// without n.mu.
+	spatial atomic.Pointer[SpatialIndex]
+	journal Journal
+	mu      sync.RWMutex
+
+	// options shape the tree, and created tells if they were set by CREATE NAMESPACE, which puts the namespace
+	// in the catalog.
+	options IndexOptions
+	created bool
+	// dropped tells the writers still holding a namespace that DROP NAMESPACE or RENAME took it out of the world,
+	// so they retry against the one now under its name.
+	dropped bool
+
+	defaultTTL time.Duration
+	expiries   expiryHeap
+
+	historySize int
+	historyAge  time.Duration
+
+	subscriptions *regionTree[*Subscription]
+
+	fences     map[string]*Fence
+	fenceIndex *regionTree[*Fence]
+	fenceHub   *fenceHub
 }
 
 func NewNamespace(name string) *Namespace {
-	return &Namespace{
-		Name:      name,
-		locations: map[string]*Location{},
-		tree:      NewQuadTree(-90, 90, -180, 180),
-		mu:        sync.RWMutex{},
+	return newNamespaceWithOptions(name, DefaultIndexOptions())
+}
+
+func newNamespaceWithOptions(name string, options IndexOptions) *Namespace {
+	namespace := &Namespace{
+		Name:    name,
+		mu:      sync.RWMutex{},
+		options: options,
 	}
+	index := newSpatialIndex(options)
+	namespace.spatial.Store(&index)
+
+	return namespace
+}
+
+// index returns the namespace's spatial index.
+func (n *Namespace) index() SpatialIndex {
+	return *n.spatial.Load()
 }
 
 func (n *Namespace) SaveLocation(id string, lat, lon float64) (*Location, error) {
+	return n.SaveLocationWithAttributes(id, lat, lon, 0, nil)
+}
+
+// SaveLocationWithTTL saves a location that expires ttl from now. A zero TTL uses the namespace default.
+// Saving a location again resets its expiry.
+func (n *Namespace) SaveLocationWithTTL(id string, lat, lon float64, ttl time.Duration) (*Location, error) {
+	return n.SaveLocationWithAttributes(id, lat, lon, ttl, nil)
+}
+
+// SaveLocationWithAttributes saves a location like SaveLocationWithTTL and merges the attributes into the ones
+// it already has. An empty value removes its key, and saving without attributes keeps them all.
+func (n *Namespace) SaveLocationWithAttributes(id string, lat, lon float64, ttl time.Duration, attributes Attributes) (*Location, error) {
+	err := validateSave(ttl, attributes)
+	if err != nil {
+		return nil, err
+	}
+
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
-	loc, ok := n.locations[id]
+	if n.dropped {
+		return nil, errNamespaceDropped
+	}
 
-	if ok {
-		err := loc.Update(lat, lon)
+	return n.saveWithAttributes(id, lat, lon, ttl, attributes)
+}
+
+// saveBatch saves the locations like SaveLocationWithAttributes, all under one acquisition of the namespace's
+// lock, and returns the error of each save, nil for those that succeeded.
+func (n *Namespace) saveBatch(saves []BatchSave) ([]error, error) {
+	errs := make([]error, len(saves))
+	for i, save := range saves {
+		errs[i] = validateSave(save.TTL, save.Attributes)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.dropped {
+		return nil, errNamespaceDropped
+	}
+
+	for i, save := range saves {
+		if errs[i] == nil {
+			_, errs[i] = n.saveWithAttributes(save.Id, save.Lat, save.Lon, save.TTL, save.Attributes)
+		}
+	}
+
+	return errs, nil
+}
+
+func validateSave(ttl time.Duration, attributes Attributes) error {
+	if ttl < 0 {
+		return ErrInvalidTTL
+	}
+
+	return attributes.validate()
+}
+
+// saveWithAttributes is SaveLocationWithAttributes once the namespace is locked.
+func (n *Namespace) saveWithAttributes(id string, lat, lon float64, ttl time.Duration, attributes Attributes) (*Location, error) {
+	var current Attributes
+	if loc, ok := n.locations.get(id); ok {
+		current = loc.attributes
+	}
+
+	merged, err := current.merge(attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, err := n.save(id, lat, lon, n.expiryFor(ttl), merged)
+	if err != nil {
+		return nil, err
+	}
+
+	n.record(loc)
+
+	return loc, nil
+}
+
+// saveLocationUntil saves a location with a known expiry and attributes, as replays and merges do.
+func (n *Namespace) saveLocationUntil(id string, lat, lon float64, expiresAt time.Time, attributes Attributes) (*Location, error) {
+	err := attributes.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(attributes) == 0 {
+		attributes = nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.dropped {
+		return nil, errNamespaceDropped
+	}
+
+	return n.save(id, lat, lon, expiresAt, attributes)
+}
+
+func (n *Namespace) save(id string, lat, lon float64, expiresAt time.Time, attributes Attributes) (*Location, error) {
+	err := validateLocation(n.Name, id, lat, lon)
+	if err != nil {
+		return nil, err
+	}
+
+	if !n.options.Extent.contains(lat, lon) {
+		return nil, ErrLocationOutOfExtent
+	}
+
+	// The save is logged before it is applied, and only once it is known to apply, so one the log could not take is
+	// neither seen nor announced, and the log holds no save its replay would refuse.
+	if n.journal != nil {
+		err = n.journal.Append(Mutation{Op: OpSave, Ns: n.Name, Id: id, Lat: lat, Lon: lon, ExpiresAt: expiresAt, Attributes: attributes})
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	// The location changes under its shard's write lock, so the reads of the shard, which take no other lock,
+	// see it whole.
+	shard := n.locations.lock(id)
+	loc, ok := shard.locations[id]
+
+	var oldLat, oldLon float64
+	if ok {
+		oldLat, oldLon = loc.lat, loc.lon
+
+		n.index().change(loc, func() {
+			loc.attributes = attributes
+		})
+
+		err = n.index().Move(loc, lat, lon)
 	} else {
-		newLoc, err := NewLocation(n.Name, id, lat, lon)
-		if err != nil {
-			return nil, err
+		loc, err = NewLocation(n.Name, id, lat, lon)
+		if err == nil {
+			loc.attributes = attributes
+			shard.put(loc)
+
+			err = n.index().Insert(loc)
 		}
-		loc = newLoc
+	}
 
-		n.locations[id] = loc
+	if err == nil {
+		n.setExpiry(loc, expiresAt)
 	}
+	shard.mu.Unlock()
 
-	err := n.tree.Insert(loc)
 	if err != nil {
 		return nil, err
 	}
 
+	n.publishSave(loc, ok, oldLat, oldLon)
+	n.evaluateFences(loc)
+
 	return loc, nil
 }
 
-func (n *Namespace) DeleteLocation(id string) {
-	n.mu.RLock()
-	loc, ok := n.locations[id]
-	n.mu.RUnlock()
+// DeleteLocation removes the location from the tree and the namespace in one go, under the namespace's lock, so
+// a save of the same id cannot put it back in the tree in between and leave it there unknown to the namespace.
+func (n *Namespace) DeleteLocation(id string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.dropped {
+		return errNamespaceDropped
+	}
 
+	loc, ok := n.locations.get(id)
 	if !ok {
-		return
+		return nil
 	}
 
-	if loc.Node != nil {
-		loc.Node.Delete(loc.Id())
+	if n.journal != nil {
+		err := n.journal.Append(Mutation{Op: OpDelete, Ns: n.Name, Id: id})
+		if err != nil {
+			return err
+		}
 	}
 
+	shard := n.locations.lock(id)
+	n.index().Remove(loc)
+	n.setExpiry(loc, time.Time{})
+	delete(shard.locations, id)
+	shard.mu.Unlock()
+
+	n.publishDelete(loc)
+	n.exitFences(loc)
+
+	return nil
+}
+
+func (n *Namespace) setJournal(journal Journal) {
 	n.mu.Lock()
-	delete(n.locations, id)
+	n.journal = journal
 	n.mu.Unlock()
 }
 
+// GetLocation returns a copy of the location of the id, which the saves going on meanwhile do not change. It takes
+// the lock of the location's shard only, so it does not wait on the writes to the other shards.
 func (n *Namespace) GetLocation(id string) (*Location, bool) {
-	n.mu.RLock()
-	loc, ok := n.locations[id]
-	n.mu.RUnlock()
+	loc, ok := n.locations.copy(id)
+	if !ok {
+		return nil, false
+	}
+
+	return &loc, true
+}
 
-	return loc, ok
+// location returns a copy of the location, taken under its shard's lock so no save changes it meanwhile.
+func (n *Namespace) location(id string) (Location, bool) {
+	return n.locations.copy(id)
 }
 
+// QueryRange returns the locations within the range, bounds included. A range with lon1 > lon2 crosses the
+// antimeridian.
 func (n *Namespace) QueryRange(lat1, lat2, lon1, lon2 float64) []*Location {
-	return n.tree.Root.QueryRange(lat1, lat2, lon1, lon2)
+	return n.QueryRangeWhere(lat1, lat2, lon1, lon2, nil)
+}
+
+// QueryRangeWhere returns the locations within the range that match the filter. Like all of the walks of the
+// index, it returns copies of the locations, which the saves going on meanwhile do not change.
+func (n *Namespace) QueryRangeWhere(lat1, lat2, lon1, lon2 float64, filter Filter) []*Location {
+	var locations []*Location
+
+	n.WalkRange(lat1, lat2, lon1, lon2, filter, func(location *Location) bool {
+		locations = append(locations, location)
+		return true
+	})
+
+	return locations
+}
+
+// WalkRange calls visit for the locations within the range that match the filter, as the index finds them,
+// until visit returns false, which stops the walk. visit gets copies of the locations and runs once the index let
+// go of their part, so it holds up no change to the index however long it takes.
+func (n *Namespace) WalkRange(lat1, lat2, lon1, lon2 float64, filter Filter, visit func(location *Location) bool) {
+	walking := true
+
+	for _, b := range splitRange(lat1, lat2, lon1, lon2) {
+		if !walking {
+			return
+		}
+
+		n.index().Walk(b.lat1, b.lat2, b.lon1, b.lon2, filter, func(location *Location) bool {
+			walking = visit(location)
+			return walking
+		})
+	}
+}
+
+// QueryRadius returns the locations within meters of (lat, lon), closest first.
+func (n *Namespace) QueryRadius(lat, lon, meters float64) []Neighbor {
+	return n.QueryRadiusWhere(lat, lon, meters, nil)
+}
+
+// QueryRadiusWhere returns the locations within meters of (lat, lon) that match the filter, closest first. They
+// are copies, taken while the index holds them still, so the saves going on meanwhile do not change them.
+func (n *Namespace) QueryRadiusWhere(lat, lon, meters float64, filter Filter) []Neighbor {
+	var neighbors []Neighbor
+
+	for _, b := range circleBounds(lat, lon, meters) {
+		n.index().Walk(b.lat1, b.lat2, b.lon1, b.lon2, filter, func(location *Location) bool {
+			distance := haversine(lat, lon, location.Lat(), location.Lon())
+			if distance <= meters {
+				neighbors = append(neighbors, Neighbor{Location: location, Distance: distance})
+			}
+
+			return true
+		})
+	}
+
+	sort.Slice(neighbors, func(i, j int) bool {
+		return neighbors[i].Distance < neighbors[j].Distance
+	})
+
+	return neighbors
+}
+
+func (n *Namespace) QueryPolygon(polygon *Polygon) []*Location {
+	return n.QueryPolygonWhere(polygon, nil)
+}
+
+// QueryPolygonWhere returns the locations inside the polygon that match the filter.
+func (n *Namespace) QueryPolygonWhere(polygon *Polygon, filter Filter) []*Location {
+	var locations []*Location
+
+	n.WalkPolygon(polygon, filter, func(location *Location) bool {
+		locations = append(locations, location)
+		return true
+	})
+
+	return locations
+}
+
+// WalkPolygon is WalkRange for the locations inside the polygon.
+func (n *Namespace) WalkPolygon(polygon *Polygon, filter Filter, visit func(location *Location) bool) {
+	n.index().WalkPolygon(polygon, filter, visit)
+}
+
+// Nearest returns the k locations closest to (lat, lon) within maxDistance meters (0 for no limit), closest first.
+func (n *Namespace) Nearest(lat, lon float64, k int, maxDistance float64) []Neighbor {
+	return n.index().Nearest(lat, lon, k, maxDistance, nil)
+}
+
+// NearestWhere is Nearest among the locations matching the filter.
+func (n *Namespace) NearestWhere(lat, lon float64, k int, maxDistance float64, filter Filter) []Neighbor {
+	return n.index().Nearest(lat, lon, k, maxDistance, filter)
 }
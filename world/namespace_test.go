@@ -92,6 +92,26 @@ func TestNamespace(t *testing.T) {
 		})
 	})
 
+	t.Run("GetLocation", func(t *testing.T) {
+		t.Parallel()
+		t.Run("should return a copy the later saves do not change", func(t *testing.T) {
+			t.Parallel()
+			ns := NewNamespace("test")
+			_, _ = ns.SaveLocationWithAttributes("id", 87, 125, 0, Attributes{"status": "idle"})
+
+			loc, found := ns.GetLocation("id")
+			if !found {
+				t.Fatalf("expected location to be saved")
+			}
+
+			_, _ = ns.SaveLocationWithAttributes("id", 10, 20, 0, Attributes{"status": "busy"})
+
+			if loc.Lat() != 87 || loc.Lon() != 125 || loc.Attributes()["status"] != "idle" {
+				t.Errorf("expected the copy to keep 87,125 and idle, got %f,%f and %v", loc.Lat(), loc.Lon(), loc.Attributes())
+			}
+		})
+	})
+
 	t.Run("DeleteLocation", func(t *testing.T) {
 		t.Parallel()
 		t.Run("should delete a location from the namespace", func(t *testing.T) {
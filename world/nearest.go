@@ -0,0 +1,107 @@
+package world
+
+import (
+	"errors"
+	"math"
+)
+
+var (
+	ErrInvalidNeighborCount = errors.New("the number of neighbors must be a positive integer")
+)
+
+// nearestCandidate is either a region of an index, keyed by the smallest distance any point of it can be at,
+// or a location, keyed by its actual distance.
+type nearestCandidate struct {
+	region   nearestRegion
+	location *Location
+	distance float64
+}
+
+type nearestQueue []nearestCandidate
+
+func (q nearestQueue) Len() int           { return len(q) }
+func (q nearestQueue) Less(i, j int) bool { return q[i].distance < q[j].distance }
+func (q nearestQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *nearestQueue) Push(x any) {
+	*q = append(*q, x.(nearestCandidate))
+}
+
+func (q *nearestQueue) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return last
+}
+
+// Nearest returns the k locations closest to (lat, lon) that match the filter, closest first. Locations further
+// than maxDistance meters are ignored, unless maxDistance is 0. See nearest.
+func (node *TreeNode) Nearest(lat, lon float64, k int, maxDistance float64, filter Filter) []Neighbor {
+	return nearest(node, lat, lon, k, maxDistance, filter)
+}
+
+// open hands the node's children to the nearest search, or its locations if it is a leaf.
+func (node *TreeNode) open(region func(region nearestRegion, lat1, lat2, lon1, lon2 float64), location func(location *Location)) {
+	node.mu.RLock()
+	if node.IsDivided {
+		children := node.children()
+		node.mu.RUnlock()
+
+		for _, child := range children {
+			region(child, child.Lat1, child.Lat2, child.Lon1, child.Lon2)
+		}
+
+		return
+	}
+
+	for _, loc := range node.Objects {
+		location(loc)
+	}
+	node.mu.RUnlock()
+}
+
+// minDistanceToRect returns the great-circle distance in meters from a point to the closest point of a rectangle.
+func minDistanceToRect(lat, lon, lat1, lat2, lon1, lon2 float64) float64 {
+	if lon1 <= lon && lon <= lon2 {
+		if lat1 <= lat && lat <= lat2 {
+			return 0
+		}
+
+		// Within the rectangle's longitudes the closest point is straight north or south, on the same meridian.
+		return haversine(lat, lon, clamp(lat, lat1, lat2), lon)
+	}
+
+	// Otherwise it lies on the edge meridian closest in longitude (going around the antimeridian if shorter).
+	edge := lon1
+	deltaLon := longitudeDistance(lon, lon1)
+	if d := longitudeDistance(lon, lon2); d < deltaLon {
+		edge = lon2
+		deltaLon = d
+	}
+
+	// Along a great circle the distance to the point varies as the cosine of the angle to the point's foot
+	// on that circle, so on the edge the closest point is either that foot or one of the two corners.
+	foot := toDegrees(math.Atan2(math.Sin(toRadians(lat)), math.Cos(toRadians(lat))*math.Cos(toRadians(deltaLon))))
+
+	distance := math.Min(haversine(lat, lon, lat1, edge), haversine(lat, lon, lat2, edge))
+	if lat1 <= foot && foot <= lat2 {
+		distance = math.Min(distance, haversine(lat, lon, foot, edge))
+	}
+
+	return distance
+}
+
+// longitudeDistance returns the smallest angle in degrees between two longitudes, between 0 and 180.
+func longitudeDistance(lon1, lon2 float64) float64 {
+	d := math.Mod(math.Abs(lon1-lon2), 360)
+	if d > 180 {
+		return 360 - d
+	}
+
+	return d
+}
+
+func clamp(value, low, high float64) float64 {
+	return math.Max(low, math.Min(high, value))
+}
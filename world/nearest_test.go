@@ -0,0 +1,162 @@
+package world
+
+import (
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNearest(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should match a brute force search", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		rng := rand.New(rand.NewPCG(1, 2))
+
+		for i := 0; i < 5000; i++ {
+			assert.NoError(t, world.Save("ns", strconv.Itoa(i), rng.Float64()*180-90, rng.Float64()*360-180))
+		}
+
+		centers := [][2]float64{{0, 0}, {48.85, 2.35}, {-17.7, 179.9}, {89.99, -45}, {-90, 0}, {10, -180}}
+
+		for _, center := range centers {
+			all := world.QueryRange("ns", -90, 90, -180, 180)
+			expected := make([]float64, 0, len(all))
+			for _, location := range all {
+				expected = append(expected, haversine(center[0], center[1], location.Lat(), location.Lon()))
+			}
+			sort.Float64s(expected)
+
+			neighbors, err := world.Nearest("ns", center[0], center[1], 10, 0)
+			assert.NoError(t, err)
+			assert.Len(t, neighbors, 10)
+
+			for i, neighbor := range neighbors {
+				assert.InDelta(t, expected[i], neighbor.Distance, 1e-6, "center %v, rank %d", center, i)
+			}
+		}
+	})
+
+	t.Run("should stop at the max distance", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		assert.NoError(t, world.Save("ns", "near", 0, 0.001))
+		assert.NoError(t, world.Save("ns", "far", 0, 0.1))
+
+		neighbors, err := world.Nearest("ns", 0, 0, 10, 1000)
+		assert.NoError(t, err)
+		assert.Len(t, neighbors, 1)
+		assert.Equal(t, "near", neighbors[0].Location.Id())
+
+		neighbors, err = world.Nearest("ns", 0, 0, 10, 0)
+		assert.NoError(t, err)
+		assert.Len(t, neighbors, 2)
+		assert.Equal(t, "far", neighbors[1].Location.Id())
+	})
+
+	t.Run("should return nothing for an empty namespace", func(t *testing.T) {
+		t.Parallel()
+		neighbors, err := NewWorld().Nearest("ns", 0, 0, 3, 0)
+		assert.NoError(t, err)
+		assert.Empty(t, neighbors)
+	})
+
+	t.Run("should return copies the saves going on meanwhile do not change", func(t *testing.T) {
+		t.Parallel()
+
+		for _, backend := range []string{IndexQuadtree, IndexGrid, IndexRTree} {
+			world := NewWorld()
+			options := DefaultIndexOptions()
+			options.Backend = backend
+			assert.NoError(t, world.CreateNamespace("ns", options))
+			assert.NoError(t, world.Save("ns", "moving", 0, 0.001))
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 200; i++ {
+					_ = world.SaveWithAttributes("ns", "moving", 0, 0.001+float64(i%2)/1000, 0, Attributes{"i": strconv.Itoa(i)})
+				}
+			}()
+
+			for i := 0; i < 200; i++ {
+				neighbors, err := world.Nearest("ns", 0, 0, 1, 0)
+				assert.NoError(t, err)
+				assert.Len(t, neighbors, 1)
+				_ = neighbors[0].Location.Lon()
+				_ = neighbors[0].Location.Attributes()["i"]
+			}
+			<-done
+
+			neighbors, err := world.Nearest("ns", 0, 0, 1, 0)
+			assert.NoError(t, err)
+			assert.NoError(t, world.Save("ns", "moving", 0, 0.005))
+			assert.Equal(t, 0.002, neighbors[0].Location.Lon(), backend)
+		}
+	})
+
+	t.Run("should reject invalid arguments", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		_, err := world.Nearest("ns", 0, 200, 1, 0)
+		assert.ErrorIs(t, err, ErrLocationInvalidLongitude)
+
+		_, err = world.Nearest("ns", 0, 0, 0, 0)
+		assert.ErrorIs(t, err, ErrInvalidNeighborCount)
+
+		_, err = world.Nearest("ns", 0, 0, 1, -5)
+		assert.ErrorIs(t, err, ErrInvalidRadius)
+	})
+}
+
+func TestMinDistanceToRect(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should never exceed the distance to any point of the rectangle", func(t *testing.T) {
+		t.Parallel()
+		rng := rand.New(rand.NewPCG(3, 4))
+
+		for i := 0; i < 500; i++ {
+			lat, lon := rng.Float64()*180-90, rng.Float64()*360-180
+			lat1 := rng.Float64()*170 - 90
+			lat2 := lat1 + rng.Float64()*(90-lat1)
+			lon1 := rng.Float64()*350 - 180
+			lon2 := lon1 + rng.Float64()*(180-lon1)
+
+			bound := minDistanceToRect(lat, lon, lat1, lat2, lon1, lon2)
+			closest := haversine(lat, lon, lat1, lon1)
+
+			if lat1 <= lat && lat <= lat2 && lon1 <= lon && lon <= lon2 {
+				closest = 0
+			}
+
+			// Outside the rectangle the closest point is on its edges, so sampling them densely finds it.
+			for step := 0; step <= 2000; step++ {
+				a := float64(step) / 2000
+				latA, lonA := lat1+a*(lat2-lat1), lon1+a*(lon2-lon1)
+				closest = min(closest,
+					haversine(lat, lon, latA, lon1), haversine(lat, lon, latA, lon2),
+					haversine(lat, lon, lat1, lonA), haversine(lat, lon, lat2, lonA))
+			}
+
+			assert.LessOrEqual(t, bound, closest+1e-6)
+			assert.InDelta(t, closest, bound, 0.001*closest+100, "the bound should be exact")
+		}
+	})
+
+	t.Run("should be zero inside the rectangle", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, 0.0, minDistanceToRect(5, 5, 0, 10, 0, 10))
+	})
+
+	t.Run("should go around the antimeridian", func(t *testing.T) {
+		t.Parallel()
+		assert.InDelta(t, haversine(0, 179.5, 0, -180), minDistanceToRect(0, 179.5, -10, 10, -180, -170), 1e-6)
+	})
+}
@@ -0,0 +1,389 @@
+package world
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrPolygonInvalid       = errors.New("invalid polygon, expected a WKT POLYGON or a GeoJSON Polygon")
+	ErrPolygonTooFewPoints  = errors.New("polygon rings need at least 3 distinct points")
+	ErrPolygonOutOfTheWorld = errors.New("polygon coordinates must be valid latitudes and longitudes")
+)
+
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+// Polygon is a simple polygon in latitude/longitude, taken as planar. The first ring is the outer boundary
+// and the following ones are holes. Rings are stored open: the closing point is not repeated.
+type Polygon struct {
+	Rings  [][]Point
+	bounds box
+}
+
+// cellRelation is how a rectangle sits relative to a polygon.
+type cellRelation int
+
+const (
+	cellOutside cellRelation = iota
+	cellInside
+	cellPartial
+)
+
+func NewPolygon(rings [][]Point) (*Polygon, error) {
+	if len(rings) == 0 {
+		return nil, ErrPolygonInvalid
+	}
+
+	polygon := &Polygon{
+		Rings:  make([][]Point, 0, len(rings)),
+		bounds: box{lat1: math.Inf(1), lat2: math.Inf(-1), lon1: math.Inf(1), lon2: math.Inf(-1)},
+	}
+
+	for _, ring := range rings {
+		if len(ring) > 1 && ring[0] == ring[len(ring)-1] {
+			ring = ring[:len(ring)-1]
+		}
+
+		if len(ring) < 3 {
+			return nil, ErrPolygonTooFewPoints
+		}
+
+		for _, point := range ring {
+			if validateLatLon(point.Lat, point.Lon) != nil {
+				return nil, ErrPolygonOutOfTheWorld
+			}
+		}
+
+		polygon.Rings = append(polygon.Rings, ring)
+	}
+
+	for _, point := range polygon.Rings[0] {
+		polygon.bounds.lat1 = math.Min(polygon.bounds.lat1, point.Lat)
+		polygon.bounds.lat2 = math.Max(polygon.bounds.lat2, point.Lat)
+		polygon.bounds.lon1 = math.Min(polygon.bounds.lon1, point.Lon)
+		polygon.bounds.lon2 = math.Max(polygon.bounds.lon2, point.Lon)
+	}
+
+	return polygon, nil
+}
+
+// ParsePolygon reads a polygon written in WKT, such as "POLYGON ((lon lat, ...), (hole...))", or as a GeoJSON
+// Polygon geometry (or a Feature holding one). Both put the longitude first.
+func ParsePolygon(input string) (*Polygon, error) {
+	input = strings.TrimSpace(input)
+
+	if strings.HasPrefix(input, "{") {
+		return parseGeoJSONPolygon(input)
+	}
+
+	return parseWKTPolygon(input)
+}
+
+func parseWKTPolygon(input string) (*Polygon, error) {
+	if len(input) < len("POLYGON") || !strings.EqualFold(input[:len("POLYGON")], "POLYGON") {
+		return nil, ErrPolygonInvalid
+	}
+
+	body := strings.TrimSpace(input[len("POLYGON"):])
+	if !strings.HasPrefix(body, "(") || !strings.HasSuffix(body, ")") {
+		return nil, ErrPolygonInvalid
+	}
+	body = strings.TrimSpace(body[1 : len(body)-1])
+
+	var rings [][]Point
+
+	for body != "" {
+		if !strings.HasPrefix(body, "(") {
+			return nil, ErrPolygonInvalid
+		}
+
+		end := strings.Index(body, ")")
+		if end < 0 {
+			return nil, ErrPolygonInvalid
+		}
+
+		ring, err := parseWKTRing(body[1:end])
+		if err != nil {
+			return nil, err
+		}
+		rings = append(rings, ring)
+
+		body = strings.TrimSpace(body[end+1:])
+		if strings.HasPrefix(body, ",") {
+			body = strings.TrimSpace(body[1:])
+			if body == "" {
+				return nil, ErrPolygonInvalid
+			}
+		} else if body != "" {
+			return nil, ErrPolygonInvalid
+		}
+	}
+
+	return NewPolygon(rings)
+}
+
+func parseWKTRing(input string) ([]Point, error) {
+	coordinates := strings.Split(input, ",")
+	ring := make([]Point, 0, len(coordinates))
+
+	for _, coordinate := range coordinates {
+		fields := strings.Fields(coordinate)
+		if len(fields) != 2 {
+			return nil, ErrPolygonInvalid
+		}
+
+		lon, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, ErrPolygonInvalid
+		}
+
+		lat, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, ErrPolygonInvalid
+		}
+
+		ring = append(ring, Point{Lat: lat, Lon: lon})
+	}
+
+	return ring, nil
+}
+
+func parseGeoJSONPolygon(input string) (*Polygon, error) {
+	type geometry struct {
+		Type        string         `json:"type"`
+		Coordinates [][][2]float64 `json:"coordinates"`
+		Geometry    *geometry      `json:"geometry"`
+	}
+
+	var geo geometry
+
+	err := json.Unmarshal([]byte(input), &geo)
+	if err != nil {
+		return nil, ErrPolygonInvalid
+	}
+
+	if geo.Type == "Feature" && geo.Geometry != nil {
+		geo = *geo.Geometry
+	}
+
+	if geo.Type != "Polygon" {
+		return nil, ErrPolygonInvalid
+	}
+
+	rings := make([][]Point, 0, len(geo.Coordinates))
+	for _, coordinates := range geo.Coordinates {
+		ring := make([]Point, 0, len(coordinates))
+		for _, coordinate := range coordinates {
+			ring = append(ring, Point{Lat: coordinate[1], Lon: coordinate[0]})
+		}
+		rings = append(rings, ring)
+	}
+
+	return NewPolygon(rings)
+}
+
+// String returns the polygon in WKT, longitude first, with its rings closed.
+func (p *Polygon) String() string {
+	var builder strings.Builder
+
+	builder.WriteString("POLYGON (")
+	for i, ring := range p.Rings {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+
+		builder.WriteByte('(')
+		for j := 0; j <= len(ring); j++ {
+			point := ring[j%len(ring)]
+			if j > 0 {
+				builder.WriteString(", ")
+			}
+			builder.WriteString(strconv.FormatFloat(point.Lon, 'f', -1, 64))
+			builder.WriteByte(' ')
+			builder.WriteString(strconv.FormatFloat(point.Lat, 'f', -1, 64))
+		}
+		builder.WriteByte(')')
+	}
+	builder.WriteByte(')')
+
+	return builder.String()
+}
+
+// Contains tells if the point is inside the polygon and outside its holes, using the even-odd rule.
+func (p *Polygon) Contains(lat, lon float64) bool {
+	if lat < p.bounds.lat1 || lat > p.bounds.lat2 || lon < p.bounds.lon1 || lon > p.bounds.lon2 {
+		return false
+	}
+
+	inside := false
+
+	for _, ring := range p.Rings {
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			a, b := ring[i], ring[j]
+			if (a.Lat > lat) != (b.Lat > lat) && lon < (b.Lon-a.Lon)*(lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+				inside = !inside
+			}
+		}
+	}
+
+	return inside
+}
+
+// classify tells if a rectangle is fully inside the polygon, fully outside, or crossed by its boundary.
+// Touching the boundary counts as crossing it, so the answer errs on the side of testing points one by one.
+func (p *Polygon) classify(lat1, lat2, lon1, lon2 float64) cellRelation {
+	if !rectangleOverlap(lat1, lat2, lon1, lon2, p.bounds.lat1, p.bounds.lat2, p.bounds.lon1, p.bounds.lon2) {
+		return cellOutside
+	}
+
+	corners := [4]Point{{lat1, lon1}, {lat1, lon2}, {lat2, lon2}, {lat2, lon1}}
+
+	for _, ring := range p.Rings {
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			a, b := ring[j], ring[i]
+
+			// A vertex inside the rectangle means part of a ring (maybe a whole hole) is in there.
+			if lat1 < a.Lat && a.Lat < lat2 && lon1 < a.Lon && a.Lon < lon2 {
+				return cellPartial
+			}
+
+			for k := range corners {
+				if segmentsIntersect(a, b, corners[k], corners[(k+1)%4]) {
+					return cellPartial
+				}
+			}
+		}
+	}
+
+	// No boundary crosses the rectangle, so it is all on one side: any corner tells which.
+	if p.Contains(lat1, lon1) {
+		return cellInside
+	}
+
+	return cellOutside
+}
+
+// segmentsIntersect tells if segments ab and cd share at least a point.
+func segmentsIntersect(a, b, c, d Point) bool {
+	d1 := orientation(c, d, a)
+	d2 := orientation(c, d, b)
+	d3 := orientation(a, b, c)
+	d4 := orientation(a, b, d)
+
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+
+	return (d1 == 0 && onSegment(c, d, a)) ||
+		(d2 == 0 && onSegment(c, d, b)) ||
+		(d3 == 0 && onSegment(a, b, c)) ||
+		(d4 == 0 && onSegment(a, b, d))
+}
+
+func orientation(a, b, c Point) float64 {
+	return (b.Lon-a.Lon)*(c.Lat-a.Lat) - (b.Lat-a.Lat)*(c.Lon-a.Lon)
+}
+
+// onSegment tells if c, known to be on the line ab, lies between a and b.
+func onSegment(a, b, c Point) bool {
+	return math.Min(a.Lon, b.Lon) <= c.Lon && c.Lon <= math.Max(a.Lon, b.Lon) &&
+		math.Min(a.Lat, b.Lat) <= c.Lat && c.Lat <= math.Max(a.Lat, b.Lat)
+}
+
+// WalkPolygon is Walk for the locations inside the polygon. Cells fully inside it are taken whole, without
+// testing their locations, and cells fully outside are skipped; only cells crossed by the boundary are searched.
+func (node *TreeNode) WalkPolygon(polygon *Polygon, filter Filter, visit func(location *Location) bool) bool {
+	switch polygon.classify(node.Lat1, node.Lat2, node.Lon1, node.Lon2) {
+	case cellOutside:
+		return true
+	case cellInside:
+		return node.walkAll(filter, visit)
+	}
+
+	node.mu.RLock()
+	if !node.IsDivided {
+		var found []*Location
+		for _, location := range node.Objects {
+			if polygon.Contains(location.Lat(), location.Lon()) && filter.Match(location) {
+				found = append(found, location.walkCopy())
+			}
+		}
+		node.mu.RUnlock()
+
+		return visitAll(found, visit)
+	}
+	children := node.children()
+	node.mu.RUnlock()
+
+	for _, child := range children {
+		if !child.WalkPolygon(polygon, filter, visit) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CountPolygon returns how many locations are inside the polygon. Cells fully inside it are taken by their count.
+func (node *TreeNode) CountPolygon(polygon *Polygon) int {
+	switch polygon.classify(node.Lat1, node.Lat2, node.Lon1, node.Lon2) {
+	case cellOutside:
+		return 0
+	case cellInside:
+		return int(node.count.Load())
+	}
+
+	count := 0
+
+	node.mu.RLock()
+	if !node.IsDivided {
+		for _, location := range node.Objects {
+			if polygon.Contains(location.Lat(), location.Lon()) {
+				count++
+			}
+		}
+		node.mu.RUnlock()
+
+		return count
+	}
+	children := node.children()
+	node.mu.RUnlock()
+
+	for _, child := range children {
+		count += child.CountPolygon(polygon)
+	}
+
+	return count
+}
+
+// walkAll is Walk for every location under the node.
+func (node *TreeNode) walkAll(filter Filter, visit func(location *Location) bool) bool {
+	node.mu.RLock()
+	if !node.IsDivided {
+		var found []*Location
+		for _, location := range node.Objects {
+			if filter.Match(location) {
+				found = append(found, location.walkCopy())
+			}
+		}
+		node.mu.RUnlock()
+
+		return visitAll(found, visit)
+	}
+	children := node.children()
+	node.mu.RUnlock()
+
+	for _, child := range children {
+		if !child.walkAll(filter, visit) {
+			return false
+		}
+	}
+
+	return true
+}
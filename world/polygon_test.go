@@ -0,0 +1,112 @@
+package world
+
+import (
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePolygon(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should read WKT with holes, longitude first", func(t *testing.T) {
+		t.Parallel()
+		polygon, err := ParsePolygon("POLYGON ((1 2, 11 2, 11 12, 1 12, 1 2), (4 5, 6 5, 6 7))")
+		assert.NoError(t, err)
+		assert.Len(t, polygon.Rings, 2)
+		assert.Equal(t, []Point{{2, 1}, {2, 11}, {12, 11}, {12, 1}}, polygon.Rings[0])
+		assert.Equal(t, []Point{{5, 4}, {5, 6}, {7, 6}}, polygon.Rings[1])
+	})
+
+	t.Run("should read a GeoJSON Polygon or a Feature holding one", func(t *testing.T) {
+		t.Parallel()
+		geometry := `{"type":"Polygon","coordinates":[[[1,2],[11,2],[11,12],[1,2]]]}`
+
+		for _, input := range []string{geometry, `{"type":"Feature","properties":{},"geometry":` + geometry + `}`} {
+			polygon, err := ParsePolygon(input)
+			assert.NoError(t, err)
+			assert.Equal(t, [][]Point{{{2, 1}, {2, 11}, {12, 11}}}, polygon.Rings)
+		}
+	})
+
+	t.Run("should reject malformed polygons", func(t *testing.T) {
+		t.Parallel()
+		inputs := map[string]error{
+			"":                                     ErrPolygonInvalid,
+			"LINESTRING (0 0, 1 1)":                ErrPolygonInvalid,
+			"POLYGON ((0 0, 1 0, 1 1, 0 0)":        ErrPolygonInvalid,
+			"POLYGON ((0 0, 1 0, 1 1, 0 0)),":      ErrPolygonInvalid,
+			"POLYGON ((0 0, 1 0 3, 1 1, 0 0))":     ErrPolygonInvalid,
+			"POLYGON ((0 0, 1 0, 0 0))":            ErrPolygonTooFewPoints,
+			"POLYGON ((0 0, 1 0, 1 100, 0 0))":     ErrPolygonOutOfTheWorld,
+			`{"type":"Polygon","coordinates":[]}`:  ErrPolygonInvalid,
+			`{"type":"MultiPolygon"}`:              ErrPolygonInvalid,
+			`{"type":"Polygon","coordinates":"x"}`: ErrPolygonInvalid,
+		}
+
+		for input, expected := range inputs {
+			_, err := ParsePolygon(input)
+			assert.ErrorIs(t, err, expected, input)
+		}
+	})
+}
+
+func TestPolygon(t *testing.T) {
+	t.Parallel()
+
+	// A U shape opening north, with a square hole in its base.
+	polygon, err := ParsePolygon("POLYGON ((0 0, 30 0, 30 30, 20 30, 20 10, 10 10, 10 30, 0 30, 0 0), (12 2, 18 2, 18 8, 12 8, 12 2))")
+	assert.NoError(t, err)
+
+	t.Run("should tell which points are inside", func(t *testing.T) {
+		t.Parallel()
+		assert.True(t, polygon.Contains(5, 5))
+		assert.True(t, polygon.Contains(25, 5))
+		assert.True(t, polygon.Contains(25, 25))
+		assert.False(t, polygon.Contains(20, 15), "between the arms")
+		assert.False(t, polygon.Contains(5, 15), "in the hole")
+		assert.False(t, polygon.Contains(-1, 5))
+	})
+
+	t.Run("should classify cells", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, cellInside, polygon.classify(1, 9, 1, 9))
+		assert.Equal(t, cellOutside, polygon.classify(15, 25, 12, 18), "between the arms")
+		assert.Equal(t, cellOutside, polygon.classify(3, 7, 13, 17), "in the hole")
+		assert.Equal(t, cellOutside, polygon.classify(40, 50, 40, 50))
+		assert.Equal(t, cellPartial, polygon.classify(1, 9, 1, 15), "crossing the hole")
+		assert.Equal(t, cellPartial, polygon.classify(1, 9, 11, 19), "around the hole")
+		assert.Equal(t, cellPartial, polygon.classify(-10, 40, -10, 40), "around the polygon")
+		assert.Equal(t, cellPartial, polygon.classify(0, 5, 0, 5), "touching the boundary")
+	})
+
+	t.Run("should match a brute force search", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		rng := rand.New(rand.NewPCG(5, 6))
+
+		for i := 0; i < 20000; i++ {
+			assert.NoError(t, world.Save("ns", strconv.Itoa(i), rng.Float64()*40-5, rng.Float64()*40-5))
+		}
+
+		var expected []string
+		for _, location := range world.QueryRange("ns", -90, 90, -180, 180) {
+			if polygon.Contains(location.Lat(), location.Lon()) {
+				expected = append(expected, location.Id())
+			}
+		}
+
+		var found []string
+		for _, location := range world.QueryPolygon("ns", polygon) {
+			found = append(found, location.Id())
+		}
+
+		sort.Strings(expected)
+		sort.Strings(found)
+		assert.NotEmpty(t, found)
+		assert.Equal(t, expected, found)
+	})
+}
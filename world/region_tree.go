@@ -0,0 +1,168 @@
+package world
+
+// regionTreeMaxDepth bounds how far boxes are pushed down the region tree. Deeper cells are about 10 meters
+// high, smaller than any useful area.
+const regionTreeMaxDepth = 20
+
+// regionTree indexes boxes, such as subscription areas or fence bounds, so a write only looks at the ones around
+// it. Each box is kept in the deepest cell containing it whole (an MX-CIF quadtree), so finding the boxes
+// containing a point walks a single branch of cells.
+type regionTree[T comparable] struct {
+	root *regionNode[T]
+	size int
+}
+
+type regionNode[T comparable] struct {
+	bounds   box
+	entries  []regionEntry[T]
+	children *[4]regionNode[T]
+}
+
+type regionEntry[T comparable] struct {
+	bounds box
+	value  T
+}
+
+func newRegionTree[T comparable]() *regionTree[T] {
+	return &regionTree[T]{root: &regionNode[T]{bounds: box{lat1: -90, lat2: 90, lon1: -180, lon2: 180}}}
+}
+
+func (t *regionTree[T]) insert(b box, value T) {
+	node := t.root
+
+	for depth := 0; depth < regionTreeMaxDepth; depth++ {
+		child := node.childContaining(b)
+		if child == nil {
+			break
+		}
+		node = child
+	}
+
+	node.entries = append(node.entries, regionEntry[T]{bounds: b, value: value})
+	t.size++
+}
+
+func (t *regionTree[T]) remove(b box, value T) {
+	node := t.root
+
+	for node != nil {
+		for i, entry := range node.entries {
+			if entry.value == value && entry.bounds == b {
+				last := len(node.entries) - 1
+				node.entries[i] = node.entries[last]
+				node.entries[last] = regionEntry[T]{}
+				node.entries = node.entries[:last]
+				t.size--
+				return
+			}
+		}
+
+		if node.children == nil {
+			return
+		}
+		node = node.childContaining(b)
+	}
+}
+
+// all appends every value of the tree, each once.
+func (t *regionTree[T]) all(values []T) []T {
+	return unique(t.root.all(values))
+}
+
+func (node *regionNode[T]) all(values []T) []T {
+	for _, entry := range node.entries {
+		values = append(values, entry.value)
+	}
+
+	if node.children != nil {
+		for i := range node.children {
+			values = node.children[i].all(values)
+		}
+	}
+
+	return values
+}
+
+// match appends the values having a box that contains the point, each once.
+func (t *regionTree[T]) match(lat, lon float64, values []T) []T {
+	return unique(t.root.match(lat, lon, values))
+}
+
+func (node *regionNode[T]) match(lat, lon float64, values []T) []T {
+	for _, entry := range node.entries {
+		b := entry.bounds
+		if b.lat1 <= lat && lat <= b.lat2 && b.lon1 <= lon && lon <= b.lon2 {
+			values = append(values, entry.value)
+		}
+	}
+
+	if node.children == nil {
+		return values
+	}
+
+	// A point on a cell border belongs to every cell sharing it.
+	for i := range node.children {
+		c := &node.children[i]
+		if c.bounds.lat1 <= lat && lat <= c.bounds.lat2 && c.bounds.lon1 <= lon && lon <= c.bounds.lon2 {
+			values = c.match(lat, lon, values)
+		}
+	}
+
+	return values
+}
+
+// unique removes the values seen earlier in the slice, in place, keeping the order of the others. A value is in the
+// tree once per box, so a subscription split across the antimeridian comes up twice.
+func unique[T comparable](values []T) []T {
+	if len(values) < 2 {
+		return values
+	}
+
+	seen := make(map[T]struct{}, len(values))
+	kept := values[:0]
+	for _, value := range values {
+		if _, ok := seen[value]; ok {
+			continue
+		}
+		seen[value] = struct{}{}
+		kept = append(kept, value)
+	}
+
+	return kept
+}
+
+// childContaining returns the quadrant containing the box whole, creating the quadrants if needed, or nil if the
+// box straddles them.
+func (node *regionNode[T]) childContaining(b box) *regionNode[T] {
+	midLat := (node.bounds.lat1 + node.bounds.lat2) / 2
+	midLon := (node.bounds.lon1 + node.bounds.lon2) / 2
+
+	var index int
+	switch {
+	case b.lat2 < midLat:
+	case b.lat1 >= midLat:
+		index += 2
+	default:
+		return nil
+	}
+
+	switch {
+	case b.lon2 < midLon:
+	case b.lon1 >= midLon:
+		index++
+	default:
+		return nil
+	}
+
+	if node.children == nil {
+		lat1, lat2, lon1, lon2 := node.bounds.lat1, node.bounds.lat2, node.bounds.lon1, node.bounds.lon2
+		node.children = &[4]regionNode[T]{
+			{bounds: box{lat1: lat1, lat2: midLat, lon1: lon1, lon2: midLon}},
+			{bounds: box{lat1: lat1, lat2: midLat, lon1: midLon, lon2: lon2}},
+			{bounds: box{lat1: midLat, lat2: lat2, lon1: lon1, lon2: midLon}},
+			{bounds: box{lat1: midLat, lat2: lat2, lon1: midLon, lon2: lon2}},
+		}
+	}
+
+	return &node.children[index]
+}
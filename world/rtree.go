@@ -0,0 +1,661 @@
+package world
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// rTree groups nearby locations into leaves, and nearby leaves into nodes, each with the rectangle bounding
+// what it holds, so its shape follows the locations rather than the extent: a sparse namespace spread over the
+// globe gets a shallow tree with no empty cells. Nodes are split the R*-tree way when they overflow, and
+// dissolved, their locations inserted again, when they fall under their minimum.
+//
+// A change rebalances the tree from a leaf up to the root, so the tree has one lock: the changes take it, the
+// walks read it.
+type rTree struct {
+	mu         sync.RWMutex
+	root       *rNode
+	maxEntries int
+	minEntries int
+}
+
+type rNode struct {
+	bounds box
+	// count is the number of locations in the leaves under the node.
+	count     int
+	leaf      bool
+	children  []*rNode
+	locations []*Location
+	parent    *rNode
+}
+
+// emptyBox bounds nothing: any point extends it to that point.
+var emptyBox = box{lat1: math.Inf(1), lat2: math.Inf(-1), lon1: math.Inf(1), lon2: math.Inf(-1)}
+
+// newRTree returns an empty R-tree whose nodes hold up to Capacity entries, and at least 4.
+func newRTree(options IndexOptions) *rTree {
+	maxEntries := max(options.Capacity, 4)
+
+	return &rTree{
+		root:       &rNode{bounds: emptyBox, leaf: true},
+		maxEntries: maxEntries,
+		minEntries: max(1, maxEntries*2/5),
+	}
+}
+
+func (b box) extend(other box) box {
+	return box{
+		lat1: math.Min(b.lat1, other.lat1),
+		lat2: math.Max(b.lat2, other.lat2),
+		lon1: math.Min(b.lon1, other.lon1),
+		lon2: math.Max(b.lon2, other.lon2),
+	}
+}
+
+func (b box) area() float64 {
+	return (b.lat2 - b.lat1) * (b.lon2 - b.lon1)
+}
+
+func (b box) margin() float64 {
+	return (b.lat2 - b.lat1) + (b.lon2 - b.lon1)
+}
+
+// overlap returns the area two boxes share.
+func (b box) overlap(other box) float64 {
+	lat := math.Min(b.lat2, other.lat2) - math.Max(b.lat1, other.lat1)
+	lon := math.Min(b.lon2, other.lon2) - math.Max(b.lon1, other.lon1)
+	if lat <= 0 || lon <= 0 {
+		return 0
+	}
+
+	return lat * lon
+}
+
+func (b box) covers(other box) bool {
+	return b.lat1 <= other.lat1 && other.lat2 <= b.lat2 && b.lon1 <= other.lon1 && other.lon2 <= b.lon2
+}
+
+func pointBox(lat, lon float64) box {
+	return box{lat1: lat, lat2: lat, lon1: lon, lon2: lon}
+}
+
+func (node *rNode) entries() int {
+	if node.leaf {
+		return len(node.locations)
+	}
+
+	return len(node.children)
+}
+
+// entry returns the bounds of the node's i-th location or child.
+func (node *rNode) entry(i int) box {
+	if node.leaf {
+		return pointBox(node.locations[i].lat, node.locations[i].lon)
+	}
+
+	return node.children[i].bounds
+}
+
+// refit shrinks the node's bounds to what it holds, and counts its locations again.
+func (node *rNode) refit() {
+	node.bounds = emptyBox
+	for i := 0; i < node.entries(); i++ {
+		node.bounds = node.bounds.extend(node.entry(i))
+	}
+
+	node.count = len(node.locations)
+	for _, child := range node.children {
+		node.count += child.count
+	}
+}
+
+// collect appends the locations of the leaves under the node.
+func (node *rNode) collect(locations []*Location) []*Location {
+	if node.leaf {
+		return append(locations, node.locations...)
+	}
+
+	for _, child := range node.children {
+		locations = child.collect(locations)
+	}
+
+	return locations
+}
+
+func (t *rTree) Insert(location *Location) error {
+	if location == nil {
+		return ErrTreeLocationNil
+	}
+
+	t.mu.Lock()
+	t.insert(location)
+	t.mu.Unlock()
+
+	return nil
+}
+
+// insert goes down to the leaf whose bounds grow the least to take the location, extending the bounds on the
+// way, and splits the nodes overflowing on the way back up.
+func (t *rTree) insert(location *Location) {
+	point := pointBox(location.lat, location.lon)
+
+	node := t.root
+	for !node.leaf {
+		node.bounds = node.bounds.extend(point)
+		node.count++
+		node = node.chooseChild(point)
+	}
+
+	node.bounds = node.bounds.extend(point)
+	node.count++
+	node.locations = append(node.locations, location)
+
+	for node.entries() > t.maxEntries {
+		sibling := t.split(node)
+
+		parent := node.parent
+		if parent == nil {
+			root := &rNode{children: []*rNode{node, sibling}}
+			node.parent, sibling.parent = root, root
+			root.refit()
+			t.root = root
+
+			return
+		}
+
+		sibling.parent = parent
+		parent.children = append(parent.children, sibling)
+		node = parent
+	}
+}
+
+// chooseChild returns the child whose bounds grow the least to cover the point, the smallest one on a tie.
+func (node *rNode) chooseChild(point box) *rNode {
+	var best *rNode
+	bestGrowth, bestArea := math.Inf(1), math.Inf(1)
+
+	for _, child := range node.children {
+		area := child.bounds.area()
+		growth := child.bounds.extend(point).area() - area
+
+		if growth < bestGrowth || (growth == bestGrowth && area < bestArea) {
+			best, bestGrowth, bestArea = child, growth, area
+		}
+	}
+
+	return best
+}
+
+// split moves part of the node's entries to a new sibling, which it returns, the R*-tree way: the entries are
+// sorted along the axis where the two groups have the smallest perimeters, and cut where the groups overlap the
+// least, then where they cover the least. Each group keeps at least the minimum of entries.
+func (t *rTree) split(node *rNode) *rNode {
+	count := node.entries()
+	order := make([]int, count)
+	entries := make([]box, count)
+	for i := range entries {
+		entries[i] = node.entry(i)
+	}
+
+	var best []int
+	bestMargin := math.Inf(1)
+
+	for _, axis := range []func(b box) (float64, float64){
+		func(b box) (float64, float64) { return b.lat1, b.lat2 },
+		func(b box) (float64, float64) { return b.lon1, b.lon2 },
+	} {
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(i, j int) bool {
+			low1, high1 := axis(entries[order[i]])
+			low2, high2 := axis(entries[order[j]])
+
+			return low1 < low2 || (low1 == low2 && high1 < high2)
+		})
+
+		margin := 0.0
+		for cut := t.minEntries; cut <= count-t.minEntries; cut++ {
+			first, second := groupBounds(entries, order, cut)
+			margin += first.margin() + second.margin()
+		}
+
+		if margin < bestMargin {
+			best, bestMargin = slices.Clone(order), margin
+		}
+	}
+
+	bestCut := t.minEntries
+	bestOverlap, bestArea := math.Inf(1), math.Inf(1)
+	for cut := t.minEntries; cut <= count-t.minEntries; cut++ {
+		first, second := groupBounds(entries, best, cut)
+		overlap := first.overlap(second)
+		area := first.area() + second.area()
+
+		if overlap < bestOverlap || (overlap == bestOverlap && area < bestArea) {
+			bestCut, bestOverlap, bestArea = cut, overlap, area
+		}
+	}
+
+	sibling := &rNode{leaf: node.leaf}
+
+	if node.leaf {
+		locations := node.locations
+		node.locations = make([]*Location, 0, t.maxEntries+1)
+		for i, entry := range best {
+			if i < bestCut {
+				node.locations = append(node.locations, locations[entry])
+			} else {
+				sibling.locations = append(sibling.locations, locations[entry])
+			}
+		}
+	} else {
+		children := node.children
+		node.children = make([]*rNode, 0, t.maxEntries+1)
+		for i, entry := range best {
+			if i < bestCut {
+				node.children = append(node.children, children[entry])
+			} else {
+				children[entry].parent = sibling
+				sibling.children = append(sibling.children, children[entry])
+			}
+		}
+	}
+
+	node.refit()
+	sibling.refit()
+
+	return sibling
+}
+
+// groupBounds returns the bounds of the entries before the cut in the order, and of those after it.
+func groupBounds(entries []box, order []int, cut int) (box, box) {
+	first, second := emptyBox, emptyBox
+	for i, entry := range order {
+		if i < cut {
+			first = first.extend(entries[entry])
+		} else {
+			second = second.extend(entries[entry])
+		}
+	}
+
+	return first, second
+}
+
+// Move takes the location out of the tree, changes its coordinates and inserts it again, all under the tree's
+// lock, so the walks see it either where it was or where it is.
+func (t *rTree) Move(location *Location, lat, lon float64) error {
+	if location == nil {
+		return ErrTreeLocationNil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.remove(location)
+	err := location.Update(lat, lon)
+	t.insert(location)
+
+	return err
+}
+
+func (t *rTree) Remove(location *Location) {
+	if location == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.remove(location)
+	t.mu.Unlock()
+}
+
+// remove finds the leaf holding the location by its coordinates, takes the location out and condenses the tree.
+func (t *rTree) remove(location *Location) {
+	leaf, i := t.root.find(location, pointBox(location.lat, location.lon))
+	if leaf == nil {
+		return
+	}
+
+	leaf.locations = append(leaf.locations[:i], leaf.locations[i+1:]...)
+	t.condense(leaf)
+}
+
+// find returns the leaf under the node holding the location, and its position in the leaf.
+func (node *rNode) find(location *Location, point box) (*rNode, int) {
+	if !node.bounds.covers(point) {
+		return nil, 0
+	}
+
+	if node.leaf {
+		for i, candidate := range node.locations {
+			if candidate == location {
+				return node, i
+			}
+		}
+
+		return nil, 0
+	}
+
+	for _, child := range node.children {
+		if leaf, i := child.find(location, point); leaf != nil {
+			return leaf, i
+		}
+	}
+
+	return nil, 0
+}
+
+// condense goes up from a leaf that lost a location, dissolving the nodes left under the minimum and shrinking
+// the bounds of the others, then inserts the locations of the dissolved nodes again.
+func (t *rTree) condense(node *rNode) {
+	var orphans []*Location
+
+	for node.parent != nil {
+		parent := node.parent
+
+		if node.entries() < t.minEntries {
+			for i, child := range parent.children {
+				if child == node {
+					parent.children = append(parent.children[:i], parent.children[i+1:]...)
+					break
+				}
+			}
+			orphans = node.collect(orphans)
+		} else {
+			node.refit()
+		}
+
+		node = parent
+	}
+	node.refit()
+
+	for !t.root.leaf && len(t.root.children) == 1 {
+		t.root = t.root.children[0]
+		t.root.parent = nil
+	}
+
+	if !t.root.leaf && len(t.root.children) == 0 {
+		t.root = &rNode{bounds: emptyBox, leaf: true}
+	}
+
+	for _, orphan := range orphans {
+		t.insert(orphan)
+	}
+}
+
+// Walk calls visit for copies of the locations within the range that match the filter, until visit returns false.
+// The tree has one lock, so the copies of the whole walk are taken under it before they are visited.
+func (t *rTree) Walk(lat1, lat2, lon1, lon2 float64, filter Filter, visit func(location *Location) bool) {
+	t.mu.RLock()
+	found := t.root.walk(box{lat1: lat1, lat2: lat2, lon1: lon1, lon2: lon2}, filter, nil)
+	t.mu.RUnlock()
+
+	visitAll(found, visit)
+}
+
+// walk appends to found copies of the locations under the node within the area that match the filter.
+func (node *rNode) walk(area box, filter Filter, found []*Location) []*Location {
+	if !rectangleOverlap(node.bounds.lat1, node.bounds.lat2, node.bounds.lon1, node.bounds.lon2, area.lat1, area.lat2, area.lon1, area.lon2) {
+		return found
+	}
+
+	if node.leaf {
+		for _, location := range node.locations {
+			if area.covers(pointBox(location.lat, location.lon)) && filter.Match(location) {
+				found = append(found, location.walkCopy())
+			}
+		}
+
+		return found
+	}
+
+	for _, child := range node.children {
+		found = child.walk(area, filter, found)
+	}
+
+	return found
+}
+
+// WalkPolygon is Walk for the locations inside the polygon. The nodes fully inside it are taken without testing
+// their locations, and the nodes fully outside are skipped.
+func (t *rTree) WalkPolygon(polygon *Polygon, filter Filter, visit func(location *Location) bool) {
+	t.mu.RLock()
+	found := t.root.walkPolygon(polygon, filter, nil)
+	t.mu.RUnlock()
+
+	visitAll(found, visit)
+}
+
+func (node *rNode) walkPolygon(polygon *Polygon, filter Filter, found []*Location) []*Location {
+	if node.entries() == 0 {
+		return found
+	}
+
+	switch polygon.classify(node.bounds.lat1, node.bounds.lat2, node.bounds.lon1, node.bounds.lon2) {
+	case cellOutside:
+		return found
+	case cellInside:
+		for _, location := range node.collect(nil) {
+			if filter.Match(location) {
+				found = append(found, location.walkCopy())
+			}
+		}
+		return found
+	}
+
+	if node.leaf {
+		for _, location := range node.locations {
+			if polygon.Contains(location.lat, location.lon) && filter.Match(location) {
+				found = append(found, location.walkCopy())
+			}
+		}
+
+		return found
+	}
+
+	for _, child := range node.children {
+		found = child.walkPolygon(polygon, filter, found)
+	}
+
+	return found
+}
+
+// Count returns how many locations are within the range, taking the nodes it covers whole by their count.
+func (t *rTree) Count(lat1, lat2, lon1, lon2 float64) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.root.countIn(box{lat1: lat1, lat2: lat2, lon1: lon1, lon2: lon2})
+}
+
+func (node *rNode) countIn(area box) int {
+	if node.count == 0 || !rectangleOverlap(node.bounds.lat1, node.bounds.lat2, node.bounds.lon1, node.bounds.lon2, area.lat1, area.lat2, area.lon1, area.lon2) {
+		return 0
+	}
+
+	if area.covers(node.bounds) {
+		return node.count
+	}
+
+	count := 0
+	if node.leaf {
+		for _, location := range node.locations {
+			if area.covers(pointBox(location.lat, location.lon)) {
+				count++
+			}
+		}
+
+		return count
+	}
+
+	for _, child := range node.children {
+		count += child.countIn(area)
+	}
+
+	return count
+}
+
+// CountPolygon returns how many locations are inside the polygon, taking the nodes fully inside it by their count.
+func (t *rTree) CountPolygon(polygon *Polygon) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.root.countPolygon(polygon)
+}
+
+func (node *rNode) countPolygon(polygon *Polygon) int {
+	if node.count == 0 {
+		return 0
+	}
+
+	switch polygon.classify(node.bounds.lat1, node.bounds.lat2, node.bounds.lon1, node.bounds.lon2) {
+	case cellOutside:
+		return 0
+	case cellInside:
+		return node.count
+	}
+
+	count := 0
+	if node.leaf {
+		for _, location := range node.locations {
+			if polygon.Contains(location.lat, location.lon) {
+				count++
+			}
+		}
+
+		return count
+	}
+
+	for _, child := range node.children {
+		count += child.countPolygon(polygon)
+	}
+
+	return count
+}
+
+// Nearest returns the k locations closest to (lat, lon) that match the filter, closest first. See nearest.
+func (t *rTree) Nearest(lat, lon float64, k int, maxDistance float64, filter Filter) []Neighbor {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return nearest(t.root, lat, lon, k, maxDistance, filter)
+}
+
+// open hands the node's children to the nearest search, or its locations if it is a leaf. The tree's read lock
+// is held all along the search.
+func (node *rNode) open(region func(region nearestRegion, lat1, lat2, lon1, lon2 float64), location func(location *Location)) {
+	if node.leaf {
+		for _, loc := range node.locations {
+			location(loc)
+		}
+
+		return
+	}
+
+	for _, child := range node.children {
+		region(child, child.bounds.lat1, child.bounds.lat2, child.bounds.lon1, child.bounds.lon2)
+	}
+}
+
+func (t *rTree) change(_ *Location, change func()) {
+	t.mu.Lock()
+	change()
+	t.mu.Unlock()
+}
+
+// stats counts the nodes as grids and the leaves as leaves. The depth is the number of levels under the root.
+func (t *rTree) stats(stats *Stats) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	t.root.stats(stats, 0)
+}
+
+func (node *rNode) stats(stats *Stats, depth int) {
+	stats.Grids++
+
+	if node.leaf {
+		stats.Leaves++
+		stats.Depth = max(stats.Depth, depth)
+
+		return
+	}
+
+	for _, child := range node.children {
+		child.stats(stats, depth+1)
+	}
+}
+
+// verify reports the nodes holding too many or too few entries, not pointing back to their parent, out of their
+// parent's bounds, holding locations out of their own or counting a wrong number of them, and the leaves that are
+// not all at the same depth.
+func (t *rTree) verify(visit func(id string, location *Location), report func(format string, args ...any)) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.root.parent != nil {
+		report("root %s has a parent", t.root)
+	}
+
+	leafDepth := -1
+	var walk func(node *rNode, depth int) int
+	walk = func(node *rNode, depth int) int {
+		if node.entries() > t.maxEntries || (node != t.root && node.entries() < t.minEntries) {
+			report("node %s holds %d entries, out of %d to %d", node, node.entries(), t.minEntries, t.maxEntries)
+		}
+
+		if node.leaf {
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if depth != leafDepth {
+				report("leaf %s is at depth %d, not %d", node, depth, leafDepth)
+			}
+
+			for _, location := range node.locations {
+				if location == nil {
+					visit("", nil)
+					continue
+				}
+
+				visit(location.id, location)
+
+				if !node.bounds.covers(pointBox(location.lat, location.lon)) {
+					report("leaf %s holds location %s at %f,%f, out of its bounds", node, location.id, location.lat, location.lon)
+				}
+			}
+
+			if node.count != len(node.locations) {
+				report("leaf %s counts %d locations but holds %d", node, node.count, len(node.locations))
+			}
+
+			return len(node.locations)
+		}
+
+		held := 0
+		for _, child := range node.children {
+			if child.parent != node {
+				report("node %s does not point back to its parent %s", child, node)
+			}
+
+			if !node.bounds.covers(child.bounds) {
+				report("node %s is out of its parent %s", child, node)
+			}
+
+			held += walk(child, depth+1)
+		}
+
+		if node.count != held {
+			report("node %s counts %d locations but holds %d", node, node.count, held)
+		}
+
+		return held
+	}
+	walk(t.root, 0)
+}
+
+func (node *rNode) String() string {
+	return fmt.Sprintf("[%f,%f %f,%f]", node.bounds.lat1, node.bounds.lon1, node.bounds.lat2, node.bounds.lon2)
+}
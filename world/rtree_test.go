@@ -0,0 +1,81 @@
+package world
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRTree(t *testing.T) {
+	t.Parallel()
+
+	verify := func(t *testing.T, tree *rTree) int {
+		count := 0
+		tree.verify(func(string, *Location) {
+			count++
+		}, func(format string, args ...any) {
+			t.Errorf(format, args...)
+		})
+
+		return count
+	}
+
+	t.Run("should split its nodes and keep its leaves at the same depth", func(t *testing.T) {
+		t.Parallel()
+		tree := newRTree(IndexOptions{Capacity: 4})
+		random := rand.New(rand.NewSource(1))
+
+		for i := 0; i < 500; i++ {
+			location, err := NewLocation("ns", strconv.Itoa(i), random.Float64()*180-90, random.Float64()*360-180)
+			assert.NoError(t, err)
+			assert.NoError(t, tree.Insert(location))
+		}
+
+		assert.Equal(t, 500, verify(t, tree))
+
+		var stats Stats
+		tree.stats(&stats)
+		assert.GreaterOrEqual(t, stats.Depth, 3)
+		assert.GreaterOrEqual(t, stats.Leaves, 500/4)
+	})
+
+	t.Run("should condense back to a single leaf as locations are removed", func(t *testing.T) {
+		t.Parallel()
+		tree := newRTree(IndexOptions{Capacity: 4})
+
+		var locations []*Location
+		for i := 0; i < 100; i++ {
+			location, err := NewLocation("ns", strconv.Itoa(i), float64(i)/2, float64(i))
+			assert.NoError(t, err)
+			assert.NoError(t, tree.Insert(location))
+			locations = append(locations, location)
+		}
+
+		for _, location := range locations[:98] {
+			tree.Remove(location)
+		}
+
+		assert.Equal(t, 2, verify(t, tree))
+		assert.True(t, tree.root.leaf)
+		assert.Equal(t, box{lat1: 49, lat2: 49.5, lon1: 98, lon2: 99}, tree.root.bounds)
+
+		tree.Remove(locations[98])
+		tree.Remove(locations[99])
+		assert.Equal(t, 0, verify(t, tree))
+		assert.Equal(t, emptyBox, tree.root.bounds)
+	})
+
+	t.Run("should keep a location where it was when its move is invalid", func(t *testing.T) {
+		t.Parallel()
+		tree := newRTree(IndexOptions{Capacity: 4})
+		location, err := NewLocation("ns", "a", 1, 1)
+		assert.NoError(t, err)
+		assert.NoError(t, tree.Insert(location))
+
+		assert.ErrorIs(t, tree.Move(location, 1, 200), ErrLocationInvalidLongitude)
+		assert.Equal(t, 1, verify(t, tree))
+		assert.Len(t, tree.root.locations, 1)
+	})
+}
@@ -0,0 +1,460 @@
+package world
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	snapshotMagic   = "LGHD"
+	snapshotVersion = uint16(1)
+
+	maxSnapshotStringSize = 1 << 20
+
+	snapshotNamespaceMarker = byte(1)
+	snapshotEndMarker       = byte(0)
+)
+
+var (
+	ErrSnapshotInvalid            = errors.New("not a loggerhead snapshot")
+	ErrSnapshotUnsupportedVersion = errors.New("unsupported snapshot version")
+	ErrSnapshotChecksum           = errors.New("snapshot checksum mismatch")
+
+	snapshotCRCTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// WriteSnapshot writes every namespace in the snapshot format:
+//
+//	magic "LGHD" | version uint16
+//	for each namespace: 0x01 | name | created byte | [capacity | pre-division | max depth | extent | backend] |
+//	                    default ttl | history size | history age | fence count | (id | dwell | rings)... |
+//	                    location count | (id | lat float64 | lon float64 | expiry | attributes)...
+//	0x00 | CRC32-C of everything before it
+//
+// Counts, sizes and durations are uvarints, durations in nanoseconds and the expiry in Unix nanoseconds, 0 for
+// never. Strings are uvarint length prefixed, and a fence's rings are a count of rings, each a count of lat/lon
+// float64 pairs. The index options only follow a created byte of 1. The history itself is not saved. Each namespace is read-locked only while it is written, so the snapshot is consistent per
+// namespace, not across namespaces.
+func (m *World) WriteSnapshot(w io.Writer) error {
+	namespaces := m.allNamespaces()
+
+	enc := newSnapshotEncoder(w)
+
+	enc.writeBytes([]byte(snapshotMagic))
+	enc.writeUint16(snapshotVersion)
+
+	for _, namespace := range namespaces {
+		namespace.mu.RLock()
+		enc.writeByte(snapshotNamespaceMarker)
+		enc.writeString(namespace.Name)
+		if namespace.created {
+			enc.writeByte(1)
+			enc.writeUvarint(uint64(namespace.options.Capacity))
+			enc.writeUvarint(uint64(namespace.options.PreDivide))
+			enc.writeUvarint(uint64(namespace.options.MaxDepth))
+			enc.writeFloat64(namespace.options.Extent.Lat1)
+			enc.writeFloat64(namespace.options.Extent.Lat2)
+			enc.writeFloat64(namespace.options.Extent.Lon1)
+			enc.writeFloat64(namespace.options.Extent.Lon2)
+			enc.writeString(namespace.options.Backend)
+		} else {
+			enc.writeByte(0)
+		}
+		enc.writeUvarint(uint64(namespace.defaultTTL))
+		enc.writeUvarint(uint64(namespace.historySize))
+		enc.writeUvarint(uint64(namespace.historyAge))
+		enc.writeUvarint(uint64(len(namespace.fences)))
+		for id, fence := range namespace.fences {
+			enc.writeString(id)
+			enc.writeUvarint(uint64(fence.Dwell))
+			enc.writeUvarint(uint64(len(fence.Polygon.Rings)))
+			for _, ring := range fence.Polygon.Rings {
+				enc.writeUvarint(uint64(len(ring)))
+				for _, point := range ring {
+					enc.writeFloat64(point.Lat)
+					enc.writeFloat64(point.Lon)
+				}
+			}
+		}
+		enc.writeUvarint(uint64(namespace.locations.len()))
+		for loc := range namespace.locations.all() {
+			enc.writeString(loc.id)
+			enc.writeFloat64(loc.Lat())
+			enc.writeFloat64(loc.Lon())
+			enc.writeUvarint(unixNano(loc.expiresAt))
+			enc.writeUvarint(uint64(len(loc.attributes)))
+			for key, value := range loc.attributes {
+				enc.writeString(key)
+				enc.writeString(value)
+			}
+		}
+		namespace.mu.RUnlock()
+
+		if enc.err != nil {
+			return enc.err
+		}
+	}
+
+	enc.writeByte(snapshotEndMarker)
+
+	return enc.finish()
+}
+
+// ReadSnapshot builds a new world from a snapshot written by WriteSnapshot.
+func ReadSnapshot(r io.Reader) (*World, error) {
+	dec := newSnapshotDecoder(r)
+
+	magic := dec.readBytes(len(snapshotMagic))
+	if dec.err != nil || string(magic) != snapshotMagic {
+		return nil, ErrSnapshotInvalid
+	}
+
+	version := dec.readUint16()
+	if dec.err != nil {
+		return nil, ErrSnapshotInvalid
+	}
+	if version != snapshotVersion {
+		return nil, ErrSnapshotUnsupportedVersion
+	}
+
+	world := NewWorld()
+
+	for {
+		marker := dec.readByte()
+		if dec.err != nil {
+			return nil, dec.err
+		}
+
+		if marker == snapshotEndMarker {
+			break
+		}
+
+		if marker != snapshotNamespaceMarker {
+			return nil, ErrSnapshotInvalid
+		}
+
+		name := dec.readString()
+		if dec.err != nil {
+			return nil, dec.err
+		}
+
+		namespace := world.getNamespace(name)
+		if dec.readByte() == 1 {
+			options := IndexOptions{
+				Capacity:  int(dec.readUvarint()),
+				PreDivide: int(dec.readUvarint()),
+				MaxDepth:  int(dec.readUvarint()),
+			}
+			options.Extent.Lat1 = dec.readFloat64()
+			options.Extent.Lat2 = dec.readFloat64()
+			options.Extent.Lon1 = dec.readFloat64()
+			options.Extent.Lon2 = dec.readFloat64()
+			options.Backend = dec.readString()
+			if dec.err != nil {
+				return nil, dec.err
+			}
+
+			err := world.CreateNamespace(name, options)
+			if err != nil {
+				return nil, ErrSnapshotInvalid
+			}
+		}
+		namespace.defaultTTL = time.Duration(dec.readUvarint())
+		namespace.historySize = int(dec.readUvarint())
+		namespace.historyAge = time.Duration(dec.readUvarint())
+
+		fences := dec.readUvarint()
+		for i := uint64(0); i < fences && dec.err == nil; i++ {
+			id := dec.readString()
+			dwell := time.Duration(dec.readUvarint())
+			rings := dec.readRings()
+			if dec.err != nil {
+				break
+			}
+
+			polygon, err := NewPolygon(rings)
+			if err != nil {
+				return nil, ErrSnapshotInvalid
+			}
+
+			err = namespace.AddFence(id, polygon, dwell)
+			if err != nil {
+				return nil, ErrSnapshotInvalid
+			}
+		}
+
+		count := dec.readUvarint()
+
+		for i := uint64(0); i < count && dec.err == nil; i++ {
+			id := dec.readString()
+			lat := dec.readFloat64()
+			lon := dec.readFloat64()
+			expiresAt := fromUnixNano(dec.readUvarint())
+			attributes := dec.readAttributes()
+
+			if dec.err != nil {
+				break
+			}
+
+			_, err := namespace.saveLocationUntil(id, lat, lon, expiresAt, attributes)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if dec.err != nil {
+			return nil, dec.err
+		}
+	}
+
+	err := dec.verify()
+	if err != nil {
+		return nil, err
+	}
+
+	return world, nil
+}
+
+// LoadSnapshot reads a snapshot and merges it into the world.
+func (m *World) LoadSnapshot(r io.Reader) error {
+	snapshot, err := ReadSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	m.Merge(snapshot)
+
+	return nil
+}
+
+// unixNano returns the time in Unix nanoseconds, 0 for the zero time.
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return uint64(t.UnixNano())
+}
+
+func fromUnixNano(nanos uint64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, int64(nanos))
+}
+
+type snapshotEncoder struct {
+	w       *bufio.Writer
+	crc     hash.Hash32
+	scratch [binary.MaxVarintLen64]byte
+	err     error
+}
+
+func newSnapshotEncoder(w io.Writer) *snapshotEncoder {
+	return &snapshotEncoder{
+		w:   bufio.NewWriter(w),
+		crc: crc32.New(snapshotCRCTable),
+	}
+}
+
+func (e *snapshotEncoder) writeBytes(b []byte) {
+	if e.err != nil {
+		return
+	}
+	e.crc.Write(b)
+	_, e.err = e.w.Write(b)
+}
+
+func (e *snapshotEncoder) writeByte(b byte) {
+	e.scratch[0] = b
+	e.writeBytes(e.scratch[:1])
+}
+
+func (e *snapshotEncoder) writeUint16(v uint16) {
+	e.writeBytes(binary.BigEndian.AppendUint16(e.scratch[:0], v))
+}
+
+func (e *snapshotEncoder) writeUvarint(v uint64) {
+	e.writeBytes(binary.AppendUvarint(e.scratch[:0], v))
+}
+
+func (e *snapshotEncoder) writeFloat64(v float64) {
+	e.writeBytes(binary.BigEndian.AppendUint64(e.scratch[:0], math.Float64bits(v)))
+}
+
+func (e *snapshotEncoder) writeString(s string) {
+	e.writeUvarint(uint64(len(s)))
+	e.writeBytes([]byte(s))
+}
+
+// finish appends the checksum of everything written so far and flushes.
+func (e *snapshotEncoder) finish() error {
+	if e.err != nil {
+		return e.err
+	}
+
+	_, err := e.w.Write(binary.BigEndian.AppendUint32(e.scratch[:0], e.crc.Sum32()))
+	if err != nil {
+		return err
+	}
+
+	return e.w.Flush()
+}
+
+type snapshotDecoder struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+func newSnapshotDecoder(r io.Reader) *snapshotDecoder {
+	return &snapshotDecoder{
+		r:   bufio.NewReader(r),
+		crc: crc32.New(snapshotCRCTable),
+	}
+}
+
+func (d *snapshotDecoder) readBytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	buf := make([]byte, n)
+	_, err := io.ReadFull(d.r, buf)
+	if err != nil {
+		d.err = ErrSnapshotInvalid
+		return nil
+	}
+	d.crc.Write(buf)
+
+	return buf
+}
+
+func (d *snapshotDecoder) readByte() byte {
+	buf := d.readBytes(1)
+	if d.err != nil {
+		return 0
+	}
+
+	return buf[0]
+}
+
+func (d *snapshotDecoder) readUint16() uint16 {
+	buf := d.readBytes(2)
+	if d.err != nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint16(buf)
+}
+
+func (d *snapshotDecoder) readUvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.err = ErrSnapshotInvalid
+		return 0
+	}
+	d.crc.Write(binary.AppendUvarint(nil, v))
+
+	return v
+}
+
+func (d *snapshotDecoder) readFloat64() float64 {
+	buf := d.readBytes(8)
+	if d.err != nil {
+		return 0
+	}
+
+	return math.Float64frombits(binary.BigEndian.Uint64(buf))
+}
+
+func (d *snapshotDecoder) readString() string {
+	length := d.readUvarint()
+	if d.err != nil {
+		return ""
+	}
+
+	if length > maxSnapshotStringSize {
+		d.err = ErrSnapshotInvalid
+		return ""
+	}
+
+	return string(d.readBytes(int(length)))
+}
+
+func (d *snapshotDecoder) readAttributes() Attributes {
+	count := d.readUvarint()
+	if d.err != nil || count == 0 {
+		return nil
+	}
+
+	if count > maxAttributes {
+		d.err = ErrSnapshotInvalid
+		return nil
+	}
+
+	attributes := make(Attributes, count)
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		key := d.readString()
+		attributes[key] = d.readString()
+	}
+
+	return attributes
+}
+
+func (d *snapshotDecoder) readRings() [][]Point {
+	count := d.readUvarint()
+	if d.err != nil {
+		return nil
+	}
+
+	if count > maxSnapshotStringSize {
+		d.err = ErrSnapshotInvalid
+		return nil
+	}
+
+	rings := make([][]Point, 0, count)
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		points := d.readUvarint()
+		if points > maxSnapshotStringSize {
+			d.err = ErrSnapshotInvalid
+			return nil
+		}
+
+		ring := make([]Point, 0, points)
+		for j := uint64(0); j < points && d.err == nil; j++ {
+			lat := d.readFloat64()
+			ring = append(ring, Point{Lat: lat, Lon: d.readFloat64()})
+		}
+		rings = append(rings, ring)
+	}
+
+	return rings
+}
+
+func (d *snapshotDecoder) verify() error {
+	expected := d.crc.Sum32()
+
+	var trailer [4]byte
+	_, err := io.ReadFull(d.r, trailer[:])
+	if err != nil {
+		return ErrSnapshotInvalid
+	}
+
+	if binary.BigEndian.Uint32(trailer[:]) != expected {
+		return ErrSnapshotChecksum
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package world
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Run("should restore every namespace and location", func(t *testing.T) {
+		world := NewWorld()
+		assert.NoError(t, world.Save("ns1", "a", 1.123456789, -2.5))
+		assert.NoError(t, world.Save("ns1", "b", -90, 180))
+		assert.NoError(t, world.Save("ns2", "c", 45, 45))
+
+		var buf bytes.Buffer
+		assert.NoError(t, world.WriteSnapshot(&buf))
+
+		restored, err := ReadSnapshot(&buf)
+		assert.NoError(t, err)
+
+		a, ok := restored.GetLocation("ns1", "a")
+		assert.True(t, ok)
+		assert.Equal(t, 1.123456789, a.Lat())
+		assert.Equal(t, -2.5, a.Lon())
+
+		b, ok := restored.GetLocation("ns1", "b")
+		assert.True(t, ok)
+		assert.Equal(t, -90.0, b.Lat())
+		assert.Equal(t, 180.0, b.Lon())
+
+		_, ok = restored.GetLocation("ns2", "c")
+		assert.True(t, ok)
+	})
+
+	t.Run("should restore default TTLs and expiries", func(t *testing.T) {
+		world := NewWorld()
+		assert.NoError(t, world.SetDefaultTTL("ns", time.Minute))
+		assert.NoError(t, world.SaveWithTTL("ns", "a", 1, 2, time.Hour))
+		assert.NoError(t, world.Save("ns", "b", 3, 4))
+		assert.NoError(t, world.SaveWithTTL("other", "c", 5, 6, 0))
+
+		var buf bytes.Buffer
+		assert.NoError(t, world.WriteSnapshot(&buf))
+
+		restored, err := ReadSnapshot(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Minute, restored.getNamespace("ns").DefaultTTL())
+
+		for _, id := range []string{"a", "b"} {
+			original, _ := world.GetLocation("ns", id)
+			loc, ok := restored.GetLocation("ns", id)
+			assert.True(t, ok)
+			assert.True(t, original.ExpiresAt().Equal(loc.ExpiresAt()), id)
+		}
+
+		c, ok := restored.GetLocation("other", "c")
+		assert.True(t, ok)
+		assert.True(t, c.ExpiresAt().IsZero())
+
+		assert.Equal(t, 2, restored.Expire(time.Now().Add(2*time.Hour), nil))
+	})
+
+	t.Run("should restore attributes", func(t *testing.T) {
+		world := NewWorld()
+		assert.NoError(t, world.SaveWithAttributes("ns", "a", 1, 2, 0, Attributes{"status": "available", "type": "van"}))
+		assert.NoError(t, world.Save("ns", "b", 3, 4))
+
+		restored := NewWorldFromBytes(world.ToBytes())
+
+		a, ok := restored.GetLocation("ns", "a")
+		assert.True(t, ok)
+		assert.Equal(t, Attributes{"status": "available", "type": "van"}, a.Attributes())
+
+		b, ok := restored.GetLocation("ns", "b")
+		assert.True(t, ok)
+		assert.Nil(t, b.Attributes())
+	})
+
+	t.Run("should restore history settings but not the history", func(t *testing.T) {
+		world := NewWorld()
+		assert.NoError(t, world.SetHistory("ns", 5, time.Hour))
+		assert.NoError(t, world.Save("ns", "a", 1, 2))
+
+		restored := NewWorldFromBytes(world.ToBytes())
+		assert.Equal(t, 5, restored.getNamespace("ns").historySize)
+		assert.Equal(t, time.Hour, restored.getNamespace("ns").historyAge)
+
+		positions, err := restored.History("ns", "a", time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		assert.Empty(t, positions)
+	})
+
+	t.Run("should restore fences before the locations entering them", func(t *testing.T) {
+		world := NewWorld()
+		polygon, err := ParsePolygon("POLYGON ((0 0, 10 0, 10 10, 0 10, 0 0), (4 4, 6 4, 6 6, 4 6, 4 4))")
+		assert.NoError(t, err)
+		assert.NoError(t, world.AddFence("ns", "depot", polygon, time.Minute))
+		assert.NoError(t, world.Save("ns", "a", 1, 1))
+
+		restored := NewWorldFromBytes(world.ToBytes())
+		fences := restored.Fences("ns")
+		assert.Len(t, fences, 1)
+		assert.Equal(t, "depot", fences[0].Id)
+		assert.Equal(t, time.Minute, fences[0].Dwell)
+		assert.Equal(t, polygon.String(), fences[0].Polygon.String())
+
+		events, err := restored.WatchFences("ns", 4, DropEvents)
+		assert.NoError(t, err)
+		assert.NoError(t, restored.Save("ns", "a", 50, 50))
+		assert.Equal(t, Event{Kind: EventExit, Ns: "ns", Id: "a", Lat: 50, Lon: 50, Fence: "depot"}, <-events.Events())
+	})
+
+	t.Run("should restore the catalog before the locations", func(t *testing.T) {
+		world := NewWorld()
+		assert.NoError(t, world.CreateNamespace("paris", cityOptions()))
+		assert.NoError(t, world.Save("paris", "a", 48.85, 2.35))
+		assert.NoError(t, world.Save("implicit", "a", 10, 10))
+
+		restored := NewWorldFromBytes(world.ToBytes())
+		assert.Equal(t, []NamespaceOptions{{Name: "paris", Options: cityOptions()}}, restored.Catalog())
+		assert.Equal(t, 48.8, restored.getNamespace("paris").index().(*QuadTree).Root.Lat1)
+		assert.Len(t, restored.QueryRange("paris", 48.8, 48.9, 2.2, 2.5), 1)
+		assert.Equal(t, DefaultIndexOptions(), restored.getNamespace("implicit").Options())
+	})
+
+	t.Run("should restore the index backend", func(t *testing.T) {
+		world := NewWorld()
+		options := cityOptions()
+		options.Backend = IndexGrid
+		assert.NoError(t, world.CreateNamespace("paris", options))
+		assert.NoError(t, world.Save("paris", "a", 48.85, 2.35))
+
+		restored := NewWorldFromBytes(world.ToBytes())
+		assert.Equal(t, options, restored.getNamespace("paris").Options())
+		assert.IsType(t, &gridIndex{}, restored.getNamespace("paris").index())
+		assert.Len(t, restored.QueryRange("paris", 48.8, 48.9, 2.2, 2.5), 1)
+	})
+
+	t.Run("should restore an empty world", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, NewWorld().WriteSnapshot(&buf))
+
+		restored, err := ReadSnapshot(&buf)
+		assert.NoError(t, err)
+		assert.Empty(t, restored.allNamespaces())
+	})
+
+	t.Run("should reject what is not a snapshot", func(t *testing.T) {
+		_, err := ReadSnapshot(bytes.NewReader([]byte("not a snapshot")))
+		assert.ErrorIs(t, err, ErrSnapshotInvalid)
+	})
+
+	t.Run("should reject unknown versions", func(t *testing.T) {
+		_, err := ReadSnapshot(bytes.NewReader([]byte(snapshotMagic + "\x00\x63")))
+		assert.ErrorIs(t, err, ErrSnapshotUnsupportedVersion)
+	})
+
+	t.Run("should detect damaged and truncated snapshots", func(t *testing.T) {
+		world := NewWorld()
+		assert.NoError(t, world.Save("ns", "a", 1, 2))
+
+		var buf bytes.Buffer
+		assert.NoError(t, world.WriteSnapshot(&buf))
+		snapshot := buf.Bytes()
+
+		damaged := bytes.Clone(snapshot)
+		damaged[len(damaged)-8] ^= 0xff
+		_, err := ReadSnapshot(bytes.NewReader(damaged))
+		assert.ErrorIs(t, err, ErrSnapshotChecksum)
+
+		_, err = ReadSnapshot(bytes.NewReader(snapshot[:len(snapshot)-2]))
+		assert.ErrorIs(t, err, ErrSnapshotInvalid)
+	})
+
+	t.Run("should merge a snapshot into an existing world", func(t *testing.T) {
+		source := NewWorld()
+		assert.NoError(t, source.Save("ns", "a", 1, 2))
+
+		var buf bytes.Buffer
+		assert.NoError(t, source.WriteSnapshot(&buf))
+
+		target := NewWorld()
+		assert.NoError(t, target.Save("ns", "b", 3, 4))
+		assert.NoError(t, target.LoadSnapshot(&buf))
+
+		_, ok := target.GetLocation("ns", "a")
+		assert.True(t, ok)
+		_, ok = target.GetLocation("ns", "b")
+		assert.True(t, ok)
+	})
+}
@@ -0,0 +1,292 @@
+package world
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ErrInvalidSlowConsumerPolicy = errors.New("slow consumer policy must be drop or disconnect")
+	ErrInvalidSubscriptionBuffer = errors.New("subscription buffer must be a positive number of events")
+
+	subscriptionsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "loggerhead_world_subscriptions",
+		Help: "Number of open area subscriptions",
+	})
+	droppedEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_world_subscription_dropped_events",
+		Help: "Number of events dropped because a subscriber was too slow",
+	})
+	slowConsumerDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_world_subscription_disconnects",
+		Help: "Number of subscriptions closed because the subscriber was too slow",
+	})
+)
+
+type EventKind int
+
+const (
+	// EventEnter is a location saved inside the area that was outside of it, or did not exist.
+	EventEnter EventKind = iota
+	// EventMove is a location saved inside the area that was already in it.
+	EventMove
+	// EventLeave is a location saved outside the area that was inside of it.
+	EventLeave
+	// EventDelete is a location deleted, or expired, while inside the area.
+	EventDelete
+	// EventExit is a location leaving a fence, by a save outside of it, a delete or an expiry.
+	EventExit
+	// EventDwell is a location saved in a fence it entered at least the fence's dwell time ago.
+	EventDwell
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventEnter:
+		return "ENTER"
+	case EventMove:
+		return "MOVE"
+	case EventLeave:
+		return "LEAVE"
+	case EventDelete:
+		return "DELETE"
+	case EventExit:
+		return "EXIT"
+	case EventDwell:
+		return "DWELL"
+	}
+
+	return "UNKNOWN"
+}
+
+// Event is a change of a location seen by a subscription. Lat and Lon are the new position, or the last one for
+// EventDelete. Fence events also carry the id of their fence.
+type Event struct {
+	Kind       EventKind
+	Ns         string
+	Id         string
+	Lat        float64
+	Lon        float64
+	Attributes Attributes
+	Fence      string
+}
+
+// SlowConsumerPolicy decides what happens to the events of a subscription whose buffer is full.
+type SlowConsumerPolicy int
+
+const (
+	// DropEvents drops the events that do not fit in the buffer and keeps the subscription open.
+	DropEvents SlowConsumerPolicy = iota
+	// Disconnect closes the subscription as soon as an event does not fit in the buffer.
+	Disconnect
+)
+
+// ParseSlowConsumerPolicy reads "drop" or "disconnect".
+func ParseSlowConsumerPolicy(policy string) (SlowConsumerPolicy, error) {
+	switch policy {
+	case "drop":
+		return DropEvents, nil
+	case "disconnect":
+		return Disconnect, nil
+	}
+
+	return DropEvents, ErrInvalidSlowConsumerPolicy
+}
+
+// Subscription receives the events of the locations of a namespace entering, moving in, leaving or being deleted
+// from an area, or the events of fences. Events are sent while the location is saved, so they arrive in the order
+// the writes happened.
+type Subscription struct {
+	events chan Event
+	// lock serializes the sends and the closing: it is the namespace's lock for areas and the fence hub's for fences.
+	lock sync.Locker
+	// detach removes the subscription from where its events come from. It is called under lock.
+	detach  func(subscription *Subscription)
+	boxes   []box
+	policy  SlowConsumerPolicy
+	closed  bool
+	dropped atomic.Uint64
+}
+
+// Events returns the channel the events are delivered on. It is closed when the subscription is closed, by Close
+// or by the Disconnect policy.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns how many events the DropEvents policy dropped so far.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.stop()
+}
+
+// stop detaches the subscription and closes its channel. Call it under s.lock.
+func (s *Subscription) stop() {
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	s.detach(s)
+	close(s.events)
+	subscriptionsGauge.Dec()
+}
+
+func (s *Subscription) contains(lat, lon float64) bool {
+	return boxesContain(s.boxes, lat, lon)
+}
+
+// send delivers an event without ever blocking the writer. Call it under s.lock.
+func (s *Subscription) send(event Event) {
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.events <- event:
+		return
+	default:
+	}
+
+	if s.policy == Disconnect {
+		slowConsumerDisconnects.Inc()
+		s.stop()
+		return
+	}
+
+	s.dropped.Add(1)
+	droppedEvents.Inc()
+}
+
+// Subscribe opens a subscription to the changes within the range, crossing the antimeridian when lon1 > lon2.
+// The buffer is the number of events waiting to be read before the policy kicks in.
+func (n *Namespace) Subscribe(lat1, lat2, lon1, lon2 float64, buffer int, policy SlowConsumerPolicy) (*Subscription, error) {
+	for _, corner := range [][2]float64{{lat1, lon1}, {lat2, lon2}} {
+		err := validateLatLon(corner[0], corner[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if buffer <= 0 {
+		return nil, ErrInvalidSubscriptionBuffer
+	}
+
+	if lat1 > lat2 {
+		lat1, lat2 = lat2, lat1
+	}
+
+	subscription := &Subscription{
+		events: make(chan Event, buffer),
+		lock:   &n.mu,
+		detach: n.unsubscribe,
+		boxes:  splitRange(lat1, lat2, lon1, lon2),
+		policy: policy,
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.dropped {
+		return nil, errNamespaceDropped
+	}
+
+	if n.subscriptions == nil {
+		n.subscriptions = newRegionTree[*Subscription]()
+	}
+
+	for _, b := range subscription.boxes {
+		n.subscriptions.insert(b, subscription)
+	}
+	subscriptionsGauge.Inc()
+
+	return subscription, nil
+}
+
+// unsubscribe removes the subscription from the index. Call it under n.mu.
+func (n *Namespace) unsubscribe(subscription *Subscription) {
+	for _, b := range subscription.boxes {
+		n.subscriptions.remove(b, subscription)
+	}
+}
+
+// publishSave tells the subscriptions about a location saved at its current position, from (oldLat, oldLon) if
+// it existed. Call it under n.mu.
+func (n *Namespace) publishSave(loc *Location, existed bool, oldLat, oldLon float64) {
+	if n.subscriptions == nil || n.subscriptions.size == 0 {
+		return
+	}
+
+	var before []*Subscription
+	if existed {
+		before = n.subscriptions.match(oldLat, oldLon, nil)
+	}
+	after := n.subscriptions.match(loc.lat, loc.lon, nil)
+
+	event := Event{Ns: n.Name, Id: loc.id, Lat: loc.lat, Lon: loc.lon, Attributes: loc.attributes}
+
+	// left holds the subscriptions the location was in, until it is found still in them.
+	left := make(map[*Subscription]bool, len(before))
+	for _, subscription := range before {
+		left[subscription] = true
+	}
+
+	for _, subscription := range after {
+		event.Kind = EventEnter
+		if left[subscription] {
+			event.Kind = EventMove
+			left[subscription] = false
+		}
+		subscription.send(event)
+	}
+
+	event.Kind = EventLeave
+	for _, subscription := range before {
+		if left[subscription] {
+			subscription.send(event)
+		}
+	}
+}
+
+// publishDelete tells the subscriptions about a location deleted at its last position. Call it under n.mu.
+func (n *Namespace) publishDelete(loc *Location) {
+	if n.subscriptions == nil || n.subscriptions.size == 0 {
+		return
+	}
+
+	event := Event{Kind: EventDelete, Ns: n.Name, Id: loc.id, Lat: loc.lat, Lon: loc.lon, Attributes: loc.attributes}
+	for _, subscription := range n.subscriptions.match(loc.lat, loc.lon, nil) {
+		subscription.send(event)
+	}
+}
+
+// Subscribe opens a subscription to the changes of a namespace within a range. See Namespace.Subscribe.
+// Subscribing creates the namespace, unless the world is strict.
+func (m *World) Subscribe(ns string, lat1, lat2, lon1, lon2 float64, buffer int, policy SlowConsumerPolicy) (*Subscription, error) {
+	err := m.CheckNamespace(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscription *Subscription
+
+	err = m.write(ns, func(namespace *Namespace) error {
+		var err error
+		subscription, err = namespace.Subscribe(lat1, lat2, lon1, lon2, buffer, policy)
+
+		return err
+	})
+
+	return subscription, err
+}
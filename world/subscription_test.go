@@ -0,0 +1,187 @@
+package world
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscription(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should stream enter, move, leave and delete events", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		subscription, err := world.Subscribe("ns", 0, 10, 0, 10, 16, DropEvents)
+		assert.NoError(t, err)
+
+		assert.NoError(t, world.Save("ns", "a", 20, 20))
+		assert.NoError(t, world.SaveWithAttributes("ns", "a", 1, 1, 0, Attributes{"status": "busy"}))
+		assert.NoError(t, world.Save("ns", "a", 2, 2))
+		assert.NoError(t, world.Save("ns", "a", 30, 30))
+		assert.NoError(t, world.Save("ns", "b", 5, 5))
+		assert.NoError(t, world.Delete("ns", "b"))
+		assert.NoError(t, world.Save("other", "c", 5, 5))
+
+		expected := []Event{
+			{Kind: EventEnter, Ns: "ns", Id: "a", Lat: 1, Lon: 1, Attributes: Attributes{"status": "busy"}},
+			{Kind: EventMove, Ns: "ns", Id: "a", Lat: 2, Lon: 2, Attributes: Attributes{"status": "busy"}},
+			{Kind: EventLeave, Ns: "ns", Id: "a", Lat: 30, Lon: 30, Attributes: Attributes{"status": "busy"}},
+			{Kind: EventEnter, Ns: "ns", Id: "b", Lat: 5, Lon: 5},
+			{Kind: EventDelete, Ns: "ns", Id: "b", Lat: 5, Lon: 5},
+		}
+		for _, event := range expected {
+			assert.Equal(t, event, <-subscription.Events())
+		}
+
+		subscription.Close()
+		subscription.Close()
+		_, open := <-subscription.Events()
+		assert.False(t, open)
+
+		assert.NoError(t, world.Save("ns", "a", 1, 1))
+	})
+
+	t.Run("should send expirations as deletes", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		subscription, err := world.Subscribe("ns", -10, 10, -10, 10, 16, DropEvents)
+		assert.NoError(t, err)
+
+		assert.NoError(t, world.SaveWithTTL("ns", "a", 1, 1, time.Minute))
+		assert.Equal(t, 1, world.Expire(time.Now().Add(time.Hour), nil))
+
+		assert.Equal(t, EventEnter, (<-subscription.Events()).Kind)
+		assert.Equal(t, EventDelete, (<-subscription.Events()).Kind)
+	})
+
+	t.Run("should watch areas across the antimeridian", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		subscription, err := world.Subscribe("ns", -20, -10, 170, -170, 16, DropEvents)
+		assert.NoError(t, err)
+
+		assert.NoError(t, world.Save("ns", "fiji", -17, 178))
+		assert.NoError(t, world.Save("ns", "fiji", -15, -175))
+		assert.NoError(t, world.Save("ns", "fiji", -15, 0))
+
+		assert.Equal(t, EventEnter, (<-subscription.Events()).Kind)
+		assert.Equal(t, EventMove, (<-subscription.Events()).Kind)
+		assert.Equal(t, EventLeave, (<-subscription.Events()).Kind)
+	})
+
+	t.Run("should count the areas across the antimeridian once", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		_, err := world.Subscribe("ns", -20, -10, 170, -170, 16, DropEvents)
+		assert.NoError(t, err)
+		_, err = world.Subscribe("ns", 0, 10, 0, 10, 16, DropEvents)
+		assert.NoError(t, err)
+
+		stats, err := world.Stats("ns")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, stats.Subscriptions)
+	})
+
+	t.Run("should drop events for slow consumers", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		subscription, err := world.Subscribe("ns", 0, 10, 0, 10, 2, DropEvents)
+		assert.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, world.Save("ns", "a", 1, float64(i)))
+		}
+
+		assert.Equal(t, uint64(3), subscription.Dropped())
+		assert.Equal(t, 0.0, (<-subscription.Events()).Lon)
+		assert.Equal(t, 1.0, (<-subscription.Events()).Lon)
+
+		assert.NoError(t, world.Save("ns", "a", 1, 9))
+		assert.Equal(t, 9.0, (<-subscription.Events()).Lon)
+	})
+
+	t.Run("should disconnect slow consumers", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		subscription, err := world.Subscribe("ns", 0, 10, 0, 10, 2, Disconnect)
+		assert.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, world.Save("ns", "a", 1, float64(i)))
+		}
+
+		var events []Event
+		for event := range subscription.Events() {
+			events = append(events, event)
+		}
+		assert.Len(t, events, 2)
+		subscription.Close()
+	})
+
+	t.Run("should reject invalid subscriptions", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+
+		_, err := world.Subscribe("ns", 0, 100, 0, 10, 1, DropEvents)
+		assert.ErrorIs(t, err, ErrLocationInvalidLatitude)
+
+		_, err = world.Subscribe("ns", 0, 10, 0, 10, 0, DropEvents)
+		assert.ErrorIs(t, err, ErrInvalidSubscriptionBuffer)
+
+		_, err = ParseSlowConsumerPolicy("block")
+		assert.ErrorIs(t, err, ErrInvalidSlowConsumerPolicy)
+	})
+
+	t.Run("should match the same subscriptions as a linear scan", func(t *testing.T) {
+		t.Parallel()
+		tree := newRegionTree[*Subscription]()
+		var subscriptions []*Subscription
+
+		for i := 0; i < 2000; i++ {
+			lat := -90 + rand.Float64()*170
+			lon := -180 + rand.Float64()*350
+			size := rand.Float64() * 10
+			if i%10 == 0 {
+				size = rand.Float64() * 0.01
+			}
+
+			subscription := &Subscription{boxes: []box{{lat1: lat, lat2: lat + size, lon1: lon, lon2: lon + size}}}
+			tree.insert(subscription.boxes[0], subscription)
+			subscriptions = append(subscriptions, subscription)
+		}
+
+		for i := 0; i < 500; i++ {
+			tree.remove(subscriptions[i].boxes[0], subscriptions[i])
+		}
+		assert.Equal(t, 1500, tree.size)
+
+		for i := 0; i < 1000; i++ {
+			lat := -90 + rand.Float64()*180
+			lon := -180 + rand.Float64()*360
+
+			var expected []string
+			for _, subscription := range subscriptions[500:] {
+				if subscription.contains(lat, lon) {
+					expected = append(expected, strconv.Itoa(int(subscription.boxes[0].lat1*1e6)))
+				}
+			}
+
+			var actual []string
+			for _, subscription := range tree.match(lat, lon, nil) {
+				actual = append(actual, strconv.Itoa(int(subscription.boxes[0].lat1*1e6)))
+			}
+
+			assert.ElementsMatch(t, expected, actual)
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"math"
 	"sync"
+	"sync/atomic"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -14,6 +15,10 @@ var (
 		Name: "loggerhead_world_tree_division",
 		Help: "The number of time the tree divides itself",
 	})
+	treeMerge = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loggerhead_world_tree_merge",
+		Help: "The number of time the tree merges leaves back into their parent",
+	})
 )
 
 type QuadTree struct {
@@ -33,22 +38,32 @@ type TreeNode struct {
 	Objects   map[string]*Location
 	Capacity  int
 	IsDivided bool
+	parent    *TreeNode
+	depth     int
+	// maxDepth is the deepest a node can be and still divide, 0 for no limit.
+	maxDepth int
+	// pinned nodes were divided by ForceDivide and are never merged back.
+	pinned bool
+	// count is the number of locations in the leaves under the node, so counts take the nodes they cover whole
+	// without visiting them.
+	count atomic.Int64
 }
 
 func NewQuadTree(lat1, lat2, lon1, lon2 float64) *QuadTree {
-	qt := &QuadTree{
-		Root: &TreeNode{
-			IsDivided: false,
-			Capacity:  500,
-			Lat1:      lat1,
-			Lat2:      lat2,
-			Lon1:      lon1,
-			Lon2:      lon2,
-			Objects:   make(map[string]*Location),
-		},
-	}
+	options := DefaultIndexOptions()
+	options.Extent = Extent{Lat1: lat1, Lat2: lat2, Lon1: lon1, Lon2: lon2}
+
+	return NewQuadTreeWithOptions(options)
+}
+
+// NewQuadTreeWithOptions returns a tree covering the options' extent, divided PreDivide levels deep up front.
+// The options are expected to be valid.
+func NewQuadTreeWithOptions(options IndexOptions) *QuadTree {
+	root := NewTreeNode(options.Extent.Lat1, options.Extent.Lat2, options.Extent.Lon1, options.Extent.Lon2, options.Capacity)
+	root.maxDepth = options.MaxDepth
 
-	qt.Root.ForceDivide(5)
+	qt := &QuadTree{Root: root}
+	qt.Root.ForceDivide(options.PreDivide)
 
 	return qt
 }
@@ -57,7 +72,84 @@ func (q *QuadTree) Insert(location *Location) error {
 	if location == nil {
 		return ErrTreeLocationNil
 	}
-	return q.Root.insert(location)
+
+	previous := location.Node()
+
+	err := q.Root.insert(location)
+	if err != nil {
+		return err
+	}
+
+	// A location moving out of a leaf may leave it and its siblings empty enough to merge.
+	if previous != nil && previous != location.Node() {
+		previous.mergeUp()
+	}
+
+	return nil
+}
+
+// Move changes the location's coordinates under the lock of its leaf, then inserts it where it now belongs.
+func (q *QuadTree) Move(location *Location, lat, lon float64) error {
+	if location == nil {
+		return ErrTreeLocationNil
+	}
+
+	var err error
+	withOwner(location, func() {
+		err = location.Update(lat, lon)
+	})
+	if err != nil {
+		return err
+	}
+
+	return q.Insert(location)
+}
+
+// Remove takes the location out of the leaf holding it, then merges that leaf with its siblings if they hold
+// few enough locations.
+func (q *QuadTree) Remove(location *Location) {
+	if location == nil {
+		return
+	}
+
+	node := detach(location)
+	if node != nil {
+		node.mergeUp()
+	}
+}
+
+// Walk calls visit for copies of the locations within the range that match the filter, a leaf at a time, until
+// visit returns false.
+func (q *QuadTree) Walk(lat1, lat2, lon1, lon2 float64, filter Filter, visit func(location *Location) bool) {
+	q.Root.Walk(lat1, lat2, lon1, lon2, filter, visit)
+}
+
+// WalkPolygon is Walk for the locations inside the polygon.
+func (q *QuadTree) WalkPolygon(polygon *Polygon, filter Filter, visit func(location *Location) bool) {
+	q.Root.WalkPolygon(polygon, filter, visit)
+}
+
+// Nearest returns the k locations closest to (lat, lon) that match the filter, closest first.
+func (q *QuadTree) Nearest(lat, lon float64, k int, maxDistance float64, filter Filter) []Neighbor {
+	return q.Root.Nearest(lat, lon, k, maxDistance, filter)
+}
+
+// Count returns how many locations are within the range, bounds included.
+func (q *QuadTree) Count(lat1, lat2, lon1, lon2 float64) int {
+	return q.Root.Count(lat1, lat2, lon1, lon2)
+}
+
+// CountPolygon returns how many locations are inside the polygon.
+func (q *QuadTree) CountPolygon(polygon *Polygon) int {
+	return q.Root.CountPolygon(polygon)
+}
+
+func (q *QuadTree) change(location *Location, change func()) {
+	withOwner(location, change)
+}
+
+func (q *QuadTree) stats(stats *Stats) {
+	q.Root.stats(stats)
 }
 
 func NewTreeNode(lat1, lat2, lon1, lon2 float64, capacity int) *TreeNode {
@@ -99,26 +191,95 @@ func (node *TreeNode) insert(location *Location) error {
 		return err
 	}
 
-	// If the node is not divided, insert the location into the node
-	if location.Node != nil && location.Node != node {
-		location.Node.Delete(location.Id())
+	// If the node is not divided, the location moves into it. It is taken out of the leaf owning it and handed
+	// over while both leaves are locked, so a concurrent detach finds it in the one or the other.
+	// The node's lock is held, so a location it already owns cannot be handed elsewhere meanwhile.
+	if location.Node() != node {
+		if owner := lockOwner(location); owner != nil {
+			delete(owner.Objects, location.Id())
+			owner.addCount(-1)
+			location.SetNode(node)
+			owner.mu.Unlock()
+		}
+		node.addCount(1)
 	}
 	node.Objects[location.Id()] = location
 	location.SetNode(node)
 
-	if len(node.Objects) > node.Capacity {
+	if len(node.Objects) > node.Capacity && node.canDivide() {
 		node.divide()
 	}
 
 	return nil
 }
 
+// canDivide tells if the node is above the maximum depth. Leaves at the maximum depth hold more than their
+// capacity rather than divide, which also stops a crowd of locations at the same point dividing forever.
+func (node *TreeNode) canDivide() bool {
+	return node.maxDepth == 0 || node.depth < node.maxDepth
+}
+
+// Delete removes the id from this leaf only. Use QuadTree.Remove to remove a location wherever it is.
 func (node *TreeNode) Delete(id string) {
 	node.mu.Lock()
-	delete(node.Objects, id)
+	if _, ok := node.Objects[id]; ok {
+		delete(node.Objects, id)
+		node.addCount(-1)
+	}
 	node.mu.Unlock()
 }
 
+// addCount adds delta to the count of the node and of the nodes above it.
+func (node *TreeNode) addCount(delta int64) {
+	for ; node != nil; node = node.parent {
+		node.count.Add(delta)
+	}
+}
+
+// detach removes the location from the leaf owning it and returns that leaf. The location is left without an
+// owner, as if it was never inserted.
+func detach(location *Location) *TreeNode {
+	node := lockOwner(location)
+	if node == nil {
+		return nil
+	}
+
+	delete(node.Objects, location.Id())
+	node.addCount(-1)
+	location.node.Store(nil)
+	node.mu.Unlock()
+
+	return node
+}
+
+// lockOwner locks and returns the leaf owning the location, nil if it has none. A divide or a merge may hand the
+// location to another leaf while lockOwner waits for the lock, so the owner is looked up again until it holds.
+// Only leaves own locations, so a caller holding the locks down to a leaf, as insert does, never waits on itself.
+func lockOwner(location *Location) *TreeNode {
+	for {
+		node := location.Node()
+		if node == nil {
+			return nil
+		}
+
+		node.mu.Lock()
+		if location.Node() == node {
+			return node
+		}
+		node.mu.Unlock()
+	}
+}
+
+// withOwner runs change under the lock of the leaf owning the location, so the walks of that leaf never see the
+// location half changed.
+func withOwner(location *Location, change func()) {
+	node := lockOwner(location)
+	change()
+	if node != nil {
+		node.mu.Unlock()
+	}
+}
+
 func (node *TreeNode) divide() {
 	defer treeDivision.Inc()
 	if node.IsDivided {
@@ -130,70 +291,201 @@ func (node *TreeNode) divide() {
 	node.NE = NewTreeNode((node.Lat1+node.Lat2)/2, node.Lat2, (node.Lon1+node.Lon2)/2, node.Lon2, node.Capacity)
 	node.NW = NewTreeNode((node.Lat1+node.Lat2)/2, node.Lat2, node.Lon1, (node.Lon1+node.Lon2)/2, node.Capacity)
 
-	node.IsDivided = true
+	children := []*TreeNode{node.NW, node.NE, node.SW, node.SE}
+	for _, child := range children {
+		child.parent = node
+		child.depth = node.depth + 1
+		child.maxDepth = node.maxDepth
+		child.mu.Lock()
+	}
+
+	// The locations are handed to the children directly, and keep pointing to a node holding them all along, so
+	// a concurrent detach finds them.
 	for i, location := range node.Objects {
 		if location == nil {
 			panic("The Node is holding nil location. weird don't you think?. Location index: " + i)
 		}
-		delete(node.Objects, location.Id())
-		location.Node = nil
 
-		err := node.NW.insert(location)
-		if err != nil {
-			err = node.NE.insert(location)
-			if err != nil {
-				err = node.SW.insert(location)
-				if err != nil {
-					_ = node.SE.insert(location) // Ignoring the error here as it means the object has been moved mid-flight to another node which is not a problem
-				}
-			}
-		}
+		child := node.childContaining(location.lat, location.lon)
+		child.Objects[location.Id()] = location
+		location.SetNode(child)
 	}
 
+	node.IsDivided = true
 	node.Objects = map[string]*Location{}
+
+	for _, child := range children {
+		child.count.Store(int64(len(child.Objects)))
+		if len(child.Objects) > child.Capacity && child.canDivide() {
+			child.divide()
+		}
+		child.mu.Unlock()
+	}
+}
+
+// childContaining returns the child a location within the node goes to, the first of NW, NE, SW and SE holding
+// it as insert tries them.
+func (node *TreeNode) childContaining(lat, lon float64) *TreeNode {
+	for _, child := range []*TreeNode{node.NW, node.NE, node.SW} {
+		if child.Lon1 <= lon && lon <= child.Lon2 && child.Lat1 <= lat && lat <= child.Lat2 {
+			return child
+		}
+	}
+
+	return node.SE
+}
+
+// lowWaterMark is the number of locations four sibling leaves must hold at most to be merged back into their
+// parent. It is well below the capacity so a leaf does not merge and divide again for a handful of moves.
+func (node *TreeNode) lowWaterMark() int {
+	return node.Capacity / 2
+}
+
+// mergeUp merges the leaf's parent, then its grandparent and so on, for as long as their children are leaves
+// holding few enough locations. It must be called without holding any node's lock.
+func (node *TreeNode) mergeUp() {
+	for parent := node.parent; parent != nil; parent = parent.parent {
+		if !parent.merge() {
+			return
+		}
+	}
+}
+
+// merge turns a node whose children are all leaves holding at most lowWaterMark locations back into a leaf, and
+// tells if it did. The node's lock is taken before its children's, as insert does. The children are left empty
+// rather than unlinked, so a walk that already went past the node finds them and carries on.
+func (node *TreeNode) merge() bool {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	if !node.IsDivided || node.pinned {
+		return false
+	}
+
+	children := []*TreeNode{node.NW, node.NE, node.SW, node.SE}
+	for i, child := range children {
+		child.mu.Lock()
+		defer children[i].mu.Unlock()
+	}
+
+	count := 0
+	for _, child := range children {
+		if child.IsDivided {
+			return false
+		}
+		count += len(child.Objects)
+	}
+
+	if count > node.lowWaterMark() {
+		return false
+	}
+
+	objects := make(map[string]*Location, count)
+	for _, child := range children {
+		for id, location := range child.Objects {
+			objects[id] = location
+			location.SetNode(node)
+		}
+		child.Objects = map[string]*Location{}
+		child.count.Store(0)
+	}
+
+	node.Objects = objects
+	node.IsDivided = false
+	treeMerge.Inc()
+
+	return true
 }
 
+// rectangleOverlap tells if two rectangles intersect. Touching edges count, as points on an edge belong to both.
 func rectangleOverlap(lat1, lat2, lon1, lon2, lat3, lat4, lon3, lon4 float64) bool {
-	return math.Max(lat1, lat3) < math.Min(lat2, lat4) && math.Max(lon1, lon3) < math.Min(lon2, lon4)
+	return math.Max(lat1, lat3) <= math.Min(lat2, lat4) && math.Max(lon1, lon3) <= math.Min(lon2, lon4)
 }
 
+// QueryRange returns the locations within the range, bounds included. A range with lon1 > lon2 crosses the
+// antimeridian: it runs east from lon1 to 180 and carries on from -180 to lon2.
 func (node *TreeNode) QueryRange(lat1, lat2, lon1, lon2 float64) []*Location {
-
 	var locations []*Location
 
+	for _, b := range splitRange(lat1, lat2, lon1, lon2) {
+		node.Walk(b.lat1, b.lat2, b.lon1, b.lon2, nil, func(location *Location) bool {
+			locations = append(locations, location)
+			return true
+		})
+	}
+
+	return locations
+}
+
+// Walk calls visit for copies of the locations within the range, bounds included, that match the filter, until
+// visit returns false, and returns false if it did. The copies of a leaf are taken under its read lock and
+// visited once it is released.
+func (node *TreeNode) Walk(lat1, lat2, lon1, lon2 float64, filter Filter, visit func(location *Location) bool) bool {
 	if !rectangleOverlap(node.Lat1, node.Lat2, node.Lon1, node.Lon2, lat1, lat2, lon1, lon2) {
-		return locations
+		return true
 	}
 
+	node.mu.RLock()
 	if !node.IsDivided {
-		node.mu.RLock()
+		var found []*Location
 		for _, location := range node.Objects {
-			if location.Lon() >= lon1 && location.Lon() <= lon2 && location.Lat() >= lat1 && location.Lat() <= lat2 {
-				locations = append(locations, location)
+			if location.Lon() >= lon1 && location.Lon() <= lon2 && location.Lat() >= lat1 && location.Lat() <= lat2 && filter.Match(location) {
+				found = append(found, location.walkCopy())
 			}
 		}
 		node.mu.RUnlock()
 
-		return locations
+		return visitAll(found, visit)
 	}
+	children := node.children()
+	node.mu.RUnlock()
 
-	if rectangleOverlap(node.NE.Lat1, node.NE.Lat2, node.NE.Lon1, node.NE.Lon2, lat1, lat2, lon1, lon2) {
-		locations = append(locations, node.NE.QueryRange(lat1, lat2, lon1, lon2)...)
+	for _, child := range children {
+		if !child.Walk(lat1, lat2, lon1, lon2, filter, visit) {
+			return false
+		}
 	}
 
-	if rectangleOverlap(node.NW.Lat1, node.NW.Lat2, node.NW.Lon1, node.NW.Lon2, lat1, lat2, lon1, lon2) {
-		locations = append(locations, node.NW.QueryRange(lat1, lat2, lon1, lon2)...)
+	return true
+}
+
+// Count returns how many locations are within the range, bounds included. The nodes the range covers whole are
+// taken by their count, and only the leaves it crosses are searched.
+func (node *TreeNode) Count(lat1, lat2, lon1, lon2 float64) int {
+	if !rectangleOverlap(node.Lat1, node.Lat2, node.Lon1, node.Lon2, lat1, lat2, lon1, lon2) {
+		return 0
 	}
 
-	if rectangleOverlap(node.SE.Lat1, node.SE.Lat2, node.SE.Lon1, node.SE.Lon2, lat1, lat2, lon1, lon2) {
-		locations = append(locations, node.SE.QueryRange(lat1, lat2, lon1, lon2)...)
+	if lat1 <= node.Lat1 && node.Lat2 <= lat2 && lon1 <= node.Lon1 && node.Lon2 <= lon2 {
+		return int(node.count.Load())
 	}
 
-	if rectangleOverlap(node.SW.Lat1, node.SW.Lat2, node.SW.Lon1, node.SW.Lon2, lat1, lat2, lon1, lon2) {
-		locations = append(locations, node.SW.QueryRange(lat1, lat2, lon1, lon2)...)
+	count := 0
+
+	node.mu.RLock()
+	if !node.IsDivided {
+		for _, location := range node.Objects {
+			if location.Lon() >= lon1 && location.Lon() <= lon2 && location.Lat() >= lat1 && location.Lat() <= lat2 {
+				count++
+			}
+		}
+		node.mu.RUnlock()
+
+		return count
 	}
+	children := node.children()
+	node.mu.RUnlock()
 
-	return locations
+	for _, child := range children {
+		count += child.Count(lat1, lat2, lon1, lon2)
+	}
+
+	return count
+}
+
+// children returns the node's children, in the order the walks visit them. Call it under the node's lock.
+func (node *TreeNode) children() []*TreeNode {
+	return []*TreeNode{node.NE, node.NW, node.SE, node.SW}
 }
 
 func (node *TreeNode) ForceDivide(level int) {
@@ -205,6 +497,7 @@ func (node *TreeNode) ForceDivide(level int) {
 	if !node.IsDivided {
 		node.divide()
 	}
+	node.pinned = true
 	node.mu.Unlock()
 
 	level--
@@ -214,3 +507,24 @@ func (node *TreeNode) ForceDivide(level int) {
 	node.SE.ForceDivide(level)
 	node.SW.ForceDivide(level)
 }
+
+// stats adds the node and the nodes under it to the namespace's statistics.
+func (node *TreeNode) stats(stats *Stats) {
+	node.mu.RLock()
+	divided := node.IsDivided
+	children := node.children()
+	node.mu.RUnlock()
+
+	stats.Grids++
+
+	if !divided {
+		stats.Leaves++
+		stats.Depth = max(stats.Depth, node.depth)
+
+		return
+	}
+
+	for _, child := range children {
+		child.stats(stats)
+	}
+}
@@ -1,6 +1,9 @@
 package world
 
 import (
+	"math/rand"
+	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -18,7 +21,7 @@ func TestTree(t *testing.T) {
 
 			err = tree.Insert(loc)
 			assert.ErrorIs(t, err, nil)
-			assert.NotNil(t, loc.Node)
+			assert.NotNil(t, loc.Node())
 
 			tree.Root.Delete("locId")
 
@@ -26,7 +29,7 @@ func TestTree(t *testing.T) {
 
 			assert.Len(t, locations, 1, "Delete was cascaded, which we don't want as it is slow")
 
-			loc.Node.Delete("locId")
+			loc.Node().Delete("locId")
 			locations = tree.Root.QueryRange(-90, 90, -180, 180)
 			assert.Len(t, locations, 0)
 		})
@@ -131,4 +134,133 @@ func TestTree(t *testing.T) {
 			}
 		})
 	})
+	t.Run("Merge", func(t *testing.T) {
+		t.Parallel()
+
+		newSmallTree := func() *QuadTree {
+			return &QuadTree{Root: NewTreeNode(-90, 90, -180, 180, 4)}
+		}
+
+		insert := func(t *testing.T, tree *QuadTree, id string, lat, lon float64) *Location {
+			loc, err := NewLocation("ns", id, lat, lon)
+			assert.NoError(t, err)
+			assert.NoError(t, tree.Insert(loc))
+
+			return loc
+		}
+
+		t.Run("Should merge sibling leaves once removals bring them under the low-water mark", func(t *testing.T) {
+			t.Parallel()
+			tree := newSmallTree()
+
+			var locations []*Location
+			for i := 0; i < 5; i++ {
+				locations = append(locations, insert(t, tree, strconv.Itoa(i), float64(i*10), float64(i*20)))
+			}
+			assert.True(t, tree.Root.IsDivided)
+
+			tree.Remove(locations[0])
+			tree.Remove(locations[1])
+			assert.True(t, tree.Root.IsDivided, "3 locations are above the low-water mark of 2")
+
+			tree.Remove(locations[2])
+			assert.False(t, tree.Root.IsDivided)
+			assert.Len(t, tree.Root.Objects, 2)
+			assert.Same(t, tree.Root, locations[3].Node())
+			assert.Same(t, tree.Root, locations[4].Node())
+			assert.Empty(t, tree.Root.NE.Objects)
+			assert.Len(t, tree.Root.QueryRange(-90, 90, -180, 180), 2)
+		})
+
+		t.Run("Should merge the leaves points moved out of, level after level", func(t *testing.T) {
+			t.Parallel()
+			tree := newSmallTree()
+
+			var downtown []*Location
+			for i := 0; i < 5; i++ {
+				downtown = append(downtown, insert(t, tree, strconv.Itoa(i), 45+float64(i), 90+float64(i)))
+			}
+			assert.True(t, tree.Root.NE.IsDivided)
+
+			for i, loc := range downtown[:4] {
+				assert.NoError(t, loc.Update(-45-float64(i), -90-float64(i)))
+				assert.NoError(t, tree.Insert(loc))
+			}
+
+			assert.False(t, tree.Root.NE.IsDivided)
+			assert.Same(t, tree.Root.NE, downtown[4].Node())
+			assert.True(t, tree.Root.IsDivided, "the root still holds 5 locations")
+
+			for _, loc := range downtown[:3] {
+				tree.Remove(loc)
+			}
+			assert.False(t, tree.Root.IsDivided)
+			assert.Len(t, tree.Root.QueryRange(-90, 90, -180, 180), 2)
+		})
+
+		t.Run("Should never merge the nodes divided up front", func(t *testing.T) {
+			t.Parallel()
+			tree := NewQuadTree(-90, 90, -180, 180)
+			loc := insert(t, tree, "a", 1, 1)
+			leaf := loc.Node()
+
+			tree.Remove(loc)
+
+			assert.Nil(t, loc.Node())
+			assert.True(t, tree.Root.IsDivided)
+			assert.True(t, leaf.parent.IsDivided)
+			assert.Empty(t, tree.Root.QueryRange(-90, 90, -180, 180))
+		})
+
+		t.Run("Should keep every location exactly once under concurrent inserts, moves and removals", func(t *testing.T) {
+			t.Parallel()
+			tree := newSmallTree()
+
+			const workers = 8
+			const perWorker = 200
+
+			kept := make([][]*Location, workers)
+			var waitGroup sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				waitGroup.Add(1)
+				go func(w int) {
+					defer waitGroup.Done()
+					random := rand.New(rand.NewSource(int64(w)))
+					randomPoint := func() (float64, float64) {
+						return random.Float64()*20 - 10, random.Float64()*20 - 10
+					}
+
+					for i := 0; i < perWorker; i++ {
+						lat, lon := randomPoint()
+						loc := insert(t, tree, strconv.Itoa(w)+"-"+strconv.Itoa(i), lat, lon)
+
+						lat, lon = randomPoint()
+						assert.NoError(t, tree.Move(loc, lat, lon))
+
+						if i%3 == 0 {
+							kept[w] = append(kept[w], loc)
+						} else {
+							tree.Remove(loc)
+						}
+					}
+				}(w)
+			}
+			waitGroup.Wait()
+
+			seen := map[string]int{}
+			for _, loc := range tree.Root.QueryRange(-90, 90, -180, 180) {
+				seen[loc.Id()]++
+			}
+
+			expected := 0
+			for _, locations := range kept {
+				for _, loc := range locations {
+					expected++
+					assert.Equal(t, 1, seen[loc.Id()], loc.Id())
+					assert.Same(t, loc, loc.Node().Objects[loc.Id()])
+				}
+			}
+			assert.Len(t, seen, expected)
+		})
+	})
 }
@@ -0,0 +1,168 @@
+package world
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrInconsistentIndex = errors.New("inconsistent index")
+)
+
+// Verify checks every namespace's index, locations and expiry heap against each other and returns the
+// inconsistencies it finds joined in one error, each wrapping ErrInconsistentIndex. It returns nil for a sound
+// world. Each namespace is checked under its read lock, so the writers wait for it one namespace at a time.
+func (m *World) Verify() error {
+	namespaces := m.allNamespaces()
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+
+	var problems []error
+	for _, namespace := range namespaces {
+		problems = append(problems, namespace.verify()...)
+	}
+
+	return errors.Join(problems...)
+}
+
+// Verify checks the namespace like World.Verify.
+func (n *Namespace) Verify() error {
+	return errors.Join(n.verify()...)
+}
+
+// verify checks that every location of the namespace is held by the index exactly once, that the index holds
+// nothing else and is in shape itself, and that the expiry heap holds the expiring locations, in order and at
+// their index.
+func (n *Namespace) verify() []error {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	var problems []error
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf("%w: namespace %s: %s", ErrInconsistentIndex, n.Name, fmt.Sprintf(format, args...)))
+	}
+
+	held := map[string]int{}
+	n.index().verify(func(id string, loc *Location) {
+		held[id]++
+
+		switch {
+		case loc == nil:
+			report("index holds a nil location under %s", id)
+			return
+		case loc.Id() != id:
+			report("index holds location %s under %s", loc.Id(), id)
+		}
+
+		if current, ok := n.locations.get(id); !ok || current != loc {
+			report("index holds location %s the namespace does not have", id)
+		}
+	}, report)
+
+	for loc := range n.locations.all() {
+		switch count := held[loc.id]; {
+		case count == 0:
+			report("location %s is not in the index", loc.id)
+		case count > 1:
+			report("location %s is in the index %d times", loc.id, count)
+		}
+
+		if loc.ns != n.Name {
+			report("location %s belongs to namespace %s", loc.id, loc.ns)
+		}
+
+		if loc.expiresAt.IsZero() {
+			continue
+		}
+
+		if loc.expiryIndex < 0 || loc.expiryIndex >= len(n.expiries) || n.expiries[loc.expiryIndex] != loc {
+			report("location %s expires but is not in the expiry heap at its index %d", loc.id, loc.expiryIndex)
+		}
+	}
+
+	for i, loc := range n.expiries {
+		if loc.expiryIndex != i {
+			report("expiry heap holds location %s at %d, not at its index %d", loc.id, i, loc.expiryIndex)
+		}
+
+		if current, ok := n.locations.get(loc.id); !ok || current != loc {
+			report("expiry heap holds location %s the namespace does not have", loc.id)
+		}
+
+		if i > 0 && n.expiries.Less(i, (i-1)/2) {
+			report("expiry heap holds location %s before its parent", loc.id)
+		}
+	}
+
+	return problems
+}
+
+func (q *QuadTree) verify(visit func(id string, location *Location), report func(format string, args ...any)) {
+	q.Root.verify(visit, report)
+}
+
+// verify calls visit for every location held by the leaves under the node, and report for the nodes out of
+// shape: a grid holding locations, a child that does not point back to its parent or sits at the wrong depth,
+// a leaf holding a location it does not own or out of its bounds, or a count that is not what the node holds. It
+// returns how many locations the leaves under the node hold.
+func (node *TreeNode) verify(visit func(id string, location *Location), report func(format string, args ...any)) int {
+	node.mu.RLock()
+	divided := node.IsDivided
+	children := node.children()
+	objects := make(map[string]*Location, len(node.Objects))
+	for id, loc := range node.Objects {
+		objects[id] = loc
+	}
+	count := int(node.count.Load())
+	node.mu.RUnlock()
+
+	held := len(objects)
+
+	if !divided {
+		for id, loc := range objects {
+			visit(id, loc)
+
+			switch {
+			case loc == nil:
+			case loc.Node() != node:
+				report("leaf %s holds location %s owned by %s", node, id, loc.Node())
+			case !node.contains(loc.lat, loc.lon):
+				report("leaf %s holds location %s at %f,%f, out of its bounds", node, id, loc.lat, loc.lon)
+			}
+		}
+	} else {
+		if len(objects) > 0 {
+			report("grid %s is divided but holds %d locations", node, len(objects))
+		}
+
+		held = 0
+		for _, child := range children {
+			if child.parent != node || child.depth != node.depth+1 {
+				report("node %s is not a child of %s at depth %d", child, node, node.depth+1)
+			}
+
+			held += child.verify(visit, report)
+		}
+	}
+
+	if count != held {
+		report("node %s counts %d locations but holds %d", node, count, held)
+	}
+
+	return held
+}
+
+// contains tells if the point is within the node's bounds, edges included.
+func (node *TreeNode) contains(lat, lon float64) bool {
+	return node.Lat1 <= lat && lat <= node.Lat2 && node.Lon1 <= lon && lon <= node.Lon2
+}
+
+func (node *TreeNode) String() string {
+	if node == nil {
+		return "none"
+	}
+
+	return fmt.Sprintf("[%f,%f %f,%f]", node.Lat1, node.Lon1, node.Lat2, node.Lon2)
+}
@@ -0,0 +1,133 @@
+package world
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should find nothing wrong with a sound world", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.CreateNamespace("paris", cityOptions()))
+		for i := 0; i < 50; i++ {
+			assert.NoError(t, world.SaveWithTTL("paris", strconv.Itoa(i), 48.8+float64(i)/1000, 2.3, time.Duration(i+1)*time.Hour))
+		}
+		assert.NoError(t, world.Delete("paris", "7"))
+		assert.NoError(t, world.Save("other", "a", 1, 1))
+
+		assert.NoError(t, world.Verify())
+	})
+
+	t.Run("should report a ghost left in a leaf", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.Save("ns", "a", 1, 1))
+		namespace := world.getNamespace("ns")
+		loc, _ := namespace.locations.get("a")
+		namespace.locations.delete("a")
+
+		err := world.Verify()
+		assert.ErrorIs(t, err, ErrInconsistentIndex)
+		assert.ErrorContains(t, err, "holds location a the namespace does not have")
+
+		namespace.locations.put(loc)
+		loc.Node().Delete("a")
+
+		err = world.Verify()
+		assert.ErrorIs(t, err, ErrInconsistentIndex)
+		assert.ErrorContains(t, err, "namespace ns: location a is not in the index")
+	})
+
+	t.Run("should report a location held by a leaf it does not point to", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.Save("ns", "a", 1, 1))
+		assert.NoError(t, world.Save("ns", "b", -1, -1))
+		namespace := world.getNamespace("ns")
+		a, _ := namespace.locations.get("a")
+		b, _ := namespace.locations.get("b")
+		b.Node().Objects["a"] = a
+
+		err := world.Verify()
+		assert.ErrorContains(t, err, "location a is in the index 2 times")
+		assert.ErrorContains(t, err, "owned by")
+	})
+
+	t.Run("should report an expiry heap out of shape", func(t *testing.T) {
+		t.Parallel()
+		world := NewWorld()
+		assert.NoError(t, world.SaveWithTTL("ns", "a", 1, 1, time.Hour))
+		assert.NoError(t, world.SaveWithTTL("ns", "b", 1, 1, time.Minute))
+		namespace := world.getNamespace("ns")
+		namespace.expiries[0].expiryIndex = 1
+
+		err := world.Verify()
+		assert.ErrorContains(t, err, "not at its index 1")
+		assert.ErrorContains(t, err, "not in the expiry heap at its index 1")
+	})
+}
+
+// TestOwnershipRace saves, moves, deletes and expires a small set of ids from many goroutines, in namespaces
+// small enough to divide and merge all along, while others read them. Run it with -race.
+func TestOwnershipRace(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			t.Parallel()
+
+			options := cityOptions()
+			options.Backend = backend
+			world := NewWorld()
+			assert.NoError(t, world.CreateNamespace("paris", options))
+
+			const workers = 8
+			const operations = 2000
+			const ids = 40
+
+			var waitGroup sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				waitGroup.Add(1)
+				go func(w int) {
+					defer waitGroup.Done()
+					random := rand.New(rand.NewSource(int64(w)))
+
+					for i := 0; i < operations; i++ {
+						id := strconv.Itoa(random.Intn(ids))
+						lat, lon := 48.8+random.Float64()*0.1, 2.2+random.Float64()*0.3
+
+						switch random.Intn(8) {
+						case 0, 1, 2:
+							assert.NoError(t, world.Save("paris", id, lat, lon))
+						case 3:
+							assert.NoError(t, world.SaveWithTTL("paris", id, lat, lon, time.Duration(random.Intn(3))*time.Millisecond+time.Nanosecond))
+						case 4, 5:
+							assert.NoError(t, world.Delete("paris", id))
+						case 6:
+							world.GetLocation("paris", id)
+							world.QueryRange("paris", lat-0.02, lat+0.02, lon-0.05, lon+0.05)
+						case 7:
+							world.Expire(time.Now(), nil)
+							_, err := world.Nearest("paris", lat, lon, 3, 0)
+							assert.NoError(t, err)
+						}
+					}
+				}(w)
+			}
+			waitGroup.Wait()
+
+			assert.NoError(t, world.Verify())
+
+			count := world.Namespaces()[0].Locations
+			assert.Len(t, world.QueryRange("paris", 48.8, 48.9, 2.2, 2.5), count)
+		})
+	}
+}
@@ -4,21 +4,47 @@ import (
 	"bytes"
 	"encoding/gob"
 	"errors"
+	"math"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	ErrUnexpectedNilNamespace = errors.New("failed to create namespace")
 )
 
+// Stats describe a namespace: its locations, how many expire, its fences and subscriptions, the shape of its
+// tree (nodes, leaves and the depth of the deepest leaf) and its settings.
 type Stats struct {
-	Locations int
-	Grids     int
+	Locations     int
+	Grids         int
+	Leaves        int
+	Depth         int
+	Expiring      int
+	Fences        int
+	Subscriptions int
+	Options       IndexOptions
+	Created       bool
+	DefaultTTL    time.Duration
+	HistorySize   int
+	HistoryAge    time.Duration
 }
 
 type World struct {
-	namespaces map[string]*Namespace
-	mu         sync.RWMutex
+	// namespaces maps the names to their *Namespace. Lookups read it without a lock; namespaces are added,
+	// dropped and renamed under mu.
+	namespaces sync.Map
+	journal    Journal
+	fenceHub   *fenceHub
+	// defaultOptions are the index options of the namespaces created by their first use.
+	defaultOptions IndexOptions
+	// strict worlds refuse reads and subscriptions against the namespaces they do not have.
+	strict atomic.Bool
+	mu     sync.RWMutex
+	// catalog is read-locked by the changes to the catalog, CREATE, DROP and RENAME NAMESPACE, and locked by
+	// FreezeCatalog, so a snapshot and the log it starts see all of them on the same side.
+	catalog sync.RWMutex
 }
 
 func init() {
@@ -28,149 +54,324 @@ func init() {
 
 func NewWorld() *World {
 	return &World{
-		namespaces: map[string]*Namespace{},
-		mu:         sync.RWMutex{},
+		fenceHub:       newFenceHub(),
+		defaultOptions: DefaultIndexOptions(),
+		mu:             sync.RWMutex{},
 	}
 }
 
-func (m *World) Delete(ns, locId string) {
-	namespace := m.getNamespace(ns)
-	namespace.DeleteLocation(locId)
+func (m *World) Delete(ns, locId string) error {
+	return m.update(ns, func(namespace *Namespace) error {
+		return namespace.DeleteLocation(locId)
+	})
 }
 
 // Save a location to the world. If the location already exists, it will be updated.
 func (m *World) Save(ns, locId string, lat, lon float64) error {
-	namespace := m.getNamespace(ns)
+	return m.SaveWithAttributes(ns, locId, lat, lon, 0, nil)
+}
 
-	_, err := namespace.SaveLocation(locId, lat, lon)
+// SaveWithTTL saves a location that expires ttl from now, or after the namespace default TTL if ttl is zero.
+func (m *World) SaveWithTTL(ns, locId string, lat, lon float64, ttl time.Duration) error {
+	return m.SaveWithAttributes(ns, locId, lat, lon, ttl, nil)
+}
 
-	return err
+// SaveWithAttributes saves a location like SaveWithTTL and merges the attributes into its own.
+// An empty value removes its key.
+func (m *World) SaveWithAttributes(ns, locId string, lat, lon float64, ttl time.Duration, attributes Attributes) error {
+	return m.write(ns, func(namespace *Namespace) error {
+		_, err := namespace.SaveLocationWithAttributes(locId, lat, lon, ttl, attributes)
+		return err
+	})
 }
 
-func (m *World) getNamespace(ns string) *Namespace {
-	m.mu.Lock()
+// BatchSave is one of the saves of SaveBatch.
+type BatchSave struct {
+	Id         string
+	Lat        float64
+	Lon        float64
+	TTL        time.Duration
+	Attributes Attributes
+}
+
+// SaveBatch saves the locations in the namespace like SaveWithAttributes, taking the namespace's lock once for
+// all of them rather than once each. A failed save does not stop the others: it returns the error of each save,
+// nil for those that succeeded.
+func (m *World) SaveBatch(ns string, saves []BatchSave) []error {
+	var errs []error
+
+	_ = m.write(ns, func(namespace *Namespace) error {
+		var err error
+		errs, err = namespace.saveBatch(saves)
+
+		return err
+	})
+
+	return errs
+}
+
+// write runs a change against the namespace, creating it if needed, and runs it again against the namespace
+// now under that name if it was dropped or renamed in the meantime.
+func (m *World) write(ns string, change func(namespace *Namespace) error) error {
+	for {
+		err := change(m.getNamespace(ns))
+		if !errors.Is(err, errNamespaceDropped) {
+			return err
+		}
+	}
+}
 
-	namespace, ok := m.namespaces[ns]
+// update is write for the changes that have nothing to do in a namespace that does not exist, like deletes.
+func (m *World) update(ns string, change func(namespace *Namespace) error) error {
+	for {
+		namespace, ok := m.lookupNamespace(ns)
+		if !ok {
+			return nil
+		}
 
+		err := change(namespace)
+		if !errors.Is(err, errNamespaceDropped) {
+			return err
+		}
+	}
+}
+
+// lookupNamespace returns the namespace without creating it, as reads do. It takes no lock.
+func (m *World) lookupNamespace(ns string) (*Namespace, bool) {
+	value, ok := m.namespaces.Load(ns)
 	if !ok {
-		namespace = NewNamespace(ns)
-		m.namespaces[ns] = namespace
+		return nil, false
 	}
 
+	namespace := value.(*Namespace)
 	if namespace == nil {
-		m.mu.Unlock()
 		panic(ErrUnexpectedNilNamespace)
 	}
 
-	m.mu.Unlock()
-
-	return namespace
+	return namespace, true
 }
 
-func (m *World) ToBytes() []byte {
-	type serialLocation struct {
-		ID  string
-		Lat float64
-		Lon float64
+// getNamespace returns the namespace, creating it if needed. Only the creation takes the world's lock.
+func (m *World) getNamespace(ns string) *Namespace {
+	namespace, ok := m.lookupNamespace(ns)
+	if ok {
+		return namespace
 	}
 
-	type serialNamespace struct {
-		Name      string
-		Locations []serialLocation
-	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	type serialWorld struct {
-		Namespaces []serialNamespace
+	namespace, ok = m.lookupNamespace(ns)
+	if ok {
+		return namespace
 	}
 
-	m.mu.RLock()
-	dto := serialWorld{}
+	namespace = newNamespaceWithOptions(ns, m.defaultOptions)
+	namespace.setJournal(m.journal)
+	namespace.fenceHub = m.fenceHub
+	m.namespaces.Store(ns, namespace)
 
-	for _, namespace := range m.namespaces {
-		namespace.mu.RLock()
-		nsDTO := serialNamespace{Name: namespace.Name}
-		for id, loc := range namespace.locations {
-			nsDTO.Locations = append(nsDTO.Locations, serialLocation{ID: id, Lat: loc.Lat(), Lon: loc.Lon()})
-		}
-		namespace.mu.RUnlock()
+	return namespace
+}
 
-		dto.Namespaces = append(dto.Namespaces, nsDTO)
-	}
-	m.mu.RUnlock()
+// allNamespaces returns the namespaces of the world, in no particular order.
+func (m *World) allNamespaces() []*Namespace {
+	var namespaces []*Namespace
+
+	m.namespaces.Range(func(_, value any) bool {
+		namespaces = append(namespaces, value.(*Namespace))
+		return true
+	})
 
+	return namespaces
+}
+
+// ToBytes returns the world in the snapshot format. It is what a node shares with the nodes joining the cluster.
+func (m *World) ToBytes() []byte {
 	var buf bytes.Buffer
 
-	_ = gob.NewEncoder(&buf).Encode(dto)
+	_ = m.WriteSnapshot(&buf)
 
 	return buf.Bytes()
 }
 
 func NewWorldFromBytes(buf []byte) *World {
-	type serialLocation struct {
-		ID  string
-		Lat float64
-		Lon float64
+	world, err := ReadSnapshot(bytes.NewReader(buf))
+	if err != nil {
+		panic(err)
 	}
 
-	type serialNamespace struct {
-		Name      string
-		Locations []serialLocation
-	}
+	return world
+}
 
-	type serialWorld struct {
-		Namespaces []serialNamespace
+func (m *World) Merge(w *World) {
+	for _, n := range w.allNamespaces() {
+		err := m.write(n.Name, n.mergeInto)
+		if err != nil {
+			panic(err)
+		}
 	}
+}
 
-	var dto serialWorld
-
-	dec := gob.NewDecoder(bytes.NewReader(buf))
-	err := dec.Decode(&dto)
+// mergeInto copies the namespace's settings, fences and locations into another one. Everything it does can be
+// done again, so a merge interrupted by a drop starts over in the namespace replacing the dropped one.
+func (n *Namespace) mergeInto(namespace *Namespace) error {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
 
-	if err != nil {
-		panic(err)
+	if n.created {
+		err := namespace.create(n.options)
+		if err != nil {
+			return err
+		}
 	}
 
-	world := NewWorld()
+	if n.defaultTTL != 0 {
+		err := namespace.SetDefaultTTL(n.defaultTTL)
+		if err != nil {
+			return err
+		}
+	}
 
-	for _, namespace := range dto.Namespaces {
-		for _, loc := range namespace.Locations {
-			err := world.Save(namespace.Name, loc.ID, loc.Lat, loc.Lon)
-			if err != nil {
-				panic(err)
-			}
+	if n.keepsHistory() {
+		err := namespace.SetHistory(n.historySize, n.historyAge)
+		if err != nil {
+			return err
 		}
 	}
 
-	return world
-}
+	for _, fence := range n.fences {
+		err := namespace.AddFence(fence.Id, fence.Polygon, fence.Dwell)
+		if err != nil {
+			return err
+		}
+	}
 
-func (m *World) Merge(w *World) {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-
-	for ns, n := range w.namespaces {
-		for locId, loc := range n.locations {
-			err := m.Save(ns, locId, loc.Lat(), loc.Lon())
-			if err != nil {
-				panic(err)
-			}
+	for loc := range n.locations.all() {
+		_, err := namespace.saveLocationUntil(loc.id, loc.Lat(), loc.Lon(), loc.expiresAt, loc.attributes)
+		if err != nil {
+			return err
 		}
 	}
+
+	return nil
 }
 
 func (m *World) GetLocation(ns, id string) (Location, bool) {
-	namespace := m.getNamespace(ns)
-
-	location, ok := namespace.GetLocation(id)
+	namespace, ok := m.lookupNamespace(ns)
 	if !ok {
 		return Location{}, false
 	}
 
-	return *location, true
+	return namespace.location(id)
 }
 
 func (m *World) QueryRange(ns string, lat1, lat2, lon1, lon2 float64) []*Location {
-	namespace := m.getNamespace(ns)
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return nil
+	}
 
 	return namespace.QueryRange(lat1, lat2, lon1, lon2)
 }
+
+// QueryRangeWhere returns the locations of the namespace within the range that match the filter.
+func (m *World) QueryRangeWhere(ns string, lat1, lat2, lon1, lon2 float64, filter Filter) []*Location {
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return nil
+	}
+
+	return namespace.QueryRangeWhere(lat1, lat2, lon1, lon2, filter)
+}
+
+// QueryPolygon returns the locations of the namespace inside the polygon.
+func (m *World) QueryPolygon(ns string, polygon *Polygon) []*Location {
+	return m.QueryPolygonWhere(ns, polygon, nil)
+}
+
+// QueryPolygonWhere returns the locations of the namespace inside the polygon that match the filter.
+func (m *World) QueryPolygonWhere(ns string, polygon *Polygon, filter Filter) []*Location {
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return nil
+	}
+
+	return namespace.QueryPolygonWhere(polygon, filter)
+}
+
+// WalkRange calls visit for the locations of the namespace within the range that match the filter, as they are
+// found, until visit returns false. See Namespace.WalkRange.
+func (m *World) WalkRange(ns string, lat1, lat2, lon1, lon2 float64, filter Filter, visit func(location *Location) bool) {
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return
+	}
+
+	namespace.WalkRange(lat1, lat2, lon1, lon2, filter, visit)
+}
+
+// WalkPolygon is WalkRange for the locations of the namespace inside the polygon.
+func (m *World) WalkPolygon(ns string, polygon *Polygon, filter Filter, visit func(location *Location) bool) {
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return
+	}
+
+	namespace.WalkPolygon(polygon, filter, visit)
+}
+
+// QueryRadius returns the locations of the namespace within meters of (lat, lon), closest first.
+// Distances are great-circle distances, so the search works across the poles and the antimeridian.
+func (m *World) QueryRadius(ns string, lat, lon, meters float64) ([]Neighbor, error) {
+	return m.QueryRadiusWhere(ns, lat, lon, meters, nil)
+}
+
+// QueryRadiusWhere is QueryRadius among the locations matching the filter.
+func (m *World) QueryRadiusWhere(ns string, lat, lon, meters float64, filter Filter) ([]Neighbor, error) {
+	err := validateLatLon(lat, lon)
+	if err != nil {
+		return nil, err
+	}
+
+	if !(meters > 0) || math.IsInf(meters, 1) {
+		return nil, ErrInvalidRadius
+	}
+
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return nil, nil
+	}
+
+	return namespace.QueryRadiusWhere(lat, lon, meters, filter), nil
+}
+
+// Nearest returns the k locations of the namespace closest to (lat, lon), closest first.
+// Locations further than maxDistance meters are left out, unless maxDistance is 0.
+func (m *World) Nearest(ns string, lat, lon float64, k int, maxDistance float64) ([]Neighbor, error) {
+	return m.NearestWhere(ns, lat, lon, k, maxDistance, nil)
+}
+
+// NearestWhere is Nearest among the locations matching the filter. The filter is applied while searching, so
+// up to k matching locations are returned however many closer ones do not match.
+func (m *World) NearestWhere(ns string, lat, lon float64, k int, maxDistance float64, filter Filter) ([]Neighbor, error) {
+	err := validateLatLon(lat, lon)
+	if err != nil {
+		return nil, err
+	}
+
+	if k <= 0 {
+		return nil, ErrInvalidNeighborCount
+	}
+
+	if maxDistance < 0 || math.IsNaN(maxDistance) {
+		return nil, ErrInvalidRadius
+	}
+
+	namespace, ok := m.lookupNamespace(ns)
+	if !ok {
+		return nil, nil
+	}
+
+	return namespace.NearestWhere(lat, lon, k, maxDistance, filter), nil
+}
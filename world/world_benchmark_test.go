@@ -151,3 +151,127 @@ func BenchmarkWorld(b *testing.B) {
 		})
 	})
 }
+
+// BenchmarkSubscriptionSave saves locations while 10k small areas are subscribed to.
+func BenchmarkSubscriptionSave(b *testing.B) {
+	world := NewWorld()
+
+	for i := 0; i < 10000; i++ {
+		lat := -80 + rand.Float64()*160
+		lon := -170 + rand.Float64()*340
+		subscription, err := world.Subscribe("ns", lat, lat+0.5, lon, lon+0.5, 1, DropEvents)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer subscription.Close()
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		err := world.Save("ns", strconv.Itoa(i%1000), -80+rand.Float64()*160, -170+rand.Float64()*340)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWorldScaling measures how the read path scales with the number of cores, with
+// go test -bench WorldScaling -cpu 1,2,4,8,16,32. Every goroutine reads its own ids, and a lookup takes neither
+// the world's nor the namespace's lock, only the one of its id's shard, so the goroutines rarely meet on a lock.
+func BenchmarkWorldScaling(b *testing.B) {
+	const locations = 100000
+
+	world := NewWorld()
+	for i := 0; i < locations; i++ {
+		ns, id, lat, lon := CreateRandomLocation(i)
+
+		err := world.Save(ns, id, lat, lon)
+		if err != nil {
+			b.Fatalf("Error saving location: %v", err)
+		}
+	}
+
+	ids := make([]string, locations)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+	}
+
+	var goroutines atomic.Uint64
+
+	b.Run("GetLocation", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			i := int(goroutines.Add(1)) * 7919
+
+			for pb.Next() {
+				_, ok := world.GetLocation("1", ids[i%locations])
+				if !ok {
+					b.Fatal("location not found")
+				}
+				i++
+			}
+		})
+	})
+
+	b.Run("GetLocation while the namespace is written to", func(b *testing.B) {
+		stop := make(chan struct{})
+		defer close(stop)
+
+		go func() {
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				_ = world.Save("1", "writer-"+strconv.Itoa(i%1000), 1, 1)
+			}
+		}()
+
+		b.RunParallel(func(pb *testing.PB) {
+			i := int(goroutines.Add(1)) * 7919
+
+			for pb.Next() {
+				_, ok := world.GetLocation("1", ids[i%locations])
+				if !ok {
+					b.Fatal("location not found")
+				}
+				i++
+			}
+		})
+	})
+
+	b.Run("GetLocation of an unknown namespace", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				world.GetLocation("unknown", "1")
+			}
+		})
+	})
+
+	b.Run("QueryRange in Singapore", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_ = world.QueryRange("1", 1.16, 1.48, 103.6, 104)
+			}
+		})
+	})
+
+	b.Run("Save in a namespace per goroutine", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			ns := "scaling-" + strconv.Itoa(int(goroutines.Add(1)))
+			i := 0
+
+			for pb.Next() {
+				_, id, lat, lon := CreateRandomLocation(i % locations)
+
+				err := world.Save(ns, id, lat, lon)
+				if err != nil {
+					b.Fatalf("Error saving location: %v", err)
+				}
+				i++
+			}
+		})
+	})
+}
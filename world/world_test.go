@@ -1,6 +1,7 @@
 package world
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -90,6 +91,43 @@ func TestWorld(t *testing.T) {
 		})
 	})
 
+	t.Run("SaveBatch", func(t *testing.T) {
+		t.Parallel()
+		t.Run("Should save the valid locations and report the others", func(t *testing.T) {
+			t.Parallel()
+			world := NewWorld()
+
+			err := world.SaveWithAttributes("ns", "a", 1.0, 1.0, 0, Attributes{"color": "red"})
+			if err != nil {
+				t.Fatalf("Error saving location: %v", err)
+			}
+
+			errs := world.SaveBatch("ns", []BatchSave{
+				{Id: "a", Lat: 2.0, Lon: 2.0, Attributes: Attributes{"speed": "30"}},
+				{Id: "b", Lat: 100.0, Lon: 2.0},
+				{Id: "c", Lat: 3.0, Lon: 3.0, TTL: -1},
+				{Id: "d", Lat: 4.0, Lon: 4.0, Attributes: Attributes{"bad key": "x"}},
+				{Id: "e", Lat: 5.0, Lon: 5.0},
+			})
+
+			if len(errs) != 5 || errs[0] != nil || errs[4] != nil {
+				t.Fatalf("Expected a and e to be saved, got %v", errs)
+			}
+			if !errors.Is(errs[1], ErrLocationInvalidLatitude) || !errors.Is(errs[2], ErrInvalidTTL) || !errors.Is(errs[3], ErrInvalidAttributeKey) {
+				t.Fatalf("Expected the errors of b, c and d, got %v", errs)
+			}
+
+			loc, ok := world.GetLocation("ns", "a")
+			if !ok || loc.Lat() != 2.0 || loc.Attributes()["color"] != "red" || loc.Attributes()["speed"] != "30" {
+				t.Fatalf("Expected a to be moved with its attributes merged, got %v", loc.String())
+			}
+
+			if len(world.QueryRange("ns", -90, 90, -180, 180)) != 2 {
+				t.Fatalf("Expected only a and e to be saved")
+			}
+		})
+	})
+
 	t.Run("GetLocation", func(t *testing.T) {
 		t.Parallel()
 		t.Run("Should return boolean false if location not found", func(t *testing.T) {
@@ -143,6 +181,35 @@ func TestWorld(t *testing.T) {
 				t.Fatalf("Expected 0 locations to be returned, got %v locations", len(locations))
 			}
 		})
+
+		t.Run("Should cross the antimeridian when lon1 is greater than lon2", func(t *testing.T) {
+			t.Parallel()
+			world := NewWorld()
+
+			saves := map[string][2]float64{
+				"fiji":      {-17.7, 178.4},
+				"samoa":     {-13.8, -172.1},
+				"dateline":  {-15, 180},
+				"australia": {-25, 135},
+				"tahiti":    {-17.6, -149.4},
+			}
+
+			for id, coordinates := range saves {
+				err := world.Save("ns", id, coordinates[0], coordinates[1])
+				if err != nil {
+					t.Fatalf("Error saving location: %v", err)
+				}
+			}
+
+			found := map[string]bool{}
+			for _, location := range world.QueryRange("ns", -20, -10, 170, -170) {
+				found[location.Id()] = true
+			}
+
+			if len(found) != 3 || !found["fiji"] || !found["samoa"] || !found["dateline"] {
+				t.Fatalf("Expected fiji, samoa and dateline to be returned, got %v", found)
+			}
+		})
 	})
 
 	t.Run("Delete", func(t *testing.T) {
@@ -180,4 +247,110 @@ func TestWorld(t *testing.T) {
 			world.Delete("ns", "locId")
 		})
 	})
+
+	t.Run("QueryRadius", func(t *testing.T) {
+		t.Parallel()
+		t.Run("Should return the locations within the radius closest first", func(t *testing.T) {
+			t.Parallel()
+			world := NewWorld()
+
+			_ = world.Save("ns", "far", 0, 0.009)
+			_ = world.Save("ns", "near", 0, 0.001)
+			_ = world.Save("ns", "corner", 0.009, 0.009) // inside the bounding box but ~1415m away
+			_ = world.Save("ns", "out", 0, 1)
+
+			neighbors, err := world.QueryRadius("ns", 0, 0, 1001)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if len(neighbors) != 2 {
+				t.Fatalf("Expected 2 locations to be returned, got %v locations", len(neighbors))
+			}
+
+			if neighbors[0].Location.Id() != "near" || neighbors[1].Location.Id() != "far" {
+				t.Fatalf("Expected near then far, got %s then %s", neighbors[0].Location.Id(), neighbors[1].Location.Id())
+			}
+
+			if neighbors[1].Distance < 1000 || neighbors[1].Distance > 1001 {
+				t.Fatalf("Expected far to be about 1000m away, got %f", neighbors[1].Distance)
+			}
+		})
+
+		t.Run("Should find locations across the antimeridian", func(t *testing.T) {
+			t.Parallel()
+			world := NewWorld()
+
+			_ = world.Save("ns", "east", -17.7, 179.99)
+			_ = world.Save("ns", "west", -17.7, -179.99)
+
+			neighbors, err := world.QueryRadius("ns", -17.7, 180, 5000)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if len(neighbors) != 2 {
+				t.Fatalf("Expected 2 locations to be returned, got %v locations", len(neighbors))
+			}
+		})
+
+		t.Run("Should find locations across the poles", func(t *testing.T) {
+			t.Parallel()
+			world := NewWorld()
+
+			_ = world.Save("ns", "other-side", 89.9, 180)
+			_ = world.Save("ns", "south-pole", -90, 0)
+
+			neighbors, err := world.QueryRadius("ns", 89.9, 0, 25000)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if len(neighbors) != 1 || neighbors[0].Location.Id() != "other-side" {
+				t.Fatalf("Expected the location on the other side of the pole, got %v", neighbors)
+			}
+
+			neighbors, err = world.QueryRadius("ns", -89.99, 120, 2000)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if len(neighbors) != 1 || neighbors[0].Location.Id() != "south-pole" {
+				t.Fatalf("Expected the location on the pole, got %v", neighbors)
+			}
+		})
+
+		t.Run("Should return copies the later saves do not change", func(t *testing.T) {
+			t.Parallel()
+			world := NewWorld()
+
+			_ = world.Save("ns", "moving", 0, 0.001)
+
+			neighbors, err := world.QueryRadius("ns", 0, 0, 1001)
+			if err != nil || len(neighbors) != 1 {
+				t.Fatalf("Expected the location, got %v: %v", neighbors, err)
+			}
+
+			_ = world.Save("ns", "moving", 0, 0.002)
+
+			if neighbors[0].Location.Lon() != 0.001 {
+				t.Fatalf("Expected the location as it was found, got %f", neighbors[0].Location.Lon())
+			}
+		})
+
+		t.Run("Should reject invalid centers and radiuses", func(t *testing.T) {
+			t.Parallel()
+			world := NewWorld()
+
+			_, err := world.QueryRadius("ns", 91, 0, 10)
+			if !errors.Is(err, ErrLocationInvalidLatitude) {
+				t.Fatalf("Expected ErrLocationInvalidLatitude, got %v", err)
+			}
+
+			_, err = world.QueryRadius("ns", 0, 0, 0)
+			if !errors.Is(err, ErrInvalidRadius) {
+				t.Fatalf("Expected ErrInvalidRadius, got %v", err)
+			}
+		})
+	})
 }